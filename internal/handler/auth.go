@@ -2,11 +2,21 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/breakglass"
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+	"github.com/yovily/customers/citi/auth-service/pkg/roles"
+	"github.com/yovily/customers/citi/auth-service/pkg/throttle"
 )
 
 type Logger interface {
@@ -17,7 +27,10 @@ type AuthRequest struct {
 	UserID   string
 	Password string
 	Domain   string
-	Role     string
+	// Role narrows the token to one role. It must be one the user holds.
+	Role string
+	// Application selects the role mappings and the token audience
+	Application string `json:",omitempty"`
 }
 
 type AuthResponse struct {
@@ -25,34 +38,156 @@ type AuthResponse struct {
 	IsAuthenticated bool
 	Role            string
 	Token           string
+	RefreshToken    string `json:",omitempty"`
+
+	// MFARequired is set, with IsAuthenticated false, when the password was
+	// right and the user still has to send a code with MFAToken to
+	// HandleMFAVerification
+	MFARequired bool   `json:",omitempty"`
+	MFAToken    string `json:",omitempty"`
+	// MFAMethods lists the second factors the user can answer with,
+	// MFAMethodTOTP and MFAMethodWebAuthn
+	MFAMethods []string `json:",omitempty"`
 }
 
 type ErrorResponse struct {
 	Error string
 }
 
-type LDAPClient interface {
-	Authenticate(username, password string) (*ldap.AuthResult, error)
+// Authenticator verifies credentials against a backend such as LDAP or a
+// local htpasswd file
+type Authenticator interface {
+	Authenticate(username, password string) (*authn.Identity, error)
 }
 
 type AuthClient interface {
-	GenerateToken(userID string) (string, error)
+	GenerateToken(userID string, opts ...auth.TokenOption) (string, error)
+}
+
+// Throttle guards the LDAP bind against brute force and account lockout.
+// Every attempt allowed by Check must end in RecordFailure, RecordSuccess
+// or Release.
+type Throttle interface {
+	Check(username, ip string) throttle.Decision
+	RecordFailure(username, ip string)
+	RecordSuccess(username, ip string)
+	Release(username, ip string)
+}
+
+// BreakGlassStore holds emergency local accounts
+type BreakGlassStore interface {
+	IsDesignated(username string) bool
+	Authenticate(username, password string) (*breakglass.Account, error)
+}
+
+// RefreshIssuer starts refresh token families at login
+type RefreshIssuer interface {
+	IssueRefreshToken(grant auth.RefreshGrant) (string, error)
 }
 
+// RoleMapper derives a user's roles in an application from directory
+// groups and attributes
+type RoleMapper interface {
+	Roles(application string, subject roles.Subject) ([]string, error)
+}
+
+// SessionStarter binds the request's server-side session to a user after
+// login, rotating its ID, and returns the session ID
+type SessionStarter interface {
+	Login(ctx context.Context, userID string) (string, error)
+}
+
+// DefaultOfflineTokenLifetime is the lifetime of tokens issued after an
+// offline-verified login
+const DefaultOfflineTokenLifetime = 15 * time.Minute
+
 type AuthHandler struct {
-	ldapClient LDAPClient
-	authClient AuthClient
-	logger     Logger
+	authenticator   Authenticator
+	authClient      AuthClient
+	logger          Logger
+	throttle        Throttle
+	breakGlass      BreakGlassStore
+	offlineLifetime time.Duration
+	refresh         RefreshIssuer
+	roles           RoleMapper
+	sessions        SessionStarter
+	mfa             SecondFactor
+	challenges      MFAChallenges
+	webAuthn        *WebAuthnConfig
 }
 
-func NewAuthHandler(ldapClient LDAPClient, authClient AuthClient, logger Logger) *AuthHandler {
-	return &AuthHandler{
-		ldapClient: ldapClient,
-		authClient: authClient,
-		logger:     logger,
+// Option configures optional AuthHandler behaviour
+type Option func(*AuthHandler)
+
+// WithThrottle rejects login attempts with 429 Too Many Requests while the
+// throttle reports the username or client IP as blocked
+func WithThrottle(t Throttle) Option {
+	return func(h *AuthHandler) {
+		h.throttle = t
 	}
 }
 
+// WithBreakGlass enables local emergency accounts. The store is consulted
+// for designated accounts and, for everyone else, only when the
+// authenticator reports an outage. Every attempt is audited.
+func WithBreakGlass(store BreakGlassStore) Option {
+	return func(h *AuthHandler) {
+		h.breakGlass = store
+	}
+}
+
+// WithOfflineTokenLifetime sets the lifetime of tokens issued when the
+// authenticator verified the password from its offline cache
+func WithOfflineTokenLifetime(d time.Duration) Option {
+	return func(h *AuthHandler) {
+		h.offlineLifetime = d
+	}
+}
+
+// WithRefreshTokens issues a refresh token alongside the access token for
+// logins verified by the directory, which RefreshHandler can re-check.
+// Offline, break-glass and file backed logins never get one.
+func WithRefreshTokens(issuer RefreshIssuer) Option {
+	return func(h *AuthHandler) {
+		h.refresh = issuer
+	}
+}
+
+// WithRoleMapper puts the roles mapped from the user's directory groups into
+// tokens. A requested Role the user does not hold is rejected with 403.
+// Without a mapper tokens carry no roles and requested roles are ignored.
+func WithRoleMapper(mapper RoleMapper) Option {
+	return func(h *AuthHandler) {
+		h.roles = mapper
+	}
+}
+
+// WithSessions starts a server-side session on every successful login. The
+// session ID is renewed to prevent fixation and carried in the sid claim so
+// that ending the session can revoke its tokens. The handler must be wrapped
+// in the session middleware.
+func WithSessions(sessions SessionStarter) Option {
+	return func(h *AuthHandler) {
+		h.sessions = sessions
+	}
+}
+
+func NewAuthHandler(authenticator Authenticator, authClient AuthClient, logger Logger, opts ...Option) *AuthHandler {
+	h := &AuthHandler{
+		authenticator:   authenticator,
+		authClient:      authClient,
+		logger:          logger,
+		offlineLifetime: DefaultOfflineTokenLifetime,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.challenges == nil && (h.mfa != nil || h.webAuthn != nil) {
+		h.challenges = mfa.NewChallenges(0, 0)
+	}
+	return h
+}
+
 func (h *AuthHandler) HandleAuthentication(w http.ResponseWriter, r *http.Request) {
 	// Check method first
 	if r.Method != http.MethodPost {
@@ -66,19 +201,167 @@ func (h *AuthHandler) HandleAuthentication(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Format username for LDAP
+	// Format username for the backend
 	username := fmt.Sprintf("%s@%s", request.UserID, request.Domain)
+	ip := clientIP(r)
+
+	if h.throttle != nil {
+		if decision := h.throttle.Check(username, ip); !decision.Allowed {
+			h.logger.Error("Login attempt throttled", "username", username, "ip", ip, "reason", decision.Reason)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+			h.respondError(w, http.StatusTooManyRequests, "too many attempts")
+			return
+		}
+	}
+
+	// Dedicated break-glass accounts never touch the directory
+	if h.breakGlass != nil && h.breakGlass.IsDesignated(request.UserID) {
+		h.breakGlassLogin(w, r, request, username, ip, "designated account")
+		return
+	}
 
-	// Authenticate with LDAP
-	result, err := h.ldapClient.Authenticate(username, request.Password)
-	if err != nil || !result.Success {
-		h.logger.Error("LDAP authentication failed", "error", err)
+	// Authenticate with the backend
+	identity, err := h.authenticator.Authenticate(username, request.Password)
+	if err != nil {
+		h.logger.Error("Authentication failed", "error", err)
+		if h.breakGlass != nil && errors.Is(err, authn.ErrUnavailable) {
+			h.breakGlassLogin(w, r, request, username, ip, "directory unavailable")
+			return
+		}
+		// Only rejected credentials count; directory outages are not the user's fault
+		if h.throttle != nil {
+			if errors.Is(err, authn.ErrInvalidCredentials) {
+				h.throttle.RecordFailure(username, ip)
+			} else {
+				h.throttle.Release(username, ip)
+			}
+		}
 		h.respondError(w, http.StatusUnauthorized, "authentication failed")
 		return
 	}
 
+	granted, audience, ok := h.mapRoles(w, identity, request, ip)
+	if !ok {
+		h.releaseAttempt(username, ip)
+		return
+	}
+
+	methods := identity.Methods
+	if len(methods) == 0 {
+		methods = []string{auth.AMRPassword}
+	}
+
+	request.Password = ""
+	login := &pendingLogin{
+		identity: identity,
+		request:  request,
+		username: username,
+		granted:  granted,
+		audience: audience,
+		methods:  methods,
+	}
+	factors, err := h.secondFactors(identity.ID)
+	if err != nil {
+		// Never fall back to a single factor for an enrolled user
+		h.logger.Error("MFA enrollment lookup failed", "userID", identity.ID, "error", err)
+		h.releaseAttempt(username, ip)
+		h.respondError(w, http.StatusInternalServerError, "mfa unavailable")
+		return
+	}
+	if len(factors) > 0 {
+		// Earlier failures are only cleared once the second factor passes
+		h.releaseAttempt(username, ip)
+		h.challengeMFA(w, login, factors, ip)
+		return
+	}
+	if h.throttle != nil {
+		h.throttle.RecordSuccess(username, ip)
+	}
+	h.completeLogin(w, r, login)
+}
+
+// mapRoles returns the roles and audience of the token for the user's
+// request. It responds itself when the request cannot be granted.
+func (h *AuthHandler) mapRoles(w http.ResponseWriter, identity *authn.Identity, request AuthRequest, ip string) (granted, audience []string, ok bool) {
+	if h.roles == nil {
+		if request.Role != "" {
+			h.logger.Error("Ignoring requested role, no role mappings configured", "userID", identity.ID, "role", request.Role)
+		}
+		return nil, nil, true
+	}
+
+	entitled, err := h.roles.Roles(request.Application, roles.Subject{
+		Groups:     identity.Groups,
+		Attributes: identity.Attributes,
+	})
+	switch {
+	case errors.Is(err, roles.ErrUnknownApplication):
+		h.respondError(w, http.StatusBadRequest, "unknown application")
+		return nil, nil, false
+	case err != nil:
+		h.logger.Error("Role mapping failed", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "role mapping failed")
+		return nil, nil, false
+	}
+
+	granted = entitled
+	if request.Role != "" {
+		if !containsRole(entitled, request.Role) {
+			audit(h.logger, "Login denied role", "userID", identity.ID, "ip", ip, "role", request.Role, "application", request.Application)
+			h.respondError(w, http.StatusForbidden, "role not permitted")
+			return nil, nil, false
+		}
+		granted = []string{request.Role}
+	}
+	if request.Application != "" {
+		audience = []string{request.Application}
+	}
+	return granted, audience, true
+}
+
+// pendingLogin is a login whose password was verified, kept in the MFA
+// challenge until the second factor is verified too
+type pendingLogin struct {
+	identity *authn.Identity
+	// request is the login request without the password
+	request  AuthRequest
+	username string
+	granted  []string
+	audience []string
+	methods  []string
+}
+
+// completeLogin issues the tokens for a verified login
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, login *pendingLogin) {
+	identity, request := login.identity, login.request
+	granted, audience, methods := login.granted, login.audience, login.methods
+	ip := clientIP(r)
+
+	tokenOpts := []auth.TokenOption{
+		auth.WithAMR(methods...),
+		auth.WithProfile(identity.Name, identity.Email),
+		auth.WithGroups(identity.Groups...),
+		auth.WithRoles(granted...),
+	}
+	if len(audience) > 0 {
+		tokenOpts = append(tokenOpts, auth.ForAudience(audience...))
+	}
+	if identity.Offline {
+		audit(h.logger, "Offline-verified login", "userID", identity.ID, "ip", ip)
+		tokenOpts = append(tokenOpts, auth.WithLifetime(h.offlineLifetime), auth.WithOfflineVerified())
+	}
+
+	sessionID, err := h.startSession(r.Context(), identity.ID)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "session start failed")
+		return
+	}
+	if sessionID != "" {
+		tokenOpts = append(tokenOpts, auth.WithSessionID(sessionID))
+	}
+
 	// Generate JWT token
-	token, err := h.authClient.GenerateToken(request.UserID)
+	token, err := h.authClient.GenerateToken(identity.ID, tokenOpts...)
 	if err != nil {
 		h.logger.Error("Token generation failed", "error", err)
 		h.respondError(w, http.StatusInternalServerError, "token generation failed")
@@ -87,27 +370,130 @@ func (h *AuthHandler) HandleAuthentication(w http.ResponseWriter, r *http.Reques
 
 	// Create response
 	response := AuthResponse{
-		UserID:          request.UserID,
+		UserID:          identity.ID,
 		IsAuthenticated: true,
-		Role:            request.Role,
 		Token:           token,
 	}
+	if h.roles != nil {
+		response.Role = request.Role
+	}
+
+	if h.refresh != nil && identity.Backend == authn.BackendLDAP && !identity.Offline {
+		refreshToken, err := h.refresh.IssueRefreshToken(auth.RefreshGrant{
+			UserID:      identity.ID,
+			Audience:    audience,
+			Application: request.Application,
+			Name:        identity.Name,
+			Email:       identity.Email,
+			Groups:      identity.Groups,
+			Roles:       granted,
+			AMR:         methods,
+			SessionID:   sessionID,
+		})
+		if err != nil {
+			// The access token is still good; the client just has to log in again later
+			h.logger.Error("Refresh token issuance failed", "error", err)
+		} else {
+			response.RefreshToken = refreshToken
+		}
+	}
 
 	h.respondJSON(w, http.StatusOK, response)
 }
 
-func (h *AuthHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.WriteHeader(status)
+// breakGlassLogin verifies the request against the local emergency store
+func (h *AuthHandler) breakGlassLogin(w http.ResponseWriter, r *http.Request, request AuthRequest, username, ip, reason string) {
+	account, err := h.breakGlass.Authenticate(request.UserID, request.Password)
+	if err != nil {
+		audit(h.logger, "Break-glass login failed", "userID", request.UserID, "ip", ip, "reason", reason, "error", err)
+		if h.throttle != nil {
+			// During an outage every directory user ends up here; counting
+			// them would soon block their shared IP for spraying
+			if errors.Is(err, breakglass.ErrUnknownAccount) {
+				h.throttle.Release(username, ip)
+			} else {
+				h.throttle.RecordFailure(username, ip)
+			}
+		}
+		h.respondError(w, http.StatusUnauthorized, "authentication failed")
+		return
+	}
 
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Error("Failed to encode response", "error", err)
+	roles := account.Roles
+	if request.Role != "" {
+		if !account.HasRole(request.Role) {
+			audit(h.logger, "Break-glass login denied role", "userID", account.Username, "ip", ip, "role", request.Role)
+			if h.throttle != nil {
+				h.throttle.Release(username, ip)
+			}
+			h.respondError(w, http.StatusForbidden, "role not permitted")
+			return
+		}
+		roles = []string{request.Role}
 	}
+
+	if h.throttle != nil {
+		h.throttle.RecordSuccess(username, ip)
+	}
+
+	tokenOpts := []auth.TokenOption{
+		auth.WithAMR(auth.AMRPassword, auth.AMRLocal),
+		auth.WithRoles(roles...),
+	}
+	sessionID, err := h.startSession(r.Context(), account.Username)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "session start failed")
+		return
+	}
+	if sessionID != "" {
+		tokenOpts = append(tokenOpts, auth.WithSessionID(sessionID))
+	}
+
+	token, err := h.authClient.GenerateToken(account.Username, tokenOpts...)
+	if err != nil {
+		h.logger.Error("Token generation failed", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "token generation failed")
+		return
+	}
+
+	audit(h.logger, "Break-glass login succeeded", "userID", account.Username, "ip", ip, "reason", reason, "roles", roles)
+
+	h.respondJSON(w, http.StatusOK, AuthResponse{
+		UserID:          account.Username,
+		IsAuthenticated: true,
+		Role:            request.Role,
+		Token:           token,
+	})
+}
+
+// startSession returns "" when sessions are not enabled
+func (h *AuthHandler) startSession(ctx context.Context, userID string) (string, error) {
+	if h.sessions == nil {
+		return "", nil
+	}
+	sessionID, err := h.sessions.Login(ctx, userID)
+	if err != nil {
+		h.logger.Error("Session start failed", "userID", userID, "error", err)
+		return "", err
+	}
+	return sessionID, nil
+}
+
+func containsRole(granted []string, role string) bool {
+	for _, r := range granted {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *AuthHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	respondJSON(w, h.logger, status, data)
 }
 
 func (h *AuthHandler) respondError(w http.ResponseWriter, status int, message string) {
-	h.respondJSON(w, status, ErrorResponse{
+	respondJSON(w, h.logger, status, ErrorResponse{
 		Error: message,
 	})
 }
@@ -3,44 +3,58 @@ package handler
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/breakglass"
+	"github.com/yovily/customers/citi/auth-service/pkg/roles"
+	"github.com/yovily/customers/citi/auth-service/pkg/throttle"
 )
 
-// Add after the imports
-type AuthResult struct {
-	Success bool
-}
-
-// Mock LDAP Client
-type mockLDAPClient struct {
+// Mock Authenticator
+type mockAuthenticator struct {
 	shouldSucceed bool
+	err           error
+	calls         int
 	lastUsername  string
 	lastPassword  string
 }
 
-func (m *mockLDAPClient) Authenticate(username, password string) (*ldap.AuthResult, error) {
+func (m *mockAuthenticator) Authenticate(username, password string) (*authn.Identity, error) {
+	m.calls++
 	m.lastUsername = username
 	m.lastPassword = password
+	if m.err != nil {
+		return nil, m.err
+	}
 	if m.shouldSucceed {
-		return &ldap.AuthResult{Success: true}, nil
+		_, domain := authn.SplitUsername(username)
+		return &authn.Identity{ID: authn.CanonicalID(username), Username: username, Domain: domain}, nil
 	}
-	return &ldap.AuthResult{Success: false}, nil
+	return nil, authn.ErrInvalidCredentials
 }
 
 // Mock Auth Client
 type mockAuthClient struct {
 	token     string
 	shouldErr bool
+	lastUser  string
+	lastOpts  []auth.TokenOption
 }
 
-func (m *mockAuthClient) GenerateToken(userID string) (string, error) {
+func (m *mockAuthClient) GenerateToken(userID string, opts ...auth.TokenOption) (string, error) {
+	m.lastUser = userID
+	m.lastOpts = opts
 	if m.shouldErr {
 		return "", fmt.Errorf("token generation failed")
 	}
@@ -85,10 +99,10 @@ func TestHandleAuthentication(t *testing.T) {
 			tokenSuccess: true,
 			mockToken:    "valid.jwt.token",
 			wantStatus:   http.StatusOK,
+			// Without role mappings a requested role is not echoed back
 			wantResponse: AuthResponse{
 				UserID:          "testuser",
 				IsAuthenticated: true,
-				Role:            "user",
 				Token:           "valid.jwt.token",
 			},
 		},
@@ -136,7 +150,7 @@ func TestHandleAuthentication(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup mocks
-			ldapClient := &mockLDAPClient{shouldSucceed: tt.ldapSuccess}
+			ldapClient := &mockAuthenticator{shouldSucceed: tt.ldapSuccess}
 			authClient := &mockAuthClient{
 				token:     tt.mockToken,
 				shouldErr: !tt.tokenSuccess,
@@ -194,3 +208,373 @@ func TestHandleAuthentication(t *testing.T) {
 		})
 	}
 }
+
+func postAuth(handler *AuthHandler, request AuthRequest, remoteAddr string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/auth", bytes.NewBuffer(body))
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	handler.HandleAuthentication(rr, req)
+	return rr
+}
+
+func TestHandleAuthenticationThrottle(t *testing.T) {
+	ldapClient := &mockAuthenticator{shouldSucceed: false}
+	limiter := throttle.New(throttle.Config{
+		FreeAttempts:     1,
+		BaseDelay:        time.Minute,
+		LockoutThreshold: 10,
+	}, &mockLogger{})
+	handler := NewAuthHandler(ldapClient, &mockAuthClient{token: "t"}, &mockLogger{}, WithThrottle(limiter))
+
+	request := AuthRequest{UserID: "testuser", Password: "wrong", Domain: "example.com"}
+
+	for i := 0; i < 2; i++ {
+		if rr := postAuth(handler, request, "10.0.0.1:5000"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %v, want %v", i+1, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rr := postAuth(handler, request, "10.0.0.1:5000")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("throttled attempt status = %v, want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if ldapClient.calls != 2 {
+		t.Errorf("LDAP bind called %d times, want 2 (throttled attempt must not reach LDAP)", ldapClient.calls)
+	}
+
+	// Case and domain variations map to the same account
+	request.UserID = "TESTUSER"
+	if rr := postAuth(handler, request, "10.0.0.2:5000"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("variant username status = %v, want %v", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandleAuthenticationThrottleIgnoresOutages(t *testing.T) {
+	ldapClient := &mockAuthenticator{err: fmt.Errorf("failed to connect to LDAP: %w", authn.ErrUnavailable)}
+	limiter := throttle.New(throttle.Config{FreeAttempts: 1, LockoutThreshold: 2}, &mockLogger{})
+	handler := NewAuthHandler(ldapClient, &mockAuthClient{token: "t"}, &mockLogger{}, WithThrottle(limiter))
+
+	request := AuthRequest{UserID: "testuser", Password: "secret", Domain: "example.com"}
+	for i := 0; i < 5; i++ {
+		if rr := postAuth(handler, request, "10.0.0.1:5000"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %v, want %v", i+1, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+type mockBreakGlass struct {
+	designated map[string]bool
+	account    *breakglass.Account
+	calls      int
+}
+
+func (m *mockBreakGlass) IsDesignated(username string) bool {
+	return m.designated[username]
+}
+
+func (m *mockBreakGlass) Authenticate(username, password string) (*breakglass.Account, error) {
+	m.calls++
+	if m.account == nil || username != m.account.Username {
+		return nil, breakglass.ErrUnknownAccount
+	}
+	if password != "local-pass" {
+		return nil, breakglass.ErrInvalidCredentials
+	}
+	return m.account, nil
+}
+
+func TestHandleAuthenticationBreakGlass(t *testing.T) {
+	account := &breakglass.Account{Username: "oncall", Roles: []string{"operator"}}
+
+	tests := []struct {
+		name          string
+		ldapErr       error
+		designated    bool
+		request       AuthRequest
+		wantStatus    int
+		wantLocal     bool
+		wantLDAPCalls int
+	}{
+		{
+			name:          "directory outage falls back to local account",
+			ldapErr:       fmt.Errorf("connect: %w", authn.ErrUnavailable),
+			request:       AuthRequest{UserID: "oncall", Password: "local-pass", Domain: "example.com", Role: "operator"},
+			wantStatus:    http.StatusOK,
+			wantLocal:     true,
+			wantLDAPCalls: 1,
+		},
+		{
+			name:          "designated account skips LDAP",
+			designated:    true,
+			request:       AuthRequest{UserID: "oncall", Password: "local-pass", Domain: "example.com"},
+			wantStatus:    http.StatusOK,
+			wantLocal:     true,
+			wantLDAPCalls: 0,
+		},
+		{
+			name:          "invalid credentials never fall back",
+			ldapErr:       fmt.Errorf("bind: %w", authn.ErrInvalidCredentials),
+			request:       AuthRequest{UserID: "oncall", Password: "local-pass", Domain: "example.com"},
+			wantStatus:    http.StatusUnauthorized,
+			wantLDAPCalls: 1,
+		},
+		{
+			name:          "wrong local password",
+			ldapErr:       authn.ErrUnavailable,
+			request:       AuthRequest{UserID: "oncall", Password: "guess", Domain: "example.com"},
+			wantStatus:    http.StatusUnauthorized,
+			wantLDAPCalls: 1,
+		},
+		{
+			name:          "role outside allowed roles",
+			ldapErr:       authn.ErrUnavailable,
+			request:       AuthRequest{UserID: "oncall", Password: "local-pass", Domain: "example.com", Role: "admin"},
+			wantStatus:    http.StatusForbidden,
+			wantLDAPCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ldapClient := &mockAuthenticator{err: tt.ldapErr}
+			authClient := &mockAuthClient{token: "local.jwt.token"}
+			store := &mockBreakGlass{
+				designated: map[string]bool{"oncall": tt.designated},
+				account:    account,
+			}
+			logger := &mockLogger{}
+			handler := NewAuthHandler(ldapClient, authClient, logger, WithBreakGlass(store))
+
+			rr := postAuth(handler, tt.request, "10.0.0.1:5000")
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+			if ldapClient.calls != tt.wantLDAPCalls {
+				t.Errorf("LDAP calls = %d, want %d", ldapClient.calls, tt.wantLDAPCalls)
+			}
+			if errors.Is(tt.ldapErr, authn.ErrInvalidCredentials) && store.calls != 0 {
+				t.Error("break-glass store must not be consulted for rejected credentials")
+			}
+			if !tt.wantLocal {
+				return
+			}
+
+			client := auth.NewClient(auth.Config{JWTSecret: []byte("s"), TokenDuration: time.Minute})
+			token, _ := client.GenerateToken(authClient.lastUser, authClient.lastOpts...)
+			if !strings.Contains(decodeClaims(t, token), `"amr":["pwd","local"]`) {
+				t.Errorf("token claims = %s, want amr [pwd local]", decodeClaims(t, token))
+			}
+			if len(logger.errorMsgs) == 0 || logger.errorMsgs[len(logger.errorMsgs)-1] != "Break-glass login succeeded" {
+				t.Errorf("expected audit entry, got %v", logger.errorMsgs)
+			}
+		})
+	}
+}
+
+func TestHandleAuthenticationBreakGlassOutageThrottle(t *testing.T) {
+	limiter := throttle.New(throttle.Config{SprayThreshold: 3}, &mockLogger{})
+	store := &mockBreakGlass{account: &breakglass.Account{Username: "oncall", Roles: []string{"operator"}}}
+	handler := NewAuthHandler(&mockAuthenticator{err: authn.ErrUnavailable}, &mockAuthClient{token: "local.jwt.token"}, &mockLogger{},
+		WithBreakGlass(store), WithThrottle(limiter))
+
+	// Directory users behind one proxy all fall through to the local store
+	for i := 0; i < 5; i++ {
+		rr := postAuth(handler, AuthRequest{UserID: fmt.Sprintf("user%d", i), Password: "s3cret", Domain: "example.com"}, "10.0.0.1:5000")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("user%d: status = %d, want %d", i, rr.Code, http.StatusUnauthorized)
+		}
+	}
+	rr := postAuth(handler, AuthRequest{UserID: "oncall", Password: "local-pass", Domain: "example.com"}, "10.0.0.1:5000")
+	if rr.Code != http.StatusOK {
+		t.Errorf("operator behind the same IP: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	// Wrong passwords for break-glass accounts still count
+	for i := 0; i < 3; i++ {
+		postAuth(handler, AuthRequest{UserID: "oncall", Password: "guess", Domain: "example.com"}, "10.0.0.2:5000")
+	}
+	if d := limiter.Check("oncall@example.com", "10.0.0.2"); d.Allowed {
+		t.Error("failed break-glass logins were not counted")
+	}
+}
+
+// decodeClaims returns the raw JSON payload of a token
+func decodeClaims(t *testing.T, token string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token %q", token)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	return string(payload)
+}
+
+type offlineAuthenticator struct{}
+
+func (offlineAuthenticator) Authenticate(username, password string) (*authn.Identity, error) {
+	return &authn.Identity{ID: "jdoe", Methods: []string{"pwd", authn.AMROffline}, Offline: true}, nil
+}
+
+func TestHandleAuthenticationOffline(t *testing.T) {
+	authClient := &mockAuthClient{token: "offline.jwt.token"}
+	handler := NewAuthHandler(offlineAuthenticator{}, authClient, &mockLogger{}, WithOfflineTokenLifetime(5*time.Minute))
+
+	rr := postAuth(handler, AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"}, "10.0.0.1:5000")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", rr.Code, http.StatusOK)
+	}
+
+	client := auth.NewClient(auth.Config{JWTSecret: []byte("s"), TokenDuration: time.Hour})
+	token, _ := client.GenerateToken(authClient.lastUser, authClient.lastOpts...)
+	claims := decodeClaims(t, token)
+	if !strings.Contains(claims, `"offline_verified":true`) || !strings.Contains(claims, `"amr":["pwd","offline"]`) {
+		t.Errorf("token claims = %s, want offline marker", claims)
+	}
+
+	var payload struct{ Exp int64 }
+	json.Unmarshal([]byte(claims), &payload)
+	if lifetime := time.Until(time.Unix(payload.Exp, 0)); lifetime > 5*time.Minute {
+		t.Errorf("offline token lifetime = %v, want at most 5m", lifetime)
+	}
+}
+
+type groupAuthenticator struct {
+	groups []string
+}
+
+func (a groupAuthenticator) Authenticate(username, password string) (*authn.Identity, error) {
+	return &authn.Identity{ID: "jdoe", Groups: a.groups, Backend: authn.BackendLDAP}, nil
+}
+
+func TestHandleAuthenticationRoleMapping(t *testing.T) {
+	mapper, err := roles.New(roles.Config{
+		Default: roles.Profile{Rules: []roles.Rule{{Group: "staff", Roles: []string{"user"}}}},
+		Applications: map[string]roles.Profile{
+			"payments": {Rules: []roles.Rule{{Group: "payments-admins", Roles: []string{"admin"}}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("roles.New() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		request      AuthRequest
+		wantStatus   int
+		wantRoles    string
+		wantAudience string
+		wantAudit    string
+	}{
+		{
+			name:       "roles come from groups",
+			request:    AuthRequest{UserID: "jdoe", Password: "s3cret"},
+			wantStatus: http.StatusOK,
+			wantRoles:  `"roles":["user"]`,
+		},
+		{
+			name:         "application roles and audience",
+			request:      AuthRequest{UserID: "jdoe", Password: "s3cret", Application: "payments"},
+			wantStatus:   http.StatusOK,
+			wantRoles:    `"roles":["admin","user"]`,
+			wantAudience: `"aud":["payments"]`,
+		},
+		{
+			name:       "requested role narrows the token",
+			request:    AuthRequest{UserID: "jdoe", Password: "s3cret", Role: "admin", Application: "payments"},
+			wantStatus: http.StatusOK,
+			wantRoles:  `"roles":["admin"]`,
+		},
+		{
+			name:       "role the user does not hold",
+			request:    AuthRequest{UserID: "jdoe", Password: "s3cret", Role: "admin"},
+			wantStatus: http.StatusForbidden,
+			wantAudit:  "Login denied role",
+		},
+		{
+			name:       "unknown application",
+			request:    AuthRequest{UserID: "jdoe", Password: "s3cret", Application: "ledger"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := groupAuthenticator{groups: []string{"CN=Staff,OU=Groups,DC=corp", "CN=Payments-Admins,OU=Groups,DC=corp"}}
+			authClient := &mockAuthClient{token: "mapped.jwt.token"}
+			logger := &mockLogger{}
+			handler := NewAuthHandler(authenticator, authClient, logger, WithRoleMapper(mapper))
+
+			rr := postAuth(handler, tt.request, "10.0.0.1:5000")
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantAudit != "" && (len(logger.errorMsgs) == 0 || logger.errorMsgs[len(logger.errorMsgs)-1] != tt.wantAudit) {
+				t.Errorf("expected audit entry %q, got %v", tt.wantAudit, logger.errorMsgs)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response AuthResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			if response.Role != tt.request.Role {
+				t.Errorf("response role = %q, want %q", response.Role, tt.request.Role)
+			}
+
+			client := auth.NewClient(auth.Config{JWTSecret: []byte("s"), TokenDuration: time.Minute})
+			token, _ := client.GenerateToken(authClient.lastUser, authClient.lastOpts...)
+			claims := decodeClaims(t, token)
+			if !strings.Contains(claims, tt.wantRoles) {
+				t.Errorf("token claims = %s, want %s", claims, tt.wantRoles)
+			}
+			if tt.wantAudience != "" && !strings.Contains(claims, tt.wantAudience) {
+				t.Errorf("token claims = %s, want %s", claims, tt.wantAudience)
+			}
+		})
+	}
+}
+
+func TestHandleAuthenticationStartsSession(t *testing.T) {
+	sessions := auth.NewSessions(auth.SessionConfig{Store: auth.NewMemorySessionStore()})
+	authClient := &mockAuthClient{token: "session.jwt.token"}
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, authClient, &mockLogger{}, WithSessions(sessions))
+
+	body, _ := json.Marshal(AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"})
+	req := httptest.NewRequest(http.MethodPost, "/auth", bytes.NewBuffer(body))
+	// A session planted before login must not survive it
+	req.AddCookie(&http.Cookie{Name: auth.DefaultSessionCookieName, Value: "planted"})
+	rr := httptest.NewRecorder()
+	sessions.Middleware(http.HandlerFunc(handler.HandleAuthentication)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", rr.Code, http.StatusOK)
+	}
+	var cookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == auth.DefaultSessionCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "planted" {
+		t.Fatalf("session cookie = %+v, want a new session", cookie)
+	}
+
+	listed, err := sessions.UserSessions("jdoe")
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("UserSessions() = %v, %v; want one session", listed, err)
+	}
+
+	client := auth.NewClient(auth.Config{JWTSecret: []byte("s"), TokenDuration: time.Minute})
+	token, _ := client.GenerateToken(authClient.lastUser, authClient.lastOpts...)
+	if claims := decodeClaims(t, token); !strings.Contains(claims, `"sid":"`+listed[0].ID+`"`) {
+		t.Errorf("token claims = %s, want sid %s", claims, listed[0].ID)
+	}
+}
@@ -0,0 +1,72 @@
+// internal/handler/client_credentials.go
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+)
+
+// clientCredentialsGrant issues a token to a registered non-human client
+// acting on its own behalf (RFC 6749 section 4.4). No user and no directory
+// are involved; the token's subject is the client.
+func (h *OIDCProvider) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.authenticateClient(r)
+	if err == nil && client.Public() {
+		err = oauth.ErrInvalidClient
+	}
+	if err != nil {
+		h.respondInvalidClient(w, r, err)
+		return
+	}
+	if !client.AllowsGrantType(oauth.GrantClientCredentials) {
+		audit(h.logger, "Client credentials grant not allowed", "clientID", client.ID, "ip", clientIP(r))
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	binding, err := h.tokenBinding(r, client)
+	if err != nil {
+		h.respondBindingError(w, client, err)
+		return
+	}
+
+	scopes, err := client.GrantScopes(strings.Fields(r.PostForm.Get("scope")))
+	if errors.Is(err, oauth.ErrInvalidScope) {
+		audit(h.logger, "Client requested unregistered scope", "clientID", client.ID, "scope", r.PostForm.Get("scope"))
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_scope", "")
+		return
+	}
+
+	lifetime := h.accessTokenLifetime(client)
+	opts := []auth.TokenOption{
+		auth.AsClientToken(),
+		auth.WithClientID(client.ID),
+		auth.WithScope(scopes...),
+		auth.WithLifetime(lifetime),
+	}
+	if audience := client.TokenAudience(); audience != "" {
+		opts = append(opts, auth.ForAudience(audience))
+	}
+	opts = append(opts, binding.opts...)
+
+	token, err := h.tokens.GenerateToken(client.ID, opts...)
+	if err != nil {
+		h.logger.Error("Token issuance failed", "clientID", client.ID, "error", err)
+		respondOAuthError(w, h.logger, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	audit(h.logger, "Client token issued", "clientID", client.ID, "scope", scopes, "audience", client.TokenAudience())
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, http.StatusOK, TokenResponse{
+		AccessToken: token,
+		TokenType:   binding.tokenType,
+		ExpiresIn:   int(lifetime / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+const testTokenEndpoint = "https://auth.example.com/token"
+
+// newClientGrantProvider serves the token endpoint for a registry with a
+// secret client, a private_key_jwt client and a browser client
+func newClientGrantProvider(t *testing.T) (*OIDCProvider, *auth.Client, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	hash, err := passwd.Hash("batch-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := auth.PublicJWK(&key.PublicKey)
+	jwk.Kid = "sync-1"
+	jwks, _ := json.Marshal(auth.JWKSet{Keys: []auth.JWK{jwk}})
+
+	path := filepath.Join(t.TempDir(), "clients.json")
+	os.WriteFile(path, []byte(`{"clients": [
+		{"client_id": "batch", "client_secret_hash": "`+hash+`", "grant_types": ["client_credentials"],
+		 "scopes": ["ledger:read", "ledger:write"], "audience": "ledger", "access_token_lifetime": "2m"},
+		{"client_id": "sync", "jwks": `+string(jwks)+`, "grant_types": ["client_credentials"], "scopes": ["directory:read"]},
+		{"client_id": "portal", "client_secret_hash": "`+hash+`", "redirect_uris": ["https://portal.example.com/cb"]}
+	]}`), 0o600)
+	registry, err := oauth.LoadRegistry(path, oauth.WithAssertionAudience(testTokenEndpoint))
+	if err != nil {
+		t.Fatalf("LoadRegistry() error = %v", err)
+	}
+
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	provider := NewOIDCProvider(nil, registry, nil, tokens, ProviderConfig{}, &mockLogger{})
+	return provider, tokens, key
+}
+
+func postToken(h *OIDCProvider, form url.Values, clientID, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, TokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	rr := httptest.NewRecorder()
+	h.HandleToken(rr, req)
+	return rr
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	provider, tokens, _ := newClientGrantProvider(t)
+
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"ledger:read"}}
+	rr := postToken(provider, form, "batch", "batch-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response TokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.ExpiresIn != 120 || response.Scope != "ledger:read" || response.IDToken != "" || response.RefreshToken != "" {
+		t.Errorf("response = %+v", response)
+	}
+
+	claims, err := tokens.ValidateToken(response.AccessToken, auth.WithAudience("ledger"))
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if !claims.ClientToken() || claims.UserID() != "batch" || claims.ClientID != "batch" || claims.Scope != "ledger:read" {
+		t.Errorf("claims = %+v, want a client token for batch", claims)
+	}
+
+	// Without a scope parameter every registered scope is granted
+	rr = postToken(provider, url.Values{"grant_type": {"client_credentials"}}, "batch", "batch-secret")
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Scope != "ledger:read ledger:write" {
+		t.Errorf("scope = %q, want all registered scopes", response.Scope)
+	}
+}
+
+func TestClientCredentialsGrantPrivateKeyJWT(t *testing.T) {
+	provider, tokens, key := newClientGrantProvider(t)
+
+	assertion := func(jti string) string {
+		signed, _ := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    "sync",
+			Subject:   "sync",
+			Audience:  jwt.ClaimStrings{testTokenEndpoint},
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}).SignedString(key)
+		return signed
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {oauth.ClientAssertionType},
+		"client_assertion":      {assertion("1")},
+	}
+
+	rr := postToken(provider, form, "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response TokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if claims, err := tokens.ValidateToken(response.AccessToken); err != nil || claims.UserID() != "sync" {
+		t.Errorf("ValidateToken() = %+v, %v; want a token for sync", claims, err)
+	}
+
+	if rr := postToken(provider, form, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed assertion: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestClientCredentialsGrantRejects(t *testing.T) {
+	provider, _, _ := newClientGrantProvider(t)
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		clientID   string
+		secret     string
+		wantStatus int
+		wantError  string
+	}{
+		{"wrong secret", url.Values{}, "batch", "wrong", http.StatusUnauthorized, "invalid_client"},
+		{"no credentials", url.Values{"client_id": {"batch"}}, "", "", http.StatusUnauthorized, "invalid_client"},
+		{"grant not registered", url.Values{}, "portal", "batch-secret", http.StatusBadRequest, "unauthorized_client"},
+		{"unregistered scope", url.Values{"scope": {"ledger:admin"}}, "batch", "batch-secret", http.StatusBadRequest, "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Set("grant_type", "client_credentials")
+			rr := postToken(provider, tt.form, tt.clientID, tt.secret)
+			var body OAuthError
+			json.NewDecoder(rr.Body).Decode(&body)
+			if rr.Code != tt.wantStatus || body.Error != tt.wantError {
+				t.Errorf("got %d %q, want %d %q", rr.Code, body.Error, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+}
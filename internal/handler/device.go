@@ -0,0 +1,221 @@
+// internal/handler/device.go
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+)
+
+// Paths of the device authorization grant endpoints
+const (
+	DeviceAuthorizationPath = "/device_authorization"
+	DeviceVerificationPath  = "/device"
+)
+
+// DeviceAuthorizationResponse is the RFC 8628 section 3.2 response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// HandleDeviceAuthorization starts the device flow for input constrained
+// clients such as CLIs (RFC 8628). The client shows the user code and
+// verification URI, then polls the token endpoint with the device code.
+func (h *OIDCProvider) HandleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondOAuthError(w, h.logger, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.respondInvalidClient(w, r, err)
+		return
+	}
+	if !client.AllowsGrantType(oauth.GrantDeviceCode) {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+	scope := strings.Fields(r.PostForm.Get("scope"))
+	if err := checkUserScopes(client, scope); err != nil {
+		audit(h.logger, "Client requested unregistered scope", "clientID", client.ID, "scope", r.PostForm.Get("scope"))
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_scope", "")
+		return
+	}
+
+	deviceCode, userCode, err := oauth.StartDeviceAuthorization(h.config.Devices, oauth.DeviceGrant{
+		ClientID:  client.ID,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(h.config.DeviceCodeLifetime),
+		Interval:  h.config.DevicePollInterval,
+	})
+	if err != nil {
+		h.logger.Error("Device authorization failed", "clientID", client.ID, "error", err)
+		respondOAuthError(w, h.logger, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	response := DeviceAuthorizationResponse{
+		DeviceCode:      deviceCode,
+		UserCode:        userCode,
+		VerificationURI: h.config.VerificationURI,
+		ExpiresIn:       int(h.config.DeviceCodeLifetime / time.Second),
+		Interval:        int(h.config.DevicePollInterval / time.Second),
+	}
+	if h.config.VerificationURI != "" {
+		response.VerificationURIComplete = h.config.VerificationURI + "?user_code=" + oauth.NormalizeUserCode(userCode)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, http.StatusOK, response)
+}
+
+// HandleDeviceVerification is the hosted page where users enter the user
+// code shown by their device, check which application asks for access and
+// approve by signing in, or deny. Approval always takes the user's
+// credentials, so a forged form post cannot approve with a browser session.
+func (h *OIDCProvider) HandleDeviceVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Invalid request.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderError(w, http.StatusBadRequest, "Invalid request.")
+		return
+	}
+
+	data := devicePageData{Action: r.URL.Path, UserCode: r.Form.Get("user_code"), MFA: h.config.MFA != nil}
+	if data.UserCode == "" {
+		h.renderPage(w, http.StatusOK, deviceCodePage, data)
+		return
+	}
+
+	grant, err := oauth.PendingDeviceGrant(h.config.Devices, data.UserCode, time.Now())
+	if err == nil {
+		var client *oauth.Client
+		if client, err = h.clients.Client(grant.ClientID); err == nil {
+			data.Client = client.ID
+			if client.Name != "" {
+				data.Client = client.Name
+			}
+		}
+	}
+	if err != nil {
+		data.Error = "The code is invalid or has expired. Check the code shown on your device."
+		h.renderPage(w, http.StatusBadRequest, deviceCodePage, data)
+		return
+	}
+	data.UserCode = oauth.FormatUserCode(oauth.NormalizeUserCode(data.UserCode))
+	data.Scope = grant.Scope
+
+	if r.Method == http.MethodGet {
+		h.renderPage(w, http.StatusOK, deviceConfirmPage, data)
+		return
+	}
+
+	if r.PostForm.Get("action") == "deny" {
+		if err := oauth.DecideDeviceGrant(h.config.Devices, data.UserCode, time.Now(), nil); err != nil {
+			h.renderError(w, http.StatusBadRequest, "The code is invalid or has expired.")
+			return
+		}
+		audit(h.logger, "Device authorization denied", "clientID", grant.ClientID, "ip", clientIP(r))
+		h.renderPage(w, http.StatusOK, messagePage, pageMessage{"Access denied", "The device was not given access. You can close this window."})
+		return
+	}
+
+	data.Username = strings.TrimSpace(r.PostForm.Get("username"))
+	identity, failure := h.checkCredentials(w, r, data.Username, r.PostForm.Get("password"))
+	if failure != nil {
+		data.Error = failure.message
+		h.renderPage(w, failure.status, deviceConfirmPage, data)
+		return
+	}
+
+	now := time.Now()
+	err = oauth.DecideDeviceGrant(h.config.Devices, data.UserCode, now, func(grant *oauth.DeviceGrant) {
+		grant.UserID = identity.ID
+		grant.AuthTime = now
+		grant.AMR = identityMethods(identity)
+		grant.Name = identity.Name
+		grant.Email = identity.Email
+		grant.Groups = identity.Groups
+		grant.Attributes = identity.Attributes
+		grant.Offline = identity.Offline
+	})
+	if err != nil {
+		h.renderError(w, http.StatusBadRequest, "The code is invalid or has expired.")
+		return
+	}
+	audit(h.logger, "Device authorization approved", "clientID", grant.ClientID, "userID", identity.ID, "offline", identity.Offline, "ip", clientIP(r))
+	h.renderPage(w, http.StatusOK, messagePage, pageMessage{"Device connected", "You can close this window and return to your device."})
+}
+
+// deviceCodeGrant answers a device polling the token endpoint
+func (h *OIDCProvider) deviceCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.respondInvalidClient(w, r, err)
+		return
+	}
+	if !client.AllowsGrantType(oauth.GrantDeviceCode) {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	binding, err := h.tokenBinding(r, client)
+	if err != nil {
+		h.respondBindingError(w, client, err)
+		return
+	}
+
+	grant, err := oauth.PollDeviceCode(h.config.Devices, r.PostForm.Get("device_code"), time.Now())
+	switch {
+	case errors.Is(err, oauth.ErrAuthorizationPending), errors.Is(err, oauth.ErrSlowDown),
+		errors.Is(err, oauth.ErrAccessDenied), errors.Is(err, oauth.ErrExpiredToken):
+		// The sentinel errors carry the OAuth error codes
+		respondOAuthError(w, h.logger, http.StatusBadRequest, err.Error(), "")
+		return
+	case err != nil:
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	if grant.ClientID != client.ID {
+		audit(h.logger, "Device code presented by wrong client", "clientID", client.ID, "codeClient", grant.ClientID)
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	response, err := h.issueTokens(client, &oauth.AuthorizationCode{
+		ClientID:   grant.ClientID,
+		Scope:      grant.Scope,
+		UserID:     grant.UserID,
+		AuthTime:   grant.AuthTime,
+		AMR:        grant.AMR,
+		Name:       grant.Name,
+		Email:      grant.Email,
+		Groups:     grant.Groups,
+		Attributes: grant.Attributes,
+		Offline:    grant.Offline,
+	}, binding)
+	if err != nil {
+		h.logger.Error("Token issuance failed", "clientID", client.ID, "error", err)
+		respondOAuthError(w, h.logger, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, http.StatusOK, response)
+}
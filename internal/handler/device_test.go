@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+)
+
+func newDeviceProvider(t *testing.T) (*OIDCProvider, *auth.Client) {
+	t.Helper()
+
+	clients := oauth.StaticRegistry{
+		"cli": {ID: "cli", Name: "Deploy CLI", GrantTypes: []string{oauth.GrantDeviceCode}, Scopes: []string{"deploy"}},
+		"spa": {ID: "spa", RedirectURIs: []string{testRedirectURI}},
+	}
+	authenticator := authenticatorFunc(func(username, password string) (*authn.Identity, error) {
+		if username != "jdoe@example.com" || password != "s3cret" {
+			return nil, authn.ErrInvalidCredentials
+		}
+		return &authn.Identity{ID: "jdoe", Name: "Jane Doe", Email: "jdoe@example.com"}, nil
+	})
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Minute})
+	provider := NewOIDCProvider(authenticator, clients, nil, tokens, ProviderConfig{
+		DefaultDomain:   "example.com",
+		VerificationURI: "https://auth.example.com/device",
+	}, &mockLogger{})
+	return provider, tokens
+}
+
+func startDevice(t *testing.T, h *OIDCProvider, clientID string) DeviceAuthorizationResponse {
+	t.Helper()
+	form := url.Values{"client_id": {clientID}, "scope": {"openid profile"}}
+	req := httptest.NewRequest(http.MethodPost, DeviceAuthorizationPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.HandleDeviceAuthorization(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("device authorization status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response DeviceAuthorizationResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return response
+}
+
+func pollDevice(h *OIDCProvider, deviceCode string) (*httptest.ResponseRecorder, OAuthError) {
+	form := url.Values{"grant_type": {oauth.GrantDeviceCode}, "client_id": {"cli"}, "device_code": {deviceCode}}
+	rr := postToken(h, form, "", "")
+	var body OAuthError
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	return rr, body
+}
+
+func verifyDevice(h *OIDCProvider, method string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, DeviceVerificationPath+"?"+form.Encode(), nil)
+	} else {
+		req = httptest.NewRequest(method, DeviceVerificationPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rr := httptest.NewRecorder()
+	h.HandleDeviceVerification(rr, req)
+	return rr
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	provider, _ := newDeviceProvider(t)
+
+	response := startDevice(t, provider, "cli")
+	if response.DeviceCode == "" || len(response.UserCode) != 9 || response.ExpiresIn != 600 || response.Interval != 5 {
+		t.Errorf("response = %+v", response)
+	}
+	wantComplete := "https://auth.example.com/device?user_code=" + oauth.NormalizeUserCode(response.UserCode)
+	if response.VerificationURI != "https://auth.example.com/device" || response.VerificationURIComplete != wantComplete {
+		t.Errorf("verification URIs = %q, %q", response.VerificationURI, response.VerificationURIComplete)
+	}
+
+	if rr, body := pollDevice(provider, response.DeviceCode); rr.Code != http.StatusBadRequest || body.Error != "authorization_pending" {
+		t.Errorf("first poll = %d %q, want authorization_pending", rr.Code, body.Error)
+	}
+	if _, body := pollDevice(provider, response.DeviceCode); body.Error != "slow_down" {
+		t.Errorf("immediate second poll error = %q, want slow_down", body.Error)
+	}
+	if _, body := pollDevice(provider, "unknown"); body.Error != "invalid_grant" {
+		t.Errorf("unknown device code error = %q, want invalid_grant", body.Error)
+	}
+}
+
+func TestDeviceAuthorizationRejectsClient(t *testing.T) {
+	provider, _ := newDeviceProvider(t)
+
+	form := url.Values{"client_id": {"spa"}}
+	req := httptest.NewRequest(http.MethodPost, DeviceAuthorizationPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	provider.HandleDeviceAuthorization(rr, req)
+
+	var body OAuthError
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusBadRequest || body.Error != "unauthorized_client" {
+		t.Errorf("got %d %q, want %d unauthorized_client", rr.Code, body.Error, http.StatusBadRequest)
+	}
+}
+
+func TestDeviceAuthorizationScope(t *testing.T) {
+	provider, _ := newDeviceProvider(t)
+
+	authorize := func(scope string) (int, string) {
+		form := url.Values{"client_id": {"cli"}, "scope": {scope}}
+		req := httptest.NewRequest(http.MethodPost, DeviceAuthorizationPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		provider.HandleDeviceAuthorization(rr, req)
+		var body OAuthError
+		json.NewDecoder(rr.Body).Decode(&body)
+		return rr.Code, body.Error
+	}
+
+	if status, _ := authorize("openid deploy"); status != http.StatusOK {
+		t.Errorf("registered scope: status = %d, want %d", status, http.StatusOK)
+	}
+	if status, code := authorize("openid admin"); status != http.StatusBadRequest || code != "invalid_scope" {
+		t.Errorf("unregistered scope: got %d %q, want %d invalid_scope", status, code, http.StatusBadRequest)
+	}
+}
+
+func TestDeviceVerificationApprove(t *testing.T) {
+	provider, tokens := newDeviceProvider(t)
+	response := startDevice(t, provider, "cli")
+
+	rr := verifyDevice(provider, http.MethodGet, url.Values{"user_code": {"nope-nope"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid code: status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	// Users may type the code in lower case and without the dash
+	typed := strings.ToLower(oauth.NormalizeUserCode(response.UserCode))
+	rr = verifyDevice(provider, http.MethodGet, url.Values{"user_code": {typed}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Deploy CLI") || !strings.Contains(rr.Body.String(), response.UserCode) {
+		t.Fatalf("confirm page: status = %d, body = %s", rr.Code, rr.Body)
+	}
+
+	approve := url.Values{"user_code": {response.UserCode}, "action": {"approve"}, "username": {"jdoe"}, "password": {"wrong"}}
+	if rr := verifyDevice(provider, http.MethodPost, approve); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if _, body := pollDevice(provider, response.DeviceCode); body.Error != "authorization_pending" {
+		t.Errorf("poll after failed sign-in error = %q, want authorization_pending", body.Error)
+	}
+
+	approve.Set("password", "s3cret")
+	if rr := verifyDevice(provider, http.MethodPost, approve); rr.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if rr := verifyDevice(provider, http.MethodPost, approve); rr.Code != http.StatusBadRequest {
+		t.Errorf("second approval: status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	// Forget the last poll so the device is not told to slow down
+	provider.config.Devices.UpdateByUserCode(oauth.NormalizeUserCode(response.UserCode), func(g *oauth.DeviceGrant) error {
+		g.LastPoll = time.Time{}
+		return nil
+	})
+	rr, _ = pollDevice(provider, response.DeviceCode)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("poll after approval: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var tokenResponse TokenResponse
+	json.NewDecoder(rr.Body).Decode(&tokenResponse)
+	if tokenResponse.IDToken == "" || tokenResponse.Scope != "openid profile" {
+		t.Errorf("token response = %+v", tokenResponse)
+	}
+	claims, err := tokens.ValidateToken(tokenResponse.AccessToken)
+	if err != nil || claims.UserID() != "jdoe" || claims.ClientID != "cli" || claims.Name != "Jane Doe" {
+		t.Errorf("ValidateToken() = %+v, %v; want jdoe's token for cli", claims, err)
+	}
+
+	if _, body := pollDevice(provider, response.DeviceCode); body.Error != "invalid_grant" {
+		t.Errorf("redeemed device code error = %q, want invalid_grant", body.Error)
+	}
+}
+
+func TestDeviceVerificationOffline(t *testing.T) {
+	provider, tokens := newDeviceProvider(t)
+	provider.authenticator = authenticatorFunc(func(username, password string) (*authn.Identity, error) {
+		return &authn.Identity{ID: "jdoe", Methods: []string{auth.AMRPassword, authn.AMROffline}, Offline: true}, nil
+	})
+	provider.config.OfflineTokenLifetime = 30 * time.Second
+	response := startDevice(t, provider, "cli")
+
+	approve := url.Values{"user_code": {response.UserCode}, "action": {"approve"}, "username": {"jdoe"}, "password": {"s3cret"}}
+	if rr := verifyDevice(provider, http.MethodPost, approve); rr.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	rr, _ := pollDevice(provider, response.DeviceCode)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("poll after approval: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var tokenResponse TokenResponse
+	json.NewDecoder(rr.Body).Decode(&tokenResponse)
+	claims, err := tokens.ValidateToken(tokenResponse.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if !claims.OfflineVerified || tokenResponse.ExpiresIn != 30 || time.Until(claims.ExpiresAt.Time) > 30*time.Second {
+		t.Errorf("token = %+v, expires_in = %d; want a short-lived offline token", claims, tokenResponse.ExpiresIn)
+	}
+}
+
+func TestDeviceVerificationDeny(t *testing.T) {
+	provider, _ := newDeviceProvider(t)
+	response := startDevice(t, provider, "cli")
+
+	deny := url.Values{"user_code": {response.UserCode}, "action": {"deny"}}
+	if rr := verifyDevice(provider, http.MethodPost, deny); rr.Code != http.StatusOK {
+		t.Fatalf("deny: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if rr, body := pollDevice(provider, response.DeviceCode); rr.Code != http.StatusBadRequest || body.Error != "access_denied" {
+		t.Errorf("poll after denial = %d %q, want access_denied", rr.Code, body.Error)
+	}
+}
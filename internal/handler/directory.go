@@ -0,0 +1,309 @@
+// internal/handler/directory.go
+package handler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+)
+
+// Defaults for DirectoryConfig
+const (
+	DefaultDirectoryRequestsPerMinute = 60
+	DefaultDirectoryMaxResults        = 50
+)
+
+type DirectoryClient interface {
+	GetUser(id string, attributes []string) (*ldap.User, error)
+	GetUserByDN(dn string, attributes []string) (*ldap.User, error)
+	SearchUsers(req ldap.SearchRequest) (*ldap.SearchResult, error)
+	GroupMembers(group string, attributes []string) (*ldap.SearchResult, error)
+}
+
+type TokenValidator interface {
+	ValidateToken(token string, opts ...auth.ValidateOption) (*auth.Claims, error)
+}
+
+// DirectoryConfig controls what the directory endpoints expose
+type DirectoryConfig struct {
+	// AllowedAttributes lists the directory attributes callers may read in
+	// addition to ID, DN, name and email. Group membership is only returned
+	// when "memberOf" is allowed.
+	AllowedAttributes []string
+	// RequestsPerMinute and Burst size the per-caller rate limit
+	RequestsPerMinute int
+	Burst             int
+	// MaxResults caps the number of users a search may return
+	MaxResults int
+}
+
+type DirectoryUser struct {
+	ID         string
+	DN         string
+	Name       string
+	Email      string
+	Groups     []string            `json:",omitempty"`
+	Attributes map[string][]string `json:",omitempty"`
+}
+
+type DirectorySearchResponse struct {
+	Users     []DirectoryUser
+	Truncated bool
+}
+
+type DirectoryHandler struct {
+	directory DirectoryClient
+	tokens    TokenValidator
+	config    DirectoryConfig
+	limiter   *rateLimiter
+	logger    Logger
+}
+
+func NewDirectoryHandler(directory DirectoryClient, tokens TokenValidator, config DirectoryConfig, logger Logger) *DirectoryHandler {
+	if config.RequestsPerMinute <= 0 {
+		config.RequestsPerMinute = DefaultDirectoryRequestsPerMinute
+	}
+	if config.MaxResults <= 0 {
+		config.MaxResults = DefaultDirectoryMaxResults
+	}
+
+	return &DirectoryHandler{
+		directory: directory,
+		tokens:    tokens,
+		config:    config,
+		limiter:   newRateLimiter(config.RequestsPerMinute, config.Burst),
+		logger:    logger,
+	}
+}
+
+// HandleGetUser serves GET requests for a single user, selected by the "id"
+// or "dn" query parameter
+func (h *DirectoryHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	attrs, ok := h.requestedAttributes(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		user *ldap.User
+		err  error
+	)
+	query := r.URL.Query()
+	switch {
+	case query.Get("id") != "":
+		user, err = h.directory.GetUser(query.Get("id"), attrs)
+	case query.Get("dn") != "":
+		user, err = h.directory.GetUserByDN(query.Get("dn"), attrs)
+	default:
+		h.respondError(w, http.StatusBadRequest, "id or dn is required")
+		return
+	}
+
+	if err != nil {
+		h.directoryError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, h.toResponseUser(user, attrs))
+}
+
+// HandleSearchUsers serves prefix searches: ?q=<prefix>&by=id|name|email&limit=N
+func (h *DirectoryHandler) HandleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	attrs, ok := h.requestedAttributes(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := strings.TrimSpace(query.Get("q"))
+	if prefix == "" {
+		h.respondError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := h.config.MaxResults
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			h.respondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+
+	field := ldap.SearchField(query.Get("by"))
+	switch field {
+	case "", ldap.SearchByID, ldap.SearchByName, ldap.SearchByEmail:
+	default:
+		h.respondError(w, http.StatusBadRequest, "invalid search field")
+		return
+	}
+
+	result, err := h.directory.SearchUsers(ldap.SearchRequest{
+		Field:      field,
+		Prefix:     prefix,
+		Attributes: attrs,
+		Limit:      limit,
+	})
+	if err != nil {
+		h.directoryError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, h.toSearchResponse(result, attrs))
+}
+
+// HandleGroupMembers lists the members of the group named by the "group" query parameter
+func (h *DirectoryHandler) HandleGroupMembers(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	attrs, ok := h.requestedAttributes(w, r)
+	if !ok {
+		return
+	}
+
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		h.respondError(w, http.StatusBadRequest, "group is required")
+		return
+	}
+
+	result, err := h.directory.GroupMembers(group, attrs)
+	if err != nil {
+		h.directoryError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, h.toSearchResponse(result, attrs))
+}
+
+// authorize checks the method, the bearer token and the caller's rate limit
+func (h *DirectoryHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return false
+	}
+
+	claims, ok := bearerClaims(w, r, h.tokens, h.logger)
+	if !ok {
+		return false
+	}
+
+	if ok, wait := h.limiter.allow(claims.UserID()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		h.respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return false
+	}
+
+	return true
+}
+
+// requestedAttributes parses the comma separated "attributes" parameter and
+// checks it against the allowlist. Without the parameter every allowed
+// attribute is returned.
+func (h *DirectoryHandler) requestedAttributes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	raw := r.URL.Query().Get("attributes")
+	if raw == "" {
+		return h.config.AllowedAttributes, true
+	}
+
+	var attrs []string
+	for _, a := range strings.Split(raw, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !h.attributeAllowed(a) {
+			h.respondError(w, http.StatusBadRequest, "attribute not allowed: "+a)
+			return nil, false
+		}
+		attrs = append(attrs, a)
+	}
+
+	return attrs, true
+}
+
+func (h *DirectoryHandler) attributeAllowed(attr string) bool {
+	for _, a := range h.config.AllowedAttributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// toResponseUser copies only the allowed fields from a directory entry
+func (h *DirectoryHandler) toResponseUser(user *ldap.User, attrs []string) DirectoryUser {
+	out := DirectoryUser{
+		ID:    user.ID,
+		DN:    user.DN,
+		Name:  user.Name,
+		Email: user.Email,
+	}
+
+	for _, a := range attrs {
+		if strings.EqualFold(a, ldap.AttrMemberOf) {
+			out.Groups = user.Groups
+			continue
+		}
+		if !h.attributeAllowed(a) {
+			continue
+		}
+		if values, ok := user.Attributes[a]; ok {
+			if out.Attributes == nil {
+				out.Attributes = make(map[string][]string)
+			}
+			out.Attributes[a] = values
+		}
+	}
+
+	return out
+}
+
+func (h *DirectoryHandler) toSearchResponse(result *ldap.SearchResult, attrs []string) DirectorySearchResponse {
+	out := DirectorySearchResponse{
+		Users:     make([]DirectoryUser, 0, len(result.Users)),
+		Truncated: result.Truncated,
+	}
+	for _, user := range result.Users {
+		out.Users = append(out.Users, h.toResponseUser(user, attrs))
+	}
+	return out
+}
+
+func (h *DirectoryHandler) directoryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ldap.ErrNotFound) {
+		h.respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	h.logger.Error("Directory query failed", "error", err)
+	h.respondError(w, http.StatusInternalServerError, "directory query failed")
+}
+
+func (h *DirectoryHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	respondJSON(w, h.logger, status, data)
+}
+
+func (h *DirectoryHandler) respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, h.logger, status, ErrorResponse{
+		Error: message,
+	})
+}
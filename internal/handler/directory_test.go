@@ -0,0 +1,270 @@
+// internal/handler/directory_test.go
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+)
+
+type mockDirectory struct {
+	user      *ldap.User
+	result    *ldap.SearchResult
+	err       error
+	lastAttrs []string
+	lastQuery ldap.SearchRequest
+}
+
+func (m *mockDirectory) GetUser(id string, attributes []string) (*ldap.User, error) {
+	m.lastAttrs = attributes
+	return m.user, m.err
+}
+
+func (m *mockDirectory) GetUserByDN(dn string, attributes []string) (*ldap.User, error) {
+	m.lastAttrs = attributes
+	return m.user, m.err
+}
+
+func (m *mockDirectory) SearchUsers(req ldap.SearchRequest) (*ldap.SearchResult, error) {
+	m.lastQuery = req
+	m.lastAttrs = req.Attributes
+	return m.result, m.err
+}
+
+func (m *mockDirectory) GroupMembers(group string, attributes []string) (*ldap.SearchResult, error) {
+	m.lastAttrs = attributes
+	return m.result, m.err
+}
+
+type mockTokenValidator struct{}
+
+func (m *mockTokenValidator) ValidateToken(token string, opts ...auth.ValidateOption) (*auth.Claims, error) {
+	if token != "valid-token" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return &auth.Claims{Username: "caller"}, nil
+}
+
+func testDirectoryUser() *ldap.User {
+	return &ldap.User{
+		DN:     "CN=John Doe,OU=People,DC=example,DC=com",
+		ID:     "jdoe",
+		Name:   "John Doe",
+		Email:  "jdoe@example.com",
+		Groups: []string{"CN=Staff,OU=Groups,DC=example,DC=com"},
+		Attributes: map[string][]string{
+			"department":     {"Engineering"},
+			"employeeNumber": {"12345"},
+		},
+	}
+}
+
+func TestDirectoryGetUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		token      string
+		err        error
+		wantStatus int
+		wantGroups bool
+		wantAttrs  []string
+	}{
+		{
+			name:       "returns allowed attributes",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe",
+			token:      "valid-token",
+			wantStatus: http.StatusOK,
+			wantGroups: true,
+			wantAttrs:  []string{"department"},
+		},
+		{
+			name:       "memberOf allowlisted returns groups",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe&attributes=memberOf",
+			token:      "valid-token",
+			wantStatus: http.StatusOK,
+			wantGroups: true,
+		},
+		{
+			name:       "requested subset omits groups",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe&attributes=department",
+			token:      "valid-token",
+			wantStatus: http.StatusOK,
+			wantAttrs:  []string{"department"},
+		},
+		{
+			name:       "disallowed attribute",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe&attributes=employeeNumber",
+			token:      "valid-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing token",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid token",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe",
+			token:      "forged",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid method",
+			method:     http.MethodPost,
+			url:        "/directory/user?id=jdoe",
+			token:      "valid-token",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "missing id",
+			method:     http.MethodGet,
+			url:        "/directory/user",
+			token:      "valid-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not found",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=nobody",
+			token:      "valid-token",
+			err:        ldap.ErrNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "directory failure",
+			method:     http.MethodGet,
+			url:        "/directory/user?id=jdoe",
+			token:      "valid-token",
+			err:        fmt.Errorf("connection refused"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := &mockDirectory{user: testDirectoryUser(), err: tt.err}
+			handler := NewDirectoryHandler(dir, &mockTokenValidator{}, DirectoryConfig{
+				AllowedAttributes: []string{"department", "memberOf"},
+			}, &mockLogger{})
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.HandleGetUser(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("HandleGetUser() status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp DirectoryUser
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.ID != "jdoe" {
+				t.Errorf("ID = %v, want jdoe", resp.ID)
+			}
+			if (len(resp.Groups) > 0) != tt.wantGroups {
+				t.Errorf("Groups = %v, wantGroups %v", resp.Groups, tt.wantGroups)
+			}
+			if _, ok := resp.Attributes["employeeNumber"]; ok {
+				t.Error("employeeNumber must never be returned")
+			}
+			for _, a := range tt.wantAttrs {
+				if _, ok := resp.Attributes[a]; !ok {
+					t.Errorf("attribute %s missing from response", a)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectorySearchUsers(t *testing.T) {
+	dir := &mockDirectory{result: &ldap.SearchResult{
+		Users:     []*ldap.User{testDirectoryUser()},
+		Truncated: true,
+	}}
+	handler := NewDirectoryHandler(dir, &mockTokenValidator{}, DirectoryConfig{MaxResults: 10}, &mockLogger{})
+
+	req := httptest.NewRequest(http.MethodGet, "/directory/search?q=jd&by=email&limit=500", nil)
+	req.Header.Set("Authorization", "Bearer valid-token")
+	rr := httptest.NewRecorder()
+
+	handler.HandleSearchUsers(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HandleSearchUsers() status = %v, want %v", rr.Code, http.StatusOK)
+	}
+	if dir.lastQuery.Limit != 10 {
+		t.Errorf("search limit = %d, want capped at 10", dir.lastQuery.Limit)
+	}
+	if dir.lastQuery.Field != ldap.SearchByEmail {
+		t.Errorf("search field = %v, want email", dir.lastQuery.Field)
+	}
+
+	var resp DirectorySearchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Users) != 1 || !resp.Truncated {
+		t.Errorf("response = %+v, want one truncated result", resp)
+	}
+	if resp.Users[0].Attributes != nil {
+		t.Errorf("attributes = %v, want none without an allowlist", resp.Users[0].Attributes)
+	}
+}
+
+func TestDirectoryGroupMembers(t *testing.T) {
+	dir := &mockDirectory{result: &ldap.SearchResult{Users: []*ldap.User{testDirectoryUser()}}}
+	handler := NewDirectoryHandler(dir, &mockTokenValidator{}, DirectoryConfig{}, &mockLogger{})
+
+	req := httptest.NewRequest(http.MethodGet, "/directory/members?group=Staff", nil)
+	req.Header.Set("Authorization", "Bearer valid-token")
+	rr := httptest.NewRecorder()
+
+	handler.HandleGroupMembers(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HandleGroupMembers() status = %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestDirectoryRateLimit(t *testing.T) {
+	dir := &mockDirectory{user: testDirectoryUser()}
+	handler := NewDirectoryHandler(dir, &mockTokenValidator{}, DirectoryConfig{
+		RequestsPerMinute: 1,
+		Burst:             2,
+	}, &mockLogger{})
+
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/directory/user?id=jdoe", nil)
+		req.Header.Set("Authorization", "Bearer valid-token")
+		last = httptest.NewRecorder()
+		handler.HandleGetUser(last, req)
+	}
+
+	if last.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request status = %v, want %v", last.Code, http.StatusTooManyRequests)
+	}
+	if last.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
@@ -0,0 +1,177 @@
+// internal/handler/introspect.go
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+)
+
+// DefaultIntrospectionMaxAge caps how long an introspection answer may be cached
+const DefaultIntrospectionMaxAge = 30 * time.Second
+
+// ClientAuthenticator verifies OAuth client credentials
+type ClientAuthenticator interface {
+	AuthenticateClient(clientID, secret string) error
+}
+
+// RequestAuthenticator authenticates the client of a request with every
+// method it supports, including private_key_jwt. oauth.FileRegistry
+// implements it.
+type RequestAuthenticator interface {
+	AuthenticateRequest(r *http.Request) (*oauth.Client, error)
+}
+
+// IntrospectionConfig controls the introspection endpoint
+type IntrospectionConfig struct {
+	// MaxCacheAge caps the max-age of answers. Revocations take up to this
+	// long to reach callers that cache. Defaults to DefaultIntrospectionMaxAge.
+	MaxCacheAge time.Duration
+}
+
+// IntrospectionResponse is the RFC 7662 response. Inactive tokens only
+// carry Active.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	// Cnf tells resource servers which key the token is bound to
+	Cnf *auth.Confirmation `json:"cnf,omitempty"`
+}
+
+type IntrospectionHandler struct {
+	tokens  TokenValidator
+	clients ClientAuthenticator
+	config  IntrospectionConfig
+	logger  Logger
+}
+
+func NewIntrospectionHandler(tokens TokenValidator, clients ClientAuthenticator, config IntrospectionConfig, logger Logger) *IntrospectionHandler {
+	if config.MaxCacheAge <= 0 {
+		config.MaxCacheAge = DefaultIntrospectionMaxAge
+	}
+
+	return &IntrospectionHandler{
+		tokens:  tokens,
+		clients: clients,
+		config:  config,
+		logger:  logger,
+	}
+}
+
+// authenticateClient returns the ID of the calling client. Registries that
+// are RequestAuthenticators also accept client assertions.
+func authenticateClient(r *http.Request, clients ClientAuthenticator) (string, error) {
+	if registry, ok := clients.(RequestAuthenticator); ok {
+		client, err := registry.AuthenticateRequest(r)
+		if err != nil {
+			return "", err
+		}
+		return client.ID, nil
+	}
+
+	clientID, secret, err := oauth.ClientCredentials(r)
+	if err == nil {
+		err = clients.AuthenticateClient(clientID, secret)
+	}
+	return clientID, err
+}
+
+// HandleIntrospect implements RFC 7662. Callers authenticate with client
+// credentials; the form parameter "token" is the access token to inspect.
+// Answers may be cached privately until the token expires, capped by
+// MaxCacheAge.
+func (h *IntrospectionHandler) HandleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondOAuthError(w, h.logger, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+
+	clientID, err := authenticateClient(r, h.clients)
+	if err != nil {
+		h.logger.Error("Introspection client authentication failed", "clientID", clientID, "ip", clientIP(r), "error", err)
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		respondOAuthError(w, h.logger, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// Only access tokens are introspected; refresh tokens report inactive
+	claims, err := h.tokens.ValidateToken(token, auth.WithAnyAudience())
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrTokenRevoked):
+		h.respond(w, IntrospectionResponse{Active: false}, h.config.MaxCacheAge)
+		return
+	default:
+		// The revocation state is unknown; do not let callers cache a guess
+		h.logger.Error("Introspection failed", "error", err)
+		respondOAuthError(w, h.logger, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	maxAge := h.config.MaxCacheAge
+	if claims.ExpiresAt != nil {
+		if untilExp := time.Until(claims.ExpiresAt.Time); untilExp < maxAge {
+			maxAge = untilExp
+		}
+	}
+
+	h.respond(w, introspectionResponse(claims), maxAge)
+}
+
+func introspectionResponse(claims *auth.Claims) IntrospectionResponse {
+	out := IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Username,
+		TokenType: "Bearer",
+		Sub:       claims.UserID(),
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		Cnf:       claims.Confirmation,
+	}
+	if claims.DPoPBound() {
+		out.TokenType = auth.SchemeDPoP
+	}
+	if claims.ExpiresAt != nil {
+		out.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		out.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		out.Nbf = claims.NotBefore.Unix()
+	}
+	return out
+}
+
+// respond lets only the calling client cache the answer, keyed by its credentials
+func (h *IntrospectionHandler) respond(w http.ResponseWriter, response IntrospectionResponse, maxAge time.Duration) {
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge/time.Second)))
+	w.Header().Set("Vary", "Authorization")
+	respondJSON(w, h.logger, http.StatusOK, response)
+}
@@ -0,0 +1,183 @@
+// internal/handler/introspect_test.go
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+// secretClients registers each client ID with the secret "s3cret"
+func secretClients(t *testing.T, ids ...string) oauth.StaticRegistry {
+	t.Helper()
+	hash, err := passwd.HashWithParams("s3cret", passwd.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := oauth.StaticRegistry{}
+	for _, id := range ids {
+		clients[id] = &oauth.Client{ID: id, SecretHash: hash}
+	}
+	return clients
+}
+
+func postIntrospect(h *IntrospectionHandler, token, clientID, secret string) *httptest.ResponseRecorder {
+	form := url.Values{"token": {token}}
+	req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	rr := httptest.NewRecorder()
+	h.HandleIntrospect(rr, req)
+	return rr
+}
+
+func TestHandleIntrospect(t *testing.T) {
+	client := auth.NewClient(auth.Config{
+		JWTSecret:       []byte("test-secret"),
+		TokenDuration:   time.Hour,
+		Issuer:          "https://auth.example.com",
+		Audience:        "app-1",
+		RevocationStore: auth.NewMemoryRevocationStore(),
+	})
+	h := NewIntrospectionHandler(client, secretClients(t, "gateway"), IntrospectionConfig{}, &mockLogger{})
+
+	// Issued for another audience: introspection answers for every audience
+	token, _ := client.GenerateToken("jdoe", auth.ForAudience("app-2"), auth.WithClientID("app-2"), auth.WithScope("openid", "profile"))
+
+	rr := postIntrospect(h, token, "gateway", "s3cret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "private, max-age=30" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	var response IntrospectionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !response.Active || response.Sub != "jdoe" || response.ClientID != "app-2" || response.Scope != "openid profile" {
+		t.Errorf("response = %+v", response)
+	}
+	if len(response.Aud) != 1 || response.Aud[0] != "app-2" || response.Exp == 0 || response.Iss != "https://auth.example.com" {
+		t.Errorf("response = %+v", response)
+	}
+
+	if err := client.RevokeAccessToken(token); err != nil {
+		t.Fatalf("RevokeAccessToken() unexpected error: %v", err)
+	}
+	rr = postIntrospect(h, token, "gateway", "s3cret")
+	if body := strings.TrimSpace(rr.Body.String()); rr.Code != http.StatusOK || body != `{"active":false}` {
+		t.Errorf("revoked token: status = %d, body = %s", rr.Code, body)
+	}
+
+	if rr := postIntrospect(h, "garbage", "gateway", "s3cret"); !strings.Contains(rr.Body.String(), `"active":false`) {
+		t.Errorf("garbage token body = %s", rr.Body.String())
+	}
+}
+
+func TestHandleIntrospectBoundToken(t *testing.T) {
+	client := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	h := NewIntrospectionHandler(client, secretClients(t, "gateway"), IntrospectionConfig{}, &mockLogger{})
+
+	token, _ := client.GenerateToken("jdoe", auth.WithDPoPKey("0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"))
+	var response IntrospectionResponse
+	json.NewDecoder(postIntrospect(h, token, "gateway", "s3cret").Body).Decode(&response)
+	if response.TokenType != "DPoP" || response.Cnf == nil || response.Cnf.JKT != "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I" {
+		t.Errorf("response = %+v, want a DPoP token with its cnf", response)
+	}
+}
+
+func TestHandleIntrospectClientAuthentication(t *testing.T) {
+	client := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	h := NewIntrospectionHandler(client, secretClients(t, "gateway"), IntrospectionConfig{}, &mockLogger{})
+	token, _ := client.GenerateToken("jdoe")
+
+	for _, creds := range [][2]string{{"", ""}, {"gateway", "wrong"}, {"unknown", "s3cret"}} {
+		rr := postIntrospect(h, token, creds[0], creds[1])
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("credentials %v: status = %d, want %d", creds, rr.Code, http.StatusUnauthorized)
+		}
+		if strings.Contains(rr.Body.String(), "jdoe") {
+			t.Errorf("credentials %v: token details leaked", creds)
+		}
+	}
+
+	if rr := postIntrospect(h, "", "gateway", "s3cret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing token status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+type failingValidator struct{}
+
+func (failingValidator) ValidateToken(token string, opts ...auth.ValidateOption) (*auth.Claims, error) {
+	return nil, fmt.Errorf("revocation check failed: store down")
+}
+
+func TestHandleIntrospectStoreFailure(t *testing.T) {
+	h := NewIntrospectionHandler(failingValidator{}, secretClients(t, "gateway"), IntrospectionConfig{}, &mockLogger{})
+	rr := postIntrospect(h, "token", "gateway", "s3cret")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("status = %d, Cache-Control = %q", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+}
+
+func TestHandleIntrospectPrivateKeyJWT(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := auth.PublicJWK(&key.PublicKey)
+	jwks, _ := json.Marshal(auth.JWKSet{Keys: []auth.JWK{jwk}})
+	path := filepath.Join(t.TempDir(), "clients.json")
+	os.WriteFile(path, []byte(`{"clients": [{"client_id": "gateway", "jwks": `+string(jwks)+`}]}`), 0o600)
+
+	const endpoint = "https://auth.example.com/introspect"
+	registry, err := oauth.LoadRegistry(path, oauth.WithAssertionAudience(endpoint))
+	if err != nil {
+		t.Fatalf("LoadRegistry() error = %v", err)
+	}
+	client := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	h := NewIntrospectionHandler(client, registry, IntrospectionConfig{}, &mockLogger{})
+	token, _ := client.GenerateToken("jdoe")
+
+	introspect := func(audience string) int {
+		assertion, _ := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    "gateway",
+			Subject:   "gateway",
+			Audience:  jwt.ClaimStrings{audience},
+			ID:        audience,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}).SignedString(key)
+		form := url.Values{
+			"token":                 {token},
+			"client_assertion_type": {oauth.ClientAssertionType},
+			"client_assertion":      {assertion},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		h.HandleIntrospect(rr, req)
+		return rr.Code
+	}
+
+	if code := introspect(endpoint); code != http.StatusOK {
+		t.Errorf("status = %d, want %d", code, http.StatusOK)
+	}
+	if code := introspect("https://elsewhere.example.com/token"); code != http.StatusUnauthorized {
+		t.Errorf("foreign audience: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,174 @@
+// internal/handler/mfa.go
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+)
+
+// SecondFactor verifies the codes of users enrolled for MFA
+type SecondFactor interface {
+	Enrolled(userID string) (bool, error)
+	// Verify returns the amr value of the method the code was verified with
+	Verify(userID, code string) (string, error)
+}
+
+// MFAChallenges carries logins from the password to the code step
+type MFAChallenges interface {
+	Issue(userID string, login interface{}) (string, error)
+	Lookup(token string) (*mfa.Challenge, error)
+	// Fail records a wrong code and reports whether the challenge is still
+	// usable
+	Fail(token string) bool
+	Complete(token string) error
+}
+
+// MFARequest completes a login that answered with MFARequired
+type MFARequest struct {
+	MFAToken string
+	// Code is a code from the user's authenticator or a recovery code
+	Code string
+}
+
+// WithMFA asks users enrolled for a second factor for a code after the
+// password. HandleAuthentication then answers with an MFA token instead of
+// a token, which HandleMFAVerification exchanges for one along with the
+// code. Break-glass logins are exempt, as they are for emergencies only.
+// Nil challenges are kept in memory, which only works for a single instance.
+func WithMFA(factor SecondFactor, challenges MFAChallenges) Option {
+	return func(h *AuthHandler) {
+		h.mfa = factor
+		if challenges != nil {
+			h.challenges = challenges
+		}
+	}
+}
+
+// Second factors reported in AuthResponse.MFAMethods
+const (
+	MFAMethodTOTP     = "totp"
+	MFAMethodWebAuthn = "webauthn"
+)
+
+// secondFactors returns the second factors the user is enrolled for
+func (h *AuthHandler) secondFactors(userID string) ([]string, error) {
+	var factors []string
+	if h.mfa != nil {
+		enrolled, err := h.mfa.Enrolled(userID)
+		if err != nil {
+			return nil, err
+		}
+		if enrolled {
+			factors = append(factors, MFAMethodTOTP)
+		}
+	}
+	if h.webAuthn != nil {
+		credentials, err := h.webAuthn.Credentials.Credentials(userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(credentials) > 0 {
+			factors = append(factors, MFAMethodWebAuthn)
+		}
+	}
+	return factors, nil
+}
+
+// challengeMFA answers a login with a verified password with an MFA token
+func (h *AuthHandler) challengeMFA(w http.ResponseWriter, login *pendingLogin, factors []string, ip string) {
+	token, err := h.challenges.Issue(login.identity.ID, login)
+	if err != nil {
+		h.logger.Error("MFA challenge failed", "userID", login.identity.ID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "mfa unavailable")
+		return
+	}
+	audit(h.logger, "Login awaiting second factor", "userID", login.identity.ID, "ip", ip)
+	h.respondJSON(w, http.StatusOK, AuthResponse{
+		UserID:      login.identity.ID,
+		MFARequired: true,
+		MFAToken:    token,
+		MFAMethods:  factors,
+	})
+}
+
+// HandleMFAVerification completes a login with the code of the user's
+// second factor. Wrong codes count against the login throttle, and an MFA
+// token is dropped after a few of them.
+func (h *AuthHandler) HandleMFAVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+	if h.mfa == nil {
+		h.respondError(w, http.StatusNotFound, "mfa not enabled")
+		return
+	}
+
+	var request MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	ip := clientIP(r)
+
+	challenge, err := h.challenges.Lookup(request.MFAToken)
+	if err != nil {
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+	login, ok := challenge.Login.(*pendingLogin)
+	if !ok {
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+
+	if h.throttle != nil {
+		if decision := h.throttle.Check(login.username, ip); !decision.Allowed {
+			h.logger.Error("MFA attempt throttled", "username", login.username, "ip", ip, "reason", decision.Reason)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+			h.respondError(w, http.StatusTooManyRequests, "too many attempts")
+			return
+		}
+	}
+
+	method, err := h.mfa.Verify(challenge.UserID, request.Code)
+	if err != nil {
+		// Users with only a security key are not enrolled for codes
+		if !errors.Is(err, mfa.ErrInvalidCode) && !errors.Is(err, mfa.ErrCodeReused) && !errors.Is(err, mfa.ErrNotEnrolled) {
+			h.logger.Error("MFA verification failed", "userID", challenge.UserID, "error", err)
+			if h.throttle != nil {
+				h.throttle.Release(login.username, ip)
+			}
+			h.respondError(w, http.StatusInternalServerError, "mfa unavailable")
+			return
+		}
+		usable := h.challenges.Fail(request.MFAToken)
+		if h.throttle != nil {
+			h.throttle.RecordFailure(login.username, ip)
+		}
+		audit(h.logger, "MFA code rejected", "userID", challenge.UserID, "ip", ip, "error", err, "challengeUsable", usable)
+		h.respondError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	if h.throttle != nil {
+		h.throttle.RecordSuccess(login.username, ip)
+	}
+	if err := h.challenges.Complete(request.MFAToken); err != nil {
+		// Another request finished this login first
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+	if method == auth.AMRRecoveryCode {
+		audit(h.logger, "Recovery code used", "userID", challenge.UserID, "ip", ip)
+	}
+
+	completed := *login
+	completed.methods = append(append([]string{}, login.methods...), method, auth.AMRMultiFactor)
+	h.completeLogin(w, r, &completed)
+}
@@ -0,0 +1,231 @@
+// internal/handler/mfa_enrollment.go
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+	"github.com/yovily/customers/citi/auth-service/pkg/throttle"
+)
+
+// TOTPEnroller manages users' TOTP enrollments
+type TOTPEnroller interface {
+	Enroll(userID string) (*mfa.Provisioning, error)
+	Confirm(userID, code string) error
+	Verify(userID, code string) (string, error)
+	RegenerateRecoveryCodes(userID string) ([]string, error)
+	Disable(userID string) error
+}
+
+// MFAEnrollmentResponse is shown to the user once. The secret and the
+// recovery codes cannot be retrieved again.
+type MFAEnrollmentResponse struct {
+	Secret string
+	// URI is the otpauth provisioning URI to render as a QR code
+	URI           string
+	RecoveryCodes []string
+}
+
+// MFACodeRequest carries a code from the user's authenticator, or for
+// HandleDisable and HandleRecoveryCodes a recovery code
+type MFACodeRequest struct {
+	Code string
+}
+
+// RecoveryCodesResponse holds newly generated recovery codes
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string
+}
+
+// MFAHandler lets signed-in users manage their TOTP second factor. Every
+// endpoint takes the user's access token; changing an existing enrollment
+// also takes a current code, so a stolen token alone cannot remove or take
+// over the second factor.
+type MFAHandler struct {
+	enroller TOTPEnroller
+	tokens   TokenValidator
+	throttle Throttle
+	logger   Logger
+}
+
+// NewMFAHandler returns a handler whose code checks are limited by limiter,
+// keyed by user ID and client IP. Passing the login throttle makes wrong
+// codes count towards the user's lockout; nil gives the handler a limiter
+// of its own with the default limits.
+func NewMFAHandler(enroller TOTPEnroller, tokens TokenValidator, limiter Throttle, logger Logger) *MFAHandler {
+	if limiter == nil {
+		limiter = throttle.New(throttle.Config{}, logger)
+	}
+
+	return &MFAHandler{
+		enroller: enroller,
+		tokens:   tokens,
+		throttle: limiter,
+		logger:   logger,
+	}
+}
+
+// HandleEnroll starts an enrollment and returns the new secret and recovery
+// codes. It takes effect once confirmed with HandleConfirm.
+func (h *MFAHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	provisioning, err := h.enroller.Enroll(userID)
+	switch {
+	case errors.Is(err, mfa.ErrAlreadyEnrolled):
+		h.respondError(w, http.StatusConflict, "already enrolled")
+		return
+	case err != nil:
+		h.logger.Error("MFA enrollment failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "enrollment failed")
+		return
+	}
+	audit(h.logger, "MFA enrollment started", "userID", userID, "ip", clientIP(r))
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.respondJSON(w, http.StatusOK, MFAEnrollmentResponse{
+		Secret:        provisioning.Secret,
+		URI:           provisioning.URI,
+		RecoveryCodes: provisioning.RecoveryCodes,
+	})
+}
+
+// HandleConfirm completes an enrollment with a first code
+func (h *MFAHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	code, ok := h.code(w, r)
+	if !ok {
+		return
+	}
+
+	switch err := h.enroller.Confirm(userID, code); {
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		h.respondError(w, http.StatusNotFound, "no pending enrollment")
+	case errors.Is(err, mfa.ErrAlreadyEnrolled):
+		h.respondError(w, http.StatusConflict, "already enrolled")
+	case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrCodeReused):
+		h.respondError(w, http.StatusBadRequest, "invalid code")
+	case err != nil:
+		h.logger.Error("MFA confirmation failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "enrollment failed")
+	default:
+		audit(h.logger, "MFA enrolled", "userID", userID, "ip", clientIP(r))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleDisable removes the user's second factor
+func (h *MFAHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok || !h.verify(w, r, userID) {
+		return
+	}
+	if err := h.enroller.Disable(userID); err != nil {
+		h.logger.Error("MFA removal failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "removal failed")
+		return
+	}
+	audit(h.logger, "MFA disabled", "userID", userID, "ip", clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRecoveryCodes replaces the user's recovery codes
+func (h *MFAHandler) HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authorize(w, r)
+	if !ok || !h.verify(w, r, userID) {
+		return
+	}
+	codes, err := h.enroller.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		h.logger.Error("Recovery code generation failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "recovery code generation failed")
+		return
+	}
+	audit(h.logger, "Recovery codes regenerated", "userID", userID, "ip", clientIP(r))
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.respondJSON(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// authorize returns the user of the request's access token. Client tokens
+// have no user to enroll.
+func (h *MFAHandler) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return "", false
+	}
+
+	claims, ok := bearerClaims(w, r, h.tokens, h.logger)
+	if !ok {
+		return "", false
+	}
+	if claims.ClientToken() {
+		h.respondError(w, http.StatusForbidden, "user token required")
+		return "", false
+	}
+	return claims.UserID(), true
+}
+
+func (h *MFAHandler) code(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return "", false
+	}
+	return request.Code, true
+}
+
+// verify checks the code of an enrolled user before their enrollment is
+// changed. Wrong codes count like failed logins, so a stolen token cannot
+// be used to guess codes.
+func (h *MFAHandler) verify(w http.ResponseWriter, r *http.Request, userID string) bool {
+	code, ok := h.code(w, r)
+	if !ok {
+		return false
+	}
+
+	ip := clientIP(r)
+	if decision := h.throttle.Check(userID, ip); !decision.Allowed {
+		audit(h.logger, "MFA attempt throttled", "userID", userID, "ip", ip, "reason", decision.Reason)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+		h.respondError(w, http.StatusTooManyRequests, "too many attempts")
+		return false
+	}
+
+	_, err := h.enroller.Verify(userID, code)
+	switch {
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		h.throttle.Release(userID, ip)
+		h.respondError(w, http.StatusNotFound, "not enrolled")
+	case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrCodeReused):
+		h.throttle.RecordFailure(userID, ip)
+		audit(h.logger, "MFA code rejected", "userID", userID, "ip", ip, "error", err)
+		h.respondError(w, http.StatusForbidden, "invalid code")
+	case err != nil:
+		h.throttle.Release(userID, ip)
+		h.logger.Error("MFA verification failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "mfa unavailable")
+	default:
+		h.throttle.RecordSuccess(userID, ip)
+		return true
+	}
+	return false
+}
+
+func (h *MFAHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	respondJSON(w, h.logger, status, data)
+}
+
+func (h *MFAHandler) respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, h.logger, status, ErrorResponse{Error: message})
+}
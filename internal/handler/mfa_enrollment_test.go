@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+	"github.com/yovily/customers/citi/auth-service/pkg/throttle"
+)
+
+func TestMFAHandler(t *testing.T) {
+	cipher, _ := mfa.NewCipher(bytes.Repeat([]byte{5}, mfa.KeySize))
+	manager := mfa.NewManager(mfa.NewMemoryStore(), cipher, mfa.Config{Issuer: "Example"})
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	handler := NewMFAHandler(manager, tokens, nil, &mockLogger{})
+	userToken, _ := tokens.GenerateToken("jdoe")
+	clientToken, _ := tokens.GenerateToken("batch", auth.AsClientToken())
+
+	call := func(endpoint http.HandlerFunc, token string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/mfa", bytes.NewBuffer(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		endpoint(rr, req)
+		return rr
+	}
+
+	if rr := call(handler.HandleEnroll, "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := call(handler.HandleEnroll, clientToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("client token: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	rr := call(handler.HandleEnroll, userToken, nil)
+	var enrollment MFAEnrollmentResponse
+	json.NewDecoder(rr.Body).Decode(&enrollment)
+	if rr.Code != http.StatusOK || enrollment.URI == "" || len(enrollment.RecoveryCodes) == 0 {
+		t.Fatalf("enroll = %d %+v", rr.Code, enrollment)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("enrollment response may be cached")
+	}
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	var totp mfa.TOTP
+	codeAt := func(offset int64) string {
+		return totp.Code(secret, totp.Step(time.Now())+offset)
+	}
+
+	if rr := call(handler.HandleConfirm, userToken, MFACodeRequest{Code: "000000"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("confirm with wrong code: status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := call(handler.HandleConfirm, userToken, MFACodeRequest{Code: codeAt(-1)}); rr.Code != http.StatusNoContent {
+		t.Fatalf("confirm: status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if rr := call(handler.HandleEnroll, userToken, nil); rr.Code != http.StatusConflict {
+		t.Errorf("enroll again: status = %d, want %d", rr.Code, http.StatusConflict)
+	}
+
+	rr = call(handler.HandleRecoveryCodes, userToken, MFACodeRequest{Code: codeAt(0)})
+	var recovery RecoveryCodesResponse
+	json.NewDecoder(rr.Body).Decode(&recovery)
+	if rr.Code != http.StatusOK || len(recovery.RecoveryCodes) != mfa.DefaultRecoveryCodes {
+		t.Fatalf("recovery codes = %d %+v", rr.Code, recovery)
+	}
+
+	// Removing the second factor takes one of its codes
+	if rr := call(handler.HandleDisable, userToken, MFACodeRequest{Code: enrollment.RecoveryCodes[0]}); rr.Code != http.StatusForbidden {
+		t.Errorf("disable with replaced recovery code: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := call(handler.HandleDisable, userToken, MFACodeRequest{Code: recovery.RecoveryCodes[0]}); rr.Code != http.StatusNoContent {
+		t.Fatalf("disable: status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if enrolled, _ := manager.Enrolled("jdoe"); enrolled {
+		t.Error("user still enrolled after disable")
+	}
+}
+
+func TestMFAHandlerLockout(t *testing.T) {
+	manager, _, codeAt := enrolledManager(t)
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	logger := &mockLogger{}
+	limiter := throttle.New(throttle.Config{FreeAttempts: 10, LockoutThreshold: 2}, &mockLogger{})
+	handler := NewMFAHandler(manager, tokens, limiter, logger)
+	userToken, _ := tokens.GenerateToken("jdoe")
+
+	call := func(endpoint http.HandlerFunc, code string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(MFACodeRequest{Code: code})
+		req := httptest.NewRequest(http.MethodPost, "/mfa", bytes.NewBuffer(data))
+		req.Header.Set("Authorization", "Bearer "+userToken)
+		rr := httptest.NewRecorder()
+		endpoint(rr, req)
+		return rr
+	}
+
+	for _, endpoint := range []http.HandlerFunc{handler.HandleDisable, handler.HandleRecoveryCodes} {
+		if rr := call(endpoint, "000000"); rr.Code != http.StatusForbidden {
+			t.Fatalf("wrong code: status = %d, want %d", rr.Code, http.StatusForbidden)
+		}
+	}
+	if logger.errorMsgs[len(logger.errorMsgs)-1] != "MFA code rejected" {
+		t.Errorf("expected audit entry, got %v", logger.errorMsgs)
+	}
+
+	// The user is locked out, even with the right code
+	rr := call(handler.HandleDisable, codeAt(0))
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("after lockout: status = %d, want %d with Retry-After", rr.Code, http.StatusTooManyRequests)
+	}
+	if enrolled, _ := manager.Enrolled("jdoe"); !enrolled {
+		t.Error("second factor removed while locked out")
+	}
+}
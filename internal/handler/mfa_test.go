@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+	"github.com/yovily/customers/citi/auth-service/pkg/throttle"
+)
+
+// enrolledManager returns a manager with jdoe enrolled and a function
+// returning the code of a time step relative to now
+func enrolledManager(t *testing.T) (*mfa.Manager, []string, func(offset int64) string) {
+	t.Helper()
+	cipher, err := mfa.NewCipher(bytes.Repeat([]byte{3}, mfa.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := mfa.NewManager(mfa.NewMemoryStore(), cipher, mfa.Config{Issuer: "Example"})
+	provisioning, err := manager.Enroll("jdoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(provisioning.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var totp mfa.TOTP
+	codeAt := func(offset int64) string {
+		return totp.Code(secret, totp.Step(time.Now())+offset)
+	}
+	// The confirmation uses the previous step so tests can log in with the
+	// current one
+	if err := manager.Confirm("jdoe", codeAt(-1)); err != nil {
+		t.Fatal(err)
+	}
+	return manager, provisioning.RecoveryCodes, codeAt
+}
+
+func postJSON(handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/auth", bytes.NewBuffer(data))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestHandleAuthenticationMFA(t *testing.T) {
+	manager, recoveryCodes, codeAt := enrolledManager(t)
+	authClient := &mockAuthClient{token: "mfa.jwt.token"}
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, authClient, &mockLogger{},
+		WithMFA(manager, mfa.NewChallenges(0, 3)))
+
+	login := func() string {
+		t.Helper()
+		rr := postJSON(handler.HandleAuthentication, AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"})
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusOK || !response.MFARequired || response.IsAuthenticated || response.Token != "" || response.MFAToken == "" {
+			t.Fatalf("login = %d %+v, want an MFA challenge", rr.Code, response)
+		}
+		return response.MFAToken
+	}
+	verify := func(token, code string) (int, AuthResponse) {
+		rr := postJSON(handler.HandleMFAVerification, MFARequest{MFAToken: token, Code: code})
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr.Code, response
+	}
+	amr := func() []string {
+		client := auth.NewClient(auth.Config{JWTSecret: []byte("s"), TokenDuration: time.Minute})
+		token, _ := client.GenerateToken(authClient.lastUser, authClient.lastOpts...)
+		claims, _ := client.ValidateToken(token)
+		return claims.AMR
+	}
+
+	token := login()
+	if authClient.lastUser != "" {
+		t.Fatal("token issued before the second factor")
+	}
+	code := codeAt(0)
+	status, response := verify(token, code)
+	if status != http.StatusOK || !response.IsAuthenticated || response.Token != "mfa.jwt.token" {
+		t.Fatalf("verify = %d %+v, want a token", status, response)
+	}
+	if got, want := amr(), []string{auth.AMRPassword, auth.AMROTP, auth.AMRMultiFactor}; !reflect.DeepEqual(got, want) {
+		t.Errorf("amr = %v, want %v", got, want)
+	}
+
+	// Neither the challenge nor the code can be used twice
+	if status, _ := verify(token, code); status != http.StatusUnauthorized {
+		t.Errorf("reused challenge: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	token = login()
+	if status, _ := verify(token, code); status != http.StatusUnauthorized {
+		t.Errorf("replayed code: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	status, _ = verify(token, recoveryCodes[0])
+	if status != http.StatusOK {
+		t.Fatalf("recovery code: status = %d, want %d", status, http.StatusOK)
+	}
+	if got, want := amr(), []string{auth.AMRPassword, auth.AMRRecoveryCode, auth.AMRMultiFactor}; !reflect.DeepEqual(got, want) {
+		t.Errorf("amr = %v, want %v", got, want)
+	}
+
+	// The challenge is dropped after too many wrong codes
+	token = login()
+	for i := 0; i < 3; i++ {
+		verify(token, "000000")
+	}
+	if status, _ := verify(token, codeAt(1)); status != http.StatusUnauthorized {
+		t.Errorf("exhausted challenge: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestHandleAuthenticationMFAThrottle(t *testing.T) {
+	manager, _, codeAt := enrolledManager(t)
+	limiter := throttle.New(throttle.Config{FreeAttempts: 10, LockoutThreshold: 3}, &mockLogger{})
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, &mockAuthClient{token: "mfa.jwt.token"}, &mockLogger{},
+		WithMFA(manager, mfa.NewChallenges(0, 3)), WithThrottle(limiter))
+
+	login := func() *httptest.ResponseRecorder {
+		return postAuth(handler, AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"}, "10.0.0.1:5000")
+	}
+	wrongCode := func() {
+		t.Helper()
+		rr := login()
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusOK || !response.MFARequired {
+			t.Fatalf("login = %d %+v, want an MFA challenge", rr.Code, response)
+		}
+		if rr := postJSON(handler.HandleMFAVerification, MFARequest{MFAToken: response.MFAToken, Code: "000000"}); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// A correct code clears earlier failures
+	wrongCode()
+	wrongCode()
+	rr := login()
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr := postJSON(handler.HandleMFAVerification, MFARequest{MFAToken: response.MFAToken, Code: codeAt(0)}); rr.Code != http.StatusOK {
+		t.Fatalf("correct code: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	// The password alone does not, so wrong codes add up across MFA tokens
+	for i := 0; i < 3; i++ {
+		wrongCode()
+	}
+	if rr := login(); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("login after wrong codes: status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandleAuthenticationMFAOtherSpellings(t *testing.T) {
+	manager, _, _ := enrolledManager(t)
+	authClient := &mockAuthClient{token: "mfa.jwt.token"}
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, authClient, &mockLogger{}, WithMFA(manager, nil))
+
+	// jdoe enrolled as "jdoe" cannot skip the code by spelling the account differently
+	for _, userID := range []string{"JDOE", `CORP\jdoe`} {
+		rr := postJSON(handler.HandleAuthentication, AuthRequest{UserID: userID, Password: "s3cret", Domain: "example.com"})
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusOK || !response.MFARequired || response.Token != "" || authClient.lastUser != "" {
+			t.Errorf("login as %q = %d %+v, want an MFA challenge", userID, rr.Code, response)
+		}
+	}
+}
+
+func TestHandleAuthenticationMFANotEnrolled(t *testing.T) {
+	manager, _, _ := enrolledManager(t)
+	authClient := &mockAuthClient{token: "plain.jwt.token"}
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, authClient, &mockLogger{},
+		WithMFA(manager, mfa.NewChallenges(0, 0)))
+
+	rr := postJSON(handler.HandleAuthentication, AuthRequest{UserID: "asmith", Password: "s3cret", Domain: "example.com"})
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || response.MFARequired || response.Token != "plain.jwt.token" {
+		t.Errorf("login = %d %+v, want a token without MFA", rr.Code, response)
+	}
+}
+
+func TestOIDCLoginMFA(t *testing.T) {
+	o := newOIDCTest(t)
+	manager, _, codeAt := enrolledManager(t)
+	o.provider.config.MFA = manager
+
+	submit := func(form *http.Response, code string) *http.Response {
+		t.Helper()
+		match := loginRequestPattern.FindStringSubmatch(readBody(t, form))
+		if match == nil {
+			t.Fatal("no login form")
+		}
+		resp, err := o.browser.PostForm(o.server.URL+AuthorizePath, url.Values{
+			"login_request": {match[1]},
+			"username":      {"jdoe"},
+			"password":      {"s3cret"},
+			"code":          {code},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	form := o.authorize(t, authorizeParams("spa"))
+	resp := submit(form, "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("login without code: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	resp = submit(resp, "000000")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("login with wrong code: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	params := redirectParams(t, submit(resp, codeAt(0)))
+	_, tokens := o.token(t, codeForm(params.Get("code")), "spa", "")
+	claims, err := o.tokens.ValidateToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(claims.AMR, " "), auth.AMRMultiFactor) {
+		t.Errorf("amr = %v, want %s", claims.AMR, auth.AMRMultiFactor)
+	}
+}
+
+func TestOIDCLoginMFAThrottle(t *testing.T) {
+	o := newOIDCTest(t)
+	manager, _, codeAt := enrolledManager(t)
+	o.provider.config.MFA = manager
+	o.provider.config.Throttle = throttle.New(throttle.Config{FreeAttempts: 10, LockoutThreshold: 2}, &mockLogger{})
+
+	submit := func(form *http.Response, code string) *http.Response {
+		t.Helper()
+		match := loginRequestPattern.FindStringSubmatch(readBody(t, form))
+		if match == nil {
+			t.Fatal("no login form")
+		}
+		resp, err := o.browser.PostForm(o.server.URL+AuthorizePath, url.Values{
+			"login_request": {match[1]},
+			"username":      {"jdoe"},
+			"password":      {"s3cret"},
+			"code":          {code},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// Each submission has the right password, which must not reset the
+	// count of wrong codes
+	resp := o.authorize(t, authorizeParams("spa"))
+	for i := 0; i < 2; i++ {
+		resp = submit(resp, "000000")
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status = %d, want %d", i+1, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	if resp = submit(resp, codeAt(0)); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("login after wrong codes: status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+}
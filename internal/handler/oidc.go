@@ -0,0 +1,956 @@
+// internal/handler/oidc.go
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+	"github.com/yovily/customers/citi/auth-service/pkg/roles"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+)
+
+// Paths of the OpenID Connect provider endpoints
+const (
+	AuthorizePath  = "/authorize"
+	TokenPath      = "/token"
+	UserinfoPath   = "/userinfo"
+	EndSessionPath = "/logout"
+)
+
+// Token lifetimes used by the provider unless configured
+const (
+	DefaultAccessTokenLifetime = 10 * time.Minute
+	DefaultIDTokenLifetime     = 10 * time.Minute
+)
+
+// Scopes understood by the provider. Other requested scopes must be
+// registered for the client and are granted as-is for resource servers to
+// interpret.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopeGroups  = "groups"
+)
+
+// ProviderTokens issues, checks and revokes the provider's tokens and ends
+// browser logins. auth.Client implements it.
+type ProviderTokens interface {
+	GenerateToken(userID string, opts ...auth.TokenOption) (string, error)
+	ValidateToken(token string, opts ...auth.ValidateOption) (*auth.Claims, error)
+	VerifyTokenHint(token string) (*auth.Claims, error)
+	RevokeSessionTokens(sid string, until time.Time) error
+	Logout(w http.ResponseWriter, r *http.Request) error
+}
+
+// LoginSessions are the browser sessions of the hosted login page.
+// auth.Sessions implements it.
+type LoginSessions interface {
+	auth.SessionManager
+	SessionStarter
+}
+
+// ProviderConfig controls the OpenID Connect provider
+type ProviderConfig struct {
+	// Issuer is returned as iss in authorization responses (RFC 9207)
+	Issuer string
+	// DefaultDomain is appended to usernames entered without "@domain"
+	DefaultDomain string
+	// AccessTokenLifetime defaults to DefaultAccessTokenLifetime
+	AccessTokenLifetime time.Duration
+	// IDTokenLifetime defaults to DefaultIDTokenLifetime
+	IDTokenLifetime time.Duration
+	// OfflineTokenLifetime caps the lifetime of tokens issued when the
+	// directory was unreachable and the password was verified offline.
+	// It defaults to DefaultOfflineTokenLifetime.
+	OfflineTokenLifetime time.Duration
+	// CodeLifetime defaults to oauth.DefaultCodeLifetime
+	CodeLifetime time.Duration
+	// Codes defaults to an in-memory store, which only works for a single
+	// instance
+	Codes oauth.CodeStore
+	// Devices keeps device authorization grants. It defaults to an
+	// in-memory store, which only works for a single instance.
+	Devices oauth.DeviceStore
+	// VerificationURI is the absolute URL of HandleDeviceVerification that
+	// device grant users are told to visit
+	VerificationURI string
+	// DeviceCodeLifetime defaults to oauth.DefaultDeviceCodeLifetime
+	DeviceCodeLifetime time.Duration
+	// DevicePollInterval defaults to oauth.DefaultPollInterval
+	DevicePollInterval time.Duration
+	// Roles puts the roles mapped for the client's application into access
+	// tokens
+	Roles RoleMapper
+	// Throttle guards the hosted login form
+	Throttle Throttle
+	// DPoP lets clients bind access tokens to their keys by sending DPoP
+	// proofs to the token endpoint (RFC 9449). The userinfo endpoint then
+	// requires proofs with those tokens. Nil disables DPoP.
+	DPoP *auth.DPoPVerifier
+	// MFA asks users enrolled for a second factor for a code on the hosted
+	// pages, along with their password
+	MFA SecondFactor
+	// WebAuthnCredentials, when set, refuses users whose only second factor
+	// is a security key. The hosted pages take codes only, so such users
+	// must sign in through the login API.
+	WebAuthnCredentials webauthn.CredentialStore
+}
+
+// TokenResponse is the successful token endpoint response (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is set on token exchange responses (RFC 8693)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// UserinfoResponse holds the claims released for the scopes of the access token
+type UserinfoResponse struct {
+	Sub    string   `json:"sub"`
+	Name   string   `json:"name,omitempty"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// OIDCProvider is an OpenID Connect provider for the authorization code
+// flow with mandatory PKCE. Users sign in on a hosted login page, so
+// relying parties never see passwords. The authorize and end session
+// endpoints must be wrapped in the session middleware. A provider serving
+// only the token endpoint to non-human clients needs no authenticator and
+// no sessions.
+type OIDCProvider struct {
+	authenticator Authenticator
+	clients       oauth.ClientRegistry
+	sessions      LoginSessions
+	tokens        ProviderTokens
+	config        ProviderConfig
+	logger        Logger
+}
+
+func NewOIDCProvider(authenticator Authenticator, clients oauth.ClientRegistry, sessions LoginSessions, tokens ProviderTokens, config ProviderConfig, logger Logger) *OIDCProvider {
+	if config.AccessTokenLifetime <= 0 {
+		config.AccessTokenLifetime = DefaultAccessTokenLifetime
+	}
+	if config.IDTokenLifetime <= 0 {
+		config.IDTokenLifetime = DefaultIDTokenLifetime
+	}
+	if config.OfflineTokenLifetime <= 0 {
+		config.OfflineTokenLifetime = DefaultOfflineTokenLifetime
+	}
+	if config.CodeLifetime <= 0 {
+		config.CodeLifetime = oauth.DefaultCodeLifetime
+	}
+	if config.Codes == nil {
+		config.Codes = oauth.NewMemoryCodeStore()
+	}
+	if config.Devices == nil {
+		config.Devices = oauth.NewMemoryDeviceStore()
+	}
+	if config.DeviceCodeLifetime <= 0 {
+		config.DeviceCodeLifetime = oauth.DefaultDeviceCodeLifetime
+	}
+	if config.DevicePollInterval <= 0 {
+		config.DevicePollInterval = oauth.DefaultPollInterval
+	}
+
+	return &OIDCProvider{
+		authenticator: authenticator,
+		clients:       clients,
+		sessions:      sessions,
+		tokens:        tokens,
+		config:        config,
+		logger:        logger,
+	}
+}
+
+// Session keys used by the provider
+const (
+	sessionKeyIdentity = "oidc.identity"
+	sessionKeyPending  = "oidc.pending"
+)
+
+// maxPendingRequests bounds the authorization requests kept per session
+// while their login forms are open
+const maxPendingRequests = 5
+
+// authorizationRequest is a validated authorization request waiting for
+// the user to sign in
+type authorizationRequest struct {
+	ID            string   `json:"id"`
+	ClientID      string   `json:"client_id"`
+	RedirectURI   string   `json:"redirect_uri"`
+	Scope         []string `json:"scope"`
+	State         string   `json:"state,omitempty"`
+	Nonce         string   `json:"nonce,omitempty"`
+	CodeChallenge string   `json:"code_challenge"`
+}
+
+// sessionIdentity is the signed-in user, kept in the session for single
+// sign-on. Offline-verified users are never kept.
+type sessionIdentity struct {
+	UserID     string              `json:"user_id"`
+	Name       string              `json:"name,omitempty"`
+	Email      string              `json:"email,omitempty"`
+	Groups     []string            `json:"groups,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	AMR        []string            `json:"amr,omitempty"`
+	Offline    bool                `json:"offline,omitempty"`
+}
+
+// HandleAuthorize is the authorization endpoint. It validates the request,
+// issues a code right away when the browser already has a login session,
+// and otherwise shows the login page, whose form posts back here.
+func (h *OIDCProvider) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Invalid request.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderError(w, http.StatusBadRequest, "Invalid request.")
+		return
+	}
+	if r.Method == http.MethodPost && r.PostForm.Get("login_request") != "" {
+		h.handleLogin(w, r)
+		return
+	}
+
+	// Until the redirect URI is known to belong to the client, errors must
+	// not be sent to it
+	clientID := r.Form.Get("client_id")
+	redirectURI := r.Form.Get("redirect_uri")
+	client, err := h.clients.Client(clientID)
+	if err != nil {
+		h.logger.Error("Authorization request from unknown client", "clientID", clientID, "error", err)
+		h.renderError(w, http.StatusBadRequest, "The application is not registered.")
+		return
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		h.logger.Error("Authorization request with unregistered redirect URI", "clientID", clientID, "redirectURI", redirectURI)
+		h.renderError(w, http.StatusBadRequest, "The application sent an invalid redirect URI.")
+		return
+	}
+
+	request := authorizationRequest{
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Fields(r.Form.Get("scope")),
+		State:         r.Form.Get("state"),
+		Nonce:         r.Form.Get("nonce"),
+		CodeChallenge: r.Form.Get("code_challenge"),
+	}
+	if !client.AllowsGrantType(oauth.GrantAuthorizationCode) {
+		h.redirectError(w, r, request, "unauthorized_client", "")
+		return
+	}
+	if err := checkUserScopes(client, request.Scope); err != nil {
+		audit(h.logger, "Client requested unregistered scope", "clientID", client.ID, "scope", r.Form.Get("scope"))
+		h.redirectError(w, r, request, "invalid_scope", "")
+		return
+	}
+	if responseType := r.Form.Get("response_type"); responseType != "code" {
+		h.redirectError(w, r, request, "unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	if err := oauth.ValidateCodeChallenge(request.CodeChallenge, r.Form.Get("code_challenge_method")); err != nil {
+		h.redirectError(w, r, request, "invalid_request", "PKCE with S256 is required")
+		return
+	}
+
+	prompt := strings.Fields(r.Form.Get("prompt"))
+	identity, session, ok := h.currentLogin(r)
+	switch {
+	case ok && !contains(prompt, "login"):
+		h.issueCode(w, r, request, identity, session)
+	case contains(prompt, "none"):
+		h.redirectError(w, r, request, "login_required", "")
+	default:
+		h.showLogin(w, r, request, "", "", http.StatusOK)
+	}
+}
+
+// handleLogin verifies the credentials posted by the login page
+func (h *OIDCProvider) handleLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request, ok := h.takePending(r, r.PostForm.Get("login_request"))
+	if !ok {
+		h.renderError(w, http.StatusBadRequest, "The sign-in request has expired. Return to the application and try again.")
+		return
+	}
+	if _, err := h.clients.Client(request.ClientID); err != nil {
+		h.renderError(w, http.StatusBadRequest, "The application is not registered.")
+		return
+	}
+
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	identity, failure := h.checkCredentials(w, r, username, r.PostForm.Get("password"))
+	if failure != nil {
+		h.showLogin(w, r, request, username, failure.message, failure.status)
+		return
+	}
+
+	signedIn := sessionIdentity{
+		UserID:     identity.ID,
+		Name:       identity.Name,
+		Email:      identity.Email,
+		Groups:     identity.Groups,
+		Attributes: identity.Attributes,
+		AMR:        identityMethods(identity),
+		Offline:    identity.Offline,
+	}
+	if identity.Offline {
+		// The directory could not confirm the login, so it gets a single
+		// code with short-lived tokens and no session to sign in again with
+		audit(h.logger, "Offline-verified login", "userID", identity.ID, "clientID", request.ClientID, "ip", clientIP(r))
+		h.issueCode(w, r, request, &signedIn, auth.SessionInfo{CreatedAt: time.Now()})
+		return
+	}
+
+	if _, err := h.sessions.Login(ctx, identity.ID); err != nil {
+		h.logger.Error("Session start failed", "userID", identity.ID, "error", err)
+		h.renderError(w, http.StatusInternalServerError, "Sign-in failed. Try again later.")
+		return
+	}
+
+	data, err := json.Marshal(signedIn)
+	if err != nil {
+		h.logger.Error("Failed to encode session identity", "error", err)
+		h.renderError(w, http.StatusInternalServerError, "Sign-in failed. Try again later.")
+		return
+	}
+	h.sessions.Put(ctx, sessionKeyIdentity, string(data))
+
+	session, _ := auth.SessionFromContext(ctx)
+	h.issueCode(w, r, request, &signedIn, session)
+}
+
+// loginFailure is what the hosted pages show when sign-in fails
+type loginFailure struct {
+	status  int
+	message string
+}
+
+// checkCredentials verifies credentials entered on a hosted page, applying
+// the default domain and the login throttle
+func (h *OIDCProvider) checkCredentials(w http.ResponseWriter, r *http.Request, username, password string) (*authn.Identity, *loginFailure) {
+	qualified := username
+	if h.config.DefaultDomain != "" && !strings.Contains(username, "@") {
+		qualified = username + "@" + h.config.DefaultDomain
+	}
+	ip := clientIP(r)
+
+	if h.config.Throttle != nil {
+		if decision := h.config.Throttle.Check(qualified, ip); !decision.Allowed {
+			h.logger.Error("Login attempt throttled", "username", qualified, "ip", ip, "reason", decision.Reason)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+			return nil, &loginFailure{http.StatusTooManyRequests, "Too many attempts. Try again later."}
+		}
+	}
+
+	identity, err := h.authenticator.Authenticate(qualified, password)
+	if err != nil {
+		h.logger.Error("Authentication failed", "username", qualified, "ip", ip, "error", err)
+		if h.config.Throttle != nil {
+			if errors.Is(err, authn.ErrInvalidCredentials) {
+				h.config.Throttle.RecordFailure(qualified, ip)
+			} else {
+				h.config.Throttle.Release(qualified, ip)
+			}
+		}
+		if errors.Is(err, authn.ErrUnavailable) {
+			return nil, &loginFailure{http.StatusServiceUnavailable, "Sign-in is temporarily unavailable."}
+		}
+		return nil, &loginFailure{http.StatusUnauthorized, "Invalid username or password."}
+	}
+	if h.config.MFA != nil || h.config.WebAuthnCredentials != nil {
+		if failure := h.checkSecondFactor(r, identity, qualified, ip); failure != nil {
+			return nil, failure
+		}
+	}
+	// A correct password alone does not clear earlier failures
+	if h.config.Throttle != nil {
+		h.config.Throttle.RecordSuccess(qualified, ip)
+	}
+	return identity, nil
+}
+
+// checkSecondFactor verifies the code entered with the password of a user
+// enrolled for MFA and records the method in identity. Failures end the
+// throttled attempt; wrong codes count like wrong passwords.
+func (h *OIDCProvider) checkSecondFactor(r *http.Request, identity *authn.Identity, qualified, ip string) *loginFailure {
+	enrolled := false
+	if h.config.MFA != nil {
+		var err error
+		if enrolled, err = h.config.MFA.Enrolled(identity.ID); err != nil {
+			h.logger.Error("MFA enrollment lookup failed", "userID", identity.ID, "error", err)
+			h.releaseAttempt(qualified, ip)
+			return &loginFailure{http.StatusServiceUnavailable, "Sign-in is temporarily unavailable."}
+		}
+	}
+	if !enrolled {
+		failure := h.checkSecurityKeys(identity, ip)
+		if failure != nil {
+			h.releaseAttempt(qualified, ip)
+		}
+		return failure
+	}
+	code := strings.TrimSpace(r.PostForm.Get("code"))
+	if code == "" {
+		h.releaseAttempt(qualified, ip)
+		return &loginFailure{http.StatusUnauthorized, "Enter the code from your authenticator app."}
+	}
+
+	method, err := h.config.MFA.Verify(identity.ID, code)
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrCodeReused):
+		audit(h.logger, "MFA code rejected", "userID", identity.ID, "ip", ip, "error", err)
+		if h.config.Throttle != nil {
+			h.config.Throttle.RecordFailure(qualified, ip)
+		}
+		return &loginFailure{http.StatusUnauthorized, "Invalid authentication code."}
+	case err != nil:
+		h.logger.Error("MFA verification failed", "userID", identity.ID, "error", err)
+		h.releaseAttempt(qualified, ip)
+		return &loginFailure{http.StatusServiceUnavailable, "Sign-in is temporarily unavailable."}
+	}
+	if method == auth.AMRRecoveryCode {
+		audit(h.logger, "Recovery code used", "userID", identity.ID, "ip", ip)
+	}
+	identity.Methods = append(append([]string{}, identityMethods(identity)...), method, auth.AMRMultiFactor)
+	return nil
+}
+
+// releaseAttempt ends a throttled attempt without counting it
+func (h *OIDCProvider) releaseAttempt(qualified, ip string) {
+	if h.config.Throttle != nil {
+		h.config.Throttle.Release(qualified, ip)
+	}
+}
+
+// checkSecurityKeys refuses users who can only complete MFA with a
+// security key, which the hosted pages do not support
+func (h *OIDCProvider) checkSecurityKeys(identity *authn.Identity, ip string) *loginFailure {
+	if h.config.WebAuthnCredentials == nil {
+		return nil
+	}
+	credentials, err := h.config.WebAuthnCredentials.Credentials(identity.ID)
+	if err != nil {
+		h.logger.Error("WebAuthn credential lookup failed", "userID", identity.ID, "error", err)
+		return &loginFailure{http.StatusServiceUnavailable, "Sign-in is temporarily unavailable."}
+	}
+	if len(credentials) > 0 {
+		audit(h.logger, "Hosted login refused, security key required", "userID", identity.ID, "ip", ip)
+		return &loginFailure{http.StatusForbidden, "Sign in with your security key in the application."}
+	}
+	return nil
+}
+
+// checkUserScopes checks the scopes a client requests on behalf of a user:
+// the provider's own scopes, plus those registered for the client
+func checkUserScopes(client *oauth.Client, requested []string) error {
+	var registered []string
+	for _, scope := range requested {
+		switch scope {
+		case ScopeOpenID, ScopeProfile, ScopeEmail, ScopeGroups:
+		default:
+			registered = append(registered, scope)
+		}
+	}
+	if len(registered) == 0 {
+		return nil
+	}
+	_, err := client.GrantScopes(registered)
+	return err
+}
+
+// identityMethods returns the amr values of a hosted page login
+func identityMethods(identity *authn.Identity) []string {
+	if len(identity.Methods) == 0 {
+		return []string{auth.AMRPassword}
+	}
+	return identity.Methods
+}
+
+// currentLogin returns the user signed in to the browser session
+func (h *OIDCProvider) currentLogin(r *http.Request) (*sessionIdentity, auth.SessionInfo, bool) {
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok || session.UserID == "" {
+		return nil, session, false
+	}
+	raw, _ := h.sessions.Get(r.Context(), sessionKeyIdentity).(string)
+	var identity sessionIdentity
+	if raw == "" || json.Unmarshal([]byte(raw), &identity) != nil || identity.UserID != session.UserID || identity.Offline {
+		return nil, session, false
+	}
+	return &identity, session, true
+}
+
+func (h *OIDCProvider) issueCode(w http.ResponseWriter, r *http.Request, request authorizationRequest, identity *sessionIdentity, session auth.SessionInfo) {
+	code, err := oauth.IssueCode(h.config.Codes, oauth.AuthorizationCode{
+		ClientID:      request.ClientID,
+		RedirectURI:   request.RedirectURI,
+		CodeChallenge: request.CodeChallenge,
+		Scope:         request.Scope,
+		Nonce:         request.Nonce,
+		UserID:        identity.UserID,
+		SessionID:     session.ID,
+		AuthTime:      session.CreatedAt,
+		AMR:           identity.AMR,
+		Name:          identity.Name,
+		Email:         identity.Email,
+		Groups:        identity.Groups,
+		Attributes:    identity.Attributes,
+		Offline:       identity.Offline,
+		ExpiresAt:     time.Now().Add(h.config.CodeLifetime),
+	})
+	if err != nil {
+		h.logger.Error("Authorization code issuance failed", "error", err)
+		h.redirectError(w, r, request, "server_error", "")
+		return
+	}
+
+	params := url.Values{"code": {code}}
+	h.redirect(w, r, request, params)
+}
+
+// redirectError returns an error to the client's registered redirect URI
+func (h *OIDCProvider) redirectError(w http.ResponseWriter, r *http.Request, request authorizationRequest, code, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	h.redirect(w, r, request, params)
+}
+
+func (h *OIDCProvider) redirect(w http.ResponseWriter, r *http.Request, request authorizationRequest, params url.Values) {
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+	if h.config.Issuer != "" {
+		params.Set("iss", h.config.Issuer)
+	}
+
+	target, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		h.renderError(w, http.StatusBadRequest, "The application sent an invalid redirect URI.")
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+
+	// 303 makes browsers follow a redirect from the login form with GET
+	status := http.StatusFound
+	if r.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), status)
+}
+
+// showLogin keeps request in the session and renders the login page for it
+func (h *OIDCProvider) showLogin(w http.ResponseWriter, r *http.Request, request authorizationRequest, username, message string, status int) {
+	id, err := randomID()
+	if err != nil {
+		h.logger.Error("Failed to generate login request ID", "error", err)
+		h.renderError(w, http.StatusInternalServerError, "Sign-in failed. Try again later.")
+		return
+	}
+	request.ID = id
+
+	pending := append(h.pending(r), request)
+	if len(pending) > maxPendingRequests {
+		pending = pending[len(pending)-maxPendingRequests:]
+	}
+	if err := h.savePending(r, pending); err != nil {
+		h.logger.Error("Failed to save login request", "error", err)
+		h.renderError(w, http.StatusInternalServerError, "Sign-in failed. Try again later.")
+		return
+	}
+
+	h.renderLogin(w, status, loginPageData{
+		Action:    r.URL.Path,
+		RequestID: id,
+		Client:    request.ClientID,
+		Username:  username,
+		Error:     message,
+		MFA:       h.config.MFA != nil,
+	})
+}
+
+// takePending removes and returns the pending request with id. A request
+// can only be used for one login attempt; failed attempts get a new one.
+func (h *OIDCProvider) takePending(r *http.Request, id string) (authorizationRequest, bool) {
+	pending := h.pending(r)
+	for i, request := range pending {
+		if request.ID == id {
+			h.savePending(r, append(pending[:i:i], pending[i+1:]...))
+			return request, true
+		}
+	}
+	return authorizationRequest{}, false
+}
+
+func (h *OIDCProvider) pending(r *http.Request) []authorizationRequest {
+	raw, _ := h.sessions.Get(r.Context(), sessionKeyPending).(string)
+	var pending []authorizationRequest
+	if raw != "" {
+		json.Unmarshal([]byte(raw), &pending)
+	}
+	return pending
+}
+
+func (h *OIDCProvider) savePending(r *http.Request, pending []authorizationRequest) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	h.sessions.Put(r.Context(), sessionKeyPending, string(data))
+	return nil
+}
+
+// HandleToken is the token endpoint. It serves the authorization code and
+// device code grants and, for registered non-human clients,
+// client_credentials and token exchange.
+func (h *OIDCProvider) HandleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondOAuthError(w, h.logger, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+	r, err := h.verifyDPoP(r)
+	if err != nil {
+		h.logger.Error("Token request DPoP proof rejected", "ip", clientIP(r), "error", err)
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_dpop_proof", "")
+		return
+	}
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case oauth.GrantAuthorizationCode:
+		h.authorizationCodeGrant(w, r)
+	case oauth.GrantClientCredentials:
+		h.clientCredentialsGrant(w, r)
+	case oauth.GrantDeviceCode:
+		h.deviceCodeGrant(w, r)
+	case oauth.GrantTokenExchange:
+		h.tokenExchangeGrant(w, r)
+	case "":
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// authenticateClient identifies the client at the token endpoint.
+// Confidential clients must authenticate, with private_key_jwt when the
+// registry is a RequestAuthenticator; public clients send client_id.
+func (h *OIDCProvider) authenticateClient(r *http.Request) (*oauth.Client, error) {
+	var client *oauth.Client
+	var err error
+	if clients, ok := h.clients.(RequestAuthenticator); ok {
+		client, err = clients.AuthenticateRequest(r)
+	} else {
+		client, err = oauth.AuthenticateRequest(r, h.clients, nil)
+	}
+	if !errors.Is(err, oauth.ErrNoClientCredentials) {
+		return client, err
+	}
+
+	client, err = h.clients.Client(r.PostForm.Get("client_id"))
+	if err != nil {
+		return nil, err
+	}
+	if !client.Public() {
+		return nil, fmt.Errorf("%w: client %q must authenticate", oauth.ErrInvalidClient, client.ID)
+	}
+	return client, nil
+}
+
+func (h *OIDCProvider) respondInvalidClient(w http.ResponseWriter, r *http.Request, err error) {
+	h.logger.Error("Token endpoint client authentication failed", "ip", clientIP(r), "error", err)
+	w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	respondOAuthError(w, h.logger, http.StatusUnauthorized, "invalid_client", "")
+}
+
+func (h *OIDCProvider) authorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.respondInvalidClient(w, r, err)
+		return
+	}
+	if !client.AllowsGrantType(oauth.GrantAuthorizationCode) {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+	binding, err := h.tokenBinding(r, client)
+	if err != nil {
+		h.respondBindingError(w, client, err)
+		return
+	}
+
+	code, err := oauth.RedeemCode(h.config.Codes, r.PostForm.Get("code"))
+	switch {
+	case errors.Is(err, oauth.ErrCodeReused):
+		// The code leaked; the tokens issued for it must not stay usable
+		audit(h.logger, "Authorization code reused", "clientID", client.ID, "userID", code.UserID, "ip", clientIP(r))
+		if code.SessionID != "" {
+			until := time.Now().Add(h.accessTokenLifetime(client) + time.Minute)
+			if err := h.tokens.RevokeSessionTokens(code.SessionID, until); err != nil {
+				h.logger.Error("Failed to revoke tokens of reused code", "error", err)
+			}
+		}
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	case err != nil:
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		audit(h.logger, "Authorization code presented by wrong client", "clientID", client.ID, "codeClient", code.ClientID)
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	if err := oauth.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge); err != nil {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	response, err := h.issueTokens(client, code, binding)
+	if err != nil {
+		h.logger.Error("Token issuance failed", "clientID", client.ID, "error", err)
+		respondOAuthError(w, h.logger, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, http.StatusOK, response)
+}
+
+// issueTokens mints the access token and, for openid requests, the ID token
+func (h *OIDCProvider) issueTokens(client *oauth.Client, code *oauth.AuthorizationCode, binding tokenBinding) (*TokenResponse, error) {
+	scopes := code.Scope
+
+	common := []auth.TokenOption{
+		auth.WithAuthTime(code.AuthTime),
+		auth.WithAMR(code.AMR...),
+	}
+	if code.SessionID != "" {
+		common = append(common, auth.WithSessionID(code.SessionID))
+	}
+	name, email := "", ""
+	if contains(scopes, ScopeProfile) {
+		name = code.Name
+	}
+	if contains(scopes, ScopeEmail) {
+		email = code.Email
+	}
+	common = append(common, auth.WithProfile(name, email))
+	if contains(scopes, ScopeGroups) {
+		common = append(common, auth.WithGroups(code.Groups...))
+	}
+
+	accessLifetime, idLifetime := h.accessTokenLifetime(client), h.idTokenLifetime(client)
+	if code.Offline {
+		if accessLifetime > h.config.OfflineTokenLifetime {
+			accessLifetime = h.config.OfflineTokenLifetime
+		}
+		if idLifetime > h.config.OfflineTokenLifetime {
+			idLifetime = h.config.OfflineTokenLifetime
+		}
+		common = append(common, auth.WithOfflineVerified())
+	}
+
+	accessOpts := append([]auth.TokenOption{
+		auth.WithClientID(client.ID),
+		auth.WithScope(scopes...),
+		auth.WithLifetime(accessLifetime),
+	}, common...)
+	if audience := client.TokenAudience(); audience != "" {
+		accessOpts = append(accessOpts, auth.ForAudience(audience))
+	}
+	accessOpts = append(accessOpts, binding.opts...)
+	if h.config.Roles != nil {
+		granted, err := h.config.Roles.Roles(client.Application, roles.Subject{
+			Groups:     code.Groups,
+			Attributes: code.Attributes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		accessOpts = append(accessOpts, auth.WithRoles(granted...))
+	}
+
+	accessToken, err := h.tokens.GenerateToken(code.UserID, accessOpts...)
+	if err != nil {
+		return nil, err
+	}
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   binding.tokenType,
+		ExpiresIn:   int(accessLifetime / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if contains(scopes, ScopeOpenID) {
+		idOpts := append([]auth.TokenOption{
+			auth.AsIDToken(),
+			auth.ForAudience(client.ID),
+			auth.WithNonce(code.Nonce),
+			auth.WithLifetime(idLifetime),
+		}, common...)
+		if response.IDToken, err = h.tokens.GenerateToken(code.UserID, idOpts...); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// accessTokenLifetime is the client's registered lifetime or the default
+func (h *OIDCProvider) accessTokenLifetime(client *oauth.Client) time.Duration {
+	if client.AccessTokenLifetime > 0 {
+		return time.Duration(client.AccessTokenLifetime)
+	}
+	return h.config.AccessTokenLifetime
+}
+
+func (h *OIDCProvider) idTokenLifetime(client *oauth.Client) time.Duration {
+	if client.IDTokenLifetime > 0 {
+		return time.Duration(client.IDTokenLifetime)
+	}
+	return h.config.IDTokenLifetime
+}
+
+// HandleUserinfo returns the claims released by the scopes of the access token
+func (h *OIDCProvider) HandleUserinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		respondOAuthError(w, h.logger, http.StatusMethodNotAllowed, "invalid_request", "")
+		return
+	}
+
+	token, scheme := auth.AuthorizationToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondOAuthError(w, h.logger, http.StatusUnauthorized, "invalid_token", "")
+		return
+	}
+
+	claims, err := h.tokens.ValidateToken(token, auth.WithAnyAudience())
+	if err == nil {
+		err = auth.VerifyTokenBinding(r, h.config.DPoP, scheme, token, claims)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrInvalidDPoPProof), errors.Is(err, auth.ErrMissingDPoPProof):
+		w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+		respondOAuthError(w, h.logger, http.StatusUnauthorized, "invalid_dpop_proof", "")
+		return
+	case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrTokenRevoked):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		respondOAuthError(w, h.logger, http.StatusUnauthorized, "invalid_token", "")
+		return
+	default:
+		h.logger.Error("Userinfo token validation failed", "error", err)
+		respondOAuthError(w, h.logger, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if !contains(scopes, ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		respondOAuthError(w, h.logger, http.StatusForbidden, "insufficient_scope", "")
+		return
+	}
+
+	response := UserinfoResponse{Sub: claims.UserID()}
+	if contains(scopes, ScopeProfile) {
+		response.Name = claims.Name
+	}
+	if contains(scopes, ScopeEmail) {
+		response.Email = claims.Email
+	}
+	if contains(scopes, ScopeGroups) {
+		response.Groups = claims.Groups
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, http.StatusOK, response)
+}
+
+// HandleEndSession implements RP-initiated logout. The client is taken
+// from client_id or from the audience of id_token_hint, whose signature
+// must be ours but which may have expired. The logout itself, including
+// the post_logout_redirect_uri check, is done by ProviderTokens.Logout.
+func (h *OIDCProvider) HandleEndSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Invalid request.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderError(w, http.StatusBadRequest, "Invalid request.")
+		return
+	}
+
+	if hint := r.Form.Get("id_token_hint"); hint != "" {
+		claims, err := h.tokens.VerifyTokenHint(hint)
+		if err != nil {
+			h.logger.Error("Invalid id_token_hint", "error", err)
+			h.renderError(w, http.StatusBadRequest, "The logout request is invalid.")
+			return
+		}
+		clientID := r.Form.Get("client_id")
+		switch {
+		case clientID == "" && len(claims.Audience) == 1:
+			r.Form.Set("client_id", claims.Audience[0])
+		case clientID != "" && !contains(claims.Audience, clientID):
+			h.renderError(w, http.StatusBadRequest, "The logout request is invalid.")
+			return
+		}
+	}
+
+	if err := h.tokens.Logout(w, r); err != nil {
+		h.logger.Error("Logout failed", "error", err)
+	}
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+// internal/handler/oidc_pages.go
+package handler
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// pageSecurityPolicy keeps the hosted pages out of frames and stops them
+// from loading anything. form-action is left open because the login form
+// redirects to the client.
+const pageSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'; base-uri 'none'"
+
+const pageStyle = `body{font-family:system-ui,sans-serif;max-width:22rem;margin:4rem auto;padding:0 1rem}
+label,input,button{display:block;width:100%;box-sizing:border-box;margin:.25rem 0}
+input{padding:.5rem}button{margin-top:1rem;padding:.5rem}.error{color:#b00020}`
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<h1>Sign in</h1>
+{{if .Client}}<p>to continue to {{.Client}}</p>{{end}}
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="login_request" value="{{.RequestID}}">
+<label for="username">Username</label>
+<input id="username" name="username" value="{{.Username}}" autocomplete="username" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+{{if .MFA}}<label for="code">Authentication code, if you use an authenticator app</label>
+<input id="code" name="code" autocomplete="one-time-code">{{end}}
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign-in error</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<h1>Sign-in error</h1>
+<p class="error">{{.}}</p>
+</body>
+</html>
+`))
+
+var deviceCodePage = template.Must(template.New("device_code").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<h1>Connect a device</h1>
+<p>Enter the code shown on your device.</p>
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+<form method="get" action="{{.Action}}">
+<label for="user_code">Code</label>
+<input id="user_code" name="user_code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+var deviceConfirmPage = template.Must(template.New("device_confirm").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<h1>Connect a device</h1>
+<p>{{.Client}} is asking for access with code <strong>{{.UserCode}}</strong>. Only continue if this code is shown on your device.</p>
+{{if .Scope}}<p>Requested access: {{range $i, $s := .Scope}}{{if $i}}, {{end}}{{$s}}{{end}}</p>{{end}}
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<label for="username">Username</label>
+<input id="username" name="username" value="{{.Username}}" autocomplete="username" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+{{if .MFA}}<label for="code">Authentication code, if you use an authenticator app</label>
+<input id="code" name="code" autocomplete="one-time-code">{{end}}
+<button type="submit" name="action" value="approve">Sign in and allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</form>
+</body>
+</html>
+`))
+
+var messagePage = template.Must(template.New("message").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+type loginPageData struct {
+	Action    string
+	RequestID string
+	Client    string
+	Username  string
+	Error     string
+	// MFA shows the authentication code field
+	MFA bool
+}
+
+type devicePageData struct {
+	Action   string
+	UserCode string
+	Client   string
+	Scope    []string
+	Username string
+	Error    string
+	MFA      bool
+}
+
+type pageMessage struct {
+	Title   string
+	Message string
+}
+
+func (h *OIDCProvider) renderLogin(w http.ResponseWriter, status int, data loginPageData) {
+	h.renderPage(w, status, loginPage, data)
+}
+
+// renderError shows an error to the user. It is used where the error must
+// not, or cannot, be sent to the client's redirect URI.
+func (h *OIDCProvider) renderError(w http.ResponseWriter, status int, message string) {
+	h.renderPage(w, status, errorPage, message)
+}
+
+func (h *OIDCProvider) renderPage(w http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", pageSecurityPolicy)
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := page.Execute(w, data); err != nil {
+		h.logger.Error("Failed to render page", "page", page.Name(), "error", err)
+	}
+}
@@ -0,0 +1,507 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func testChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oidcTest runs the provider behind the session middleware, with a browser
+// that keeps cookies but does not follow redirects
+type oidcTest struct {
+	server   *httptest.Server
+	browser  *http.Client
+	tokens   *auth.Client
+	provider *OIDCProvider
+	logger   *mockLogger
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	secretHash, err := passwd.Hash("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := oauth.StaticRegistry{
+		"spa": {ID: "spa", RedirectURIs: []string{testRedirectURI}, PostLogoutRedirectURIs: []string{"https://app.example.com/"}},
+		"web": {ID: "web", SecretHash: secretHash, RedirectURIs: []string{testRedirectURI}, Application: "payments",
+			AccessTokenLifetime: oauth.Duration(5 * time.Minute), Scopes: []string{"payments:read"}},
+		"svc": {ID: "svc", SecretHash: secretHash, RedirectURIs: []string{testRedirectURI}, GrantTypes: []string{oauth.GrantClientCredentials}},
+	}
+
+	sessions := auth.NewSessions(auth.SessionConfig{Store: auth.NewMemorySessionStore(), InsecureCookie: true})
+	tokens := auth.NewClient(auth.Config{
+		JWTSecret:       []byte("test-secret"),
+		TokenDuration:   time.Minute,
+		Issuer:          "https://auth.example.com",
+		RevocationStore: auth.NewMemoryRevocationStore(),
+		SessionManager:  sessions,
+		Logout:          auth.LogoutConfig{RedirectPolicy: clients},
+	})
+	authenticator := authenticatorFunc(func(username, password string) (*authn.Identity, error) {
+		if username != "jdoe@example.com" || password != "s3cret" {
+			return nil, authn.ErrInvalidCredentials
+		}
+		return &authn.Identity{ID: "jdoe", Name: "Jane Doe", Email: "jdoe@example.com", Groups: []string{"staff"}}, nil
+	})
+	logger := &mockLogger{}
+	provider := NewOIDCProvider(authenticator, clients, sessions, tokens, ProviderConfig{
+		Issuer:        "https://auth.example.com",
+		DefaultDomain: "example.com",
+	}, logger)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(AuthorizePath, provider.HandleAuthorize)
+	mux.HandleFunc(TokenPath, provider.HandleToken)
+	mux.HandleFunc(UserinfoPath, provider.HandleUserinfo)
+	mux.HandleFunc(EndSessionPath, provider.HandleEndSession)
+	server := httptest.NewServer(sessions.Middleware(mux))
+	t.Cleanup(server.Close)
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &oidcTest{server: server, browser: browser, tokens: tokens, provider: provider, logger: logger}
+}
+
+type authenticatorFunc func(username, password string) (*authn.Identity, error)
+
+func (f authenticatorFunc) Authenticate(username, password string) (*authn.Identity, error) {
+	return f(username, password)
+}
+
+func authorizeParams(clientID string) url.Values {
+	return url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {testChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func (o *oidcTest) authorize(t *testing.T, params url.Values) *http.Response {
+	t.Helper()
+	resp, err := o.browser.Get(o.server.URL + AuthorizePath + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+var loginRequestPattern = regexp.MustCompile(`name="login_request" value="([^"]+)"`)
+
+// login submits the login form of an authorize response
+func (o *oidcTest) login(t *testing.T, form *http.Response, username, password string) *http.Response {
+	t.Helper()
+	body := readBody(t, form)
+	match := loginRequestPattern.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("no login form in %q", body)
+	}
+	resp, err := o.browser.PostForm(o.server.URL+AuthorizePath, url.Values{
+		"login_request": {match[1]},
+		"username":      {username},
+		"password":      {password},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// redirectParams returns the query of a redirect to the client
+func redirectParams(t *testing.T, resp *http.Response) url.Values {
+	t.Helper()
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("status %d without redirect", resp.StatusCode)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURI+"?") {
+		t.Fatalf("redirect = %s, want %s", location, testRedirectURI)
+	}
+	return location.Query()
+}
+
+func (o *oidcTest) token(t *testing.T, form url.Values, clientID, secret string) (*http.Response, TokenResponse) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, o.server.URL+TokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if secret != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response TokenResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp, response
+}
+
+func codeForm(code string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+		"client_id":     {"spa"},
+	}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	o := newOIDCTest(t)
+
+	form := o.authorize(t, authorizeParams("spa"))
+	if form.StatusCode != http.StatusOK {
+		t.Fatalf("authorize status = %d, want login page", form.StatusCode)
+	}
+	if form.Header.Get("X-Frame-Options") != "DENY" || form.Header.Get("Content-Security-Policy") == "" {
+		t.Errorf("login page headers = %v, want framing protection", form.Header)
+	}
+
+	resp := o.login(t, form, "jdoe", "s3cret")
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("login status = %d, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+	params := redirectParams(t, resp)
+	if params.Get("state") != "xyz" || params.Get("iss") != "https://auth.example.com" || params.Get("code") == "" {
+		t.Fatalf("redirect params = %v", params)
+	}
+
+	resp, tokens := o.token(t, codeForm(params.Get("code")), "spa", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" || tokens.TokenType != "Bearer" || tokens.Scope != "openid profile email" {
+		t.Errorf("token response = %+v", tokens)
+	}
+
+	idClaims, err := o.tokens.VerifyTokenHint(tokens.IDToken)
+	if err != nil {
+		t.Fatalf("VerifyTokenHint(id_token) error = %v", err)
+	}
+	if idClaims.Nonce != "n-0S6_WzA2Mj" || idClaims.UserID() != "jdoe" || len(idClaims.Audience) != 1 || idClaims.Audience[0] != "spa" ||
+		idClaims.SessionID == "" || idClaims.Email != "jdoe@example.com" || idClaims.AuthTime == nil {
+		t.Errorf("id token claims = %+v", idClaims)
+	}
+	if _, err := o.tokens.ValidateToken(tokens.IDToken, auth.WithAnyAudience()); err == nil {
+		t.Error("ID token accepted as access token")
+	}
+
+	accessClaims, err := o.tokens.ValidateToken(tokens.AccessToken, auth.WithAnyAudience())
+	if err != nil {
+		t.Fatalf("ValidateToken(access_token) error = %v", err)
+	}
+	if accessClaims.ClientID != "spa" || accessClaims.SessionID != idClaims.SessionID {
+		t.Errorf("access token claims = %+v", accessClaims)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, o.server.URL+UserinfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	userinfo, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userinfo.Body.Close()
+	var info UserinfoResponse
+	json.NewDecoder(userinfo.Body).Decode(&info)
+	if userinfo.StatusCode != http.StatusOK || info.Sub != "jdoe" || info.Name != "Jane Doe" || info.Email != "jdoe@example.com" || info.Groups != nil {
+		t.Errorf("userinfo = %d %+v", userinfo.StatusCode, info)
+	}
+
+	// The browser session gives single sign-on to the next request
+	params = redirectParams(t, o.authorize(t, authorizeParams("spa")))
+	if params.Get("code") == "" {
+		t.Errorf("second authorize = %v, want a code without login", params)
+	}
+}
+
+func TestOIDCOfflineLogin(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.authenticator = authenticatorFunc(func(username, password string) (*authn.Identity, error) {
+		return &authn.Identity{ID: "jdoe", Methods: []string{auth.AMRPassword, authn.AMROffline}, Offline: true}, nil
+	})
+	o.provider.config.OfflineTokenLifetime = 30 * time.Second
+
+	params := redirectParams(t, o.login(t, o.authorize(t, authorizeParams("spa")), "jdoe", "s3cret"))
+	resp, tokens := o.token(t, codeForm(params.Get("code")), "spa", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if tokens.ExpiresIn != 30 {
+		t.Errorf("expires_in = %d, want the offline lifetime", tokens.ExpiresIn)
+	}
+	for name, token := range map[string]string{"access": tokens.AccessToken, "id": tokens.IDToken} {
+		claims, err := o.tokens.VerifyTokenHint(token)
+		if err != nil {
+			t.Fatalf("%s token: %v", name, err)
+		}
+		if !claims.OfflineVerified || claims.SessionID != "" || time.Until(claims.ExpiresAt.Time) > 30*time.Second {
+			t.Errorf("%s token claims = %+v, want a short-lived offline token without session", name, claims)
+		}
+	}
+
+	// No session was started, so the next request needs a login again
+	if resp := o.authorize(t, authorizeParams("spa")); resp.StatusCode != http.StatusOK {
+		t.Errorf("second authorize status = %d, want the login page", resp.StatusCode)
+	}
+}
+
+func TestOIDCAuthorizeRejectsInvalidRequests(t *testing.T) {
+	o := newOIDCTest(t)
+
+	t.Run("unknown client", func(t *testing.T) {
+		resp := o.authorize(t, authorizeParams("unknown"))
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Location") != "" {
+			t.Errorf("status = %d, location = %q; want an error page", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("unregistered redirect URI", func(t *testing.T) {
+		params := authorizeParams("spa")
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		resp := o.authorize(t, params)
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Location") != "" {
+			t.Errorf("status = %d, location = %q; want an error page", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	tests := []struct {
+		name      string
+		modify    func(url.Values)
+		wantError string
+	}{
+		{"missing PKCE", func(v url.Values) { v.Del("code_challenge") }, "invalid_request"},
+		{"plain PKCE", func(v url.Values) { v.Set("code_challenge_method", "plain") }, "invalid_request"},
+		{"implicit flow", func(v url.Values) { v.Set("response_type", "token") }, "unsupported_response_type"},
+		{"prompt none without session", func(v url.Values) { v.Set("prompt", "none") }, "login_required"},
+		{"client not registered for the code flow", func(v url.Values) { v.Set("client_id", "svc") }, "unauthorized_client"},
+		{"scope not registered for the client", func(v url.Values) { v.Set("scope", "openid payments:read") }, "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := authorizeParams("spa")
+			tt.modify(params)
+			got := redirectParams(t, o.authorize(t, params))
+			if got.Get("error") != tt.wantError || got.Get("state") != "xyz" {
+				t.Errorf("redirect params = %v, want error %s", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestOIDCAuthorizeRegisteredScope(t *testing.T) {
+	o := newOIDCTest(t)
+
+	params := authorizeParams("web")
+	params.Set("scope", "openid profile payments:read")
+	resp := o.authorize(t, params)
+	if resp.StatusCode != http.StatusOK || !loginRequestPattern.MatchString(readBody(t, resp)) {
+		t.Errorf("status = %d, want the login page", resp.StatusCode)
+	}
+}
+
+func TestOIDCLoginFailure(t *testing.T) {
+	o := newOIDCTest(t)
+
+	resp := o.login(t, o.authorize(t, authorizeParams("spa")), "jdoe", "wrong")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// The form is shown again with a fresh request, which still works
+	resp = o.login(t, resp, "jdoe", "s3cret")
+	if params := redirectParams(t, resp); params.Get("code") == "" {
+		t.Errorf("redirect params = %v, want a code", params)
+	}
+}
+
+func TestOIDCTokenGrantChecks(t *testing.T) {
+	o := newOIDCTest(t)
+
+	issue := func(clientID string) string {
+		t.Helper()
+		resp := o.authorize(t, authorizeParams(clientID))
+		if resp.StatusCode == http.StatusOK {
+			resp = o.login(t, resp, "jdoe", "s3cret")
+		}
+		return redirectParams(t, resp).Get("code")
+	}
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		form := codeForm(issue("spa"))
+		form.Set("code_verifier", strings.Repeat("a", 43))
+		if resp, _ := o.token(t, form, "spa", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("wrong redirect URI", func(t *testing.T) {
+		form := codeForm(issue("spa"))
+		form.Set("redirect_uri", "https://app.example.com/other")
+		if resp, _ := o.token(t, form, "spa", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("confidential client must authenticate", func(t *testing.T) {
+		code := issue("web")
+		form := codeForm(code)
+		form.Set("client_id", "web")
+		if resp, _ := o.token(t, form, "web", ""); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("without secret: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+		if resp, _ := o.token(t, form, "web", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("wrong secret: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+
+		form.Del("client_id")
+		resp, tokens := o.token(t, form, "web", "app-secret")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if tokens.ExpiresIn != 300 {
+			t.Errorf("expires_in = %d, want the client's lifetime of 300", tokens.ExpiresIn)
+		}
+		claims, err := o.tokens.ValidateToken(tokens.AccessToken, auth.WithAudience("payments"))
+		if err != nil {
+			t.Errorf("access token for application audience: %v", err)
+		} else if claims.ClientID != "web" {
+			t.Errorf("client_id = %q, want web", claims.ClientID)
+		}
+	})
+
+	t.Run("code of another client", func(t *testing.T) {
+		form := codeForm(issue("web"))
+		if resp, _ := o.token(t, form, "spa", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("reuse revokes tokens", func(t *testing.T) {
+		form := codeForm(issue("spa"))
+		resp, tokens := o.token(t, form, "spa", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("first redemption status = %d", resp.StatusCode)
+		}
+		if resp, _ := o.token(t, form, "spa", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("reuse status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+		if _, err := o.tokens.ValidateToken(tokens.AccessToken, auth.WithAnyAudience()); err == nil {
+			t.Error("access token of a reused code is still valid")
+		}
+	})
+
+	t.Run("unsupported grant", func(t *testing.T) {
+		form := url.Values{"grant_type": {"password"}}
+		if resp, _ := o.token(t, form, "spa", ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestOIDCUserinfoRequiresOpenIDScope(t *testing.T) {
+	o := newOIDCTest(t)
+	token, _ := o.tokens.GenerateToken("jdoe", auth.WithScope("payments:read"))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, UserinfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	o.provider.HandleUserinfo(rr, req)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+		t.Errorf("status = %d, WWW-Authenticate = %q; want insufficient_scope", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+
+	rr = httptest.NewRecorder()
+	o.provider.HandleUserinfo(rr, httptest.NewRequest(http.MethodGet, UserinfoPath, nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCEndSession(t *testing.T) {
+	o := newOIDCTest(t)
+
+	params := redirectParams(t, o.login(t, o.authorize(t, authorizeParams("spa")), "jdoe", "s3cret"))
+	_, tokens := o.token(t, codeForm(params.Get("code")), "spa", "")
+
+	logout := func(values url.Values) *http.Response {
+		t.Helper()
+		resp, err := o.browser.Get(o.server.URL + EndSessionPath + "?" + values.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// The hint names a different client than client_id
+	resp := logout(url.Values{"id_token_hint": {tokens.IDToken}, "client_id": {"web"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("mismatched client: status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp = logout(url.Values{
+		"id_token_hint":            {tokens.IDToken},
+		"post_logout_redirect_uri": {"https://app.example.com/"},
+		"state":                    {"bye"},
+	})
+	if location := resp.Header.Get("Location"); location != "https://app.example.com/?state=bye" {
+		t.Errorf("status = %d, location = %q; want redirect to the client", resp.StatusCode, location)
+	}
+	if _, err := o.tokens.ValidateToken(tokens.AccessToken, auth.WithAnyAudience()); err == nil {
+		t.Error("access token of the session is still valid after logout")
+	}
+
+	noPrompt := authorizeParams("spa")
+	noPrompt.Set("prompt", "none")
+	if got := redirectParams(t, o.authorize(t, noPrompt)); got.Get("error") != "login_required" {
+		t.Errorf("authorize after logout = %v, want login_required", got)
+	}
+}
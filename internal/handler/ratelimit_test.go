@@ -0,0 +1,38 @@
+// internal/handler/ratelimit_test.go
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(60, 2)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow("alice"); !ok {
+			t.Fatalf("request %d should be allowed within burst", i+1)
+		}
+	}
+
+	ok, wait := limiter.allow("alice")
+	if ok {
+		t.Fatal("request beyond burst should be rejected")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want (0, 1s]", wait)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := limiter.allow("bob"); !ok {
+		t.Error("bob should not be limited by alice's usage")
+	}
+
+	// One token refills per second at 60/min
+	now = now.Add(time.Second)
+	if ok, _ := limiter.allow("alice"); !ok {
+		t.Error("request should be allowed after refill")
+	}
+}
@@ -0,0 +1,174 @@
+// internal/handler/refresh.go
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+	"github.com/yovily/customers/citi/auth-service/pkg/roles"
+)
+
+// RefreshClient rotates refresh tokens and mints the new access tokens
+type RefreshClient interface {
+	GenerateToken(userID string, opts ...auth.TokenOption) (string, error)
+	RotateRefreshToken(token string, verify func(*auth.RefreshGrant) error) (*auth.RefreshGrant, string, error)
+	RevokeRefreshToken(token string) error
+}
+
+// AccountDirectory looks up the current state of an account
+type AccountDirectory interface {
+	GetUser(id string, attributes []string) (*ldap.User, error)
+}
+
+type RefreshRequest struct {
+	RefreshToken string
+}
+
+type RefreshResponse struct {
+	UserID       string
+	Token        string
+	RefreshToken string
+}
+
+// errAccountInactive marks accounts that were disabled, locked or removed
+// since the refresh token was issued
+var errAccountInactive = errors.New("account inactive")
+
+type RefreshHandler struct {
+	tokens     RefreshClient
+	directory  AccountDirectory
+	logger     Logger
+	roles      RoleMapper
+	attributes []string
+}
+
+// RefreshOption configures optional RefreshHandler behaviour
+type RefreshOption func(*RefreshHandler)
+
+// WithRefreshRoles re-maps roles from the directory on every refresh. Roles
+// the user has lost are dropped from the grant; new ones need a new login.
+// attributes are the directory attributes the mappings refer to.
+func WithRefreshRoles(mapper RoleMapper, attributes ...string) RefreshOption {
+	return func(h *RefreshHandler) {
+		h.roles = mapper
+		h.attributes = attributes
+	}
+}
+
+func NewRefreshHandler(tokens RefreshClient, directory AccountDirectory, logger Logger, opts ...RefreshOption) *RefreshHandler {
+	h := &RefreshHandler{
+		tokens:    tokens,
+		directory: directory,
+		logger:    logger,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and a new
+// refresh token. The account is looked up in the directory first; disabled,
+// locked or deleted accounts lose the whole token family.
+func (h *RefreshHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+
+	var request RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	ip := clientIP(r)
+	grant, refreshToken, err := h.tokens.RotateRefreshToken(request.RefreshToken, h.checkAccount)
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		audit(h.logger, "Refresh token reuse detected", "ip", ip)
+		h.respondError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		h.respondError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	case errors.Is(err, errAccountInactive):
+		if revokeErr := h.tokens.RevokeRefreshToken(request.RefreshToken); revokeErr != nil {
+			h.logger.Error("Failed to revoke refresh tokens", "error", revokeErr)
+		}
+		audit(h.logger, "Refresh denied for inactive account", "ip", ip, "error", err)
+		h.respondError(w, http.StatusUnauthorized, "account inactive")
+		return
+	case errors.Is(err, ldap.ErrUnavailable):
+		// Keep the token so the client can retry once the directory is back
+		h.logger.Error("Refresh failed, directory unavailable", "error", err)
+		h.respondError(w, http.StatusServiceUnavailable, "directory unavailable")
+		return
+	default:
+		h.logger.Error("Refresh failed", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "refresh failed")
+		return
+	}
+
+	token, err := h.tokens.GenerateToken(grant.UserID, grant.TokenOptions()...)
+	if err != nil {
+		h.logger.Error("Token generation failed", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "token generation failed")
+		return
+	}
+
+	respondJSON(w, h.logger, http.StatusOK, RefreshResponse{
+		UserID:       grant.UserID,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// checkAccount re-reads the account from the directory and refreshes the
+// profile carried by the grant
+func (h *RefreshHandler) checkAccount(grant *auth.RefreshGrant) error {
+	user, err := h.directory.GetUser(grant.UserID, h.attributes)
+	switch {
+	case errors.Is(err, ldap.ErrNotFound):
+		return errAccountInactive
+	case err != nil:
+		return err
+	case user.Disabled:
+		return fmt.Errorf("%w: disabled", errAccountInactive)
+	case user.Locked:
+		return fmt.Errorf("%w: locked", errAccountInactive)
+	}
+
+	grant.Name = user.Name
+	grant.Email = user.Email
+	grant.Groups = user.Groups
+
+	if h.roles != nil {
+		entitled, err := h.roles.Roles(grant.Application, roles.Subject{
+			Groups:     user.Groups,
+			Attributes: user.Attributes,
+		})
+		if err != nil {
+			return fmt.Errorf("role mapping failed: %w", err)
+		}
+		kept := grant.Roles[:0:0]
+		for _, role := range grant.Roles {
+			if containsRole(entitled, role) {
+				kept = append(kept, role)
+			}
+		}
+		grant.Roles = kept
+	}
+	return nil
+}
+
+func (h *RefreshHandler) respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, h.logger, status, ErrorResponse{
+		Error: message,
+	})
+}
@@ -0,0 +1,215 @@
+// internal/handler/refresh_test.go
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+	"github.com/yovily/customers/citi/auth-service/pkg/roles"
+)
+
+type mockAccountDirectory struct {
+	user *ldap.User
+	err  error
+}
+
+func (m *mockAccountDirectory) GetUser(id string, attributes []string) (*ldap.User, error) {
+	return m.user, m.err
+}
+
+func newRefreshTestClient() *auth.Client {
+	return auth.NewClient(auth.Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: time.Minute,
+		RefreshStore:  auth.NewMemoryRefreshStore(),
+	})
+}
+
+func postRefresh(h *RefreshHandler, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: token})
+	req := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.HandleRefresh(rr, req)
+	return rr
+}
+
+func TestHandleRefresh(t *testing.T) {
+	client := newRefreshTestClient()
+	directory := &mockAccountDirectory{user: &ldap.User{ID: "jdoe", Name: "John Doe", Groups: []string{"Staff"}}}
+	logger := &mockLogger{}
+	h := NewRefreshHandler(client, directory, logger)
+
+	first, err := client.IssueRefreshToken(auth.RefreshGrant{UserID: "jdoe", AMR: []string{auth.AMRPassword}})
+	if err != nil {
+		t.Fatalf("IssueRefreshToken() unexpected error: %v", err)
+	}
+
+	rr := postRefresh(h, first)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var response RefreshResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.RefreshToken == "" || response.RefreshToken == first {
+		t.Error("refresh token should be rotated")
+	}
+	claims, err := client.ValidateToken(response.Token)
+	if err != nil {
+		t.Fatalf("access token invalid: %v", err)
+	}
+	if claims.UserID() != "jdoe" || claims.Name != "John Doe" || len(claims.Groups) != 1 {
+		t.Errorf("claims = %+v, want profile refreshed from the directory", claims)
+	}
+
+	// Replaying the rotated token revokes the family
+	if rr := postRefresh(h, first); rr.Code != http.StatusUnauthorized {
+		t.Errorf("reuse status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := postRefresh(h, response.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("status after reuse = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if len(logger.errorMsgs) == 0 || logger.errorMsgs[len(logger.errorMsgs)-1] != "Refresh token reuse detected" {
+		t.Errorf("expected reuse audit entry, got %v", logger.errorMsgs)
+	}
+}
+
+func TestHandleRefreshAccountStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        *ldap.User
+		err         error
+		wantStatus  int
+		wantRevoked bool
+	}{
+		{name: "disabled", user: &ldap.User{ID: "jdoe", Disabled: true}, wantStatus: http.StatusUnauthorized, wantRevoked: true},
+		{name: "locked", user: &ldap.User{ID: "jdoe", Locked: true}, wantStatus: http.StatusUnauthorized, wantRevoked: true},
+		{name: "deleted", err: ldap.ErrNotFound, wantStatus: http.StatusUnauthorized, wantRevoked: true},
+		{name: "directory down", err: fmt.Errorf("connect: %w", ldap.ErrUnavailable), wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newRefreshTestClient()
+			directory := &mockAccountDirectory{user: tt.user, err: tt.err}
+			h := NewRefreshHandler(client, directory, &mockLogger{})
+
+			token, _ := client.IssueRefreshToken(auth.RefreshGrant{UserID: "jdoe"})
+			if rr := postRefresh(h, token); rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+
+			// Once the account is healthy again the token works only if it was kept
+			directory.user, directory.err = &ldap.User{ID: "jdoe"}, nil
+			rr := postRefresh(h, token)
+			if revoked := rr.Code != http.StatusOK; revoked != tt.wantRevoked {
+				t.Errorf("token revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestHandleRefreshDropsLostRoles(t *testing.T) {
+	mapper, err := roles.New(roles.Config{
+		Applications: map[string]roles.Profile{
+			"payments": {Rules: []roles.Rule{
+				{Group: "payments-admins", Roles: []string{"admin"}},
+				{Group: "staff", Roles: []string{"user"}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("roles.New() unexpected error: %v", err)
+	}
+
+	client := newRefreshTestClient()
+	// The user has left payments-admins since logging in
+	directory := &mockAccountDirectory{user: &ldap.User{ID: "jdoe", Groups: []string{"CN=Staff,OU=Groups,DC=corp"}}}
+	h := NewRefreshHandler(client, directory, &mockLogger{}, WithRefreshRoles(mapper))
+
+	token, _ := client.IssueRefreshToken(auth.RefreshGrant{
+		UserID:      "jdoe",
+		Application: "payments",
+		Audience:    []string{"payments"},
+		Roles:       []string{"admin", "user"},
+	})
+
+	rr := postRefresh(h, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var response RefreshResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	claims, err := client.ValidateToken(response.Token, auth.WithAudience("payments"))
+	if err != nil {
+		t.Fatalf("access token invalid: %v", err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "user" {
+		t.Errorf("roles = %v, want [user]", claims.Roles)
+	}
+}
+
+func TestHandleRefreshInvalidRequest(t *testing.T) {
+	h := NewRefreshHandler(newRefreshTestClient(), &mockAccountDirectory{}, &mockLogger{})
+
+	if rr := postRefresh(h, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("empty token status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := postRefresh(h, "unknown"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/refresh", nil)
+	rr := httptest.NewRecorder()
+	h.HandleRefresh(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+type backendAuthenticator struct {
+	backend string
+	offline bool
+}
+
+func (b backendAuthenticator) Authenticate(username, password string) (*authn.Identity, error) {
+	return &authn.Identity{ID: "jdoe", Backend: b.backend, Offline: b.offline, Methods: []string{auth.AMRPassword}}, nil
+}
+
+func TestHandleAuthenticationIssuesRefreshToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		auth        backendAuthenticator
+		wantRefresh bool
+	}{
+		{name: "directory login", auth: backendAuthenticator{backend: authn.BackendLDAP}, wantRefresh: true},
+		{name: "offline login", auth: backendAuthenticator{backend: authn.BackendLDAP, offline: true}},
+		{name: "file login", auth: backendAuthenticator{backend: authn.BackendFile}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newRefreshTestClient()
+			h := NewAuthHandler(tt.auth, client, &mockLogger{}, WithRefreshTokens(client))
+
+			rr := postAuth(h, AuthRequest{UserID: "jdoe", Password: "pw", Domain: "example.com"}, "10.0.0.1:1234")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+			var response AuthResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			if got := response.RefreshToken != ""; got != tt.wantRefresh {
+				t.Errorf("refresh token issued = %v, want %v", got, tt.wantRefresh)
+			}
+		})
+	}
+}
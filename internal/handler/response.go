@@ -0,0 +1,49 @@
+// internal/handler/response.go
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+func respondJSON(w http.ResponseWriter, logger Logger, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Error("Failed to encode response", "error", err)
+	}
+}
+
+// audit records security relevant events at error level so they are never
+// filtered out
+func audit(logger Logger, msg string, keyvals ...interface{}) {
+	logger.Error(msg, append([]interface{}{"audit", true}, keyvals...)...)
+}
+
+// clientIP returns the address of the peer that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// OAuthError is the error response of the OAuth endpoints (RFC 6749 section 5.2)
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// respondOAuthError writes an OAuth error. Responses carrying token state
+// must never be cached.
+func respondOAuthError(w http.ResponseWriter, logger Logger, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, logger, status, OAuthError{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
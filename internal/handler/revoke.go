@@ -0,0 +1,228 @@
+// internal/handler/revoke.go
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+)
+
+// DefaultAdminRole is the role required by the administrative endpoints
+const DefaultAdminRole = "auth-admin"
+
+// TokenRevoker revokes access and refresh tokens
+type TokenRevoker interface {
+	ValidateToken(token string, opts ...auth.ValidateOption) (*auth.Claims, error)
+	RevokeAccessToken(token string) error
+	RefreshTokenGrant(token string) (*auth.RefreshGrant, error)
+	RevokeRefreshToken(token string) error
+	RevokeUserTokens(userID string, before time.Time) error
+}
+
+// RevocationConfig controls who may use the administrative endpoint
+type RevocationConfig struct {
+	// AdminRole defaults to DefaultAdminRole
+	AdminRole string
+}
+
+type RevokeUserRequest struct {
+	// UserID may be any spelling of the account; the response carries its
+	// canonical ID
+	UserID string
+	// Before revokes tokens issued before this time. Defaults to now.
+	Before time.Time
+}
+
+type RevokeUserResponse struct {
+	UserID string
+	Before time.Time
+}
+
+type RevocationHandler struct {
+	tokens  TokenRevoker
+	clients ClientAuthenticator
+	config  RevocationConfig
+	logger  Logger
+}
+
+func NewRevocationHandler(tokens TokenRevoker, clients ClientAuthenticator, config RevocationConfig, logger Logger) *RevocationHandler {
+	if config.AdminRole == "" {
+		config.AdminRole = DefaultAdminRole
+	}
+
+	return &RevocationHandler{
+		tokens:  tokens,
+		clients: clients,
+		config:  config,
+		logger:  logger,
+	}
+}
+
+// errNotTokenOwner refuses to revoke a token issued to another client
+var errNotTokenOwner = errors.New("token was not issued to the client")
+
+// tokenRevoker revokes token if it is of one kind and was issued to
+// clientID. It returns false when the token is not of its kind.
+type tokenRevoker func(token, clientID string) (bool, error)
+
+// HandleRevoke implements RFC 7009 token revocation. Callers authenticate
+// like at the introspection endpoint and may only revoke tokens issued to
+// them. The form parameter "token" holds an access or refresh token;
+// "token_type_hint" only decides which kind is tried first. Tokens that
+// cannot be revoked are answered with 200 as the RFC requires, so the
+// endpoint reveals nothing about them.
+func (h *RevocationHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondOAuthError(w, h.logger, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+
+	clientID, err := authenticateClient(r, h.clients)
+	if err != nil {
+		h.logger.Error("Revocation client authentication failed", "clientID", clientID, "ip", clientIP(r), "error", err)
+		w.Header().Set("WWW-Authenticate", `Basic realm="revoke"`)
+		respondOAuthError(w, h.logger, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	revokers := []tokenRevoker{h.revokeAccessToken, h.revokeRefreshToken}
+	if r.PostForm.Get("token_type_hint") == "refresh_token" {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+
+	for _, revoke := range revokers {
+		revoked, err := revoke(token, clientID)
+		if errors.Is(err, errNotTokenOwner) {
+			audit(h.logger, "Token revocation refused", "clientID", clientID, "ip", clientIP(r))
+			respondOAuthError(w, h.logger, http.StatusBadRequest, "unauthorized_client", "token was not issued to the client")
+			return
+		}
+		if err != nil {
+			h.logger.Error("Token revocation failed", "error", err)
+			respondOAuthError(w, h.logger, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+			return
+		}
+		if revoked {
+			audit(h.logger, "Token revoked", "clientID", clientID, "ip", clientIP(r))
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *RevocationHandler) revokeAccessToken(token, clientID string) (bool, error) {
+	claims, err := h.tokens.ValidateToken(token, auth.WithAnyAudience())
+	if err != nil {
+		return notRevocable(err)
+	}
+	if claims.ClientID != clientID && !contains(claims.Audience, clientID) {
+		return false, errNotTokenOwner
+	}
+	if err := h.tokens.RevokeAccessToken(token); err != nil {
+		return notRevocable(err)
+	}
+	return true, nil
+}
+
+func (h *RevocationHandler) revokeRefreshToken(token, clientID string) (bool, error) {
+	grant, err := h.tokens.RefreshTokenGrant(token)
+	if err != nil {
+		return notRevocable(err)
+	}
+	if !contains(grant.Audience, clientID) {
+		return false, errNotTokenOwner
+	}
+	if err := h.tokens.RevokeRefreshToken(token); err != nil {
+		return notRevocable(err)
+	}
+	return true, nil
+}
+
+// notRevocable turns the errors of tokens that are invalid, already revoked
+// or of another kind into false; anything else is a failure of the stores
+func notRevocable(err error) (bool, error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidToken),
+		errors.Is(err, auth.ErrTokenRevoked),
+		errors.Is(err, auth.ErrInvalidRefreshToken),
+		errors.Is(err, auth.ErrRefreshNotConfigured):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// HandleRevokeUser revokes every token issued to a user before a point in
+// time, including refresh tokens. The caller needs the admin role.
+func (h *RevocationHandler) HandleRevokeUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+
+	admin, ok := h.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var request RevokeUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	// Tokens carry the canonical ID, whichever spelling the user logged in with
+	request.UserID = authn.CanonicalID(request.UserID)
+	if request.UserID == "" {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if request.Before.IsZero() {
+		request.Before = time.Now()
+	}
+
+	if err := h.tokens.RevokeUserTokens(request.UserID, request.Before); err != nil {
+		h.logger.Error("User token revocation failed", "userID", request.UserID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "revocation failed")
+		return
+	}
+
+	audit(h.logger, "User tokens revoked", "userID", request.UserID, "before", request.Before, "admin", admin.UserID())
+	respondJSON(w, h.logger, http.StatusOK, RevokeUserResponse{
+		UserID: request.UserID,
+		Before: request.Before,
+	})
+}
+
+// authorizeAdmin checks the bearer token, its binding and the admin role
+func (h *RevocationHandler) authorizeAdmin(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	claims, ok := bearerClaims(w, r, h.tokens, h.logger)
+	if !ok {
+		return nil, false
+	}
+
+	if !claims.HasRole(h.config.AdminRole) {
+		audit(h.logger, "Administrative request denied", "userID", claims.UserID(), "path", r.URL.Path)
+		h.respondError(w, http.StatusForbidden, "forbidden")
+		return nil, false
+	}
+
+	return claims, true
+}
+
+func (h *RevocationHandler) respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, h.logger, status, ErrorResponse{
+		Error: message,
+	})
+}
@@ -0,0 +1,193 @@
+// internal/handler/revoke_test.go
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+func newRevocationTestClient() *auth.Client {
+	return auth.NewClient(auth.Config{
+		JWTSecret:       []byte("test-secret"),
+		TokenDuration:   time.Hour,
+		RevocationStore: auth.NewMemoryRevocationStore(),
+		RefreshStore:    auth.NewMemoryRefreshStore(),
+	})
+}
+
+func postRevoke(h *RevocationHandler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("app", "s3cret")
+	rr := httptest.NewRecorder()
+	h.HandleRevoke(rr, req)
+	return rr
+}
+
+func TestHandleRevoke(t *testing.T) {
+	client := newRevocationTestClient()
+	h := NewRevocationHandler(client, secretClients(t, "app"), RevocationConfig{}, &mockLogger{})
+
+	access, _ := client.GenerateToken("jdoe", auth.WithClientID("app"))
+	refresh, _ := client.IssueRefreshToken(auth.RefreshGrant{UserID: "jdoe", Audience: []string{"app"}})
+
+	if rr := postRevoke(h, url.Values{"token": {access}}); rr.Code != http.StatusOK {
+		t.Fatalf("access token status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if _, err := client.ValidateToken(access); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("access token still valid: %v", err)
+	}
+
+	// The hint only changes the order; a wrong hint still revokes
+	if rr := postRevoke(h, url.Values{"token": {refresh}, "token_type_hint": {"access_token"}}); rr.Code != http.StatusOK {
+		t.Fatalf("refresh token status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if _, _, err := client.RotateRefreshToken(refresh, nil); !errors.Is(err, auth.ErrInvalidRefreshToken) {
+		t.Errorf("refresh token still valid: %v", err)
+	}
+
+	rr := postRevoke(h, url.Values{"token": {"unknown"}, "token_type_hint": {"refresh_token"}})
+	if rr.Code != http.StatusOK {
+		t.Errorf("unknown token status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("revocation responses must not be cached")
+	}
+
+	rr = postRevoke(h, url.Values{})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("missing token status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	var oauthErr OAuthError
+	json.NewDecoder(rr.Body).Decode(&oauthErr)
+	if oauthErr.Error != "invalid_request" {
+		t.Errorf("error = %q, want invalid_request", oauthErr.Error)
+	}
+}
+
+func TestHandleRevokeRequiresClient(t *testing.T) {
+	client := newRevocationTestClient()
+	h := NewRevocationHandler(client, secretClients(t, "app", "other"), RevocationConfig{}, &mockLogger{})
+
+	access, _ := client.GenerateToken("jdoe", auth.WithClientID("other"))
+	refresh, _ := client.IssueRefreshToken(auth.RefreshGrant{UserID: "jdoe", Audience: []string{"other"}})
+
+	for _, credentials := range [][2]string{{"", ""}, {"app", "wrong"}} {
+		req := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(url.Values{"token": {access}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if credentials[0] != "" {
+			req.SetBasicAuth(credentials[0], credentials[1])
+		}
+		rr := httptest.NewRecorder()
+		h.HandleRevoke(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("client %q: status = %d, want %d", credentials[0], rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Tokens issued to another client are refused and stay valid
+	for name, token := range map[string]string{"access": access, "refresh": refresh} {
+		rr := postRevoke(h, url.Values{"token": {token}})
+		var oauthErr OAuthError
+		json.NewDecoder(rr.Body).Decode(&oauthErr)
+		if rr.Code != http.StatusBadRequest || oauthErr.Error != "unauthorized_client" {
+			t.Errorf("%s token of another client: status = %d, error = %q", name, rr.Code, oauthErr.Error)
+		}
+	}
+	if _, err := client.ValidateToken(access); err != nil {
+		t.Errorf("access token of another client revoked: %v", err)
+	}
+	if _, err := client.RefreshTokenGrant(refresh); err != nil {
+		t.Errorf("refresh token of another client revoked: %v", err)
+	}
+}
+
+func TestHandleRevokeTokenWithoutID(t *testing.T) {
+	client := newRevocationTestClient()
+	h := NewRevocationHandler(client, secretClients(t, "app"), RevocationConfig{}, &mockLogger{})
+
+	// A token without jti cannot be revoked, which is not a server failure
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "jdoe",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		ClientID: "app",
+	}).SignedString([]byte("test-secret"))
+
+	if rr := postRevoke(h, url.Values{"token": {token}}); rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestHandleRevokeUser(t *testing.T) {
+	client := newRevocationTestClient()
+	logger := &mockLogger{}
+	h := NewRevocationHandler(client, secretClients(t), RevocationConfig{}, logger)
+
+	admin, _ := client.GenerateToken("admin", auth.WithRoles(DefaultAdminRole))
+	viewer, _ := client.GenerateToken("viewer", auth.WithRoles("viewer"))
+	victim, _ := client.GenerateToken("jdoe")
+
+	post := func(token string, request RevokeUserRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest(http.MethodPost, "/admin/revoke-user", bytes.NewBuffer(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		h.HandleRevokeUser(rr, req)
+		return rr
+	}
+
+	if rr := post("", RevokeUserRequest{UserID: "jdoe"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := post(viewer, RevokeUserRequest{UserID: "jdoe"}); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	// Sender-constrained admin tokens are useless without their key
+	dpopBound, _ := client.GenerateToken("admin", auth.WithRoles(DefaultAdminRole), auth.WithDPoPKey("0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"))
+	certBound, _ := client.GenerateToken("admin", auth.WithRoles(DefaultAdminRole), auth.WithCertificateBinding("bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"))
+	for name, token := range map[string]string{"DPoP": dpopBound, "certificate": certBound} {
+		if rr := post(token, RevokeUserRequest{UserID: "jdoe"}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s bound admin token as bearer: status = %d, want %d", name, rr.Code, http.StatusUnauthorized)
+		}
+	}
+	for _, userID := range []string{"", " ", "@corp.example.com"} {
+		if rr := post(admin, RevokeUserRequest{UserID: userID}); rr.Code != http.StatusBadRequest {
+			t.Errorf("user %q status = %d, want %d", userID, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Any spelling of the account revokes the canonical subject
+	rr := post(admin, RevokeUserRequest{UserID: `CORP\JDoe`, Before: time.Now().Add(time.Second)})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("admin status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	var response RevokeUserResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.UserID != "jdoe" {
+		t.Errorf("response UserID = %q, want jdoe", response.UserID)
+	}
+	if _, err := client.ValidateToken(victim); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("user token still valid: %v", err)
+	}
+	if _, err := client.ValidateToken(admin); err != nil {
+		t.Errorf("admin token affected: %v", err)
+	}
+	if logger.errorMsgs[len(logger.errorMsgs)-1] != "User tokens revoked" {
+		t.Errorf("expected audit entry, got %v", logger.errorMsgs)
+	}
+}
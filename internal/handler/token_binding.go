@@ -0,0 +1,98 @@
+// internal/handler/token_binding.go
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+)
+
+type dpopProofKey struct{}
+
+var (
+	// errDPoPRequired is returned for clients registered for DPoP bound
+	// tokens that sent no proof
+	errDPoPRequired = errors.New("DPoP proof required")
+	// errCertificateRequired is returned for clients registered for
+	// certificate-bound tokens that connected without a client certificate
+	errCertificateRequired = errors.New("client certificate required")
+)
+
+// tokenBinding binds new access tokens to a key of the client, turning
+// them from bearer tokens into sender-constrained ones
+type tokenBinding struct {
+	opts      []auth.TokenOption
+	tokenType string
+}
+
+// bearerClaims validates the bearer token of a request to one of the JSON
+// endpoints and checks its binding the way auth.Middleware does. Tokens
+// bound to a DPoP key are refused since these endpoints take no proof. It
+// answers 401 itself when the token is missing or invalid.
+func bearerClaims(w http.ResponseWriter, r *http.Request, tokens TokenValidator, logger Logger) (*auth.Claims, bool) {
+	token := auth.BearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondJSON(w, logger, http.StatusUnauthorized, ErrorResponse{Error: "authentication required"})
+		return nil, false
+	}
+	claims, err := tokens.ValidateToken(token)
+	if err == nil {
+		err = auth.VerifyTokenBinding(r, nil, auth.SchemeBearer, token, claims)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		respondJSON(w, logger, http.StatusUnauthorized, ErrorResponse{Error: "invalid token"})
+		return nil, false
+	}
+	return claims, true
+}
+
+// verifyDPoP checks the DPoP proof of a token request, before any grant is
+// consumed, and keeps it in the request context for tokenBinding
+func (h *OIDCProvider) verifyDPoP(r *http.Request) (*http.Request, error) {
+	if h.config.DPoP == nil {
+		return r, nil
+	}
+	proof, err := h.config.DPoP.VerifyRequest(r, "")
+	switch {
+	case errors.Is(err, auth.ErrMissingDPoPProof):
+		return r, nil
+	case err != nil:
+		return r, err
+	}
+	return r.WithContext(context.WithValue(r.Context(), dpopProofKey{}, proof)), nil
+}
+
+// tokenBinding returns how access tokens issued for r are bound: to the
+// TLS client certificate of the connection (RFC 8705), to the DPoP key the
+// client proved, to both, or not at all
+func (h *OIDCProvider) tokenBinding(r *http.Request, client *oauth.Client) (tokenBinding, error) {
+	binding := tokenBinding{tokenType: auth.SchemeBearer}
+
+	if cert := auth.ClientCertificate(r); cert != nil {
+		binding.opts = append(binding.opts, auth.WithCertificateBinding(auth.CertificateThumbprint(cert)))
+	} else if client.TLSClientCertificateBoundAccessTokens {
+		return tokenBinding{}, errCertificateRequired
+	}
+
+	if proof, ok := r.Context().Value(dpopProofKey{}).(*auth.DPoPProof); ok {
+		binding.opts = append(binding.opts, auth.WithDPoPKey(proof.Thumbprint))
+		binding.tokenType = auth.SchemeDPoP
+	} else if client.DPoPBoundAccessTokens {
+		return tokenBinding{}, errDPoPRequired
+	}
+	return binding, nil
+}
+
+func (h *OIDCProvider) respondBindingError(w http.ResponseWriter, client *oauth.Client, err error) {
+	audit(h.logger, "Token request without required binding", "clientID", client.ID, "error", err)
+	code := "invalid_request"
+	if errors.Is(err, errDPoPRequired) {
+		code = "invalid_dpop_proof"
+	}
+	respondOAuthError(w, h.logger, http.StatusBadRequest, code, err.Error())
+}
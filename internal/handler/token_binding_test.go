@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+// dpopProof signs a DPoP proof with key for a request; accessToken may be
+// empty
+func dpopProof(t *testing.T, key *ecdsa.PrivateKey, method, htu, accessToken string) string {
+	t.Helper()
+	claims := jwt.MapClaims{"htm": method, "htu": htu, "iat": time.Now().Unix(), "jti": uuid.NewString()}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	jwk, _ := auth.PublicJWK(&key.PublicKey)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = jwk
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newDPoPProvider(t *testing.T) (*OIDCProvider, *auth.Client) {
+	t.Helper()
+	hash, err := passwd.Hash("svc-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := oauth.StaticRegistry{
+		"batch":  {ID: "batch", SecretHash: hash, GrantTypes: []string{oauth.GrantClientCredentials}, Scopes: []string{"openid"}},
+		"strict": {ID: "strict", SecretHash: hash, GrantTypes: []string{oauth.GrantClientCredentials}, DPoPBoundAccessTokens: true},
+		"mtls": {ID: "mtls", SecretHash: hash, GrantTypes: []string{oauth.GrantClientCredentials},
+			TLSClientCertificateBoundAccessTokens: true},
+	}
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	provider := NewOIDCProvider(nil, clients, nil, tokens, ProviderConfig{
+		DPoP: auth.NewDPoPVerifier("https://auth.example.com"),
+	}, &mockLogger{})
+	return provider, tokens
+}
+
+func postTokenWithProof(h *OIDCProvider, form url.Values, clientID, proof string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, TokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, "svc-secret")
+	if proof != "" {
+		req.Header.Set(auth.DPoPHeader, proof)
+	}
+	rr := httptest.NewRecorder()
+	h.HandleToken(rr, req)
+	return rr
+}
+
+func TestTokenEndpointDPoP(t *testing.T) {
+	provider, tokens := newDPoPProvider(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := auth.PublicJWK(&key.PublicKey)
+	thumbprint, _ := jwk.Thumbprint()
+	form := url.Values{"grant_type": {oauth.GrantClientCredentials}}
+
+	rr := postTokenWithProof(provider, form, "batch", dpopProof(t, key, "POST", testTokenEndpoint, ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response TokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.TokenType != "DPoP" {
+		t.Errorf("token_type = %q, want DPoP", response.TokenType)
+	}
+	claims, err := tokens.ValidateToken(response.AccessToken)
+	if err != nil || !claims.DPoPBound() || claims.Confirmation.JKT != thumbprint {
+		t.Fatalf("ValidateToken() = %+v, %v; want cnf.jkt %q", claims, err, thumbprint)
+	}
+
+	// Userinfo takes the bound token only with a proof of the same key
+	userinfo := func(scheme, proof string) int {
+		req := httptest.NewRequest(http.MethodGet, "https://auth.example.com"+UserinfoPath, nil)
+		req.Header.Set("Authorization", scheme+" "+response.AccessToken)
+		if proof != "" {
+			req.Header.Set(auth.DPoPHeader, proof)
+		}
+		rr := httptest.NewRecorder()
+		provider.HandleUserinfo(rr, req)
+		return rr.Code
+	}
+	if code := userinfo("DPoP", dpopProof(t, key, "GET", "https://auth.example.com"+UserinfoPath, response.AccessToken)); code != http.StatusOK {
+		t.Errorf("userinfo with proof: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := userinfo("Bearer", ""); code != http.StatusUnauthorized {
+		t.Errorf("userinfo with bound token as bearer: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// Without a proof the token is a plain bearer token
+	rr = postTokenWithProof(provider, form, "batch", "")
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.TokenType != "Bearer" {
+		t.Errorf("token_type without proof = %q, want Bearer", response.TokenType)
+	}
+}
+
+func TestTokenEndpointCertificateBinding(t *testing.T) {
+	provider, tokens := newDPoPProvider(t)
+	form := url.Values{"grant_type": {oauth.GrantClientCredentials}}
+
+	cert := selfSignedCertificate(t)
+	req := httptest.NewRequest(http.MethodPost, TokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("mtls", "svc-secret")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rr := httptest.NewRecorder()
+	provider.HandleToken(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var response TokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	claims, err := tokens.ValidateToken(response.AccessToken)
+	if err != nil || !claims.CertificateBound() || claims.Confirmation.X5TS256 != auth.CertificateThumbprint(cert) {
+		t.Fatalf("ValidateToken() = %+v, %v; want a token bound to the certificate", claims, err)
+	}
+	if response.TokenType != "Bearer" {
+		t.Errorf("token_type = %q, want Bearer", response.TokenType)
+	}
+
+	// The client is registered for bound tokens only
+	rr = postTokenWithProof(provider, form, "mtls", "")
+	var body OAuthError
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusBadRequest || body.Error != "invalid_request" {
+		t.Errorf("without certificate: got %d %q, want %d invalid_request", rr.Code, body.Error, http.StatusBadRequest)
+	}
+}
+
+func selfSignedCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mtls"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestTokenEndpointDPoPRejects(t *testing.T) {
+	provider, _ := newDPoPProvider(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	form := url.Values{"grant_type": {oauth.GrantClientCredentials}}
+
+	replayed := dpopProof(t, key, "POST", testTokenEndpoint, "")
+	postTokenWithProof(provider, form, "batch", replayed)
+
+	tests := []struct {
+		name     string
+		clientID string
+		proof    string
+	}{
+		{"proof for another URL", "batch", dpopProof(t, key, "POST", "https://auth.example.com/other", "")},
+		{"replayed proof", "batch", replayed},
+		{"proof required by registration", "strict", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postTokenWithProof(provider, form, tt.clientID, tt.proof)
+			var body OAuthError
+			json.NewDecoder(rr.Body).Decode(&body)
+			if rr.Code != http.StatusBadRequest || body.Error != "invalid_dpop_proof" {
+				t.Errorf("got %d %q, want %d invalid_dpop_proof", rr.Code, body.Error, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// internal/handler/token_exchange.go
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+)
+
+// tokenExchangeGrant lets a service call another service on a user's
+// behalf (RFC 8693). The service presents the user's token, which must have
+// been issued for it, and gets a token for the downstream audience with no
+// more scope than the original and an act claim naming the service. Roles
+// are not carried over, as they were granted for the original audience.
+func (h *OIDCProvider) tokenExchangeGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := h.authenticateClient(r)
+	if err == nil && client.Public() {
+		err = oauth.ErrInvalidClient
+	}
+	if err != nil {
+		h.respondInvalidClient(w, r, err)
+		return
+	}
+	policy := client.TokenExchange
+	if !client.AllowsGrantType(oauth.GrantTokenExchange) || policy == nil {
+		audit(h.logger, "Token exchange not allowed", "clientID", client.ID, "ip", clientIP(r))
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	binding, err := h.tokenBinding(r, client)
+	if err != nil {
+		h.respondBindingError(w, client, err)
+		return
+	}
+
+	form := r.PostForm
+	if form.Get("subject_token") == "" || !oauth.ExchangeableTokenType(form.Get("subject_token_type")) {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "subject_token of a supported subject_token_type is required")
+		return
+	}
+	if requested := form.Get("requested_token_type"); requested != "" && requested != oauth.TokenTypeAccessToken {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "only access tokens can be requested")
+		return
+	}
+	if (form.Get("actor_token") == "") != (form.Get("actor_token_type") == "") {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "actor_token and actor_token_type go together")
+		return
+	}
+
+	audiences := append(append([]string{}, form["audience"]...), form["resource"]...)
+	if len(audiences) == 0 {
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "audience is required")
+		return
+	}
+	for _, audience := range audiences {
+		if !policy.AllowsAudience(audience) {
+			audit(h.logger, "Token exchange for unregistered audience", "clientID", client.ID, "audience", audience)
+			respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_target", "")
+			return
+		}
+	}
+
+	subject, err := h.tokens.ValidateToken(form.Get("subject_token"), auth.WithAnyAudience())
+	if err != nil {
+		h.logger.Error("Token exchange subject token rejected", "clientID", client.ID, "error", err)
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	if !issuedFor(subject, client) {
+		audit(h.logger, "Token exchange of a token issued for another client", "clientID", client.ID, "userID", subject.UserID(), "audience", subject.Audience)
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	actor := client.ID
+	if actorToken := form.Get("actor_token"); actorToken != "" {
+		if !oauth.ExchangeableTokenType(form.Get("actor_token_type")) {
+			respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_request", "unsupported actor_token_type")
+			return
+		}
+		claims, err := h.tokens.ValidateToken(actorToken, auth.WithAnyAudience())
+		if err != nil || !policy.AllowsActor(client.ID, claims.UserID()) {
+			audit(h.logger, "Token exchange actor token rejected", "clientID", client.ID, "error", err)
+			respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+			return
+		}
+		actor = claims.UserID()
+	}
+	act, err := policy.Delegate(subject.Actor, actor)
+	if err != nil {
+		audit(h.logger, "Token exchange delegation refused", "clientID", client.ID, "userID", subject.UserID(), "error", err)
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	scopes, err := policy.ExchangeScopes(strings.Fields(subject.Scope), strings.Fields(form.Get("scope")))
+	if errors.Is(err, oauth.ErrInvalidScope) {
+		audit(h.logger, "Token exchange requested broader scope", "clientID", client.ID, "scope", form.Get("scope"))
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_scope", "")
+		return
+	}
+
+	// The exchanged token never outlives the token it was exchanged for
+	lifetime := h.accessTokenLifetime(client)
+	if remaining := time.Until(subject.ExpiresAt.Time); remaining < lifetime {
+		lifetime = remaining
+	}
+	if lifetime < time.Second {
+		// Only clock skew leeway kept the subject token valid
+		respondOAuthError(w, h.logger, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	opts := []auth.TokenOption{
+		auth.WithClientID(client.ID),
+		auth.ForAudience(audiences...),
+		auth.WithScope(scopes...),
+		auth.WithLifetime(lifetime),
+		auth.WithActor(act),
+		auth.WithAMR(subject.AMR...),
+		auth.WithProfile(subject.Name, subject.Email),
+		auth.WithGroups(subject.Groups...),
+	}
+	if subject.AuthTime != nil {
+		opts = append(opts, auth.WithAuthTime(subject.AuthTime.Time))
+	}
+	if subject.SessionID != "" {
+		// Ending the user's session revokes exchanged tokens too
+		opts = append(opts, auth.WithSessionID(subject.SessionID))
+	}
+	if subject.ClientToken() {
+		opts = append(opts, auth.AsClientToken())
+	}
+	opts = append(opts, binding.opts...)
+
+	token, err := h.tokens.GenerateToken(subject.UserID(), opts...)
+	if err != nil {
+		h.logger.Error("Token issuance failed", "clientID", client.ID, "error", err)
+		respondOAuthError(w, h.logger, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	audit(h.logger, "Token exchanged", "clientID", client.ID, "userID", subject.UserID(), "actor", actor, "audience", audiences, "scope", scopes)
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, http.StatusOK, TokenResponse{
+		AccessToken:     token,
+		IssuedTokenType: oauth.TokenTypeAccessToken,
+		TokenType:       binding.tokenType,
+		ExpiresIn:       int(lifetime / time.Second),
+		Scope:           strings.Join(scopes, " "),
+	})
+}
+
+// issuedFor reports whether a token was issued to client, or for its
+// audience, and so may be exchanged by it
+func issuedFor(claims *auth.Claims, client *oauth.Client) bool {
+	if claims.ClientID == client.ID {
+		return true
+	}
+	for _, audience := range claims.Audience {
+		if audience == client.ID || (audience != "" && audience == client.TokenAudience()) {
+			return true
+		}
+	}
+	return false
+}
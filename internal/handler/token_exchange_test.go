@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/oauth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+// newExchangeProvider registers orders, which calls ledger on behalf of
+// users, ledger, which calls archive in turn, and a client without a policy
+func newExchangeProvider(t *testing.T) (*OIDCProvider, *auth.Client) {
+	t.Helper()
+
+	hash, err := passwd.Hash("svc-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := []string{oauth.GrantTokenExchange}
+	clients := oauth.StaticRegistry{
+		"orders": {ID: "orders", SecretHash: hash, GrantTypes: exchange, Audience: "orders",
+			TokenExchange: &oauth.ExchangePolicy{Audiences: []string{"ledger"}, Scopes: []string{"profile", "ledger:read"}, Actors: []string{"batch"}}},
+		"ledger": {ID: "ledger", SecretHash: hash, GrantTypes: exchange, Audience: "ledger",
+			TokenExchange: &oauth.ExchangePolicy{Audiences: []string{"archive"}}},
+		"batch": {ID: "batch", SecretHash: hash, GrantTypes: []string{oauth.GrantClientCredentials}},
+	}
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	return NewOIDCProvider(nil, clients, nil, tokens, ProviderConfig{}, &mockLogger{}), tokens
+}
+
+func exchangeForm(subjectToken string, audience ...string) url.Values {
+	return url.Values{
+		"grant_type":         {oauth.GrantTokenExchange},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {oauth.TokenTypeAccessToken},
+		"audience":           audience,
+	}
+}
+
+func TestTokenExchange(t *testing.T) {
+	provider, tokens := newExchangeProvider(t)
+	userToken, _ := tokens.GenerateToken("jdoe",
+		auth.ForAudience("orders"),
+		auth.WithScope("openid", "profile", "ledger:read", "ledger:write"),
+		auth.WithRoles("orders-admin"),
+		auth.WithProfile("Jane Doe", ""),
+		auth.WithSessionID("s1"),
+		auth.WithLifetime(10*time.Minute),
+	)
+
+	rr := postToken(provider, exchangeForm(userToken, "ledger"), "orders", "svc-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response TokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.IssuedTokenType != oauth.TokenTypeAccessToken || response.Scope != "profile ledger:read" || response.ExpiresIn > 600 {
+		t.Errorf("response = %+v", response)
+	}
+
+	claims, err := tokens.ValidateToken(response.AccessToken, auth.WithAudience("ledger"))
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if claims.UserID() != "jdoe" || claims.ClientID != "orders" || claims.Name != "Jane Doe" || claims.SessionID != "s1" || len(claims.Roles) != 0 {
+		t.Errorf("claims = %+v, want jdoe's token for ledger without roles", claims)
+	}
+	if claims.Actor == nil || claims.Actor.Subject != "orders" || claims.Actor.Actor != nil {
+		t.Errorf("act = %+v, want orders", claims.Actor)
+	}
+
+	// Ledger passes the exchanged token on, extending the chain
+	rr = postToken(provider, exchangeForm(response.AccessToken, "archive"), "ledger", "svc-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("second exchange: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	claims, _ = tokens.ValidateToken(response.AccessToken)
+	if claims.Actor.Depth() != 2 || claims.Actor.Subject != "ledger" || claims.Actor.Actor.Subject != "orders" || claims.Scope != "profile ledger:read" {
+		t.Errorf("claims = %+v, act = %+v; want ledger acting after orders", claims, claims.Actor)
+	}
+}
+
+func TestTokenExchangeActorToken(t *testing.T) {
+	provider, tokens := newExchangeProvider(t)
+	userToken, _ := tokens.GenerateToken("jdoe", auth.ForAudience("orders"), auth.WithScope("ledger:read"))
+	batchToken, _ := tokens.GenerateToken("batch", auth.AsClientToken(), auth.WithClientID("batch"))
+
+	form := exchangeForm(userToken, "ledger")
+	form.Set("actor_token", batchToken)
+	form.Set("actor_token_type", oauth.TokenTypeAccessToken)
+	rr := postToken(provider, form, "orders", "svc-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response TokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if claims, _ := tokens.ValidateToken(response.AccessToken); claims.Actor.Subject != "batch" {
+		t.Errorf("act = %+v, want batch", claims.Actor)
+	}
+
+	// Actors must be the client or listed in its policy
+	strangerToken, _ := tokens.GenerateToken("mallory")
+	form.Set("actor_token", strangerToken)
+	var body OAuthError
+	rr = postToken(provider, form, "orders", "svc-secret")
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusBadRequest || body.Error != "invalid_grant" {
+		t.Errorf("unlisted actor: got %d %q, want invalid_grant", rr.Code, body.Error)
+	}
+}
+
+func TestTokenExchangeRejects(t *testing.T) {
+	provider, tokens := newExchangeProvider(t)
+	userToken, _ := tokens.GenerateToken("jdoe", auth.ForAudience("orders"), auth.WithScope("ledger:read"))
+	otherToken, _ := tokens.GenerateToken("jdoe", auth.ForAudience("payments"), auth.WithScope("ledger:read"))
+	idToken, _ := tokens.GenerateToken("jdoe", auth.AsIDToken(), auth.ForAudience("orders"))
+
+	withScope := exchangeForm(userToken, "ledger")
+	withScope.Set("scope", "ledger:write")
+	noType := exchangeForm(userToken, "ledger")
+	noType.Del("subject_token_type")
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		clientID  string
+		wantError string
+	}{
+		{"other audience", exchangeForm(userToken, "archive"), "orders", "invalid_target"},
+		{"no audience", exchangeForm(userToken), "orders", "invalid_request"},
+		{"token for another client", exchangeForm(otherToken, "ledger"), "orders", "invalid_grant"},
+		{"ID token", exchangeForm(idToken, "ledger"), "orders", "invalid_grant"},
+		{"invalid token", exchangeForm("not-a-token", "ledger"), "orders", "invalid_grant"},
+		{"broader scope", withScope, "orders", "invalid_scope"},
+		{"missing token type", noType, "orders", "invalid_request"},
+		{"no policy", exchangeForm(userToken, "ledger"), "batch", "unauthorized_client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postToken(provider, tt.form, tt.clientID, "svc-secret")
+			var body OAuthError
+			json.NewDecoder(rr.Body).Decode(&body)
+			if rr.Code != http.StatusBadRequest || body.Error != tt.wantError {
+				t.Errorf("got %d %q, want %d %q", rr.Code, body.Error, http.StatusBadRequest, tt.wantError)
+			}
+		})
+	}
+}
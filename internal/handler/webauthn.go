@@ -0,0 +1,360 @@
+// internal/handler/webauthn.go
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+)
+
+// WebAuthnCeremonies keeps WebAuthn sessions between the begin and finish
+// steps of a ceremony
+type WebAuthnCeremonies interface {
+	Start(session *webauthn.Session) (string, error)
+	Take(token string) (*webauthn.Session, error)
+}
+
+// UserLookup returns the identity of a user who authenticated without a
+// password, such as authn.LDAP with a profile directory
+type UserLookup interface {
+	Lookup(userID string) (*authn.Identity, error)
+}
+
+// WebAuthnConfig enables security keys and passkeys
+type WebAuthnConfig struct {
+	RelyingParty *webauthn.RelyingParty
+	// Credentials are stored by canonical user ID
+	Credentials webauthn.CredentialStore
+	// Ceremonies default to being kept in memory, which only works for a
+	// single instance
+	Ceremonies WebAuthnCeremonies
+	// Users enables passwordless login. Without it credentials only serve
+	// as a second factor.
+	Users UserLookup
+}
+
+// WebAuthnBeginResponse starts a ceremony in the browser. Options go to
+// navigator.credentials.create or .get, and the Token back with the result.
+type WebAuthnBeginResponse struct {
+	Token   string
+	Options interface{}
+}
+
+// WebAuthnMFABeginRequest starts answering an MFA challenge with a
+// security key
+type WebAuthnMFABeginRequest struct {
+	MFAToken string
+}
+
+// WebAuthnMFARequest completes a login that answered with MFARequired
+type WebAuthnMFARequest struct {
+	MFAToken   string
+	Token      string
+	Credential *webauthn.AssertionResponse
+}
+
+// PasswordlessRequest completes a passwordless login
+type PasswordlessRequest struct {
+	Token      string
+	Credential *webauthn.AssertionResponse
+	// Role and Application are as in AuthRequest
+	Role        string
+	Application string `json:",omitempty"`
+}
+
+// WithWebAuthn lets users with registered security keys or passkeys answer
+// the MFA challenge after the password with one, through
+// HandleWebAuthnMFABegin and HandleWebAuthnMFAFinish, and, with
+// config.Users set, log in with a passkey alone through
+// HandlePasswordlessBegin and HandlePasswordlessFinish. WebAuthnHandler
+// registers the credentials.
+func WithWebAuthn(config WebAuthnConfig) Option {
+	if config.Ceremonies == nil {
+		config.Ceremonies = webauthn.NewCeremonies()
+	}
+	return func(h *AuthHandler) {
+		h.webAuthn = &config
+	}
+}
+
+// HandleWebAuthnMFABegin returns the options for asserting one of the
+// user's credentials in answer to an MFA challenge
+func (h *AuthHandler) HandleWebAuthnMFABegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+	if h.webAuthn == nil {
+		h.respondError(w, http.StatusNotFound, "webauthn not enabled")
+		return
+	}
+
+	var request WebAuthnMFABeginRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	challenge, err := h.challenges.Lookup(request.MFAToken)
+	if err != nil {
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+
+	credentials, err := h.webAuthn.Credentials.Credentials(challenge.UserID)
+	if err != nil {
+		h.logger.Error("WebAuthn credential lookup failed", "userID", challenge.UserID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "webauthn unavailable")
+		return
+	}
+	if len(credentials) == 0 {
+		h.respondError(w, http.StatusBadRequest, "no security key registered")
+		return
+	}
+	options, session, err := h.webAuthn.RelyingParty.BeginLogin(credentials)
+	if err != nil {
+		h.logger.Error("WebAuthn login failed to start", "userID", challenge.UserID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "webauthn unavailable")
+		return
+	}
+	h.startCeremony(w, session, options)
+}
+
+// HandleWebAuthnMFAFinish completes a login with an assertion of one of the
+// user's credentials. Failed assertions count like wrong codes.
+func (h *AuthHandler) HandleWebAuthnMFAFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+	if h.webAuthn == nil {
+		h.respondError(w, http.StatusNotFound, "webauthn not enabled")
+		return
+	}
+
+	var request WebAuthnMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Credential == nil {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	ip := clientIP(r)
+
+	challenge, err := h.challenges.Lookup(request.MFAToken)
+	if err != nil {
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+	login, ok := challenge.Login.(*pendingLogin)
+	if !ok {
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+	if !h.allowAttempt(w, login.username, ip) {
+		return
+	}
+
+	session, err := h.webAuthn.Ceremonies.Take(request.Token)
+	if err != nil || session.UserID != challenge.UserID {
+		h.releaseAttempt(login.username, ip)
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired webauthn token")
+		return
+	}
+	credential, status := h.verifyAssertion(session, request.Credential, ip)
+	if status != http.StatusOK {
+		if status == http.StatusUnauthorized {
+			usable := h.challenges.Fail(request.MFAToken)
+			if h.throttle != nil {
+				h.throttle.RecordFailure(login.username, ip)
+			}
+			audit(h.logger, "Security key rejected", "userID", challenge.UserID, "ip", ip, "challengeUsable", usable)
+			h.respondError(w, status, "invalid credential")
+			return
+		}
+		h.releaseAttempt(login.username, ip)
+		h.respondError(w, status, "webauthn unavailable")
+		return
+	}
+	if h.throttle != nil {
+		h.throttle.RecordSuccess(login.username, ip)
+	}
+	if err := h.challenges.Complete(request.MFAToken); err != nil {
+		// Another request finished this login first
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+
+	completed := *login
+	completed.methods = append(append([]string{}, login.methods...), keyMethod(credential), auth.AMRMultiFactor)
+	h.completeLogin(w, r, &completed)
+}
+
+// HandlePasswordlessBegin returns the options for asserting any passkey
+func (h *AuthHandler) HandlePasswordlessBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+	if h.webAuthn == nil || h.webAuthn.Users == nil {
+		h.respondError(w, http.StatusNotFound, "passwordless login not enabled")
+		return
+	}
+
+	options, session, err := h.webAuthn.RelyingParty.BeginPasswordlessLogin()
+	if err != nil {
+		h.logger.Error("Passwordless login failed to start", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "webauthn unavailable")
+		return
+	}
+	h.startCeremony(w, session, options)
+}
+
+// HandlePasswordlessFinish logs the owner of the asserted passkey in. The
+// user must still be enabled in the directory, and roles are mapped from
+// their directory groups as for a password login.
+func (h *AuthHandler) HandlePasswordlessFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return
+	}
+	if h.webAuthn == nil || h.webAuthn.Users == nil {
+		h.respondError(w, http.StatusNotFound, "passwordless login not enabled")
+		return
+	}
+
+	var request PasswordlessRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Credential == nil {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	ip := clientIP(r)
+
+	session, err := h.webAuthn.Ceremonies.Take(request.Token)
+	if err != nil || session.UserID != "" {
+		h.respondError(w, http.StatusUnauthorized, "invalid or expired webauthn token")
+		return
+	}
+	// Signatures cannot be guessed, so failures are not throttled; counting
+	// them against the owner would let anyone lock the account out
+	credential, status := h.verifyAssertion(session, request.Credential, ip)
+	if status != http.StatusOK {
+		if status == http.StatusUnauthorized {
+			audit(h.logger, "Passwordless login failed", "ip", ip)
+			h.respondError(w, status, "authentication failed")
+			return
+		}
+		h.respondError(w, status, "webauthn unavailable")
+		return
+	}
+
+	identity, err := h.webAuthn.Users.Lookup(credential.UserID)
+	if err != nil {
+		h.logger.Error("Passwordless login user lookup failed", "userID", credential.UserID, "error", err)
+		audit(h.logger, "Passwordless login denied", "userID", credential.UserID, "ip", ip, "error", err)
+		h.respondError(w, http.StatusUnauthorized, "authentication failed")
+		return
+	}
+	identity.Methods = []string{keyMethod(credential), auth.AMRMultiFactor}
+
+	loginRequest := AuthRequest{UserID: identity.ID, Role: request.Role, Application: request.Application}
+	granted, audience, ok := h.mapRoles(w, identity, loginRequest, ip)
+	if !ok {
+		return
+	}
+	audit(h.logger, "Passwordless login", "userID", identity.ID, "ip", ip)
+	h.completeLogin(w, r, &pendingLogin{
+		identity: identity,
+		request:  loginRequest,
+		username: identity.ID,
+		granted:  granted,
+		audience: audience,
+		methods:  identity.Methods,
+	})
+}
+
+// allowAttempt applies the login throttle and responds when it blocks
+func (h *AuthHandler) allowAttempt(w http.ResponseWriter, username, ip string) bool {
+	if h.throttle == nil {
+		return true
+	}
+	decision := h.throttle.Check(username, ip)
+	if decision.Allowed {
+		return true
+	}
+	h.logger.Error("WebAuthn attempt throttled", "username", username, "ip", ip, "reason", decision.Reason)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+	h.respondError(w, http.StatusTooManyRequests, "too many attempts")
+	return false
+}
+
+// releaseAttempt ends an attempt allowed by allowAttempt without counting it
+func (h *AuthHandler) releaseAttempt(username, ip string) {
+	if h.throttle != nil {
+		h.throttle.Release(username, ip)
+	}
+}
+
+// startCeremony keeps session and sends options with its token
+func (h *AuthHandler) startCeremony(w http.ResponseWriter, session *webauthn.Session, options interface{}) {
+	token, err := h.webAuthn.Ceremonies.Start(session)
+	if err != nil {
+		h.logger.Error("WebAuthn ceremony failed to start", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "webauthn unavailable")
+		return
+	}
+	h.respondJSON(w, http.StatusOK, WebAuthnBeginResponse{Token: token, Options: options})
+}
+
+// verifyAssertion verifies response and saves the credential's new
+// counter. It returns 401 for assertions that fail verification or lose
+// the counter update to a concurrent login.
+func (h *AuthHandler) verifyAssertion(session *webauthn.Session, response *webauthn.AssertionResponse, ip string) (*webauthn.Credential, int) {
+	id, err := response.CredentialID()
+	if err != nil {
+		return nil, http.StatusUnauthorized
+	}
+	credential, err := h.webAuthn.Credentials.Credential(id)
+	switch {
+	case errors.Is(err, webauthn.ErrUnknownCredential):
+		return nil, http.StatusUnauthorized
+	case err != nil:
+		h.logger.Error("WebAuthn credential lookup failed", "error", err)
+		return nil, http.StatusInternalServerError
+	}
+
+	assertion, err := h.webAuthn.RelyingParty.FinishLogin(session, credential, response)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrCounterRegression) {
+			audit(h.logger, "Security key counter regressed, possibly cloned", "userID", credential.UserID, "ip", ip)
+		}
+		h.logger.Error("WebAuthn assertion rejected", "userID", credential.UserID, "error", err)
+		return nil, http.StatusUnauthorized
+	}
+	err = h.webAuthn.Credentials.UpdateSignCount(assertion.Credential, credential.SignCount)
+	switch {
+	case errors.Is(err, webauthn.ErrCounterRegression):
+		// Another login advanced the counter since it was read
+		audit(h.logger, "Security key counter regressed, possibly cloned", "userID", credential.UserID, "ip", ip)
+		return nil, http.StatusUnauthorized
+	case errors.Is(err, webauthn.ErrUnknownCredential):
+		return nil, http.StatusUnauthorized
+	case err != nil:
+		// Without the new counter a clone would go unnoticed
+		h.logger.Error("WebAuthn counter update failed", "userID", credential.UserID, "error", err)
+		return nil, http.StatusInternalServerError
+	}
+	return assertion.Credential, http.StatusOK
+}
+
+// keyMethod returns the amr value of a credential. Synced passkeys can
+// leave the device, so they do not count as hardware keys.
+func keyMethod(credential *webauthn.Credential) string {
+	if credential.BackupEligible {
+		return auth.AMRSoftwareKey
+	}
+	return auth.AMRHardwareKey
+}
@@ -0,0 +1,226 @@
+// internal/handler/webauthn_registration.go
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+)
+
+// WebAuthnRegistrationRequest completes registering a credential
+type WebAuthnRegistrationRequest struct {
+	Token      string
+	Credential *webauthn.RegistrationResponse
+}
+
+// WebAuthnDeleteRequest removes a credential by its base64url ID
+type WebAuthnDeleteRequest struct {
+	ID string
+}
+
+// WebAuthnCredentialInfo describes a registered credential without its key
+type WebAuthnCredentialInfo struct {
+	ID              string
+	AttestationType string
+	BackupEligible  bool
+	CreatedAt       string
+	LastUsedAt      string `json:",omitempty"`
+}
+
+// WebAuthnHandler lets signed-in users register and remove security keys
+// and passkeys. Every endpoint takes the user's access token. Once a user
+// has a credential, changing their credentials takes a token from a
+// multi-factor login, so a stolen password alone cannot add a key.
+type WebAuthnHandler struct {
+	relyingParty *webauthn.RelyingParty
+	credentials  webauthn.CredentialStore
+	ceremonies   WebAuthnCeremonies
+	tokens       TokenValidator
+	logger       Logger
+}
+
+// NewWebAuthnHandler creates the handler. Nil ceremonies are kept in
+// memory, which only works for a single instance.
+func NewWebAuthnHandler(config WebAuthnConfig, tokens TokenValidator, logger Logger) *WebAuthnHandler {
+	if config.Ceremonies == nil {
+		config.Ceremonies = webauthn.NewCeremonies()
+	}
+	return &WebAuthnHandler{
+		relyingParty: config.RelyingParty,
+		credentials:  config.Credentials,
+		ceremonies:   config.Ceremonies,
+		tokens:       tokens,
+		logger:       logger,
+	}
+}
+
+// HandleRegisterBegin returns the options for creating a credential
+func (h *WebAuthnHandler) HandleRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	claims, credentials, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	userID := credentialUserID(claims)
+	options, session, err := h.relyingParty.BeginRegistration(webauthn.User{
+		ID:          userID,
+		Name:        userID,
+		DisplayName: claims.Name,
+	}, credentials)
+	if err != nil {
+		h.logger.Error("WebAuthn registration failed to start", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "registration failed")
+		return
+	}
+	token, err := h.ceremonies.Start(session)
+	if err != nil {
+		h.logger.Error("WebAuthn ceremony failed to start", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "registration failed")
+		return
+	}
+	h.respondJSON(w, http.StatusOK, WebAuthnBeginResponse{Token: token, Options: options})
+}
+
+// HandleRegisterFinish verifies and stores the created credential
+func (h *WebAuthnHandler) HandleRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	claims, _, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	userID := credentialUserID(claims)
+
+	var request WebAuthnRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Credential == nil {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	session, err := h.ceremonies.Take(request.Token)
+	if err != nil || session.UserID != userID {
+		h.respondError(w, http.StatusBadRequest, "invalid or expired webauthn token")
+		return
+	}
+
+	credential, err := h.relyingParty.FinishRegistration(session, request.Credential)
+	if err != nil {
+		audit(h.logger, "Security key registration rejected", "userID", userID, "ip", clientIP(r), "error", err)
+		h.respondError(w, http.StatusBadRequest, "invalid credential")
+		return
+	}
+	if err := h.credentials.Save(credential); err != nil {
+		h.logger.Error("WebAuthn credential storage failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "registration failed")
+		return
+	}
+	audit(h.logger, "Security key registered", "userID", userID, "ip", clientIP(r),
+		"attestation", credential.AttestationType, "backupEligible", credential.BackupEligible)
+	h.respondJSON(w, http.StatusCreated, credentialInfo(credential))
+}
+
+// HandleCredentials lists the user's credentials
+func (h *WebAuthnHandler) HandleCredentials(w http.ResponseWriter, r *http.Request) {
+	_, credentials, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	infos := make([]WebAuthnCredentialInfo, 0, len(credentials))
+	for i := range credentials {
+		infos = append(infos, credentialInfo(&credentials[i]))
+	}
+	h.respondJSON(w, http.StatusOK, infos)
+}
+
+// HandleDelete removes one of the user's credentials
+func (h *WebAuthnHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	claims, _, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var request WebAuthnDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(request.ID, "="))
+	if err != nil || len(id) == 0 {
+		h.respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	userID := credentialUserID(claims)
+	switch err := h.credentials.Delete(userID, id); {
+	case errors.Is(err, webauthn.ErrUnknownCredential):
+		h.respondError(w, http.StatusNotFound, "unknown credential")
+	case err != nil:
+		h.logger.Error("WebAuthn credential removal failed", "userID", userID, "error", err)
+		h.respondError(w, http.StatusInternalServerError, "removal failed")
+	default:
+		audit(h.logger, "Security key removed", "userID", userID, "ip", clientIP(r))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authorize returns the claims of the request's access token and the
+// user's credentials. Users with credentials need a multi-factor token;
+// client tokens have no user.
+func (h *WebAuthnHandler) authorize(w http.ResponseWriter, r *http.Request) (*auth.Claims, []webauthn.Credential, bool) {
+	if r.Method != http.MethodPost {
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return nil, nil, false
+	}
+
+	claims, ok := bearerClaims(w, r, h.tokens, h.logger)
+	if !ok {
+		return nil, nil, false
+	}
+	if claims.ClientToken() {
+		h.respondError(w, http.StatusForbidden, "user token required")
+		return nil, nil, false
+	}
+
+	credentials, err := h.credentials.Credentials(credentialUserID(claims))
+	if err != nil {
+		h.logger.Error("WebAuthn credential lookup failed", "userID", claims.UserID(), "error", err)
+		h.respondError(w, http.StatusInternalServerError, "webauthn unavailable")
+		return nil, nil, false
+	}
+	if len(credentials) > 0 && !containsRole(claims.AMR, auth.AMRMultiFactor) {
+		h.respondError(w, http.StatusForbidden, "multi-factor login required")
+		return nil, nil, false
+	}
+	return claims, credentials, true
+}
+
+// credentialUserID keys credentials by the canonical form of the token's
+// subject, the same ID logins look them up by
+func credentialUserID(claims *auth.Claims) string {
+	return authn.CanonicalID(claims.UserID())
+}
+
+func credentialInfo(credential *webauthn.Credential) WebAuthnCredentialInfo {
+	info := WebAuthnCredentialInfo{
+		ID:              base64.RawURLEncoding.EncodeToString(credential.ID),
+		AttestationType: credential.AttestationType,
+		BackupEligible:  credential.BackupEligible,
+		CreatedAt:       credential.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if !credential.LastUsedAt.IsZero() {
+		info.LastUsedAt = credential.LastUsedAt.UTC().Format(time.RFC3339)
+	}
+	return info
+}
+
+func (h *WebAuthnHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	respondJSON(w, h.logger, status, data)
+}
+
+func (h *WebAuthnHandler) respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, h.logger, status, ErrorResponse{Error: message})
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn/webauthntest"
+)
+
+func TestWebAuthnHandler(t *testing.T) {
+	rp := testRelyingParty(t)
+	store := webauthn.NewMemoryStore()
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	handler := NewWebAuthnHandler(WebAuthnConfig{RelyingParty: rp, Credentials: store}, tokens, &mockLogger{})
+	passwordToken, _ := tokens.GenerateToken("jdoe", auth.WithAMR(auth.AMRPassword))
+	mfaToken, _ := tokens.GenerateToken("jdoe", auth.WithAMR(auth.AMRPassword, auth.AMRHardwareKey, auth.AMRMultiFactor))
+	clientToken, _ := tokens.GenerateToken("batch", auth.AsClientToken())
+
+	call := func(endpoint http.HandlerFunc, token string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/webauthn", bytes.NewBuffer(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		endpoint(rr, req)
+		return rr
+	}
+	register := func(token string, authenticator *webauthntest.Authenticator) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := call(handler.HandleRegisterBegin, token, nil)
+		var begin struct {
+			Token   string
+			Options *webauthn.CreationOptions
+		}
+		json.NewDecoder(rr.Body).Decode(&begin)
+		if rr.Code != http.StatusOK {
+			return rr
+		}
+		response, err := authenticator.Register(begin.Options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return call(handler.HandleRegisterFinish, token, WebAuthnRegistrationRequest{Token: begin.Token, Credential: response})
+	}
+
+	if rr := call(handler.HandleRegisterBegin, "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := call(handler.HandleRegisterBegin, clientToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("client token: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	// The first key can be registered after a password login
+	rr := register(passwordToken, webauthntest.New(testWebAuthnOrigin))
+	var info WebAuthnCredentialInfo
+	json.NewDecoder(rr.Body).Decode(&info)
+	if rr.Code != http.StatusCreated || info.ID == "" || info.AttestationType != webauthn.AttestationNone {
+		t.Fatalf("register = %d %+v", rr.Code, info)
+	}
+
+	// Further changes need a multi-factor login
+	if rr := register(passwordToken, webauthntest.New(testWebAuthnOrigin)); rr.Code != http.StatusForbidden {
+		t.Errorf("second key with password token: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := call(handler.HandleDelete, passwordToken, WebAuthnDeleteRequest{ID: info.ID}); rr.Code != http.StatusForbidden {
+		t.Errorf("delete with password token: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := register(mfaToken, webauthntest.New("https://evil.example.net")); rr.Code != http.StatusBadRequest {
+		t.Errorf("foreign origin: status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := register(mfaToken, webauthntest.New(testWebAuthnOrigin)); rr.Code != http.StatusCreated {
+		t.Fatalf("second key: status = %d, want %d", rr.Code, http.StatusCreated)
+	}
+
+	credentials, _ := store.Credentials("jdoe")
+	if len(credentials) != 2 || !bytes.Equal(credentials[0].UserHandle, credentials[1].UserHandle) {
+		t.Fatalf("stored credentials = %+v, want two sharing a user handle", credentials)
+	}
+	rr = call(handler.HandleCredentials, mfaToken, nil)
+	var infos []WebAuthnCredentialInfo
+	json.NewDecoder(rr.Body).Decode(&infos)
+	if rr.Code != http.StatusOK || len(infos) != 2 {
+		t.Errorf("credentials = %d %+v", rr.Code, infos)
+	}
+
+	// A ceremony started by one user cannot be finished by another
+	rr = call(handler.HandleRegisterBegin, mfaToken, nil)
+	var begin struct {
+		Token   string
+		Options *webauthn.CreationOptions
+	}
+	json.NewDecoder(rr.Body).Decode(&begin)
+	response, _ := webauthntest.New(testWebAuthnOrigin).Register(begin.Options)
+	otherToken, _ := tokens.GenerateToken("asmith")
+	if rr := call(handler.HandleRegisterFinish, otherToken, WebAuthnRegistrationRequest{Token: begin.Token, Credential: response}); rr.Code != http.StatusBadRequest {
+		t.Errorf("other user's ceremony: status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := call(handler.HandleDelete, mfaToken, WebAuthnDeleteRequest{ID: info.ID}); rr.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if rr := call(handler.HandleDelete, mfaToken, WebAuthnDeleteRequest{ID: info.ID}); rr.Code != http.StatusNotFound {
+		t.Errorf("delete again: status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestWebAuthnCredentialsCanonicalUser(t *testing.T) {
+	rp := testRelyingParty(t)
+	store := webauthn.NewMemoryStore()
+	tokens := auth.NewClient(auth.Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	registration := NewWebAuthnHandler(WebAuthnConfig{RelyingParty: rp, Credentials: store}, tokens, &mockLogger{})
+	login := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, &mockAuthClient{token: "key.jwt.token"}, &mockLogger{},
+		WithWebAuthn(WebAuthnConfig{RelyingParty: rp, Credentials: store}))
+
+	call := func(endpoint http.HandlerFunc, token string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/webauthn", bytes.NewBuffer(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		endpoint(rr, req)
+		return rr
+	}
+
+	// Register as jdoe
+	key := webauthntest.New(testWebAuthnOrigin)
+	passwordToken, _ := tokens.GenerateToken("jdoe", auth.WithAMR(auth.AMRPassword))
+	rr := call(registration.HandleRegisterBegin, passwordToken, nil)
+	var begin struct {
+		Token   string
+		Options *webauthn.CreationOptions
+	}
+	json.NewDecoder(rr.Body).Decode(&begin)
+	created, err := key.Register(begin.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := call(registration.HandleRegisterFinish, passwordToken, WebAuthnRegistrationRequest{Token: begin.Token, Credential: created}); rr.Code != http.StatusCreated {
+		t.Fatalf("register: status = %d, want %d", rr.Code, http.StatusCreated)
+	}
+
+	// Log in as JDOE: the key is still required and answers the challenge
+	rr = postJSON(login.HandleAuthentication, AuthRequest{UserID: "JDOE", Password: "s3cret", Domain: "example.com"})
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || !response.MFARequired || response.UserID != "jdoe" {
+		t.Fatalf("login as JDOE = %d %+v, want an MFA challenge for jdoe", rr.Code, response)
+	}
+	rr = postJSON(login.HandleWebAuthnMFABegin, WebAuthnMFABeginRequest{MFAToken: response.MFAToken})
+	var ceremony struct {
+		Token   string
+		Options *webauthn.RequestOptions
+	}
+	json.NewDecoder(rr.Body).Decode(&ceremony)
+	assertion, err := key.Login(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = postJSON(login.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: response.MFAToken, Token: ceremony.Token, Credential: assertion})
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || !response.IsAuthenticated {
+		t.Fatalf("finish = %d %+v, want a token", rr.Code, response)
+	}
+
+	// A token issued to another spelling manages the same credentials
+	upperToken, _ := tokens.GenerateToken("JDOE", auth.WithAMR(auth.AMRPassword, auth.AMRHardwareKey, auth.AMRMultiFactor))
+	rr = call(registration.HandleCredentials, upperToken, nil)
+	var infos []WebAuthnCredentialInfo
+	json.NewDecoder(rr.Body).Decode(&infos)
+	if rr.Code != http.StatusOK || len(infos) != 1 {
+		t.Errorf("credentials for JDOE = %d %+v, want the key registered as jdoe", rr.Code, infos)
+	}
+	upperPassword, _ := tokens.GenerateToken("JDOE", auth.WithAMR(auth.AMRPassword))
+	if rr := call(registration.HandleRegisterBegin, upperPassword, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("register as JDOE with password token: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+	"github.com/yovily/customers/citi/auth-service/pkg/mfa"
+	"github.com/yovily/customers/citi/auth-service/pkg/roles"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn/webauthntest"
+)
+
+const testWebAuthnOrigin = "https://login.example.com"
+
+func testRelyingParty(t *testing.T) *webauthn.RelyingParty {
+	t.Helper()
+	rp, err := webauthn.NewRelyingParty(webauthn.Config{ID: "example.com", Origins: []string{testWebAuthnOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+// registerKey registers a credential of authenticator for userID
+func registerKey(t *testing.T, rp *webauthn.RelyingParty, store webauthn.CredentialStore, authenticator *webauthntest.Authenticator, userID string) {
+	t.Helper()
+	existing, _ := store.Credentials(userID)
+	options, session, err := rp.BeginRegistration(webauthn.User{ID: userID}, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := authenticator.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := rp.FinishRegistration(session, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(credential); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type mockUserLookup map[string]*authn.Identity
+
+func (m mockUserLookup) Lookup(userID string) (*authn.Identity, error) {
+	identity, ok := m[userID]
+	if !ok {
+		return nil, authn.ErrInvalidCredentials
+	}
+	copied := *identity
+	return &copied, nil
+}
+
+func tokenAMR(authClient *mockAuthClient) []string {
+	client := auth.NewClient(auth.Config{JWTSecret: []byte("s"), TokenDuration: time.Minute})
+	token, _ := client.GenerateToken(authClient.lastUser, authClient.lastOpts...)
+	claims, _ := client.ValidateToken(token)
+	return claims.AMR
+}
+
+func TestHandleAuthenticationWebAuthn(t *testing.T) {
+	rp := testRelyingParty(t)
+	store := webauthn.NewMemoryStore()
+	key := webauthntest.New(testWebAuthnOrigin)
+	registerKey(t, rp, store, key, "jdoe")
+
+	authClient := &mockAuthClient{token: "key.jwt.token"}
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, authClient, &mockLogger{},
+		WithWebAuthn(WebAuthnConfig{RelyingParty: rp, Credentials: store}))
+
+	login := func() string {
+		t.Helper()
+		rr := postJSON(handler.HandleAuthentication, AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"})
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusOK || !response.MFARequired || response.Token != "" {
+			t.Fatalf("login = %d %+v, want an MFA challenge", rr.Code, response)
+		}
+		if !reflect.DeepEqual(response.MFAMethods, []string{MFAMethodWebAuthn}) {
+			t.Errorf("MFAMethods = %v, want [%s]", response.MFAMethods, MFAMethodWebAuthn)
+		}
+		return response.MFAToken
+	}
+	begin := func(mfaToken string) (string, *webauthn.RequestOptions) {
+		t.Helper()
+		rr := postJSON(handler.HandleWebAuthnMFABegin, WebAuthnMFABeginRequest{MFAToken: mfaToken})
+		var response struct {
+			Token   string
+			Options *webauthn.RequestOptions
+		}
+		json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusOK || response.Token == "" || len(response.Options.AllowCredentials) != 1 {
+			t.Fatalf("begin = %d %+v", rr.Code, response)
+		}
+		return response.Token, response.Options
+	}
+
+	mfaToken := login()
+	token, options := begin(mfaToken)
+	assertion, err := key.Login(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := postJSON(handler.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: mfaToken, Token: token, Credential: assertion})
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || !response.IsAuthenticated || response.Token != "key.jwt.token" {
+		t.Fatalf("finish = %d %+v, want a token", rr.Code, response)
+	}
+	if got, want := tokenAMR(authClient), []string{auth.AMRPassword, auth.AMRHardwareKey, auth.AMRMultiFactor}; !reflect.DeepEqual(got, want) {
+		t.Errorf("amr = %v, want %v", got, want)
+	}
+	if credentials, _ := store.Credentials("jdoe"); credentials[0].SignCount != 1 {
+		t.Errorf("stored SignCount = %d, want 1", credentials[0].SignCount)
+	}
+
+	// Neither the ceremony nor the MFA token can be used twice
+	if rr := postJSON(handler.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: mfaToken, Token: token, Credential: assertion}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed assertion: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// A cloned key is rejected once the original has been used
+	clone := key.Clone()
+	mfaToken = login()
+	token, options = begin(mfaToken)
+	assertion, _ = key.Login(options)
+	postJSON(handler.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: mfaToken, Token: token, Credential: assertion})
+	mfaToken = login()
+	token, options = begin(mfaToken)
+	assertion, _ = clone.Login(options)
+	if rr := postJSON(handler.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: mfaToken, Token: token, Credential: assertion}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("cloned key: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// Another user's key does not answer jdoe's challenge
+	other := webauthntest.New(testWebAuthnOrigin)
+	registerKey(t, rp, store, other, "asmith")
+	mfaToken = login()
+	token, options = begin(mfaToken)
+	options.AllowCredentials = nil
+	assertion, _ = other.Login(options)
+	if rr := postJSON(handler.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: mfaToken, Token: token, Credential: assertion}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other user's key: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// Users without a key log in with the password alone
+	rr = postJSON(handler.HandleAuthentication, AuthRequest{UserID: "bwayne", Password: "s3cret", Domain: "example.com"})
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || !response.IsAuthenticated || response.MFARequired {
+		t.Errorf("login without key = %d %+v, want a token", rr.Code, response)
+	}
+}
+
+// counterFailingStore cannot record logins
+type counterFailingStore struct {
+	*webauthn.MemoryStore
+}
+
+func (counterFailingStore) UpdateSignCount(*webauthn.Credential, uint32) error {
+	return errors.New("disk full")
+}
+
+func TestWebAuthnCounterUpdateFailure(t *testing.T) {
+	rp := testRelyingParty(t)
+	store := counterFailingStore{webauthn.NewMemoryStore()}
+	key := webauthntest.New(testWebAuthnOrigin)
+	registerKey(t, rp, store, key, "jdoe")
+
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, &mockAuthClient{token: "jwt"}, &mockLogger{},
+		WithWebAuthn(WebAuthnConfig{RelyingParty: rp, Credentials: store}))
+
+	rr := postJSON(handler.HandleAuthentication, AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"})
+	var login AuthResponse
+	json.NewDecoder(rr.Body).Decode(&login)
+	rr = postJSON(handler.HandleWebAuthnMFABegin, WebAuthnMFABeginRequest{MFAToken: login.MFAToken})
+	var begin struct {
+		Token   string
+		Options *webauthn.RequestOptions
+	}
+	json.NewDecoder(rr.Body).Decode(&begin)
+	assertion, err := key.Login(begin.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the new counter a clone would pass the next login
+	rr = postJSON(handler.HandleWebAuthnMFAFinish, WebAuthnMFARequest{MFAToken: login.MFAToken, Token: begin.Token, Credential: assertion})
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code == http.StatusOK || response.Token != "" {
+		t.Errorf("finish = %d %+v, want the login to fail", rr.Code, response)
+	}
+}
+
+func TestHandleAuthenticationTOTPAndWebAuthn(t *testing.T) {
+	manager, _, codeAt := enrolledManager(t)
+	rp := testRelyingParty(t)
+	store := webauthn.NewMemoryStore()
+	registerKey(t, rp, store, webauthntest.New(testWebAuthnOrigin), "jdoe")
+	registerKey(t, rp, store, webauthntest.New(testWebAuthnOrigin), "asmith")
+
+	handler := NewAuthHandler(&mockAuthenticator{shouldSucceed: true}, &mockAuthClient{token: "jwt"}, &mockLogger{},
+		WithMFA(manager, mfa.NewChallenges(0, 3)),
+		WithWebAuthn(WebAuthnConfig{RelyingParty: rp, Credentials: store}))
+
+	rr := postJSON(handler.HandleAuthentication, AuthRequest{UserID: "jdoe", Password: "s3cret", Domain: "example.com"})
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if want := []string{MFAMethodTOTP, MFAMethodWebAuthn}; !reflect.DeepEqual(response.MFAMethods, want) {
+		t.Fatalf("MFAMethods = %v, want %v", response.MFAMethods, want)
+	}
+	if rr := postJSON(handler.HandleMFAVerification, MFARequest{MFAToken: response.MFAToken, Code: codeAt(0)}); rr.Code != http.StatusOK {
+		t.Errorf("TOTP answer: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	// A user with only a key cannot answer with a code
+	rr = postJSON(handler.HandleAuthentication, AuthRequest{UserID: "asmith", Password: "s3cret", Domain: "example.com"})
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr := postJSON(handler.HandleMFAVerification, MFARequest{MFAToken: response.MFAToken, Code: codeAt(0)}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("code without TOTP enrollment: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	rp := testRelyingParty(t)
+	store := webauthn.NewMemoryStore()
+	passkey := &webauthntest.Authenticator{Origin: testWebAuthnOrigin, BackupEligible: true}
+	registerKey(t, rp, store, passkey, "jdoe")
+	disabled := webauthntest.New(testWebAuthnOrigin)
+	registerKey(t, rp, store, disabled, "gone")
+
+	authClient := &mockAuthClient{token: "passkey.jwt.token"}
+	users := mockUserLookup{"jdoe": {ID: "jdoe", Username: "jdoe", Backend: authn.BackendLDAP, Groups: []string{"staff"}}}
+	mapper, err := roles.New(roles.Config{
+		Default: roles.Profile{Rules: []roles.Rule{{Group: "staff", Roles: []string{"user"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewAuthHandler(&mockAuthenticator{}, authClient, &mockLogger{},
+		WithRoleMapper(mapper),
+		WithWebAuthn(WebAuthnConfig{RelyingParty: rp, Credentials: store, Users: users}))
+
+	login := func(authenticator *webauthntest.Authenticator, request PasswordlessRequest) (int, AuthResponse) {
+		t.Helper()
+		rr := postJSON(handler.HandlePasswordlessBegin, nil)
+		var begin struct {
+			Token   string
+			Options *webauthn.RequestOptions
+		}
+		json.NewDecoder(rr.Body).Decode(&begin)
+		if rr.Code != http.StatusOK || begin.Options.UserVerification != webauthn.UserVerificationRequired {
+			t.Fatalf("begin = %d %+v", rr.Code, begin)
+		}
+		assertion, err := authenticator.Login(begin.Options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Token, request.Credential = begin.Token, assertion
+		rr = postJSON(handler.HandlePasswordlessFinish, request)
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr.Code, response
+	}
+
+	status, response := login(passkey, PasswordlessRequest{Role: "user"})
+	if status != http.StatusOK || !response.IsAuthenticated || response.UserID != "jdoe" || response.Role != "user" {
+		t.Fatalf("passwordless login = %d %+v", status, response)
+	}
+	if got, want := tokenAMR(authClient), []string{auth.AMRSoftwareKey, auth.AMRMultiFactor}; !reflect.DeepEqual(got, want) {
+		t.Errorf("amr = %v, want %v", got, want)
+	}
+
+	if status, _ := login(passkey, PasswordlessRequest{Role: "admin"}); status != http.StatusForbidden {
+		t.Errorf("role not held: status = %d, want %d", status, http.StatusForbidden)
+	}
+	if status, _ := login(disabled, PasswordlessRequest{}); status != http.StatusUnauthorized {
+		t.Errorf("user unknown to the directory: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	unverified := &webauthntest.Authenticator{Origin: testWebAuthnOrigin, SkipUserVerification: true}
+	registerKey(t, rp, store, unverified, "jdoe")
+	if status, _ := login(unverified, PasswordlessRequest{}); status != http.StatusUnauthorized {
+		t.Errorf("without user verification: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// Without a user lookup passkeys only serve as a second factor
+	handler = NewAuthHandler(&mockAuthenticator{}, authClient, &mockLogger{},
+		WithWebAuthn(WebAuthnConfig{RelyingParty: rp, Credentials: store}))
+	if rr := postJSON(handler.HandlePasswordlessBegin, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("passwordless disabled: status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestOIDCLoginSecurityKeyUser(t *testing.T) {
+	o := newOIDCTest(t)
+	rp := testRelyingParty(t)
+	store := webauthn.NewMemoryStore()
+	registerKey(t, rp, store, webauthntest.New(testWebAuthnOrigin), "jdoe")
+	o.provider.config.WebAuthnCredentials = store
+
+	form := o.authorize(t, authorizeParams("spa"))
+	match := loginRequestPattern.FindStringSubmatch(readBody(t, form))
+	if match == nil {
+		t.Fatal("no login form")
+	}
+	resp, err := o.browser.PostForm(o.server.URL+AuthorizePath, url.Values{
+		"login_request": {match[1]},
+		"username":      {"jdoe"},
+		"password":      {"s3cret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("hosted login of a security key user: status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,183 @@
+// internal/handler/wellknown.go
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+// Paths of the well-known documents served by DiscoveryHandler
+const (
+	JWKSPath                = "/.well-known/jwks.json"
+	OpenIDConfigurationPath = "/.well-known/openid-configuration"
+)
+
+// DefaultMetadataMaxAge is how long clients may cache the discovery document
+const DefaultMetadataMaxAge = time.Hour
+
+// KeySetProvider publishes the token verification keys
+type KeySetProvider interface {
+	JWKS() (auth.JWKSet, time.Duration, error)
+	SigningAlgorithms() []string
+}
+
+// DiscoveryConfig describes the service in its OpenID Provider metadata.
+// Endpoints are paths relative to Issuer or absolute URLs; empty endpoints
+// are left out of the document.
+type DiscoveryConfig struct {
+	Issuer string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	UserinfoEndpoint      string
+	EndSessionEndpoint    string
+	RevocationEndpoint    string
+	IntrospectionEndpoint string
+	// JWKSURI defaults to JWKSPath
+	JWKSURI string
+
+	// Defaults to "code"
+	ResponseTypesSupported []string
+	GrantTypesSupported    []string
+	ScopesSupported        []string
+	ClaimsSupported        []string
+	// DPoPSigningAlgValuesSupported advertises DPoP support (RFC 9449),
+	// normally set to the token endpoint verifier's Algorithms
+	DPoPSigningAlgValuesSupported []string
+	// TLSClientCertificateBoundAccessTokens advertises certificate-bound
+	// tokens (RFC 8705)
+	TLSClientCertificateBoundAccessTokens bool
+
+	// MetadataMaxAge defaults to DefaultMetadataMaxAge
+	MetadataMaxAge time.Duration
+}
+
+// ProviderMetadata is the OpenID Connect Discovery 1.0 document
+type ProviderMetadata struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                    string   `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint               string   `json:"end_session_endpoint,omitempty"`
+	RevocationEndpoint               string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint            string   `json:"introspection_endpoint,omitempty"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	GrantTypesSupported              []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                  []string `json:"scopes_supported,omitempty"`
+	ClaimsSupported                  []string `json:"claims_supported,omitempty"`
+	DPoPSigningAlgValuesSupported    []string `json:"dpop_signing_alg_values_supported,omitempty"`
+
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+}
+
+// DiscoveryHandler serves the JWKS and OpenID Provider metadata documents
+type DiscoveryHandler struct {
+	keys   KeySetProvider
+	config DiscoveryConfig
+	logger Logger
+}
+
+func NewDiscoveryHandler(keys KeySetProvider, config DiscoveryConfig, logger Logger) *DiscoveryHandler {
+	if config.JWKSURI == "" {
+		config.JWKSURI = JWKSPath
+	}
+	if len(config.ResponseTypesSupported) == 0 {
+		config.ResponseTypesSupported = []string{"code"}
+	}
+	if config.MetadataMaxAge <= 0 {
+		config.MetadataMaxAge = DefaultMetadataMaxAge
+	}
+
+	return &DiscoveryHandler{
+		keys:   keys,
+		config: config,
+		logger: logger,
+	}
+}
+
+// HandleJWKS serves the public keys of the active, retiring and pending
+// signing keys. The cache lifetime ends before the next scheduled key change.
+func (h *DiscoveryHandler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	if !h.allowMethod(w, r) {
+		return
+	}
+
+	set, maxAge, err := h.keys.JWKS()
+	if err != nil {
+		h.logger.Error("Failed to build key set", "error", err)
+		h.respondError(w, http.StatusInternalServerError, "key set unavailable")
+		return
+	}
+
+	setCacheControl(w, maxAge)
+	respondJSON(w, h.logger, http.StatusOK, set)
+}
+
+// HandleOpenIDConfiguration serves the OpenID Provider metadata
+func (h *DiscoveryHandler) HandleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if !h.allowMethod(w, r) {
+		return
+	}
+
+	algs := h.keys.SigningAlgorithms()
+	if algs == nil {
+		algs = []string{}
+	}
+
+	setCacheControl(w, h.config.MetadataMaxAge)
+	respondJSON(w, h.logger, http.StatusOK, ProviderMetadata{
+		Issuer:                           h.config.Issuer,
+		AuthorizationEndpoint:            h.endpoint(h.config.AuthorizationEndpoint),
+		TokenEndpoint:                    h.endpoint(h.config.TokenEndpoint),
+		UserinfoEndpoint:                 h.endpoint(h.config.UserinfoEndpoint),
+		EndSessionEndpoint:               h.endpoint(h.config.EndSessionEndpoint),
+		RevocationEndpoint:               h.endpoint(h.config.RevocationEndpoint),
+		IntrospectionEndpoint:            h.endpoint(h.config.IntrospectionEndpoint),
+		JWKSURI:                          h.endpoint(h.config.JWKSURI),
+		ResponseTypesSupported:           h.config.ResponseTypesSupported,
+		GrantTypesSupported:              h.config.GrantTypesSupported,
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: algs,
+		ScopesSupported:                  h.config.ScopesSupported,
+		ClaimsSupported:                  h.config.ClaimsSupported,
+		DPoPSigningAlgValuesSupported:    h.config.DPoPSigningAlgValuesSupported,
+
+		TLSClientCertificateBoundAccessTokens: h.config.TLSClientCertificateBoundAccessTokens,
+	})
+}
+
+// endpoint resolves a path against the issuer. Absolute URLs are kept.
+func (h *DiscoveryHandler) endpoint(path string) string {
+	if path == "" || strings.Contains(path, "://") {
+		return path
+	}
+	return strings.TrimSuffix(h.config.Issuer, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+func (h *DiscoveryHandler) allowMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.respondError(w, http.StatusMethodNotAllowed, "invalid request")
+		return false
+	}
+	return true
+}
+
+func (h *DiscoveryHandler) respondError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, h.logger, status, ErrorResponse{
+		Error: message,
+	})
+}
+
+// setCacheControl allows shared caches to keep a public document for maxAge
+func setCacheControl(w http.ResponseWriter, maxAge time.Duration) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge/time.Second)))
+}
@@ -0,0 +1,141 @@
+// internal/handler/wellknown_test.go
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+type mockKeySet struct {
+	set    auth.JWKSet
+	maxAge time.Duration
+	err    error
+	algs   []string
+}
+
+func (m *mockKeySet) JWKS() (auth.JWKSet, time.Duration, error) {
+	return m.set, m.maxAge, m.err
+}
+
+func (m *mockKeySet) SigningAlgorithms() []string {
+	return m.algs
+}
+
+func TestHandleJWKS(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		keys       *mockKeySet
+		wantStatus int
+		wantCache  string
+	}{
+		{
+			name:   "serves keys with rotation-bound max age",
+			method: http.MethodGet,
+			keys: &mockKeySet{
+				set:    auth.JWKSet{Keys: []auth.JWK{{Kty: "OKP", Kid: "k1", Crv: "Ed25519", X: "abc"}}},
+				maxAge: 90 * time.Second,
+			},
+			wantStatus: http.StatusOK,
+			wantCache:  "public, max-age=90",
+		},
+		{
+			name:       "rejects POST",
+			method:     http.MethodPost,
+			keys:       &mockKeySet{},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCache:  "no-store",
+		},
+		{
+			name:       "key set failure",
+			method:     http.MethodGet,
+			keys:       &mockKeySet{err: fmt.Errorf("no active key")},
+			wantStatus: http.StatusInternalServerError,
+			wantCache:  "no-store",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewDiscoveryHandler(tt.keys, DiscoveryConfig{Issuer: "https://auth.example.com"}, &mockLogger{})
+			req := httptest.NewRequest(tt.method, JWKSPath, nil)
+			w := httptest.NewRecorder()
+			h.HandleJWKS(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.wantCache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCache)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var set auth.JWKSet
+			if err := json.NewDecoder(w.Body).Decode(&set); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(set.Keys) != 1 || set.Keys[0].Kid != "k1" {
+				t.Errorf("keys = %+v", set.Keys)
+			}
+		})
+	}
+}
+
+func TestHandleOpenIDConfiguration(t *testing.T) {
+	keys := &mockKeySet{algs: []string{auth.AlgRS256, auth.AlgEdDSA}}
+	h := NewDiscoveryHandler(keys, DiscoveryConfig{
+		Issuer:                "https://auth.example.com/",
+		AuthorizationEndpoint: "/authorize",
+		TokenEndpoint:         "token",
+		UserinfoEndpoint:      "https://userinfo.example.com/v1",
+		GrantTypesSupported:   []string{"authorization_code"},
+	}, &mockLogger{})
+
+	req := httptest.NewRequest(http.MethodGet, OpenIDConfigurationPath, nil)
+	w := httptest.NewRecorder()
+	h.HandleOpenIDConfiguration(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	var raw map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&raw); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	want := map[string]string{
+		"issuer":                 "https://auth.example.com/",
+		"authorization_endpoint": "https://auth.example.com/authorize",
+		"token_endpoint":         "https://auth.example.com/token",
+		"userinfo_endpoint":      "https://userinfo.example.com/v1",
+		"jwks_uri":               "https://auth.example.com/.well-known/jwks.json",
+	}
+	for field, value := range want {
+		if raw[field] != value {
+			t.Errorf("%s = %v, want %q", field, raw[field], value)
+		}
+	}
+	if _, ok := raw["end_session_endpoint"]; ok {
+		t.Error("unconfigured end_session_endpoint should be omitted")
+	}
+
+	algs, _ := raw["id_token_signing_alg_values_supported"].([]interface{})
+	if len(algs) != 2 || algs[0] != auth.AlgRS256 || algs[1] != auth.AlgEdDSA {
+		t.Errorf("id_token_signing_alg_values_supported = %v", raw["id_token_signing_alg_values_supported"])
+	}
+	if types, _ := raw["response_types_supported"].([]interface{}); len(types) != 1 || types[0] != "code" {
+		t.Errorf("response_types_supported = %v", raw["response_types_supported"])
+	}
+}
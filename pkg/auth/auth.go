@@ -2,25 +2,74 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
-	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // Config holds the configuration for the auth package
 type Config struct {
+	// KeyRing holds rotating signing keys and takes precedence over
+	// SigningKey and JWTSecret
+	KeyRing *KeyRing
+	// SigningKey signs tokens with RS256, ES256 or EdDSA. When nil, tokens
+	// fall back to HS256 with JWTSecret, which is kept for legacy consumers
+	// only: anyone able to verify such a token can also mint one.
+	SigningKey     *SigningKey
 	JWTSecret      []byte
 	TokenDuration  time.Duration
 	LDAPPort       string
 	SessionManager SessionManager
 	Logger         Logger
+
+	// Issuer identifies this service in the iss claim and discovery metadata
+	Issuer string
+	// Claims selects the audience and optional claims of issued tokens
+	Claims ClaimsConfig
+	// JWKSMaxAge caps how long verifiers may cache the published key set.
+	// Defaults to DefaultJWKSMaxAge.
+	JWKSMaxAge time.Duration
+
+	// Audience, when set, must appear in the aud claim of validated tokens
+	Audience string
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat.
+	// Defaults to DefaultClockSkew.
+	ClockSkew time.Duration
+	// RevocationStore is consulted by ValidateToken and enables revocation
+	RevocationStore RevocationStore
+	// RefreshStore enables refresh tokens
+	RefreshStore RefreshStore
+	// RefreshTokenDuration is how long each refresh token stays valid; every
+	// rotation starts a new period. Defaults to DefaultRefreshTokenDuration.
+	RefreshTokenDuration time.Duration
+	// MaxTokenLifetime is the longest lifetime any instance gives tokens
+	// with WithLifetime. Logout keeps a session's tokens revoked this long.
+	// Defaults to the longer of TokenDuration and the longest lifetime this
+	// client has issued.
+	MaxTokenLifetime time.Duration
+	// AllowedAlgorithms restricts the signing algorithms ValidateToken
+	// accepts. Defaults to the algorithms of the configured keys.
+	AllowedAlgorithms []string
+	// Logout controls where Logout may send browsers
+	Logout LogoutConfig
 }
 
+// ErrInvalidToken is returned for tokens that fail validation
+var ErrInvalidToken = errors.New("invalid token")
+
+// DefaultClockSkew is the default leeway for time based claims
+const DefaultClockSkew = time.Minute
+
 // Client handles authentication operations
 type Client struct {
 	config Config
+	// longestLifetime is the longest lifetime of the tokens issued so far
+	longestLifetime atomic.Int64
 }
 
 // NewClient creates a new authentication client
@@ -30,43 +79,221 @@ func NewClient(config Config) *Client {
 	}
 }
 
+// signingKey returns the key that signs new tokens: the key ring's active
+// key, the configured key, or the legacy HS256 key built from JWTSecret
+func (c *Client) signingKey() (*SigningKey, error) {
+	if c.config.KeyRing != nil {
+		return c.config.KeyRing.Active()
+	}
+	if c.config.SigningKey != nil {
+		return c.config.SigningKey, nil
+	}
+	if len(c.config.JWTSecret) == 0 {
+		return nil, fmt.Errorf("invalid client configuration: missing signing key or JWT secret")
+	}
+	return &SigningKey{ID: legacyKeyID, Algorithm: AlgHS256, Key: c.config.JWTSecret}, nil
+}
+
 // GenerateToken creates a new JWT token for an authenticated user
-func (c *Client) GenerateToken(userID string) (string, error) {
+func (c *Client) GenerateToken(userID string, opts ...TokenOption) (string, error) {
 	// Validate config
-	if len(c.config.JWTSecret) == 0 {
-		return "", fmt.Errorf("invalid client configuration: missing JWT secret")
+	key, err := c.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	var o tokenOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	lifetime := c.config.TokenDuration
+	if o.lifetime > 0 {
+		lifetime = o.lifetime
 	}
+	c.recordLifetime(lifetime)
 
-	token := jwt.New(jwt.SigningMethodHS256)
+	now := time.Now()
+	authTime := now
+	if !o.authTime.IsZero() {
+		authTime = o.authTime
+	}
+	audience := c.config.Claims.Audience
+	if len(o.audience) > 0 {
+		audience = o.audience
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["username"] = userID
-	claims["exp"] = time.Now().Add(c.config.TokenDuration).Unix()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    c.config.Issuer,
+			Subject:   userID,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
+		},
+		Username:        userID,
+		Name:            o.name,
+		Email:           o.email,
+		Groups:          o.groups,
+		Roles:           o.roles,
+		AMR:             o.amr,
+		AuthTime:        jwt.NewNumericDate(authTime),
+		SessionID:       o.sessionID,
+		ClientID:        o.clientID,
+		Scope:           strings.Join(o.scope, " "),
+		OfflineVerified: o.offline,
+		Nonce:           o.nonce,
+		TokenUse:        o.tokenUse,
+		Actor:           o.actor,
+		Confirmation:    o.cnf,
+	}
+
+	omit := c.config.Claims.omitted
+	if omit(ClaimName) {
+		claims.Name = ""
+	}
+	if omit(ClaimEmail) {
+		claims.Email = ""
+	}
+	if omit(ClaimGroups) {
+		claims.Groups = nil
+	}
+	if omit(ClaimRoles) {
+		claims.Roles = nil
+	}
+	if omit(ClaimAMR) {
+		claims.AMR = nil
+	}
+	if omit(ClaimAuthTime) {
+		claims.AuthTime = nil
+	}
 
-	return token.SignedString(c.config.JWTSecret)
+	return key.sign(claims)
 }
 
-// Logout handles user session termination and cleanup
-func (c *Client) Logout(w http.ResponseWriter, r *http.Request, sessionManager SessionManager, logger Logger) error {
-	// Use request's context instead of separate authCtx and simplified context handling
-	ctx := r.Context()
+// ValidateToken verifies a token issued by GenerateToken and returns its
+// claims. It checks the signature, restricts the algorithm to the allowlist,
+// requires exp, checks exp, nbf and iat with ClockSkew leeway, and checks
+// the issuer and audience when configured, and consults the
+// RevocationStore.
+func (c *Client) ValidateToken(tokenString string, opts ...ValidateOption) (*Claims, error) {
+	o := validateOptions{audience: c.config.Audience}
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-	// Made logging optional and safer
-	if logger != nil {
-		logger.Info("User Logged Out",
-			"message", "User Logged Out",
-			"userID", ctx.Value("userID"), // Generic userID instead of soeid
-		)
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(c.allowedAlgorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(c.clockSkew()),
+	}
+	if c.config.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(c.config.Issuer))
+	}
+	if o.audience != "" && !o.anyAudience {
+		parserOpts = append(parserOpts, jwt.WithAudience(o.audience))
 	}
 
-	// Made session management optional
-	if sessionManager != nil {
-		sessionManager.Put(ctx, "userID", "")
-		sessionManager.Put(ctx, "jwt_token", "")
-		sessionManager.Put(ctx, "authenticated", false)
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, c.verificationKey, parserOpts...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
 	}
+	if claims.UserID() == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	if claims.TokenUse == TokenUseID {
+		return nil, fmt.Errorf("%w: ID token used as access token", ErrInvalidToken)
+	}
+	if err := c.checkRevoked(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// VerifyTokenHint checks the signature and issuer of a token issued by this
+// service, such as an id_token_hint, without requiring it to be current.
+// The claims identify a user and client but grant nothing.
+func (c *Client) VerifyTokenHint(tokenString string) (*Claims, error) {
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(c.allowedAlgorithms()),
+		jwt.WithoutClaimsValidation(),
+	}
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, c.verificationKey, parserOpts...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if c.config.Issuer != "" && claims.Issuer != c.config.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	return claims, nil
+}
 
-	// Same redirect but with error handling potential
-	http.Redirect(w, r, "/login", http.StatusFound)
-	return nil
+func (c *Client) allowedAlgorithms() []string {
+	if len(c.config.AllowedAlgorithms) > 0 {
+		return c.config.AllowedAlgorithms
+	}
+	return c.SigningAlgorithms()
+}
+
+// recordLifetime remembers lifetime if it is the longest issued so far
+func (c *Client) recordLifetime(lifetime time.Duration) {
+	for {
+		longest := c.longestLifetime.Load()
+		if int64(lifetime) <= longest || c.longestLifetime.CompareAndSwap(longest, int64(lifetime)) {
+			return
+		}
+	}
+}
+
+// maxTokenLifetime is the longest an access token issued now can stay valid
+func (c *Client) maxTokenLifetime() time.Duration {
+	longest := c.config.TokenDuration
+	if c.config.MaxTokenLifetime > longest {
+		longest = c.config.MaxTokenLifetime
+	}
+	if issued := time.Duration(c.longestLifetime.Load()); issued > longest {
+		longest = issued
+	}
+	return longest
+}
+
+func (c *Client) clockSkew() time.Duration {
+	if c.config.ClockSkew > 0 {
+		return c.config.ClockSkew
+	}
+	return DefaultClockSkew
+}
+
+// verificationKey selects the key for a token by its kid header. The token's
+// alg must match the algorithm of that key, which rules out "none" and
+// algorithm confusion attacks.
+func (c *Client) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	var key *SigningKey
+	if c.config.KeyRing != nil {
+		k, ok := c.config.KeyRing.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown or retired key ID %q", kid)
+		}
+		key = k
+	} else {
+		k, err := c.signingKey()
+		if err != nil {
+			return nil, err
+		}
+		if kid != "" && kid != k.ID {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		key = k
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing algorithm %q", token.Method.Alg())
+	}
+	return key.Public(), nil
 }
@@ -3,8 +3,16 @@ package auth
 
 import "context"
 
+// SessionManager stores values in the server-side session of the request
+// in ctx. Sessions implements it.
 type SessionManager interface {
 	Put(ctx context.Context, key string, val interface{})
+	Get(ctx context.Context, key string) interface{}
+	// Destroy deletes the session and its data
+	Destroy(ctx context.Context) error
+	// RenewID moves the session to a new ID, keeping its data. Call it
+	// whenever the privilege level changes to prevent session fixation.
+	RenewID(ctx context.Context) error
 }
 
 type Logger interface {
@@ -2,17 +2,21 @@ package auth
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // Mock types for testing
 type mockSessionManager struct {
-	values map[string]interface{}
+	values    map[string]interface{}
+	destroyed bool
+	renewed   bool
 }
 
 func newMockSessionManager() *mockSessionManager {
@@ -25,10 +29,21 @@ func (m *mockSessionManager) Put(ctx context.Context, key string, val interface{
 	m.values[key] = val
 }
 
-func (m *mockSessionManager) Get(key string) interface{} {
+func (m *mockSessionManager) Get(ctx context.Context, key string) interface{} {
 	return m.values[key]
 }
 
+func (m *mockSessionManager) Destroy(ctx context.Context) error {
+	m.values = make(map[string]interface{})
+	m.destroyed = true
+	return nil
+}
+
+func (m *mockSessionManager) RenewID(ctx context.Context) error {
+	m.renewed = true
+	return nil
+}
+
 type mockLogger struct {
 	logs []string
 }
@@ -166,6 +181,183 @@ func TestGenerateToken(t *testing.T) {
 	})
 }
 
+func TestGenerateTokenOptions(t *testing.T) {
+	client := NewClient(Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: time.Hour,
+	})
+
+	token, err := client.GenerateToken("oncall", WithAMR(AMRPassword, AMRLocal), WithRoles("operator"))
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("test-secret"), nil
+	}); err != nil {
+		t.Fatalf("Failed to parse generated token: %v", err)
+	}
+
+	if got := fmt.Sprint(claims["amr"]); got != "[pwd local]" {
+		t.Errorf("amr = %v, want [pwd local]", got)
+	}
+	if got := fmt.Sprint(claims["roles"]); got != "[operator]" {
+		t.Errorf("roles = %v, want [operator]", got)
+	}
+	if _, ok := claims["offline_verified"]; ok {
+		t.Error("offline_verified must only be set for offline logins")
+	}
+
+	token, err = client.GenerateToken("jdoe", WithLifetime(5*time.Minute), WithOfflineVerified())
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+	claims = jwt.MapClaims{}
+	jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("test-secret"), nil
+	})
+	if claims["offline_verified"] != true {
+		t.Errorf("offline_verified = %v, want true", claims["offline_verified"])
+	}
+	exp, _ := claims.GetExpirationTime()
+	if want := time.Now().Add(5 * time.Minute); exp == nil || exp.Sub(want) > time.Second || want.Sub(exp.Time) > time.Second {
+		t.Errorf("exp = %v, want about %v", exp, want)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+
+	token, err := client.GenerateToken("nightly-batch", AsClientToken(), WithClientID("nightly-batch"), WithScope("ledger:read"))
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+	claims, err := client.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error: %v", err)
+	}
+	if !claims.ClientToken() || claims.UserID() != "nightly-batch" || claims.Scope != "ledger:read" {
+		t.Errorf("claims = %+v, want a client token", claims)
+	}
+
+	token, _ = client.GenerateToken("jdoe")
+	if claims, _ := client.ValidateToken(token); claims.ClientToken() {
+		t.Error("user token reported as client token")
+	}
+}
+
+func TestActorClaim(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+
+	chain := &Actor{Subject: "orders", Actor: &Actor{Subject: "gateway"}}
+	token, err := client.GenerateToken("jdoe", WithActor(chain))
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+	claims, err := client.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error: %v", err)
+	}
+	if claims.Actor.Depth() != 2 || claims.Actor.Subject != "orders" || claims.Actor.Actor.Subject != "gateway" {
+		t.Errorf("act = %+v, want orders acting after gateway", claims.Actor)
+	}
+
+	token, _ = client.GenerateToken("jdoe")
+	if claims, _ := client.ValidateToken(token); claims.Actor != nil || claims.Actor.Depth() != 0 {
+		t.Errorf("act = %+v, want none", claims.Actor)
+	}
+}
+
+func TestGenerateTokenRegisteredClaims(t *testing.T) {
+	client := NewClient(Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: time.Hour,
+		Issuer:        "https://auth.example.com",
+		Claims:        ClaimsConfig{Audience: []string{"default-app"}},
+	})
+
+	authTime := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	token, err := client.GenerateToken("jdoe",
+		WithProfile("John Doe", "jdoe@example.com"),
+		WithGroups("Staff"),
+		WithAuthTime(authTime),
+	)
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+
+	claims, err := client.ValidateToken(token, WithAudience("default-app"))
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error: %v", err)
+	}
+
+	if claims.Issuer != "https://auth.example.com" || claims.Subject != "jdoe" || claims.Username != "jdoe" {
+		t.Errorf("iss/sub/username = %q/%q/%q", claims.Issuer, claims.Subject, claims.Username)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "default-app" {
+		t.Errorf("aud = %v, want [default-app]", claims.Audience)
+	}
+	if claims.IssuedAt == nil || claims.NotBefore == nil || !claims.NotBefore.Equal(claims.IssuedAt.Time) {
+		t.Errorf("iat = %v, nbf = %v", claims.IssuedAt, claims.NotBefore)
+	}
+	if _, err := uuid.Parse(claims.ID); err != nil {
+		t.Errorf("jti = %q, want a UUID: %v", claims.ID, err)
+	}
+	if claims.Name != "John Doe" || claims.Email != "jdoe@example.com" || fmt.Sprint(claims.Groups) != "[Staff]" {
+		t.Errorf("profile = %q %q %v", claims.Name, claims.Email, claims.Groups)
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Equal(authTime) {
+		t.Errorf("auth_time = %v, want %v", claims.AuthTime, authTime)
+	}
+
+	second, _ := client.GenerateToken("jdoe")
+	again, err := client.ValidateToken(second, WithAudience("default-app"))
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error: %v", err)
+	}
+	if again.ID == claims.ID {
+		t.Error("jti must be unique per token")
+	}
+
+	perClient, _ := client.GenerateToken("jdoe", ForAudience("app-1"))
+	if _, err := client.ValidateToken(perClient, WithAudience("app-1")); err != nil {
+		t.Errorf("ForAudience token rejected for its audience: %v", err)
+	}
+	if _, err := client.ValidateToken(perClient, WithAudience("default-app")); err == nil {
+		t.Error("ForAudience must replace the default audience")
+	}
+}
+
+func TestGenerateTokenOmitClaims(t *testing.T) {
+	client := NewClient(Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: time.Hour,
+		Claims:        ClaimsConfig{Omit: []string{ClaimEmail, ClaimGroups}},
+	})
+
+	token, err := client.GenerateToken("jdoe", WithProfile("John Doe", "jdoe@example.com"), WithGroups("Staff"))
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("test-secret"), nil
+	}); err != nil {
+		t.Fatalf("Failed to parse generated token: %v", err)
+	}
+	if _, ok := claims["email"]; ok {
+		t.Error("email should be omitted")
+	}
+	if _, ok := claims["groups"]; ok {
+		t.Error("groups should be omitted")
+	}
+	if claims["name"] != "John Doe" {
+		t.Errorf("name = %v, want John Doe", claims["name"])
+	}
+}
+
 // TestTokenExpiration verifies that generated tokens actually expire
 func TestTokenExpiration(t *testing.T) {
 	client := NewClient(Config{
@@ -193,94 +385,184 @@ func TestTokenExpiration(t *testing.T) {
 	}
 }
 
-func TestLogout(t *testing.T) {
-	tests := []struct {
-		name         string
-		setupContext func(context.Context) context.Context
-		wantLogEntry string
-		wantRedirect string
-		wantError    bool
-	}{
-		{
-			name: "successful logout with user context",
-			setupContext: func(ctx context.Context) context.Context {
-				return context.WithValue(ctx, "userID", "test-user")
-			},
-			wantLogEntry: "User Logged Out message=User Logged Out userID=test-user",
-			wantRedirect: "/login",
-			wantError:    false,
-		},
-		{
-			name: "logout without user context",
-			setupContext: func(ctx context.Context) context.Context {
-				return ctx
-			},
-			wantLogEntry: "User Logged Out message=User Logged Out userID=<nil>",
-			wantRedirect: "/login",
-			wantError:    false,
-		},
+func ExampleClient_GenerateToken() {
+	client := NewClient(Config{
+		JWTSecret:     []byte("example-secret"),
+		TokenDuration: 24 * time.Hour,
+		LDAPPort:      "3269",
+	})
+
+	token, err := client.GenerateToken("example-user")
+	if err != nil {
+		panic(err)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			sessionMgr := newMockSessionManager()
-			logger := newMockLogger()
+	// Use the token
+	_ = token
+}
 
-			client := NewClient(Config{
-				JWTSecret:     []byte("test-secret"),
-				TokenDuration: time.Hour,
-			})
+func TestValidateToken(t *testing.T) {
+	client := NewClient(Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: time.Hour,
+	})
 
-			r := httptest.NewRequest("GET", "/logout", nil)
-			r = r.WithContext(tt.setupContext(r.Context()))
-			w := httptest.NewRecorder()
+	token, err := client.GenerateToken("test-user")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
 
-			err := client.Logout(w, r, sessionMgr, logger)
+	claims, err := client.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error: %v", err)
+	}
+	if claims.UserID() != "test-user" {
+		t.Errorf("ValidateToken() user = %v, want test-user", claims.UserID())
+	}
 
-			// Check error
-			if (err != nil) != tt.wantError {
-				t.Errorf("Logout() error = %v, wantError %v", err, tt.wantError)
-			}
+	other := NewClient(Config{
+		JWTSecret:     []byte("other-secret"),
+		TokenDuration: time.Hour,
+	})
+	if _, err := other.ValidateToken(token); err == nil {
+		t.Error("ValidateToken() should reject a token signed with another secret")
+	}
 
-			// Check session cleared
-			if sessionMgr.Get("userID") != "" {
-				t.Error("userID was not cleared")
-			}
-			if sessionMgr.Get("jwt_token") != "" {
-				t.Error("jwt_token was not cleared")
-			}
-			if sessionMgr.Get("authenticated") != false {
-				t.Error("authenticated was not set to false")
-			}
+	expired := NewClient(Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: -time.Hour,
+	})
+	token, _ = expired.GenerateToken("test-user")
+	if _, err := client.ValidateToken(token); err == nil {
+		t.Error("ValidateToken() should reject an expired token")
+	}
+}
 
-			// Check redirect
-			if got := w.Header().Get("Location"); got != tt.wantRedirect {
-				t.Errorf("Redirect location = %v, want %v", got, tt.wantRedirect)
-			}
+func TestValidateTokenChecks(t *testing.T) {
+	key, err := NewSigningKey(testRSAKey, "rsa-1")
+	if err != nil {
+		t.Fatalf("NewSigningKey() unexpected error: %v", err)
+	}
+	client := NewClient(Config{
+		SigningKey:    key,
+		TokenDuration: time.Hour,
+		Issuer:        "https://auth.example.com",
+		Audience:      "app-1",
+		ClockSkew:     30 * time.Second,
+	})
+
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"username": "test-user",
+			"iss":      "https://auth.example.com",
+			"aud":      []string{"app-1"},
+			"iat":      now.Unix(),
+			"exp":      now.Add(time.Hour).Unix(),
+		}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	// alg confusion: HS256 signed with the RSA public key as the secret
+	pubDER, err := x509.MarshalPKIXPublicKey(testRSAKey.Public())
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, valid())
+	confused.Header["kid"] = "rsa-1"
+	confusedToken, _ := confused.SignedString(pubDER)
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+	none.Header["kid"] = "rsa-1"
+	noneToken, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := key.sign(claims)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return token
+	}
 
-			// Check logging
-			if len(logger.logs) == 0 {
-				t.Error("No log entries were created")
+	tests := []struct {
+		name    string
+		token   string
+		opts    []ValidateOption
+		wantErr bool
+	}{
+		{name: "valid", token: sign(valid())},
+		{name: "expired within leeway", token: sign(with("exp", now.Add(-10*time.Second).Unix()))},
+		{name: "expired beyond leeway", token: sign(with("exp", now.Add(-time.Minute).Unix())), wantErr: true},
+		{name: "missing exp", token: sign(with("exp", nil)), wantErr: true},
+		{name: "not yet valid", token: sign(with("nbf", now.Add(time.Minute).Unix())), wantErr: true},
+		{name: "nbf within leeway", token: sign(with("nbf", now.Add(10*time.Second).Unix()))},
+		{name: "issued in the future", token: sign(with("iat", now.Add(time.Minute).Unix())), wantErr: true},
+		{name: "wrong issuer", token: sign(with("iss", "https://evil.example.com")), wantErr: true},
+		{name: "wrong audience", token: sign(with("aud", []string{"app-2"})), wantErr: true},
+		{name: "audience override", token: sign(with("aud", []string{"app-2"})), opts: []ValidateOption{WithAudience("app-2")}},
+		{name: "missing subject", token: sign(with("username", nil)), wantErr: true},
+		{name: "alg none", token: noneToken, wantErr: true},
+		{name: "alg confusion", token: confusedToken, wantErr: true},
+		{name: "ID token", token: sign(with("token_use", TokenUseID)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := client.ValidateToken(tt.token, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateToken() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if logger.logs[0] != tt.wantLogEntry {
-				t.Errorf("Log entry = %v, want %v", logger.logs[0], tt.wantLogEntry)
+			if err == nil && claims.UserID() != "test-user" {
+				t.Errorf("ValidateToken() user = %q", claims.UserID())
 			}
 		})
 	}
 }
 
-func ExampleClient_GenerateToken() {
-	client := NewClient(Config{
-		JWTSecret:     []byte("example-secret"),
-		TokenDuration: 24 * time.Hour,
-		LDAPPort:      "3269",
-	})
+func TestValidateTokenAlgorithmAllowlist(t *testing.T) {
+	key, err := NewSigningKey(testRSAKey, "rsa-1")
+	if err != nil {
+		t.Fatalf("NewSigningKey() unexpected error: %v", err)
+	}
+	client := NewClient(Config{SigningKey: key, TokenDuration: time.Hour, AllowedAlgorithms: []string{AlgEdDSA}})
+	token, err := client.GenerateToken("test-user")
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+	if _, err := client.ValidateToken(token); err == nil {
+		t.Error("ValidateToken() should reject algorithms outside the allowlist")
+	}
+}
 
-	token, err := client.GenerateToken("example-user")
+func TestVerifyTokenHint(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: -time.Hour, Issuer: "https://auth.example.com"})
+
+	expired, _ := client.GenerateToken("jdoe", ForAudience("portal"), AsIDToken(), WithNonce("n-1"))
+	claims, err := client.VerifyTokenHint(expired)
 	if err != nil {
-		panic(err)
+		t.Fatalf("VerifyTokenHint() unexpected error: %v", err)
+	}
+	if claims.UserID() != "jdoe" || claims.Nonce != "n-1" || claims.TokenUse != TokenUseID {
+		t.Errorf("VerifyTokenHint() claims = %+v", claims)
 	}
 
-	// Use the token
-	_ = token
+	other := NewClient(Config{JWTSecret: []byte("other-secret"), TokenDuration: time.Hour})
+	forged, _ := other.GenerateToken("jdoe")
+	if _, err := client.VerifyTokenHint(forged); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyTokenHint() of foreign token error = %v, want ErrInvalidToken", err)
+	}
+
+	foreignIssuer := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour, Issuer: "https://evil.example.com"})
+	token, _ := foreignIssuer.GenerateToken("jdoe")
+	if _, err := client.VerifyTokenHint(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyTokenHint() of wrong issuer error = %v, want ErrInvalidToken", err)
+	}
 }
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Names of the optional claims written by GenerateToken, for ClaimsConfig.Omit
+const (
+	ClaimName     = "name"
+	ClaimEmail    = "email"
+	ClaimGroups   = "groups"
+	ClaimRoles    = "roles"
+	ClaimAMR      = "amr"
+	ClaimAuthTime = "auth_time"
+)
+
+// ClaimsConfig controls the claims written by GenerateToken
+type ClaimsConfig struct {
+	// Audience is written to aud when a token is not issued for a specific
+	// client with ForAudience
+	Audience []string
+	// Omit lists optional claims that are never written, e.g. ClaimEmail to
+	// keep personal data out of tokens
+	Omit []string
+}
+
+func (c ClaimsConfig) omitted(claim string) bool {
+	for _, o := range c.Omit {
+		if o == claim {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the claims carried by tokens issued by GenerateToken. The
+// registered claims follow RFC 7519; name, email, groups, amr and auth_time
+// follow OpenID Connect.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Username duplicates sub for consumers predating it
+	Username  string           `json:"username"`
+	Name      string           `json:"name,omitempty"`
+	Email     string           `json:"email,omitempty"`
+	Groups    []string         `json:"groups,omitempty"`
+	Roles     []string         `json:"roles,omitempty"`
+	AMR       []string         `json:"amr,omitempty"`
+	AuthTime  *jwt.NumericDate `json:"auth_time,omitempty"`
+	SessionID string           `json:"sid,omitempty"`
+	// ClientID and Scope follow RFC 9068; Scope is space separated
+	ClientID        string `json:"client_id,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	OfflineVerified bool   `json:"offline_verified,omitempty"`
+	// Nonce is echoed from the authentication request into ID tokens
+	Nonce string `json:"nonce,omitempty"`
+	// TokenUse is TokenUseID for ID tokens, which ValidateToken rejects as
+	// access tokens, and TokenUseClient for tokens of non-human clients
+	TokenUse string `json:"token_use,omitempty"`
+	// Actor is set on tokens obtained by token exchange and names the party
+	// acting on behalf of the subject (RFC 8693 section 4.1)
+	Actor *Actor `json:"act,omitempty"`
+	// Confirmation binds the token to a key its holder must prove
+	// possession of (RFC 7800). Bound tokens are not bearer tokens.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Confirmation is a cnf claim
+type Confirmation struct {
+	// JKT is the JWK SHA-256 thumbprint of a DPoP key (RFC 9449)
+	JKT string `json:"jkt,omitempty"`
+	// X5TS256 is the SHA-256 thumbprint of a TLS client certificate
+	// (RFC 8705)
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+// Actor is an act claim. A nested Actor is the party that acted before, so
+// the chain lists every delegation from the most recent one.
+type Actor struct {
+	Subject string `json:"sub"`
+	Actor   *Actor `json:"act,omitempty"`
+}
+
+// Depth returns the number of actors in the chain
+func (a *Actor) Depth() int {
+	depth := 0
+	for ; a != nil; a = a.Actor {
+		depth++
+	}
+	return depth
+}
+
+// Values of the token_use claim
+const (
+	// TokenUseID marks ID tokens
+	TokenUseID = "id"
+	// TokenUseClient marks access tokens whose subject is a client
+	TokenUseClient = "client"
+)
+
+// UserID returns the authenticated user, preferring the sub claim
+func (c *Claims) UserID() string {
+	if c.Subject != "" {
+		return c.Subject
+	}
+	return c.Username
+}
+
+// ClientToken reports whether the token was issued to a client acting on
+// its own behalf rather than to a user
+func (c *Claims) ClientToken() bool {
+	return c.TokenUse == TokenUseClient
+}
+
+// DPoPBound reports whether the token may only be used with a DPoP proof
+func (c *Claims) DPoPBound() bool {
+	return c.Confirmation != nil && c.Confirmation.JKT != ""
+}
+
+// CertificateBound reports whether the token may only be used over a TLS
+// connection authenticated with the client certificate it is bound to
+func (c *Claims) CertificateBound() bool {
+	return c.Confirmation != nil && c.Confirmation.X5TS256 != ""
+}
+
+// HasRole reports whether the token grants any of roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, have := range c.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
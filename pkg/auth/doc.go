@@ -18,13 +18,74 @@
 //		log.Fatal(err)
 //	}
 //
+// Tokens should be signed with an asymmetric key so that verifiers only need
+// the public key:
+//
+//	key, err := auth.LoadSigningKey("/etc/auth-service/signing.pem", "")
+//
+//	client := auth.NewClient(auth.Config{
+//		SigningKey:    key,
+//		TokenDuration: time.Hour,
+//	})
+//
+// To rotate keys without invalidating outstanding tokens, load a KeyRing
+// from a directory and let it reload and rotate in the background:
+//
+//	ring, err := auth.LoadKeyRing("/etc/auth-service/keys", auth.KeyRingConfig{
+//		RetirementPeriod: 48 * time.Hour,
+//		RotationInterval: 30 * 24 * time.Hour,
+//	})
+//	ring.Start(ctx, time.Minute, logger)
+//
+//	client := auth.NewClient(auth.Config{KeyRing: ring, TokenDuration: time.Hour})
+//
+// Resource servers validate tokens with ValidateToken, or protect handlers
+// with Middleware and read the claims from the request context:
+//
+//	mux.Handle("/admin", client.Middleware(auth.MiddlewareConfig{CookieName: "token"})(
+//		auth.RequireRole("admin")(adminHandler)))
+//
+//	claims, ok := auth.ClaimsFromContext(r.Context())
+//
+// Tokens bound to a client key with WithDPoPKey are only accepted with the
+// DPoP scheme and a fresh proof of that key (RFC 9449). Enable DPoP on the
+// middleware to accept them:
+//
+//	client.Middleware(auth.MiddlewareConfig{
+//		DPoP: auth.NewDPoPVerifier("https://api.example.com"),
+//	})
+//
+// Tokens bound to a TLS client certificate with WithCertificateBinding
+// (RFC 8705) are only accepted over a connection presenting that
+// certificate, whatever the scheme.
+//
+// Browser sessions are kept server side behind a secure cookie. Wrap the
+// handlers in the session middleware and call Login after authentication
+// to rotate the session ID:
+//
+//	store, err := auth.OpenFileSessionStore("/var/lib/auth-service/sessions.jsonl")
+//	sessions := auth.NewSessions(auth.SessionConfig{Store: store})
+//
+//	mux.Handle("/", sessions.Middleware(app))
+//
+//	sid, err := sessions.Login(r.Context(), userID)
+//
 // The package provides:
 //   - JWT token generation with configurable expiration
+//   - Token validation with algorithm allowlist, issuer, audience and leeway
+//   - Opaque refresh tokens with rotation and reuse detection (RefreshStore)
+//   - Revocation by token, session or user through a RevocationStore
+//   - DPoP sender-constrained tokens with proof replay detection
+//   - Mutual-TLS certificate-bound tokens
+//   - Server-side sessions with idle and absolute timeouts (Sessions)
+//   - RS256, ES256 and EdDSA signing from PEM/PKCS#8 keys, with a kid header
+//   - Key rotation with overlapping validity through KeyRing
 //   - LDAP authentication support
 //   - Platform-independent LDAP server resolution
 //   - Secure default configurations
 //
 // Security Considerations:
+//   - Prefer SigningKey; HS256 with JWTSecret is a legacy option
 //   - JWTSecret should be at least 32 bytes long
 //   - TokenDuration should be set according to your security requirements
 //   - LDAP connections are made over TLS by default
@@ -38,7 +99,7 @@
 //		Logger:        myLogger,
 //	})
 
-//	client.Logout(w, r, sessionManager, logger)
+//	client.Logout(w, r)
 package auth
 
 // Version information
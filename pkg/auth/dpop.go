@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoPHeader carries DPoP proofs (RFC 9449)
+const DPoPHeader = "DPoP"
+
+// dpopProofType is the typ header of DPoP proofs
+const dpopProofType = "dpop+jwt"
+
+// DefaultDPoPProofAge is how long after its iat a DPoP proof is accepted
+const DefaultDPoPProofAge = time.Minute
+
+var (
+	// ErrMissingDPoPProof is returned when a request carries no DPoP proof
+	ErrMissingDPoPProof = errors.New("missing DPoP proof")
+	// ErrInvalidDPoPProof is returned for DPoP proofs that fail verification
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+)
+
+// dpopAlgorithms are the accepted proof algorithms. Proofs are signed with
+// the client's private key, so symmetric algorithms make no sense.
+var dpopAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// DPoPProof is a verified DPoP proof
+type DPoPProof struct {
+	// Thumbprint is the JWK SHA-256 thumbprint of the proof key, the
+	// cnf.jkt of tokens bound to it
+	Thumbprint string
+	ID         string
+	IssuedAt   time.Time
+}
+
+type dpopClaims struct {
+	jwt.RegisteredClaims
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+}
+
+// DPoPVerifier checks DPoP proofs: the signature by the embedded public
+// key, the method and URL of the request, the proof's age, the access
+// token hash and that the proof was not used before
+type DPoPVerifier struct {
+	// PublicURL is the scheme and host clients use to reach the service,
+	// such as "https://api.example.com", when it sits behind a proxy.
+	// Defaults to the request's Host, with https for TLS connections.
+	PublicURL string
+	// MaxAge defaults to DefaultDPoPProofAge
+	MaxAge time.Duration
+	// Leeway tolerates clocks of clients running ahead. Defaults to
+	// DefaultClockSkew.
+	Leeway time.Duration
+	// Replay detects reused proofs. It is required: no proof is accepted
+	// while it is nil.
+	Replay ReplayCache
+	now    func() time.Time
+}
+
+// errNoDPoPReplayCache is returned by verifiers built without a ReplayCache
+var errNoDPoPReplayCache = errors.New("DPoP verifier has no replay cache")
+
+// NewDPoPVerifier detects replayed proofs in memory, which only works for a
+// single instance
+func NewDPoPVerifier(publicURL string) *DPoPVerifier {
+	return &DPoPVerifier{
+		PublicURL: publicURL,
+		Replay:    NewMemoryReplayCache(),
+		now:       time.Now,
+	}
+}
+
+// Algorithms returns the accepted proof algorithms, for the
+// dpop_signing_alg_values_supported metadata
+func (v *DPoPVerifier) Algorithms() []string {
+	return append([]string(nil), dpopAlgorithms...)
+}
+
+// VerifyRequest verifies the single DPoP header of r. accessToken is the
+// token the request presents, or "" at the token endpoint.
+func (v *DPoPVerifier) VerifyRequest(r *http.Request, accessToken string) (*DPoPProof, error) {
+	proofs := r.Header.Values(DPoPHeader)
+	switch len(proofs) {
+	case 0:
+		return nil, ErrMissingDPoPProof
+	case 1:
+		return v.Verify(proofs[0], r.Method, v.requestURL(r), accessToken)
+	default:
+		return nil, fmt.Errorf("%w: more than one proof", ErrInvalidDPoPProof)
+	}
+}
+
+// Verify checks a DPoP proof for a request with method to uri (RFC 9449
+// section 4.3). When accessToken is not empty the proof must carry its
+// hash in ath.
+func (v *DPoPVerifier) Verify(proof, method, uri, accessToken string) (*DPoPProof, error) {
+	if v.Replay == nil {
+		return nil, errNoDPoPReplayCache
+	}
+
+	var thumbprint string
+	claims := &dpopClaims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("typ %q is not %s", typ, dpopProofType)
+		}
+		jwk, err := proofKey(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if thumbprint, err = jwk.Thumbprint(); err != nil {
+			return nil, err
+		}
+		return jwk.PublicKey()
+	},
+		jwt.WithValidMethods(dpopAlgorithms),
+		// Proofs have no exp; their age is checked below
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDPoPProof, err)
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: jti and iat are required", ErrInvalidDPoPProof)
+	}
+	if claims.HTM != method {
+		return nil, fmt.Errorf("%w: htm %q does not match %s", ErrInvalidDPoPProof, claims.HTM, method)
+	}
+	if htu, want := normalizeHTU(claims.HTU), normalizeHTU(uri); htu == "" || htu != want {
+		return nil, fmt.Errorf("%w: htu %q does not match %s", ErrInvalidDPoPProof, claims.HTU, want)
+	}
+
+	now := v.clock()
+	issuedAt := claims.IssuedAt.Time
+	if issuedAt.After(now.Add(v.leeway())) || now.Sub(issuedAt) > v.maxAge() {
+		return nil, fmt.Errorf("%w: iat outside the accepted window", ErrInvalidDPoPProof)
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		ath := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(claims.ATH), []byte(ath)) != 1 {
+			return nil, fmt.Errorf("%w: ath does not match the access token", ErrInvalidDPoPProof)
+		}
+	}
+
+	// Proofs are rejected once too old, so the jti only needs to be
+	// remembered until then
+	if v.Replay.Seen(thumbprint+" "+claims.ID, issuedAt.Add(v.maxAge()+v.leeway())) {
+		return nil, fmt.Errorf("%w: replayed", ErrInvalidDPoPProof)
+	}
+
+	return &DPoPProof{Thumbprint: thumbprint, ID: claims.ID, IssuedAt: issuedAt}, nil
+}
+
+func (v *DPoPVerifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+func (v *DPoPVerifier) maxAge() time.Duration {
+	if v.MaxAge > 0 {
+		return v.MaxAge
+	}
+	return DefaultDPoPProofAge
+}
+
+func (v *DPoPVerifier) leeway() time.Duration {
+	if v.Leeway > 0 {
+		return v.Leeway
+	}
+	return DefaultClockSkew
+}
+
+// requestURL is the URL the client used for r, without query
+func (v *DPoPVerifier) requestURL(r *http.Request) string {
+	if v.PublicURL != "" {
+		return strings.TrimSuffix(v.PublicURL, "/") + r.URL.Path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// proofKey reads the public key embedded in a proof's jwk header
+func proofKey(header interface{}) (JWK, error) {
+	members, ok := header.(map[string]interface{})
+	if !ok {
+		return JWK{}, fmt.Errorf("missing jwk header")
+	}
+	if _, private := members["d"]; private {
+		return JWK{}, fmt.Errorf("jwk header contains a private key")
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return JWK{}, err
+	}
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return JWK{}, fmt.Errorf("invalid jwk header: %w", err)
+	}
+	return jwk, nil
+}
+
+// normalizeHTU reduces a URL to the parts compared for htu: scheme, host
+// without default port, and path. Query and fragment are ignored.
+func normalizeHTU(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return ""
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	switch scheme {
+	case "https":
+		host = strings.TrimSuffix(host, ":443")
+	case "http":
+		host = strings.TrimSuffix(host, ":80")
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// dpopKey is a client's proof-of-possession key
+type dpopKey struct {
+	private    *ecdsa.PrivateKey
+	thumbprint string
+}
+
+func newDPoPKey(t *testing.T) *dpopKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, _ := PublicJWK(&private.PublicKey)
+	thumbprint, _ := jwk.Thumbprint()
+	return &dpopKey{private: private, thumbprint: thumbprint}
+}
+
+type proofOptions struct {
+	typ      string
+	iat      time.Time
+	jti      string
+	extraJWK map[string]interface{}
+}
+
+// proof signs a DPoP proof for a request; accessToken may be empty
+func (k *dpopKey) proof(t *testing.T, method, htu, accessToken string, opts ...func(*proofOptions)) string {
+	t.Helper()
+	o := proofOptions{typ: "dpop+jwt", iat: time.Now(), jti: uuid.NewString()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	claims := jwt.MapClaims{"htm": method, "htu": htu, "iat": o.iat.Unix(), "jti": o.jti}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	jwk, _ := PublicJWK(&k.private.PublicKey)
+	var header map[string]interface{}
+	data, _ := json.Marshal(jwk)
+	json.Unmarshal(data, &header)
+	for name, value := range o.extraJWK {
+		header[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = o.typ
+	token.Header["jwk"] = header
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestDPoPVerifier(t *testing.T) {
+	key := newDPoPKey(t)
+	const uri = "https://api.example.com/accounts"
+
+	tests := []struct {
+		name        string
+		proof       string
+		method      string
+		uri         string
+		accessToken string
+	}{
+		{"wrong method", key.proof(t, "GET", uri, ""), "POST", uri, ""},
+		{"wrong URL", key.proof(t, "GET", "https://api.example.com/other", ""), "GET", uri, ""},
+		{"wrong host", key.proof(t, "GET", "https://evil.example.com/accounts", ""), "GET", uri, ""},
+		{"too old", key.proof(t, "GET", uri, "", func(o *proofOptions) { o.iat = time.Now().Add(-5 * time.Minute) }), "GET", uri, ""},
+		{"from the future", key.proof(t, "GET", uri, "", func(o *proofOptions) { o.iat = time.Now().Add(5 * time.Minute) }), "GET", uri, ""},
+		{"wrong typ", key.proof(t, "GET", uri, "", func(o *proofOptions) { o.typ = "JWT" }), "GET", uri, ""},
+		{"private key in header", key.proof(t, "GET", uri, "", func(o *proofOptions) { o.extraJWK = map[string]interface{}{"d": "AAAA"} }), "GET", uri, ""},
+		{"no jti", key.proof(t, "GET", uri, "", func(o *proofOptions) { o.jti = "" }), "GET", uri, ""},
+		{"missing ath", key.proof(t, "GET", uri, ""), "GET", uri, "token"},
+		{"wrong ath", key.proof(t, "GET", uri, "other-token"), "GET", uri, "token"},
+		{"not a JWT", "garbage", "GET", uri, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewDPoPVerifier("")
+			if _, err := verifier.Verify(tt.proof, tt.method, tt.uri, tt.accessToken); !errors.Is(err, ErrInvalidDPoPProof) {
+				t.Errorf("Verify() error = %v, want %v", err, ErrInvalidDPoPProof)
+			}
+		})
+	}
+
+	verifier := NewDPoPVerifier("")
+	// Query and default port are not compared
+	proof := key.proof(t, "GET", "https://API.example.com:443/accounts?page=2", "token")
+	got, err := verifier.Verify(proof, "GET", uri, "token")
+	if err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if got.Thumbprint != key.thumbprint {
+		t.Errorf("Thumbprint = %q, want %q", got.Thumbprint, key.thumbprint)
+	}
+	if _, err := verifier.Verify(proof, "GET", uri, "token"); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Errorf("replayed proof error = %v, want %v", err, ErrInvalidDPoPProof)
+	}
+}
+
+func TestDPoPVerifierLiteral(t *testing.T) {
+	key := newDPoPKey(t)
+	const uri = "https://api.example.com/accounts"
+
+	// Without a replay cache no proof can be checked for reuse
+	if _, err := (&DPoPVerifier{}).Verify(key.proof(t, "GET", uri, ""), "GET", uri, ""); err == nil {
+		t.Error("Verify() without replay cache accepted the proof")
+	}
+
+	verifier := &DPoPVerifier{Replay: NewMemoryReplayCache()}
+	proof := key.proof(t, "GET", uri, "")
+	if _, err := verifier.Verify(proof, "GET", uri, ""); err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if _, err := verifier.Verify(proof, "GET", uri, ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Errorf("replayed proof error = %v, want %v", err, ErrInvalidDPoPProof)
+	}
+}
+
+func TestDPoPVerifyRequest(t *testing.T) {
+	key := newDPoPKey(t)
+	verifier := NewDPoPVerifier("https://auth.example.com/")
+
+	req := httptest.NewRequest(http.MethodPost, "/token", nil)
+	if _, err := verifier.VerifyRequest(req, ""); !errors.Is(err, ErrMissingDPoPProof) {
+		t.Errorf("no header error = %v, want %v", err, ErrMissingDPoPProof)
+	}
+
+	req.Header.Set(DPoPHeader, key.proof(t, "POST", "https://auth.example.com/token", ""))
+	if _, err := verifier.VerifyRequest(req, ""); err != nil {
+		t.Errorf("VerifyRequest() unexpected error: %v", err)
+	}
+
+	req.Header.Add(DPoPHeader, key.proof(t, "POST", "https://auth.example.com/token", ""))
+	if _, err := verifier.VerifyRequest(req, ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Errorf("two proofs error = %v, want %v", err, ErrInvalidDPoPProof)
+	}
+}
+
+func TestMiddlewareDPoP(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	key := newDPoPKey(t)
+	other := newDPoPKey(t)
+	bound, _ := client.GenerateToken("jdoe", WithDPoPKey(key.thumbprint))
+	bearer, _ := client.GenerateToken("jdoe")
+	const uri = "http://example.com/accounts"
+
+	tests := []struct {
+		name       string
+		config     MiddlewareConfig
+		auth       string
+		proof      string
+		wantStatus int
+		wantProof  bool
+	}{
+		{"bound token with proof", MiddlewareConfig{}, "DPoP " + bound, key.proof(t, "GET", uri, bound), http.StatusOK, false},
+		{"bound token as bearer", MiddlewareConfig{}, "Bearer " + bound, "", http.StatusUnauthorized, false},
+		{"bound token without proof", MiddlewareConfig{}, "DPoP " + bound, "", http.StatusUnauthorized, true},
+		{"proof by another key", MiddlewareConfig{}, "DPoP " + bound, other.proof(t, "GET", uri, bound), http.StatusUnauthorized, false},
+		{"proof for another token", MiddlewareConfig{}, "DPoP " + bound, key.proof(t, "GET", uri, bearer), http.StatusUnauthorized, true},
+		{"unbound token with proof", MiddlewareConfig{}, "DPoP " + bearer, key.proof(t, "GET", uri, bearer), http.StatusUnauthorized, false},
+		{"bearer token", MiddlewareConfig{}, "Bearer " + bearer, "", http.StatusOK, false},
+		{"bearer token when DPoP is required", MiddlewareConfig{RequireDPoP: true}, "Bearer " + bearer, "", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config.DPoP == nil {
+				tt.config.DPoP = NewDPoPVerifier("")
+			}
+			req := httptest.NewRequest(http.MethodGet, uri, nil)
+			req.Header.Set("Authorization", tt.auth)
+			if tt.proof != "" {
+				req.Header.Set(DPoPHeader, tt.proof)
+			}
+			w := httptest.NewRecorder()
+			client.Middleware(tt.config)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if w.Code == http.StatusUnauthorized && strings.Contains(challenge, "invalid_dpop_proof") != tt.wantProof {
+				t.Errorf("WWW-Authenticate = %q, invalid_dpop_proof expected: %v", challenge, tt.wantProof)
+			}
+		})
+	}
+
+	// Without DPoP enabled bound tokens are still refused as bearer tokens
+	req := httptest.NewRequest(http.MethodGet, uri, nil)
+	req.Header.Set("Authorization", "Bearer "+bound)
+	w := httptest.NewRecorder()
+	client.Middleware(MiddlewareConfig{})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("bound token without DPoP enabled: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
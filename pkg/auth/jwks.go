@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"time"
+)
+
+// DefaultJWKSMaxAge is the default upper bound on key set caching
+const DefaultJWKSMaxAge = time.Hour
+
+// JWKS returns the public keys verifiers need together with how long they
+// may cache them. The cache lifetime ends before the next scheduled key
+// change so verifiers pick up a new active key before it signs anything.
+// Legacy HS256 secrets are never published.
+func (c *Client) JWKS() (JWKSet, time.Duration, error) {
+	maxAge := c.config.JWKSMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultJWKSMaxAge
+	}
+
+	var keys []*SigningKey
+	switch {
+	case c.config.KeyRing != nil:
+		keys = c.config.KeyRing.Published()
+		if until, ok := c.config.KeyRing.UntilNextChange(); ok && until < maxAge {
+			maxAge = until
+		}
+	case c.config.SigningKey != nil:
+		keys = []*SigningKey{c.config.SigningKey}
+	}
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range keys {
+		if k.Symmetric() {
+			continue
+		}
+		jwk, err := k.JWK()
+		if err != nil {
+			return JWKSet{}, 0, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, maxAge, nil
+}
+
+// SigningAlgorithms lists the algorithms of the keys that may currently
+// sign or verify tokens
+func (c *Client) SigningAlgorithms() []string {
+	var keys []*SigningKey
+	switch {
+	case c.config.KeyRing != nil:
+		keys = c.config.KeyRing.Published()
+	case c.config.SigningKey != nil:
+		keys = []*SigningKey{c.config.SigningKey}
+	case len(c.config.JWTSecret) > 0:
+		return []string{AlgHS256}
+	}
+
+	var algs []string
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if !seen[k.Algorithm] {
+			seen[k.Algorithm] = true
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	return algs
+}
+
+// Issuer returns the configured issuer identifier
+func (c *Client) Issuer() string {
+	return c.config.Issuer
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func jwkIDs(set JWKSet) map[string]bool {
+	ids := make(map[string]bool)
+	for _, k := range set.Keys {
+		ids[k.Kid] = true
+	}
+	return ids
+}
+
+func TestJWKSPublishesUnretiredKeys(t *testing.T) {
+	now := time.Now()
+	ring, err := NewKeyRing(KeyRingConfig{RetirementPeriod: time.Hour},
+		&RingKey{Key: newTestEdKey(t, "retired"), ActivateAt: now.Add(-5 * time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "retiring"), ActivateAt: now.Add(-3 * time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "active"), ActivateAt: now.Add(-30 * time.Minute)},
+		&RingKey{Key: newTestEdKey(t, "pending")},
+		&RingKey{Key: newTestEdKey(t, "withdrawn"), ActivateAt: now.Add(-4 * time.Hour), Retired: true},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing() unexpected error: %v", err)
+	}
+	ring.now = func() time.Time { return now }
+
+	client := NewClient(Config{KeyRing: ring})
+	set, maxAge, err := client.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() unexpected error: %v", err)
+	}
+
+	ids := jwkIDs(set)
+	for _, kid := range []string{"retiring", "active", "pending"} {
+		if !ids[kid] {
+			t.Errorf("JWKS() missing key %q", kid)
+		}
+	}
+	for _, kid := range []string{"retired", "withdrawn"} {
+		if ids[kid] {
+			t.Errorf("JWKS() published %q", kid)
+		}
+	}
+	for _, k := range set.Keys {
+		if k.Use != "sig" || k.Alg != AlgEdDSA {
+			t.Errorf("key %q: use=%q alg=%q", k.Kid, k.Use, k.Alg)
+		}
+	}
+
+	// "retiring" retires 30 minutes from now, before the one hour default
+	if maxAge != 30*time.Minute {
+		t.Errorf("JWKS() max age = %v, want 30m", maxAge)
+	}
+}
+
+func TestJWKSMaxAgeFollowsScheduledRotation(t *testing.T) {
+	now := time.Now()
+	ring, err := NewKeyRing(KeyRingConfig{RotationInterval: 24 * time.Hour},
+		&RingKey{Key: newTestEdKey(t, "k1"), ActivateAt: now.Add(-(24*time.Hour - 10*time.Minute))},
+		&RingKey{Key: newTestEdKey(t, "k2")},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing() unexpected error: %v", err)
+	}
+	ring.now = func() time.Time { return now }
+
+	_, maxAge, err := NewClient(Config{KeyRing: ring}).JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() unexpected error: %v", err)
+	}
+	if maxAge != 10*time.Minute {
+		t.Errorf("JWKS() max age = %v, want 10m", maxAge)
+	}
+
+	_, maxAge, err = NewClient(Config{KeyRing: ring, JWKSMaxAge: time.Minute}).JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() unexpected error: %v", err)
+	}
+	if maxAge != time.Minute {
+		t.Errorf("JWKS() max age = %v, want configured 1m", maxAge)
+	}
+}
+
+func TestJWKSSingleKeyAndLegacySecret(t *testing.T) {
+	key := newTestEdKey(t, "only")
+	client := NewClient(Config{SigningKey: key})
+	set, maxAge, err := client.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() unexpected error: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "only" {
+		t.Errorf("JWKS() = %+v, want the configured key", set.Keys)
+	}
+	if maxAge != DefaultJWKSMaxAge {
+		t.Errorf("JWKS() max age = %v, want %v", maxAge, DefaultJWKSMaxAge)
+	}
+	if algs := client.SigningAlgorithms(); len(algs) != 1 || algs[0] != AlgEdDSA {
+		t.Errorf("SigningAlgorithms() = %v", algs)
+	}
+
+	legacy := NewClient(Config{JWTSecret: []byte("secret")})
+	set, _, err = legacy.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() unexpected error: %v", err)
+	}
+	if len(set.Keys) != 0 {
+		t.Errorf("JWKS() published the HS256 secret: %+v", set.Keys)
+	}
+	if algs := legacy.SigningAlgorithms(); len(algs) != 1 || algs[0] != AlgHS256 {
+		t.Errorf("SigningAlgorithms() = %v", algs)
+	}
+}
@@ -0,0 +1,481 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyState is the lifecycle state of a key in a KeyRing
+type KeyState string
+
+const (
+	// KeyPending keys are published for verification but not yet used for signing
+	KeyPending KeyState = "pending"
+	// KeyActive is the single key used for signing
+	KeyActive KeyState = "active"
+	// KeyRetiring keys no longer sign but still verify outstanding tokens
+	KeyRetiring KeyState = "retiring"
+	// KeyRetired keys are neither used nor accepted
+	KeyRetired KeyState = "retired"
+)
+
+// DefaultRetirementPeriod is how long a superseded key keeps verifying tokens
+const DefaultRetirementPeriod = 24 * time.Hour
+
+// KeyRingManifest is the file in a key directory that lists its keys
+const KeyRingManifest = "keyring.json"
+
+// ErrNoPendingKey is returned by Rotate when there is no key to promote
+var ErrNoPendingKey = errors.New("no pending key to promote")
+
+// KeyRingConfig controls rotation
+type KeyRingConfig struct {
+	// RetirementPeriod is how long a key keeps verifying tokens after it
+	// stopped signing. It must exceed the longest token lifetime.
+	RetirementPeriod time.Duration
+	// RotationInterval promotes the next pending key once the active key is
+	// this old. Zero disables scheduled rotation; Rotate still works.
+	RotationInterval time.Duration
+}
+
+// RingKey is a signing key with its schedule
+type RingKey struct {
+	Key *SigningKey
+	// ActivateAt is when the key starts signing. Zero leaves the key pending
+	// until it is promoted by Rotate.
+	ActivateAt time.Time
+	// Retired withdraws the key immediately, e.g. after a compromise
+	Retired bool
+
+	file string
+}
+
+// KeyStatus describes a key's current place in the rotation
+type KeyStatus struct {
+	ID         string
+	Algorithm  string
+	State      KeyState
+	ActivateAt time.Time
+}
+
+type manifestKey struct {
+	ID         string    `json:"kid"`
+	File       string    `json:"file"`
+	ActivateAt time.Time `json:"activate_at,omitempty"`
+	Retired    bool      `json:"retired,omitempty"`
+}
+
+type manifest struct {
+	Keys []manifestKey `json:"keys"`
+}
+
+// KeyRing holds several signing keys with overlapping validity. Exactly one
+// key is active and signs new tokens; tokens are accepted from any key that
+// is not retired, so outstanding tokens survive a rotation.
+//
+// States are derived from each key's ActivateAt: the most recently
+// activated key is active, earlier ones are retiring for RetirementPeriod
+// after they were superseded and retired afterwards, and keys not yet
+// activated are pending. It is safe for concurrent use.
+type KeyRing struct {
+	mu     sync.RWMutex
+	config KeyRingConfig
+	keys   []*RingKey
+	dir    string
+	now    func() time.Time
+}
+
+// NewKeyRing creates a key ring from keys held in memory
+func NewKeyRing(config KeyRingConfig, keys ...*RingKey) (*KeyRing, error) {
+	if config.RetirementPeriod <= 0 {
+		config.RetirementPeriod = DefaultRetirementPeriod
+	}
+
+	r := &KeyRing{config: config, now: time.Now}
+	if err := r.setKeys(keys); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadKeyRing loads the keys listed in dir/keyring.json. Each entry names a
+// PEM private key file relative to dir:
+//
+//	{"keys": [
+//	  {"kid": "2024-01", "file": "2024-01.pem", "activate_at": "2024-01-01T00:00:00Z"},
+//	  {"kid": "2024-02", "file": "2024-02.pem"}
+//	]}
+func LoadKeyRing(dir string, config KeyRingConfig) (*KeyRing, error) {
+	r, err := NewKeyRing(config)
+	if err != nil {
+		return nil, err
+	}
+	r.dir = dir
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the key directory. On error the current keys are kept.
+func (r *KeyRing) Reload() error {
+	if r.dir == "" {
+		return fmt.Errorf("key ring was not loaded from a directory")
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.dir, KeyRingManifest))
+	if err != nil {
+		return fmt.Errorf("failed to read key ring manifest: %w", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to parse key ring manifest: %w", err)
+	}
+
+	keys := make([]*RingKey, 0, len(m.Keys))
+	for _, mk := range m.Keys {
+		if mk.ID == "" || mk.File == "" {
+			return fmt.Errorf("key ring manifest entries need kid and file")
+		}
+		key, err := LoadSigningKey(filepath.Join(r.dir, mk.File), mk.ID)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, &RingKey{
+			Key:        key,
+			ActivateAt: mk.ActivateAt,
+			Retired:    mk.Retired,
+			file:       mk.File,
+		})
+	}
+
+	return r.setKeys(keys)
+}
+
+func (r *KeyRing) setKeys(keys []*RingKey) error {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Key == nil || k.Key.ID == "" {
+			return fmt.Errorf("key ring entries need a key with an ID")
+		}
+		if seen[k.Key.ID] {
+			return fmt.Errorf("duplicate key ID %q", k.Key.ID)
+		}
+		seen[k.Key.ID] = true
+	}
+
+	sorted := append([]*RingKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return activationOrder(sorted[i]).Before(activationOrder(sorted[j]))
+	})
+
+	r.mu.Lock()
+	r.keys = sorted
+	r.mu.Unlock()
+	return nil
+}
+
+// activationOrder sorts never-activated keys last
+func activationOrder(k *RingKey) time.Time {
+	if k.ActivateAt.IsZero() {
+		return time.Unix(1<<62, 0)
+	}
+	return k.ActivateAt
+}
+
+// Active returns the key that signs new tokens
+func (r *KeyRing) Active() (*SigningKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	for _, k := range r.keys {
+		if r.state(k, now) == KeyActive {
+			return k.Key, nil
+		}
+	}
+	return nil, fmt.Errorf("key ring has no active key")
+}
+
+// Lookup returns the key with the given ID if it may still verify tokens
+func (r *KeyRing) Lookup(kid string) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	for _, k := range r.keys {
+		if k.Key.ID == kid {
+			return k.Key, r.state(k, now) != KeyRetired
+		}
+	}
+	return nil, false
+}
+
+// Keys reports the current state of every key
+func (r *KeyRing) Keys() []KeyStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	out := make([]KeyStatus, 0, len(r.keys))
+	for _, k := range r.keys {
+		out = append(out, KeyStatus{
+			ID:         k.Key.ID,
+			Algorithm:  k.Key.Algorithm,
+			State:      r.state(k, now),
+			ActivateAt: k.ActivateAt,
+		})
+	}
+	return out
+}
+
+// Published returns the keys verifiers need: the active key, every retiring
+// key, and pending keys so verifiers have them before they start signing
+func (r *KeyRing) Published() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	var out []*SigningKey
+	for _, k := range r.keys {
+		if r.state(k, now) != KeyRetired {
+			out = append(out, k.Key)
+		}
+	}
+	return out
+}
+
+// UntilNextChange returns the time left until the next scheduled change of
+// key states: a pending key activating, a retiring key retiring, or a
+// scheduled rotation. It reports false when nothing is scheduled.
+func (r *KeyRing) UntilNextChange() (time.Duration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := r.now()
+	var next time.Time
+	consider := func(t time.Time) {
+		if t.After(now) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	for i, k := range r.keys {
+		if k.Retired || k.ActivateAt.IsZero() {
+			continue
+		}
+		consider(k.ActivateAt)
+
+		switch r.state(k, now) {
+		case KeyActive:
+			if r.config.RotationInterval > 0 {
+				consider(k.ActivateAt.Add(r.config.RotationInterval))
+			}
+		case KeyRetiring:
+			// Keys are sorted, so the successor is the next activated key
+			for _, succ := range r.keys[i+1:] {
+				if !succ.Retired && !succ.ActivateAt.IsZero() {
+					consider(succ.ActivateAt.Add(r.config.RetirementPeriod))
+					break
+				}
+			}
+		}
+	}
+
+	if next.IsZero() {
+		return 0, false
+	}
+	return next.Sub(now), true
+}
+
+// Rotate promotes the next pending key to active immediately. The
+// previously active key moves to retiring. When loaded from a directory the
+// new schedule is written back to the manifest so it survives a reload.
+func (r *KeyRing) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	var next *RingKey
+	for _, k := range r.keys {
+		if r.state(k, now) == KeyPending {
+			next = k
+			break
+		}
+	}
+	if next == nil {
+		return ErrNoPendingKey
+	}
+
+	next.ActivateAt = now
+	sort.SliceStable(r.keys, func(i, j int) bool {
+		return activationOrder(r.keys[i]).Before(activationOrder(r.keys[j]))
+	})
+
+	if r.dir != "" {
+		return r.writeManifest()
+	}
+	return nil
+}
+
+// Retire withdraws a key immediately, rejecting every token it signed.
+// Retiring the active key promotes the next pending key in its place; it
+// fails with ErrNoPendingKey when there is none, since an older key never
+// becomes active again.
+func (r *KeyRing) Retire(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for _, k := range r.keys {
+		if k.Key.ID != kid {
+			continue
+		}
+		if r.state(k, now) == KeyActive {
+			var next *RingKey
+			for _, other := range r.keys {
+				if r.state(other, now) == KeyPending {
+					next = other
+					break
+				}
+			}
+			if next == nil {
+				return fmt.Errorf("retire active key %q: %w", kid, ErrNoPendingKey)
+			}
+			next.ActivateAt = now
+			sort.SliceStable(r.keys, func(i, j int) bool {
+				return activationOrder(r.keys[i]).Before(activationOrder(r.keys[j]))
+			})
+		}
+		k.Retired = true
+		if r.dir != "" {
+			return r.writeManifest()
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown key ID %q", kid)
+}
+
+// RotateIfDue promotes the next pending key when the active key is older
+// than RotationInterval. It reports whether a rotation happened.
+func (r *KeyRing) RotateIfDue() (bool, error) {
+	if r.config.RotationInterval <= 0 {
+		return false, nil
+	}
+
+	r.mu.RLock()
+	now := r.now()
+	due := false
+	for _, k := range r.keys {
+		if r.state(k, now) == KeyActive {
+			due = !now.Before(k.ActivateAt.Add(r.config.RotationInterval))
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if !due {
+		return false, nil
+	}
+	if err := r.Rotate(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Start reloads the key directory (when there is one) and applies scheduled
+// rotation every interval until ctx is cancelled. Errors are reported to
+// logger, which may be nil.
+func (r *KeyRing) Start(ctx context.Context, interval time.Duration, logger Logger) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.tick(logger)
+			}
+		}
+	}()
+}
+
+func (r *KeyRing) tick(logger Logger) {
+	if r.dir != "" {
+		if err := r.Reload(); err != nil && logger != nil {
+			logger.Info("Key ring reload failed", "error", err)
+		}
+	}
+
+	rotated, err := r.RotateIfDue()
+	if logger == nil {
+		return
+	}
+	if err != nil {
+		logger.Info("Scheduled key rotation failed", "error", err)
+	} else if rotated {
+		logger.Info("Signing key rotated")
+	}
+}
+
+// state must be called with r.mu held
+func (r *KeyRing) state(k *RingKey, now time.Time) KeyState {
+	if k.Retired {
+		return KeyRetired
+	}
+	if k.ActivateAt.IsZero() || now.Before(k.ActivateAt) {
+		return KeyPending
+	}
+
+	// The key has been activated; find the key that superseded it. Retired
+	// keys count too, so withdrawing a key never revives an older one.
+	for _, other := range r.keys {
+		if other == k || other.ActivateAt.IsZero() {
+			continue
+		}
+		if other.ActivateAt.After(k.ActivateAt) && !now.Before(other.ActivateAt) {
+			if now.Before(other.ActivateAt.Add(r.config.RetirementPeriod)) {
+				return KeyRetiring
+			}
+			return KeyRetired
+		}
+	}
+	return KeyActive
+}
+
+// writeManifest must be called with r.mu held
+func (r *KeyRing) writeManifest() error {
+	var m manifest
+	for _, k := range r.keys {
+		m.Keys = append(m.Keys, manifestKey{
+			ID:         k.Key.ID,
+			File:       k.file,
+			ActivateAt: k.ActivateAt,
+			Retired:    k.Retired,
+		})
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.dir, KeyRingManifest)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write key ring manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write key ring manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestEdKey(t *testing.T, kid string) *SigningKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key, err := NewSigningKey(priv, kid)
+	if err != nil {
+		t.Fatalf("NewSigningKey() unexpected error: %v", err)
+	}
+	return key
+}
+
+func keyStates(r *KeyRing) map[string]KeyState {
+	states := make(map[string]KeyState)
+	for _, k := range r.Keys() {
+		states[k.ID] = k.State
+	}
+	return states
+}
+
+func TestKeyRingStates(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ring, err := NewKeyRing(KeyRingConfig{RetirementPeriod: time.Hour},
+		&RingKey{Key: newTestEdKey(t, "k1"), ActivateAt: start},
+		&RingKey{Key: newTestEdKey(t, "k2"), ActivateAt: start.Add(24 * time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "k3")},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want map[string]KeyState
+	}{
+		{
+			name: "before first activation",
+			at:   start.Add(-time.Minute),
+			want: map[string]KeyState{"k1": KeyPending, "k2": KeyPending, "k3": KeyPending},
+		},
+		{
+			name: "first key active",
+			at:   start.Add(time.Hour),
+			want: map[string]KeyState{"k1": KeyActive, "k2": KeyPending, "k3": KeyPending},
+		},
+		{
+			name: "overlap after scheduled promotion",
+			at:   start.Add(24*time.Hour + 30*time.Minute),
+			want: map[string]KeyState{"k1": KeyRetiring, "k2": KeyActive, "k3": KeyPending},
+		},
+		{
+			name: "retired after retirement period",
+			at:   start.Add(25 * time.Hour),
+			want: map[string]KeyState{"k1": KeyRetired, "k2": KeyActive, "k3": KeyPending},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring.now = func() time.Time { return tt.at }
+			got := keyStates(ring)
+			for kid, want := range tt.want {
+				if got[kid] != want {
+					t.Errorf("state of %s = %v, want %v", kid, got[kid], want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyRingRotationKeepsOutstandingTokensValid(t *testing.T) {
+	now := time.Now()
+	ring, err := NewKeyRing(KeyRingConfig{RetirementPeriod: time.Hour},
+		&RingKey{Key: newTestEdKey(t, "old"), ActivateAt: now.Add(-time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "new")},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing() unexpected error: %v", err)
+	}
+	ring.now = func() time.Time { return now }
+
+	client := NewClient(Config{KeyRing: ring, TokenDuration: 24 * time.Hour})
+	oldToken, err := client.GenerateToken("test-user")
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+
+	if err := ring.Rotate(); err != nil {
+		t.Fatalf("Rotate() unexpected error: %v", err)
+	}
+	if active, _ := ring.Active(); active.ID != "new" {
+		t.Errorf("active key = %v, want new", active.ID)
+	}
+	if err := ring.Rotate(); !errors.Is(err, ErrNoPendingKey) {
+		t.Errorf("second Rotate() error = %v, want ErrNoPendingKey", err)
+	}
+
+	newToken, _ := client.GenerateToken("test-user")
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := client.ValidateToken(token); err != nil {
+			t.Errorf("%s token rejected during overlap: %v", name, err)
+		}
+	}
+
+	ring.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := client.ValidateToken(oldToken); err == nil {
+		t.Error("token from retired key should be rejected")
+	}
+	if _, err := client.ValidateToken(newToken); err != nil {
+		t.Errorf("token from active key rejected: %v", err)
+	}
+}
+
+func TestKeyRingRotateIfDue(t *testing.T) {
+	now := time.Now()
+	ring, _ := NewKeyRing(KeyRingConfig{RotationInterval: 24 * time.Hour},
+		&RingKey{Key: newTestEdKey(t, "k1"), ActivateAt: now.Add(-23 * time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "k2")},
+	)
+	ring.now = func() time.Time { return now }
+
+	if rotated, err := ring.RotateIfDue(); rotated || err != nil {
+		t.Fatalf("RotateIfDue() = %v, %v before the interval elapsed", rotated, err)
+	}
+
+	ring.now = func() time.Time { return now.Add(time.Hour) }
+	if rotated, err := ring.RotateIfDue(); !rotated || err != nil {
+		t.Fatalf("RotateIfDue() = %v, %v, want rotation", rotated, err)
+	}
+	if active, _ := ring.Active(); active.ID != "k2" {
+		t.Errorf("active key = %v, want k2", active.ID)
+	}
+}
+
+func TestKeyRingRetire(t *testing.T) {
+	ring, _ := NewKeyRing(KeyRingConfig{},
+		&RingKey{Key: newTestEdKey(t, "k1"), ActivateAt: time.Now().Add(-time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "k2")},
+	)
+	client := NewClient(Config{KeyRing: ring, TokenDuration: time.Hour})
+	token, _ := client.GenerateToken("test-user")
+
+	if err := ring.Retire("k1"); err != nil {
+		t.Fatalf("Retire() unexpected error: %v", err)
+	}
+	if _, err := client.ValidateToken(token); err == nil {
+		t.Error("token from a retired key should be rejected")
+	}
+	if active, _ := ring.Active(); active == nil || active.ID != "k2" {
+		t.Errorf("active key = %v, want k2 promoted", active)
+	}
+
+	// The last key cannot be retired without a replacement
+	if err := ring.Retire("k2"); !errors.Is(err, ErrNoPendingKey) {
+		t.Errorf("Retire() of the last key error = %v, want ErrNoPendingKey", err)
+	}
+	if _, err := client.GenerateToken("test-user"); err != nil {
+		t.Errorf("GenerateToken() after refused Retire() unexpected error: %v", err)
+	}
+}
+
+func TestKeyRingRetireDoesNotReviveOlderKeys(t *testing.T) {
+	now := time.Now()
+	ring, _ := NewKeyRing(KeyRingConfig{RetirementPeriod: time.Hour},
+		&RingKey{Key: newTestEdKey(t, "k1"), ActivateAt: now.Add(-100 * time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "k2"), ActivateAt: now.Add(-50 * time.Hour)},
+	)
+	ring.now = func() time.Time { return now }
+
+	if err := ring.Retire("k2"); !errors.Is(err, ErrNoPendingKey) {
+		t.Fatalf("Retire() of the active key error = %v, want ErrNoPendingKey", err)
+	}
+	if active, _ := ring.Active(); active == nil || active.ID != "k2" {
+		t.Errorf("active key = %v, want k2", active)
+	}
+
+	// A key withdrawn in the manifest still supersedes the keys before it
+	ring, _ = NewKeyRing(KeyRingConfig{RetirementPeriod: time.Hour},
+		&RingKey{Key: newTestEdKey(t, "k1"), ActivateAt: now.Add(-100 * time.Hour)},
+		&RingKey{Key: newTestEdKey(t, "k2"), ActivateAt: now.Add(-50 * time.Hour), Retired: true},
+	)
+	ring.now = func() time.Time { return now }
+	if active, err := ring.Active(); err == nil {
+		t.Errorf("Active() = %v, want no active key", active.ID)
+	}
+	if got := keyStates(ring)["k1"]; got != KeyRetired {
+		t.Errorf("state of k1 = %v, want %v", got, KeyRetired)
+	}
+}
+
+func TestNewKeyRingDuplicateID(t *testing.T) {
+	_, err := NewKeyRing(KeyRingConfig{},
+		&RingKey{Key: newTestEdKey(t, "k1")},
+		&RingKey{Key: newTestEdKey(t, "k1")},
+	)
+	if err == nil {
+		t.Error("NewKeyRing() expected error for duplicate key IDs")
+	}
+}
+
+func writeKeyFile(t *testing.T, dir, name string) {
+	t.Helper()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+func writeManifest(t *testing.T, dir string, m manifest) {
+	t.Helper()
+	data, _ := json.Marshal(m)
+	if err := os.WriteFile(filepath.Join(dir, KeyRingManifest), data, 0o600); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+}
+
+func TestLoadKeyRingAndReload(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "k1.pem")
+	writeManifest(t, dir, manifest{Keys: []manifestKey{
+		{ID: "k1", File: "k1.pem", ActivateAt: time.Now().Add(-time.Hour)},
+	}})
+
+	ring, err := LoadKeyRing(dir, KeyRingConfig{})
+	if err != nil {
+		t.Fatalf("LoadKeyRing() unexpected error: %v", err)
+	}
+	if active, err := ring.Active(); err != nil || active.ID != "k1" {
+		t.Fatalf("Active() = %v, %v", active, err)
+	}
+
+	// An operator drops in a new pending key without restarting
+	writeKeyFile(t, dir, "k2.pem")
+	writeManifest(t, dir, manifest{Keys: []manifestKey{
+		{ID: "k1", File: "k1.pem", ActivateAt: time.Now().Add(-time.Hour)},
+		{ID: "k2", File: "k2.pem"},
+	}})
+	if err := ring.Reload(); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if got := keyStates(ring)["k2"]; got != KeyPending {
+		t.Errorf("k2 state = %v, want pending", got)
+	}
+
+	// Rotation is persisted so the next reload keeps it
+	if err := ring.Rotate(); err != nil {
+		t.Fatalf("Rotate() unexpected error: %v", err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if active, _ := ring.Active(); active.ID != "k2" {
+		t.Errorf("active key after reload = %v, want k2", active.ID)
+	}
+
+	// A broken manifest leaves the loaded keys in place
+	os.WriteFile(filepath.Join(dir, KeyRingManifest), []byte("{"), 0o600)
+	if err := ring.Reload(); err == nil {
+		t.Error("Reload() expected error for broken manifest")
+	}
+	if active, _ := ring.Active(); active == nil || active.ID != "k2" {
+		t.Error("keys should be kept after a failed reload")
+	}
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms supported for SigningKey
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+// legacyKeyID is the kid of the HS256 key built from Config.JWTSecret
+const legacyKeyID = "legacy-hs256"
+
+// minRSABits is the smallest RSA modulus accepted for signing
+const minRSABits = 2048
+
+// SigningKey is a private key used to sign tokens
+type SigningKey struct {
+	// ID is written to the kid header of every token signed with this key
+	ID        string
+	Algorithm string
+	// Key is an *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey or,
+	// for legacy HS256, a []byte secret
+	Key interface{}
+}
+
+// NewSigningKey wraps a private key, deriving the algorithm from the key
+// type. When kid is empty it defaults to the RFC 7638 thumbprint of the
+// public key.
+func NewSigningKey(key interface{}, kid string) (*SigningKey, error) {
+	var alg string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key too small: %d bits, need at least %d", k.N.BitLen(), minRSABits)
+		}
+		alg = AlgRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = AlgES256
+		case elliptic.P384():
+			alg = AlgES384
+		case elliptic.P521():
+			alg = AlgES512
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve")
+		}
+	case ed25519.PrivateKey:
+		alg = AlgEdDSA
+	case []byte:
+		if kid == "" {
+			return nil, fmt.Errorf("HS256 keys require an explicit key ID")
+		}
+		if len(k) == 0 {
+			return nil, fmt.Errorf("empty HS256 secret")
+		}
+		alg = AlgHS256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	sk := &SigningKey{ID: kid, Algorithm: alg, Key: key}
+	if sk.ID == "" {
+		jwk, err := sk.JWK()
+		if err != nil {
+			return nil, err
+		}
+		if sk.ID, err = jwk.Thumbprint(); err != nil {
+			return nil, err
+		}
+	}
+	return sk, nil
+}
+
+// ParsePrivateKeyPEM decodes a PEM encoded PKCS#8, PKCS#1 (RSA) or SEC 1
+// (EC) private key
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS#8 key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported PKCS#8 key type %T", key)
+		}
+		return signer, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// LoadSigningKey reads a PEM private key from path. See NewSigningKey for kid.
+func LoadSigningKey(path, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	signer, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return NewSigningKey(signer, kid)
+}
+
+// Public returns the verification key: the public half of an asymmetric
+// key, or the secret itself for HS256
+func (k *SigningKey) Public() interface{} {
+	if signer, ok := k.Key.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return k.Key
+}
+
+// Symmetric reports whether the key is a shared HS256 secret
+func (k *SigningKey) Symmetric() bool {
+	return k.Algorithm == AlgHS256
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// sign creates a signed token carrying the key's kid
+func (k *SigningKey) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.method(), claims)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.Key)
+}
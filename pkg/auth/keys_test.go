@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Generated once; RSA key generation is slow
+var testRSAKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+func writePKCS8(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		key     interface{}
+		wantAlg string
+	}{
+		{"RSA", testRSAKey, AlgRS256},
+		{"ECDSA P-256", ecKey, AlgES256},
+		{"Ed25519", edKey, AlgEdDSA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := LoadSigningKey(writePKCS8(t, tt.key), "")
+			if err != nil {
+				t.Fatalf("LoadSigningKey() unexpected error: %v", err)
+			}
+			if key.Algorithm != tt.wantAlg {
+				t.Errorf("Algorithm = %v, want %v", key.Algorithm, tt.wantAlg)
+			}
+			if key.ID == "" {
+				t.Error("expected thumbprint key ID")
+			}
+
+			client := NewClient(Config{SigningKey: key, TokenDuration: time.Hour})
+			token, err := client.GenerateToken("test-user")
+			if err != nil {
+				t.Fatalf("GenerateToken() unexpected error: %v", err)
+			}
+
+			parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+				return key.Public(), nil
+			}, jwt.WithValidMethods([]string{tt.wantAlg}))
+			if err != nil {
+				t.Fatalf("token does not verify with the public key: %v", err)
+			}
+			if parsed.Header["kid"] != key.ID {
+				t.Errorf("kid = %v, want %v", parsed.Header["kid"], key.ID)
+			}
+
+			if claims, err := client.ValidateToken(token); err != nil || claims.UserID() != "test-user" {
+				t.Errorf("ValidateToken() = %v, %v", claims, err)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRejectsLegacyWhenAsymmetric(t *testing.T) {
+	key, err := NewSigningKey(testRSAKey, "rsa-1")
+	if err != nil {
+		t.Fatalf("NewSigningKey() unexpected error: %v", err)
+	}
+	client := NewClient(Config{SigningKey: key, JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+
+	legacy := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	token, _ := legacy.GenerateToken("test-user")
+
+	if _, err := client.ValidateToken(token); err == nil {
+		t.Error("ValidateToken() must reject HS256 tokens once a signing key is configured")
+	}
+}
+
+func TestLegacyTokenHasKeyID(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	token, _ := client.GenerateToken("test-user")
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if parsed.Header["kid"] != legacyKeyID || parsed.Method.Alg() != AlgHS256 {
+		t.Errorf("header = %v, want HS256 with kid %s", parsed.Header, legacyKeyID)
+	}
+}
+
+func TestNewSigningKeyValidation(t *testing.T) {
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+
+	tests := []struct {
+		name string
+		key  interface{}
+		kid  string
+	}{
+		{"small RSA key", small, ""},
+		{"unsupported curve", p224, ""},
+		{"HS256 without kid", []byte("secret"), ""},
+		{"empty HS256 secret", []byte{}, "k1"},
+		{"unsupported type", "not a key", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSigningKey(tt.key, tt.kid); err == nil {
+				t.Error("NewSigningKey() expected error")
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		wantErr bool
+	}{
+		{"PKCS#1 RSA", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testRSAKey)}, false},
+		{"SEC 1 EC", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, false},
+		{"certificate", &pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}}, true},
+		{"garbage PKCS#8", &pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1, 2, 3}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePrivateKeyPEM(pem.EncodeToMemory(tt.block))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePrivateKeyPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := ParsePrivateKeyPEM([]byte("not pem")); err == nil {
+		t.Error("ParsePrivateKeyPEM() expected error for non-PEM input")
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Kid: "ignored",
+		Alg: "RS256",
+	}
+
+	got, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint() unexpected error: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %v, want %v", got, want)
+	}
+}
+
+func TestJWKPublicKeyRoundTrip(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, pub := range []interface{}{&testRSAKey.PublicKey, &ecKey.PublicKey, edPub} {
+		jwk, err := PublicJWK(pub)
+		if err != nil {
+			t.Fatalf("PublicJWK(%T) error = %v", pub, err)
+		}
+		got, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("PublicKey(%s) error = %v", jwk.Kty, err)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+			t.Errorf("PublicKey(%s) = %v, want %v", jwk.Kty, got, pub)
+		}
+	}
+
+	invalid := []JWK{
+		{Kty: "oct"},
+		{Kty: "RSA", N: "AQAB", E: "AAAA"},
+		{Kty: "EC", Crv: "P-256", X: "AQAB", Y: "AQAB"},
+		{Kty: "OKP", Crv: "X25519", X: "AQAB"},
+	}
+	for _, jwk := range invalid {
+		if _, err := jwk.PublicKey(); err == nil {
+			t.Errorf("PublicKey(%+v) expected error", jwk)
+		}
+	}
+}
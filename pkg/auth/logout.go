@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultLogoutRedirect is where browsers go after logout when no
+// post_logout_redirect_uri was requested
+const DefaultLogoutRedirect = "/login"
+
+// ErrInvalidPostLogoutRedirect is returned by Logout for redirect URIs the
+// client has not registered
+var ErrInvalidPostLogoutRedirect = errors.New("post_logout_redirect_uri not allowed")
+
+// PostLogoutRedirectPolicy decides where a client may send browsers after logout
+type PostLogoutRedirectPolicy interface {
+	AllowedPostLogoutRedirect(clientID, uri string) bool
+}
+
+// StaticPostLogoutRedirects maps client IDs to their allowed post-logout
+// redirect URIs. URIs must match exactly.
+type StaticPostLogoutRedirects map[string][]string
+
+func (s StaticPostLogoutRedirects) AllowedPostLogoutRedirect(clientID, uri string) bool {
+	for _, allowed := range s[clientID] {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// LogoutConfig controls Logout
+type LogoutConfig struct {
+	// RedirectPolicy allows post_logout_redirect_uri values per client.
+	// Without it every requested redirect is rejected.
+	RedirectPolicy PostLogoutRedirectPolicy
+	// DefaultRedirect defaults to DefaultLogoutRedirect
+	DefaultRedirect string
+	// TokenCookie names a cookie carrying the access token, as in
+	// MiddlewareConfig. Its token is revoked and the cookie cleared.
+	TokenCookie string
+}
+
+// LogoutResponse is the answer to API clients
+type LogoutResponse struct {
+	LoggedOut bool `json:"logged_out"`
+}
+
+// Logout ends the caller's login: it revokes the presented access token,
+// every token of its session and the refresh token in the "refresh_token"
+// parameter, destroys the server-side session through Config.SessionManager
+// and audits the event.
+//
+// API clients, which send Accept: application/json or a bearer token, get a
+// JSON LogoutResponse. Browsers are redirected to post_logout_redirect_uri,
+// with state appended, if client_id has registered it, or to
+// LogoutConfig.DefaultRedirect otherwise. Unregistered redirect URIs are
+// rejected with 400 before anything is revoked.
+//
+// The response is written in every case; a returned error describes what
+// failed.
+func (c *Client) Logout(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	jsonResponse := wantsJSON(r)
+
+	if err := r.ParseForm(); err != nil {
+		respondLogoutError(w, jsonResponse, http.StatusBadRequest, "invalid request")
+		return fmt.Errorf("invalid logout request: %w", err)
+	}
+	clientID := r.Form.Get("client_id")
+	redirect := r.Form.Get("post_logout_redirect_uri")
+	if redirect != "" && !c.allowedPostLogoutRedirect(clientID, redirect) {
+		c.audit("Logout redirect rejected", "clientID", clientID, "redirect", redirect)
+		respondLogoutError(w, jsonResponse, http.StatusBadRequest, "invalid post_logout_redirect_uri")
+		return fmt.Errorf("%w: %q", ErrInvalidPostLogoutRedirect, redirect)
+	}
+
+	token, claims := c.presentedToken(r)
+
+	var userID string
+	var sessionIDs []string
+	if claims != nil {
+		userID = claims.UserID()
+		if claims.SessionID != "" {
+			sessionIDs = append(sessionIDs, claims.SessionID)
+		}
+	}
+	if session, ok := SessionFromContext(ctx); ok {
+		if userID == "" {
+			userID = session.UserID
+		}
+		if len(sessionIDs) == 0 || sessionIDs[0] != session.ID {
+			sessionIDs = append(sessionIDs, session.ID)
+		}
+	}
+
+	var errs []error
+	if claims != nil && c.config.RevocationStore != nil {
+		if err := c.RevokeAccessToken(token); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, sid := range sessionIDs {
+		if err := c.endSessionTokens(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if refresh := r.Form.Get("refresh_token"); refresh != "" && c.config.RefreshStore != nil {
+		if err := c.RevokeRefreshToken(refresh); err != nil && !errors.Is(err, ErrInvalidRefreshToken) {
+			errs = append(errs, err)
+		}
+	}
+	if c.config.SessionManager != nil {
+		if err := c.config.SessionManager.Destroy(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to destroy session: %w", err))
+		}
+	}
+	if name := c.config.Logout.TokenCookie; name != "" {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, Secure: true, HttpOnly: true})
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		c.audit("Logout incomplete", "userID", userID, "sessions", sessionIDs, "clientID", clientID, "error", err)
+		respondLogoutError(w, jsonResponse, http.StatusInternalServerError, "logout failed")
+		return err
+	}
+	c.audit("User logged out", "userID", userID, "sessions", sessionIDs, "clientID", clientID)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if jsonResponse {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(LogoutResponse{LoggedOut: true})
+	}
+
+	target := c.config.Logout.DefaultRedirect
+	if target == "" {
+		target = DefaultLogoutRedirect
+	}
+	if redirect != "" {
+		target = withState(redirect, r.Form.Get("state"))
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+	return nil
+}
+
+// presentedToken returns the caller's access token and its claims. Claims
+// are nil when there is no valid token.
+func (c *Client) presentedToken(r *http.Request) (string, *Claims) {
+	token := BearerToken(r)
+	if token == "" && c.config.Logout.TokenCookie != "" {
+		if cookie, err := r.Cookie(c.config.Logout.TokenCookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return "", nil
+	}
+
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		return token, claims
+	}
+	claims, err := c.ValidateToken(token, WithAnyAudience())
+	if err != nil {
+		// Expired or already revoked tokens need no revocation
+		return token, nil
+	}
+	return token, claims
+}
+
+// endSessionTokens revokes the access and refresh tokens issued for a session
+func (c *Client) endSessionTokens(sid string) error {
+	if c.config.RevocationStore != nil {
+		// Tokens issued for the session expire within the longest lifetime
+		// they can have, which WithLifetime may make longer than TokenDuration
+		until := time.Now().Add(c.maxTokenLifetime() + c.clockSkew())
+		return c.RevokeSessionTokens(sid, until)
+	}
+	if c.config.RefreshStore != nil {
+		if err := c.config.RefreshStore.RevokeSession(sid); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) allowedPostLogoutRedirect(clientID, uri string) bool {
+	policy := c.config.Logout.RedirectPolicy
+	return clientID != "" && policy != nil && policy.AllowedPostLogoutRedirect(clientID, uri)
+}
+
+// audit records security relevant events
+func (c *Client) audit(msg string, keyvals ...interface{}) {
+	if c.config.Logger != nil {
+		c.config.Logger.Info(msg, append([]interface{}{"audit", true}, keyvals...)...)
+	}
+}
+
+// wantsJSON reports whether the caller is an API client rather than a browser
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.Contains(accept, "application/json") || BearerToken(r) != ""
+}
+
+func respondLogoutError(w http.ResponseWriter, jsonResponse bool, status int, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !jsonResponse {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{message})
+}
+
+// withState appends the state parameter to a registered redirect URI
+func withState(redirect, state string) string {
+	if state == "" {
+		return redirect
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return redirect
+	}
+	q := u.Query()
+	q.Set("state", state)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
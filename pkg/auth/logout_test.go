@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLogoutTestClient(sessions SessionManager, logger Logger) *Client {
+	return NewClient(Config{
+		JWTSecret:       []byte("test-secret"),
+		TokenDuration:   time.Hour,
+		SessionManager:  sessions,
+		Logger:          logger,
+		RevocationStore: NewMemoryRevocationStore(),
+		RefreshStore:    NewMemoryRefreshStore(),
+		Logout: LogoutConfig{
+			RedirectPolicy: StaticPostLogoutRedirects{
+				"portal": {"https://portal.example.com/bye"},
+			},
+		},
+	})
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	sessions := newMockSessionManager()
+	logger := newMockLogger()
+	client := newLogoutTestClient(sessions, logger)
+
+	access, _ := client.GenerateToken("jdoe", WithSessionID("s1"))
+	sibling, _ := client.GenerateToken("jdoe", WithSessionID("s1"))
+	unrelated, _ := client.GenerateToken("jdoe", WithSessionID("s2"))
+	refresh, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe", SessionID: "s1"})
+	presentedRefresh, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+
+	form := url.Values{"refresh_token": {presentedRefresh}}
+	r := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer "+access)
+	w := httptest.NewRecorder()
+
+	if err := client.Logout(w, r); err != nil {
+		t.Fatalf("Logout() unexpected error: %v", err)
+	}
+
+	// Bearer callers are API clients
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("response = %d %s, want JSON 200", w.Code, w.Header().Get("Content-Type"))
+	}
+	var response LogoutResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || !response.LoggedOut {
+		t.Errorf("response = %+v, %v", response, err)
+	}
+
+	for name, token := range map[string]string{"presented": access, "same session": sibling} {
+		if _, err := client.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("%s token: error = %v, want ErrTokenRevoked", name, err)
+		}
+	}
+	if _, err := client.ValidateToken(unrelated); err != nil {
+		t.Errorf("token of another session rejected: %v", err)
+	}
+	for name, token := range map[string]string{"session": refresh, "presented": presentedRefresh} {
+		if _, _, err := client.RotateRefreshToken(token, nil); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("%s refresh token: error = %v, want ErrInvalidRefreshToken", name, err)
+		}
+	}
+
+	if !sessions.destroyed {
+		t.Error("session was not destroyed")
+	}
+	if len(logger.logs) == 0 || !strings.HasPrefix(logger.logs[len(logger.logs)-1], "User logged out audit=true userID=jdoe") {
+		t.Errorf("expected audit entry, got %v", logger.logs)
+	}
+}
+
+func TestLogoutBrowserRedirects(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        url.Values
+		wantStatus   int
+		wantLocation string
+		wantErr      error
+	}{
+		{
+			name:         "default redirect",
+			wantStatus:   http.StatusFound,
+			wantLocation: "/login",
+		},
+		{
+			name: "registered redirect with state",
+			query: url.Values{
+				"client_id":                {"portal"},
+				"post_logout_redirect_uri": {"https://portal.example.com/bye"},
+				"state":                    {"xyz"},
+			},
+			wantStatus:   http.StatusFound,
+			wantLocation: "https://portal.example.com/bye?state=xyz",
+		},
+		{
+			name: "unregistered redirect",
+			query: url.Values{
+				"client_id":                {"portal"},
+				"post_logout_redirect_uri": {"https://evil.example.com/"},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrInvalidPostLogoutRedirect,
+		},
+		{
+			name: "redirect without client",
+			query: url.Values{
+				"post_logout_redirect_uri": {"https://portal.example.com/bye"},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrInvalidPostLogoutRedirect,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := newMockSessionManager()
+			client := newLogoutTestClient(sessions, newMockLogger())
+
+			r := httptest.NewRequest(http.MethodGet, "/logout?"+tt.query.Encode(), nil)
+			r.Header.Set("Accept", "text/html,application/xhtml+xml")
+			w := httptest.NewRecorder()
+
+			err := client.Logout(w, r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Logout() error = %v, want %v", err, tt.wantErr)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if destroyed := tt.wantErr == nil; sessions.destroyed != destroyed {
+				t.Errorf("session destroyed = %v, want %v", sessions.destroyed, destroyed)
+			}
+		})
+	}
+}
+
+func TestLogoutEndsServerSession(t *testing.T) {
+	store := NewMemorySessionStore()
+	sessions := NewSessions(SessionConfig{Store: store})
+	client := newLogoutTestClient(sessions, newMockLogger())
+
+	var sid string
+	login := httptest.NewRecorder()
+	sessions.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid, _ = sessions.Login(r.Context(), "jdoe")
+	})).ServeHTTP(login, httptest.NewRequest(http.MethodPost, "/auth", nil))
+	cookie := login.Result().Cookies()[0]
+	token, _ := client.GenerateToken("jdoe", WithSessionID(sid))
+
+	// A browser logs out with only its session cookie
+	r := httptest.NewRequest(http.MethodGet, "/logout", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	var logoutErr error
+	sessions.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logoutErr = client.Logout(w, r)
+	})).ServeHTTP(w, r)
+
+	if logoutErr != nil {
+		t.Fatalf("Logout() unexpected error: %v", logoutErr)
+	}
+	if w.Code != http.StatusFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if list, _ := sessions.UserSessions("jdoe"); len(list) != 0 {
+		t.Errorf("user still has %d sessions", len(list))
+	}
+	if _, err := client.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token of the ended session: error = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestLogoutOutlastsLongLivedTokens(t *testing.T) {
+	logout := func(client *Client, sid string) time.Time {
+		t.Helper()
+		token, _ := client.GenerateToken("jdoe", WithSessionID(sid))
+		r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if err := client.Logout(httptest.NewRecorder(), r); err != nil {
+			t.Fatalf("Logout() unexpected error: %v", err)
+		}
+		entry, _ := client.config.RevocationStore.Lookup(RevokeSession, sid)
+		if entry == nil {
+			t.Fatal("session revocation missing")
+		}
+		return entry.Expires
+	}
+
+	// A token issued with a longer lifetime than TokenDuration stays revoked
+	client := newLogoutTestClient(newMockSessionManager(), newMockLogger())
+	long, _ := client.GenerateToken("jdoe", WithSessionID("s1"), WithLifetime(8*time.Hour))
+	if until := logout(client, "s1"); until.Before(time.Now().Add(8 * time.Hour)) {
+		t.Errorf("revocation expires at %v, before the 8h token", until)
+	}
+	store := client.config.RevocationStore.(*MemoryRevocationStore)
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := client.ValidateToken(long); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("long-lived token after TokenDuration: error = %v, want ErrTokenRevoked", err)
+	}
+
+	// Lifetimes granted by other instances come from MaxTokenLifetime
+	client = newLogoutTestClient(newMockSessionManager(), newMockLogger())
+	client.config.MaxTokenLifetime = 12 * time.Hour
+	if until := logout(client, "s2"); until.Before(time.Now().Add(12 * time.Hour)) {
+		t.Errorf("revocation expires at %v, before MaxTokenLifetime", until)
+	}
+}
+
+type failingRevocationStore struct{ *MemoryRevocationStore }
+
+func (failingRevocationStore) Revoke(Revocation) error { return errors.New("disk full") }
+
+func TestLogoutReportsRevocationFailure(t *testing.T) {
+	logger := newMockLogger()
+	client := newLogoutTestClient(newMockSessionManager(), logger)
+	client.config.RevocationStore = failingRevocationStore{NewMemoryRevocationStore()}
+
+	token, _ := client.GenerateToken("jdoe")
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	if err := client.Logout(w, r); err == nil {
+		t.Fatal("Logout() expected error")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !strings.HasPrefix(logger.logs[len(logger.logs)-1], "Logout incomplete") {
+		t.Errorf("expected audit entry, got %v", logger.logs)
+	}
+}
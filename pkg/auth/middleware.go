@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	sessionKey
+)
+
+// MiddlewareConfig controls how Middleware finds and checks tokens
+type MiddlewareConfig struct {
+	// CookieName is read when the request has no Authorization header.
+	// Empty disables cookie tokens.
+	CookieName string
+	// Audience overrides Config.Audience for this middleware
+	Audience string
+	// Optional lets requests without a token through without claims.
+	// Invalid tokens are still rejected.
+	Optional bool
+	// DPoP enables the DPoP authorization scheme (RFC 9449). Tokens bound
+	// to a DPoP key are only accepted with a valid proof of that key for
+	// the request, whether or not DPoP is enabled.
+	DPoP *DPoPVerifier
+	// RequireDPoP rejects bearer tokens; it needs DPoP
+	RequireDPoP bool
+}
+
+// Middleware validates the bearer token, or the token cookie, of every
+// request and stores its claims in the request context. Requests without a
+// valid token are rejected with 401.
+func (c *Client) Middleware(config MiddlewareConfig) func(http.Handler) http.Handler {
+	var opts []ValidateOption
+	if config.Audience != "" {
+		opts = append(opts, WithAudience(config.Audience))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, scheme := AuthorizationToken(r)
+			if token == "" && config.CookieName != "" {
+				if cookie, err := r.Cookie(config.CookieName); err == nil {
+					token, scheme = cookie.Value, SchemeBearer
+				}
+			}
+
+			if token == "" {
+				if config.Optional {
+					next.ServeHTTP(w, r)
+					return
+				}
+				config.challenge(w, "")
+				http.Error(w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := c.ValidateToken(token, opts...)
+			if err == nil {
+				err = config.checkBinding(r, scheme, token, claims)
+			}
+			if err != nil {
+				if c.config.Logger != nil {
+					c.config.Logger.Info("Rejected token", "error", err)
+				}
+				if errors.Is(err, ErrInvalidDPoPProof) || errors.Is(err, ErrMissingDPoPProof) {
+					w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof", algs="`+strings.Join(dpopAlgorithms, " ")+`"`)
+				} else {
+					config.challenge(w, "invalid_token")
+				}
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// checkBinding makes sure a token bound to a key is presented by its holder
+// and that bearer tokens are acceptable
+func (config MiddlewareConfig) checkBinding(r *http.Request, scheme, token string, claims *Claims) error {
+	if config.RequireDPoP && scheme != SchemeDPoP {
+		return fmt.Errorf("%w: bearer tokens are not accepted", ErrInvalidToken)
+	}
+	return VerifyTokenBinding(r, config.DPoP, scheme, token, claims)
+}
+
+// VerifyTokenBinding checks that a token was presented the way its claims
+// call for: a token bound to a DPoP key with the DPoP scheme and a valid
+// proof of that key, any other token as a bearer token. A token bound to a
+// client certificate must also arrive over a TLS connection authenticated
+// with that certificate. dpop is nil where DPoP is not supported.
+func VerifyTokenBinding(r *http.Request, dpop *DPoPVerifier, scheme, token string, claims *Claims) error {
+	if claims.CertificateBound() {
+		if err := verifyCertificateBinding(r, claims); err != nil {
+			return err
+		}
+	}
+
+	if scheme != SchemeDPoP {
+		if claims.DPoPBound() {
+			return fmt.Errorf("%w: DPoP bound token used as bearer token", ErrInvalidToken)
+		}
+		return nil
+	}
+
+	if dpop == nil {
+		return fmt.Errorf("%w: DPoP is not supported", ErrInvalidToken)
+	}
+	proof, err := dpop.VerifyRequest(r, token)
+	if err != nil {
+		return err
+	}
+	if !claims.DPoPBound() || subtle.ConstantTimeCompare([]byte(claims.Confirmation.JKT), []byte(proof.Thumbprint)) != 1 {
+		return fmt.Errorf("%w: token is not bound to the DPoP key", ErrInvalidToken)
+	}
+	return nil
+}
+
+// challenge sets WWW-Authenticate for the schemes the middleware accepts
+func (config MiddlewareConfig) challenge(w http.ResponseWriter, code string) {
+	params := ""
+	if code != "" {
+		params = ` error="` + code + `"`
+	}
+	var challenges []string
+	if !config.RequireDPoP {
+		challenges = append(challenges, SchemeBearer+params)
+	}
+	if config.DPoP != nil {
+		if params != "" {
+			params += ","
+		}
+		challenges = append(challenges, SchemeDPoP+params+` algs="`+strings.Join(dpopAlgorithms, " ")+`"`)
+	}
+	w.Header().Set("WWW-Authenticate", strings.Join(challenges, ", "))
+}
+
+// RequireRole rejects requests whose claims grant none of roles. It must be
+// installed after Middleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !claims.HasRole(roles...) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ContextWithClaims returns a copy of ctx carrying claims
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored by Middleware
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// Authorization schemes of access tokens
+const (
+	SchemeBearer = "Bearer"
+	SchemeDPoP   = "DPoP"
+)
+
+// BearerToken extracts the token from an "Authorization: Bearer" header
+func BearerToken(r *http.Request) string {
+	token, scheme := AuthorizationToken(r)
+	if scheme != SchemeBearer {
+		return ""
+	}
+	return token
+}
+
+// AuthorizationToken extracts the token and scheme of a Bearer or DPoP
+// Authorization header
+func AuthorizationToken(r *http.Request) (token, scheme string) {
+	header := r.Header.Get("Authorization")
+	for _, scheme := range []string{SchemeBearer, SchemeDPoP} {
+		prefix := scheme + " "
+		if len(header) >= len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+			return strings.TrimSpace(header[len(prefix):]), scheme
+		}
+	}
+	return "", ""
+}
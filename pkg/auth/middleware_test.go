@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	token, err := client.GenerateToken("test-user", WithRoles("admin"))
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		config     MiddlewareConfig
+		header     string
+		cookie     string
+		wantStatus int
+		wantUser   string
+	}{
+		{name: "bearer token", header: "Bearer " + token, wantStatus: http.StatusOK, wantUser: "test-user"},
+		{name: "cookie token", config: MiddlewareConfig{CookieName: "session"}, cookie: token, wantStatus: http.StatusOK, wantUser: "test-user"},
+		{name: "cookie ignored when not configured", cookie: token, wantStatus: http.StatusUnauthorized},
+		{name: "missing token", wantStatus: http.StatusUnauthorized},
+		{name: "optional without token", config: MiddlewareConfig{Optional: true}, wantStatus: http.StatusOK},
+		{name: "optional with invalid token", config: MiddlewareConfig{Optional: true}, header: "Bearer garbage", wantStatus: http.StatusUnauthorized},
+		{name: "wrong audience", config: MiddlewareConfig{Audience: "app-1"}, header: "Bearer " + token, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if claims, ok := ClaimsFromContext(r.Context()); ok {
+					gotUser = claims.UserID()
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			client.Middleware(tt.config)(next).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("user in context = %q, want %q", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	admin, _ := client.GenerateToken("alice", WithRoles("admin"))
+	viewer, _ := client.GenerateToken("bob", WithRoles("viewer"))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	protected := client.Middleware(MiddlewareConfig{})(RequireRole("admin", "operator")(ok))
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"granted role", admin, http.StatusOK},
+		{"missing role", viewer, http.StatusForbidden},
+		{"no token", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			protected.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// Without Middleware there are no claims to check
+	w := httptest.NewRecorder()
+	RequireRole("admin")(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status without claims = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// CertificateThumbprint returns the x5t#S256 of a certificate: the
+// base64url SHA-256 hash of its DER encoding (RFC 8705 section 3.1)
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ClientCertificate returns the certificate the client presented on the
+// TLS connection of r, or nil. The service must terminate TLS itself and
+// request client certificates. With tls.RequestClientCert any certificate,
+// including a self-signed one, can bind tokens, since the handshake still
+// proves possession of its key; tls.VerifyClientCertIfGiven only accepts
+// certificates issued by the configured ClientCAs.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// verifyCertificateBinding checks that a certificate-bound token arrived
+// over a connection authenticated with that certificate
+func verifyCertificateBinding(r *http.Request, claims *Claims) error {
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return fmt.Errorf("%w: certificate-bound token used without a client certificate", ErrInvalidToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Confirmation.X5TS256), []byte(CertificateThumbprint(cert))) != 1 {
+		return fmt.Errorf("%w: token is not bound to the client certificate", ErrInvalidToken)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate is a client certificate as used for binding tokens
+func selfSignedCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMiddlewareCertificateBinding(t *testing.T) {
+	client := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: time.Hour})
+	cert := selfSignedCertificate(t, "payments")
+	other := selfSignedCertificate(t, "payments")
+	bound, _ := client.GenerateToken("payments", AsClientToken(), WithCertificateBinding(CertificateThumbprint(cert)))
+	unbound, _ := client.GenerateToken("jdoe")
+
+	claims, _ := client.ValidateToken(bound)
+	if !claims.CertificateBound() || claims.DPoPBound() {
+		t.Fatalf("cnf = %+v, want a certificate binding only", claims.Confirmation)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		cert       *x509.Certificate
+		wantStatus int
+	}{
+		{"bound token over mTLS", bound, cert, http.StatusOK},
+		{"bound token with another certificate", bound, other, http.StatusUnauthorized},
+		{"bound token without certificate", bound, nil, http.StatusUnauthorized},
+		{"unbound token over mTLS", unbound, cert, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://api.example.com/payments", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+			w := httptest.NewRecorder()
+			client.Middleware(MiddlewareConfig{})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package auth
+
+import "time"
+
+// Authentication method references recorded in the amr claim. Standard
+// values follow RFC 8176; AMRLocal marks a login verified against the
+// service's own break-glass store instead of the directory and
+// AMRRecoveryCode one completed with a single-use recovery code instead of
+// the user's authenticator. WebAuthn logins record AMRHardwareKey, or
+// AMRSoftwareKey for synced passkeys whose key leaves the device.
+const (
+	AMRPassword     = "pwd"
+	AMRLocal        = "local"
+	AMROTP          = "otp"
+	AMRMultiFactor  = "mfa"
+	AMRRecoveryCode = "rc"
+	AMRHardwareKey  = "hwk"
+	AMRSoftwareKey  = "swk"
+)
+
+// TokenOption customizes a single token issued by GenerateToken
+type TokenOption func(*tokenOptions)
+
+type tokenOptions struct {
+	amr       []string
+	roles     []string
+	lifetime  time.Duration
+	offline   bool
+	audience  []string
+	name      string
+	email     string
+	groups    []string
+	authTime  time.Time
+	sessionID string
+	clientID  string
+	scope     []string
+	nonce     string
+	tokenUse  string
+	actor     *Actor
+	cnf       *Confirmation
+}
+
+// WithAMR records the authentication methods used to verify the user
+func WithAMR(methods ...string) TokenOption {
+	return func(o *tokenOptions) {
+		o.amr = append(o.amr, methods...)
+	}
+}
+
+// WithRoles records the roles granted to the user
+func WithRoles(roles ...string) TokenOption {
+	return func(o *tokenOptions) {
+		o.roles = append(o.roles, roles...)
+	}
+}
+
+// WithLifetime overrides Config.TokenDuration for this token
+func WithLifetime(d time.Duration) TokenOption {
+	return func(o *tokenOptions) {
+		o.lifetime = d
+	}
+}
+
+// WithOfflineVerified marks a token issued after the password was checked
+// against the offline verification cache instead of the directory
+func WithOfflineVerified() TokenOption {
+	return func(o *tokenOptions) {
+		o.offline = true
+	}
+}
+
+// ForAudience sets the aud claim, normally to the ID of the requesting
+// client, overriding ClaimsConfig.Audience
+func ForAudience(audience ...string) TokenOption {
+	return func(o *tokenOptions) {
+		o.audience = append(o.audience, audience...)
+	}
+}
+
+// WithProfile records the user's display name and email address
+func WithProfile(name, email string) TokenOption {
+	return func(o *tokenOptions) {
+		o.name = name
+		o.email = email
+	}
+}
+
+// WithGroups records the user's directory groups
+func WithGroups(groups ...string) TokenOption {
+	return func(o *tokenOptions) {
+		o.groups = append(o.groups, groups...)
+	}
+}
+
+// WithAuthTime records when the user actually authenticated, for tokens
+// issued later from a session or refresh token. Defaults to the issue time.
+func WithAuthTime(t time.Time) TokenOption {
+	return func(o *tokenOptions) {
+		o.authTime = t
+	}
+}
+
+// WithSessionID records the login session the token belongs to, so that
+// ending the session can revoke the token
+func WithSessionID(sid string) TokenOption {
+	return func(o *tokenOptions) {
+		o.sessionID = sid
+	}
+}
+
+// WithClientID records the OAuth client the token was issued to
+func WithClientID(clientID string) TokenOption {
+	return func(o *tokenOptions) {
+		o.clientID = clientID
+	}
+}
+
+// WithScope records the granted scopes
+func WithScope(scopes ...string) TokenOption {
+	return func(o *tokenOptions) {
+		o.scope = append(o.scope, scopes...)
+	}
+}
+
+// WithNonce records the nonce of an OpenID Connect authentication request
+func WithNonce(nonce string) TokenOption {
+	return func(o *tokenOptions) {
+		o.nonce = nonce
+	}
+}
+
+// AsIDToken issues an OpenID Connect ID token. ID tokens identify the user
+// to the client named in the audience and are never accepted as access
+// tokens.
+func AsIDToken() TokenOption {
+	return func(o *tokenOptions) {
+		o.tokenUse = TokenUseID
+	}
+}
+
+// AsClientToken issues an access token to a client acting on its own
+// behalf, as in the client_credentials grant. The subject is the client ID
+// rather than a user.
+func AsClientToken() TokenOption {
+	return func(o *tokenOptions) {
+		o.tokenUse = TokenUseClient
+	}
+}
+
+// WithActor records the party acting on behalf of the subject, as in a
+// token exchange
+func WithActor(actor *Actor) TokenOption {
+	return func(o *tokenOptions) {
+		o.actor = actor
+	}
+}
+
+// WithDPoPKey binds the token to the DPoP key with the thumbprint jkt, so
+// that it is only accepted along with a proof signed by that key
+func WithDPoPKey(jkt string) TokenOption {
+	return func(o *tokenOptions) {
+		if o.cnf == nil {
+			o.cnf = &Confirmation{}
+		}
+		o.cnf.JKT = jkt
+	}
+}
+
+// WithCertificateBinding binds the token to the TLS client certificate
+// with the thumbprint x5t (see CertificateThumbprint), so that it is only
+// accepted over connections authenticated with that certificate
+func WithCertificateBinding(x5t string) TokenOption {
+	return func(o *tokenOptions) {
+		if o.cnf == nil {
+			o.cnf = &Confirmation{}
+		}
+		o.cnf.X5TS256 = x5t
+	}
+}
+
+// ValidateOption adjusts the checks made by ValidateToken
+type ValidateOption func(*validateOptions)
+
+type validateOptions struct {
+	audience    string
+	anyAudience bool
+}
+
+// WithAudience requires the aud claim to contain audience, overriding
+// Config.Audience
+func WithAudience(audience string) ValidateOption {
+	return func(o *validateOptions) {
+		o.audience = audience
+	}
+}
+
+// WithAnyAudience skips the audience check, for callers such as token
+// introspection that answer on behalf of every audience
+func WithAnyAudience() ValidateOption {
+	return func(o *validateOptions) {
+		o.anyAudience = true
+	}
+}
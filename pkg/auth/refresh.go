@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRefreshTokenDuration is how long an unused refresh token stays valid
+const DefaultRefreshTokenDuration = 8 * time.Hour
+
+// Errors returned when a refresh token cannot be used
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means an already rotated token was presented
+	// again, so the token family has been revoked
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrRefreshNotConfigured is returned when Config has no RefreshStore
+	ErrRefreshNotConfigured = errors.New("refresh tokens are not configured")
+)
+
+// refreshTokenBytes is the entropy of an opaque refresh token
+const refreshTokenBytes = 32
+
+// RefreshGrant is what a refresh token entitles its holder to: new access
+// tokens for the same user, audience and authentication context
+type RefreshGrant struct {
+	UserID   string
+	Audience []string
+	// Application is the application Roles were mapped for
+	Application string
+	Name        string
+	Email       string
+	Groups      []string
+	Roles       []string
+	AMR         []string
+	AuthTime    time.Time
+	SessionID   string
+}
+
+// TokenOptions rebuilds the options of the access token the grant was issued with
+func (g *RefreshGrant) TokenOptions() []TokenOption {
+	opts := []TokenOption{
+		WithProfile(g.Name, g.Email),
+		WithAuthTime(g.AuthTime),
+	}
+	if len(g.Audience) > 0 {
+		opts = append(opts, ForAudience(g.Audience...))
+	}
+	if len(g.Groups) > 0 {
+		opts = append(opts, WithGroups(g.Groups...))
+	}
+	if len(g.Roles) > 0 {
+		opts = append(opts, WithRoles(g.Roles...))
+	}
+	if len(g.AMR) > 0 {
+		opts = append(opts, WithAMR(g.AMR...))
+	}
+	if g.SessionID != "" {
+		opts = append(opts, WithSessionID(g.SessionID))
+	}
+	return opts
+}
+
+// RefreshRecord is a stored refresh token. Only the SHA-256 hash of the
+// token is kept, so a leaked store cannot be replayed.
+type RefreshRecord struct {
+	Hash      string
+	FamilyID  string
+	Grant     RefreshGrant
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Used is set once the token has been rotated
+	Used bool
+	// Revoked is set when the token's family has been revoked
+	Revoked bool
+}
+
+// RefreshStore persists refresh tokens. Implementations must make MarkUsed
+// atomic: of two concurrent calls for the same hash only one may succeed.
+type RefreshStore interface {
+	Save(record RefreshRecord) error
+	// Get returns ErrInvalidRefreshToken for unknown hashes
+	Get(hash string) (*RefreshRecord, error)
+	// MarkUsed reports false when the token was already used
+	MarkUsed(hash string) (bool, error)
+	RevokeFamily(familyID string) error
+	// RevokeUser revokes every family belonging to userID
+	RevokeUser(userID string) error
+	// RevokeSession revokes every family issued for sessionID
+	RevokeSession(sessionID string) error
+}
+
+// IssueRefreshToken starts a new token family for grant and returns its
+// first refresh token
+func (c *Client) IssueRefreshToken(grant RefreshGrant) (string, error) {
+	if c.config.RefreshStore == nil {
+		return "", ErrRefreshNotConfigured
+	}
+	if grant.AuthTime.IsZero() {
+		grant.AuthTime = time.Now()
+	}
+	return c.saveRefreshToken(uuid.NewString(), grant)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. verify, which may be nil, is called before the token is used up
+// so that a failed check leaves the token intact. Presenting a token that
+// was already rotated revokes the whole family and returns
+// ErrRefreshTokenReused.
+func (c *Client) RotateRefreshToken(token string, verify func(*RefreshGrant) error) (*RefreshGrant, string, error) {
+	store := c.config.RefreshStore
+	if store == nil {
+		return nil, "", ErrRefreshNotConfigured
+	}
+
+	hash := hashRefreshToken(token)
+	record, err := store.Get(hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if record.Revoked || !time.Now().Before(record.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if record.Used {
+		return nil, "", c.refreshReused(record)
+	}
+
+	if verify != nil {
+		if err := verify(&record.Grant); err != nil {
+			return nil, "", err
+		}
+	}
+
+	ok, err := store.MarkUsed(hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		// Lost a race against another use of the same token
+		return nil, "", c.refreshReused(record)
+	}
+
+	next, err := c.saveRefreshToken(record.FamilyID, record.Grant)
+	if err != nil {
+		return nil, "", err
+	}
+	return &record.Grant, next, nil
+}
+
+// RefreshTokenGrant returns the grant of a refresh token that can still be
+// used, or ErrInvalidRefreshToken
+func (c *Client) RefreshTokenGrant(token string) (*RefreshGrant, error) {
+	store := c.config.RefreshStore
+	if store == nil {
+		return nil, ErrRefreshNotConfigured
+	}
+
+	record, err := store.Get(hashRefreshToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if record.Revoked || record.Used || !time.Now().Before(record.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	return &record.Grant, nil
+}
+
+// RevokeRefreshToken revokes the family of token, ending every refresh
+// token derived from the same login
+func (c *Client) RevokeRefreshToken(token string) error {
+	store := c.config.RefreshStore
+	if store == nil {
+		return ErrRefreshNotConfigured
+	}
+
+	record, err := store.Get(hashRefreshToken(token))
+	if err != nil {
+		return err
+	}
+	return store.RevokeFamily(record.FamilyID)
+}
+
+func (c *Client) refreshReused(record *RefreshRecord) error {
+	if err := c.config.RefreshStore.RevokeFamily(record.FamilyID); err != nil {
+		return fmt.Errorf("%w: failed to revoke family: %w", ErrRefreshTokenReused, err)
+	}
+	if c.config.Logger != nil {
+		c.config.Logger.Info("Refresh token reuse detected, family revoked",
+			"userID", record.Grant.UserID, "family", record.FamilyID)
+	}
+	return ErrRefreshTokenReused
+}
+
+func (c *Client) saveRefreshToken(familyID string, grant RefreshGrant) (string, error) {
+	buf := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	lifetime := c.config.RefreshTokenDuration
+	if lifetime <= 0 {
+		lifetime = DefaultRefreshTokenDuration
+	}
+
+	now := time.Now()
+	err := c.config.RefreshStore.Save(RefreshRecord{
+		Hash:      hashRefreshToken(token),
+		FamilyID:  familyID,
+		Grant:     grant,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(lifetime),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// hashRefreshToken returns the store key of a token. Tokens carry 256 bits
+// of entropy, so an unsalted hash is sufficient.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// maxRefreshRecords bounds memory use; expired records are pruned once it is reached
+const maxRefreshRecords = 100000
+
+// MemoryRefreshStore keeps refresh tokens in memory. Tokens do not survive a
+// restart and are not shared between instances. It is safe for concurrent use.
+type MemoryRefreshStore struct {
+	mu       sync.Mutex
+	records  map[string]*RefreshRecord
+	families map[string]bool
+	now      func() time.Time
+}
+
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		records:  make(map[string]*RefreshRecord),
+		families: make(map[string]bool),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryRefreshStore) Save(record RefreshRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.records) >= maxRefreshRecords {
+		s.prune()
+	}
+	s.records[record.Hash] = &record
+	return nil
+}
+
+func (s *MemoryRefreshStore) Get(hash string) (*RefreshRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[hash]
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	out := *record
+	out.Revoked = out.Revoked || s.families[record.FamilyID]
+	return &out, nil
+}
+
+func (s *MemoryRefreshStore) MarkUsed(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[hash]
+	if !ok {
+		return false, ErrInvalidRefreshToken
+	}
+	if record.Used {
+		return false, nil
+	}
+	record.Used = true
+	return true, nil
+}
+
+func (s *MemoryRefreshStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.families[familyID] = true
+	return nil
+}
+
+func (s *MemoryRefreshStore) RevokeUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records {
+		if record.Grant.UserID == userID {
+			s.families[record.FamilyID] = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshStore) RevokeSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records {
+		if record.Grant.SessionID == sessionID {
+			s.families[record.FamilyID] = true
+		}
+	}
+	return nil
+}
+
+// prune drops expired records, and revoked families that no longer have any.
+// It must be called with s.mu held.
+func (s *MemoryRefreshStore) prune() {
+	now := s.now()
+	live := make(map[string]bool)
+	for hash, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, hash)
+			continue
+		}
+		live[record.FamilyID] = true
+	}
+	for family := range s.families {
+		if !live[family] {
+			delete(s.families, family)
+		}
+	}
+}
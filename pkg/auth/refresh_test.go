@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRefreshTestClient() (*Client, *MemoryRefreshStore) {
+	store := NewMemoryRefreshStore()
+	return NewClient(Config{
+		JWTSecret:     []byte("test-secret"),
+		TokenDuration: time.Minute,
+		RefreshStore:  store,
+	}), store
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	client, store := newRefreshTestClient()
+
+	first, err := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe", Roles: []string{"viewer"}})
+	if err != nil {
+		t.Fatalf("IssueRefreshToken() unexpected error: %v", err)
+	}
+	if _, ok := store.records[first]; ok {
+		t.Error("refresh tokens must be stored hashed")
+	}
+
+	grant, second, err := client.RotateRefreshToken(first, nil)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() unexpected error: %v", err)
+	}
+	if grant.UserID != "jdoe" || second == first {
+		t.Errorf("RotateRefreshToken() = %+v, %q", grant, second)
+	}
+	if grant.AuthTime.IsZero() {
+		t.Error("auth time should default to the issue time")
+	}
+
+	third, err := func() (string, error) {
+		_, next, err := client.RotateRefreshToken(second, nil)
+		return next, err
+	}()
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() unexpected error: %v", err)
+	}
+
+	// Replaying the first token revokes the family, including the newest token
+	if _, _, err := client.RotateRefreshToken(first, nil); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse error = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := client.RotateRefreshToken(third, nil); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("token after family revocation error = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	// Other families are unaffected
+	other, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+	if _, _, err := client.RotateRefreshToken(other, nil); err != nil {
+		t.Errorf("unrelated family rejected: %v", err)
+	}
+}
+
+func TestRefreshTokenVerifyFailureKeepsToken(t *testing.T) {
+	client, _ := newRefreshTestClient()
+	token, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+
+	errLocked := errors.New("account locked")
+	if _, _, err := client.RotateRefreshToken(token, func(*RefreshGrant) error { return errLocked }); !errors.Is(err, errLocked) {
+		t.Fatalf("RotateRefreshToken() error = %v, want verify error", err)
+	}
+	if _, _, err := client.RotateRefreshToken(token, nil); err != nil {
+		t.Errorf("token should survive a failed check: %v", err)
+	}
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	client, store := newRefreshTestClient()
+
+	if _, _, err := client.RotateRefreshToken("unknown", nil); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown token error = %v", err)
+	}
+
+	expired, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+	store.records[hashRefreshToken(expired)].ExpiresAt = time.Now().Add(-time.Second)
+	if _, _, err := client.RotateRefreshToken(expired, nil); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expired token error = %v", err)
+	}
+
+	revoked, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+	if err := client.RevokeRefreshToken(revoked); err != nil {
+		t.Fatalf("RevokeRefreshToken() unexpected error: %v", err)
+	}
+	if _, _, err := client.RotateRefreshToken(revoked, nil); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("revoked token error = %v", err)
+	}
+
+	unconfigured := NewClient(Config{JWTSecret: []byte("test-secret")})
+	if _, err := unconfigured.IssueRefreshToken(RefreshGrant{UserID: "jdoe"}); !errors.Is(err, ErrRefreshNotConfigured) {
+		t.Errorf("IssueRefreshToken() without store error = %v", err)
+	}
+}
+
+func TestRefreshTokenConcurrentUse(t *testing.T) {
+	client, _ := newRefreshTestClient()
+	token, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		successes int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := client.RotateRefreshToken(token, nil); err == nil {
+				mu.Lock()
+				successes++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Errorf("%d concurrent rotations succeeded, want exactly 1", successes)
+	}
+}
+
+func TestRefreshGrantTokenOptions(t *testing.T) {
+	client, _ := newRefreshTestClient()
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	grant := RefreshGrant{UserID: "jdoe", Audience: []string{"app-1"}, Roles: []string{"admin"}, AMR: []string{AMRPassword}, AuthTime: authTime}
+
+	token, err := client.GenerateToken(grant.UserID, grant.TokenOptions()...)
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error: %v", err)
+	}
+	claims, err := client.ValidateToken(token, WithAudience("app-1"))
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error: %v", err)
+	}
+	if !claims.HasRole("admin") || claims.AuthTime == nil || !claims.AuthTime.Equal(authTime) {
+		t.Errorf("claims = %+v, want grant roles and original auth time", claims)
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers single-use identifiers, such as the jti of a DPoP
+// proof or client assertion, until they expire
+type ReplayCache interface {
+	// Seen records id and reports whether it had been recorded before
+	Seen(id string, expiresAt time.Time) bool
+}
+
+// maxReplayEntries bounds memory use; expired entries are pruned once it
+// is reached
+const maxReplayEntries = 100000
+
+// MemoryReplayCache is a ReplayCache for a single instance. It is safe for
+// concurrent use.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	now     func() time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (c *MemoryReplayCache) Seen(id string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if exp, ok := c.entries[id]; ok && now.Before(exp) {
+		return true
+	}
+	if len(c.entries) >= maxReplayEntries {
+		for key, exp := range c.entries {
+			if !now.Before(exp) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[id] = expiresAt
+	return false
+}
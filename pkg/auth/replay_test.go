@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	now := time.Now()
+	cache := NewMemoryReplayCache()
+	cache.now = func() time.Time { return now }
+
+	if cache.Seen("a", now.Add(time.Minute)) {
+		t.Error("first use reported as seen")
+	}
+	if !cache.Seen("a", now.Add(time.Minute)) {
+		t.Error("second use not detected")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if cache.Seen("a", now.Add(time.Minute)) {
+		t.Error("expired entry still reported as seen")
+	}
+}
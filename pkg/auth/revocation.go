@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrTokenRevoked is returned by ValidateToken for revoked tokens
+var ErrTokenRevoked = errors.New("token revoked")
+
+// RevocationKind selects the claim a Revocation matches
+type RevocationKind string
+
+const (
+	// RevokeTokenID revokes the single token with a jti
+	RevokeTokenID RevocationKind = "jti"
+	// RevokeSession revokes every token carrying a sid
+	RevokeSession RevocationKind = "sid"
+	// RevokeUser revokes a user's tokens issued before IssuedBefore
+	RevokeUser RevocationKind = "sub"
+)
+
+// Revocation is an entry in the denylist
+type Revocation struct {
+	Kind  RevocationKind `json:"kind"`
+	Value string         `json:"value"`
+	// IssuedBefore limits the revocation to tokens issued earlier. Zero
+	// revokes every matching token.
+	IssuedBefore time.Time `json:"issued_before,omitempty"`
+	// Expires is when the entry may be forgotten because every token it
+	// matches has expired. Zero keeps the entry forever.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// merge widens r by other, an entry of the same kind and value: it keeps
+// the later cutoff and the later expiry, where zero beats either
+func (r Revocation) merge(other Revocation) Revocation {
+	if r.IssuedBefore.IsZero() || other.IssuedBefore.IsZero() {
+		r.IssuedBefore = time.Time{}
+	} else if other.IssuedBefore.After(r.IssuedBefore) {
+		r.IssuedBefore = other.IssuedBefore
+	}
+	if r.Expires.IsZero() || other.Expires.IsZero() {
+		r.Expires = time.Time{}
+	} else if other.Expires.After(r.Expires) {
+		r.Expires = other.Expires
+	}
+	return r
+}
+
+// matches reports whether the entry revokes a token issued at iat
+func (r *Revocation) matches(iat time.Time) bool {
+	return r.IssuedBefore.IsZero() || iat.Before(r.IssuedBefore)
+}
+
+// RevocationStore is the denylist consulted by ValidateToken
+type RevocationStore interface {
+	// Revoke records an entry. An entry of the same kind and value is merged
+	// with it, so revoking again never narrows an earlier revocation.
+	Revoke(entry Revocation) error
+	// Lookup returns the entry for kind and value, or nil when there is none
+	Lookup(kind RevocationKind, value string) (*Revocation, error)
+}
+
+// RevokeTokenID revokes a single token. expiresAt is the token's exp, after
+// which the entry is no longer needed.
+func (c *Client) RevokeTokenID(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return fmt.Errorf("%w: token has no ID", ErrInvalidToken)
+	}
+	return c.revoke(Revocation{Kind: RevokeTokenID, Value: jti, Expires: expiresAt})
+}
+
+// RevokeSessionTokens revokes every access token issued for a session and
+// the session's refresh tokens. until is when the last of the access tokens
+// expires; zero keeps the entry forever.
+func (c *Client) RevokeSessionTokens(sid string, until time.Time) error {
+	if sid == "" {
+		return fmt.Errorf("empty session ID")
+	}
+	if err := c.revoke(Revocation{Kind: RevokeSession, Value: sid, Expires: until}); err != nil {
+		return err
+	}
+	if c.config.RefreshStore != nil {
+		if err := c.config.RefreshStore.RevokeSession(sid); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes every access token issued to a user before
+// before, and all of the user's refresh tokens
+func (c *Client) RevokeUserTokens(userID string, before time.Time) error {
+	if userID == "" {
+		return fmt.Errorf("empty user ID")
+	}
+	if err := c.revoke(Revocation{Kind: RevokeUser, Value: userID, IssuedBefore: before}); err != nil {
+		return err
+	}
+	if c.config.RefreshStore != nil {
+		if err := c.config.RefreshStore.RevokeUser(userID); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeAccessToken revokes a token issued by this service by its jti.
+// Tokens that already expired need no entry and are accepted silently.
+func (c *Client) RevokeAccessToken(tokenString string) error {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, c.verificationKey,
+		jwt.WithValidMethods(c.allowedAlgorithms()),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.ExpiresAt == nil || !time.Now().Before(claims.ExpiresAt.Add(c.clockSkew())) {
+		return nil
+	}
+	return c.RevokeTokenID(claims.ID, claims.ExpiresAt.Add(c.clockSkew()))
+}
+
+func (c *Client) revoke(entry Revocation) error {
+	if c.config.RevocationStore == nil {
+		return fmt.Errorf("revocation is not configured")
+	}
+	if err := c.config.RevocationStore.Revoke(entry); err != nil {
+		return fmt.Errorf("failed to record revocation: %w", err)
+	}
+	if c.config.Logger != nil {
+		c.config.Logger.Info("Tokens revoked", "kind", entry.Kind, "value", entry.Value)
+	}
+	return nil
+}
+
+// checkRevoked consults the denylist for the token's jti, sid and subject.
+// Store failures reject the token.
+func (c *Client) checkRevoked(claims *Claims) error {
+	store := c.config.RevocationStore
+	if store == nil {
+		return nil
+	}
+
+	var iat time.Time
+	if claims.IssuedAt != nil {
+		iat = claims.IssuedAt.Time
+	}
+
+	checks := []struct {
+		kind  RevocationKind
+		value string
+	}{
+		{RevokeTokenID, claims.ID},
+		{RevokeSession, claims.SessionID},
+		{RevokeUser, claims.UserID()},
+	}
+	for _, check := range checks {
+		if check.value == "" {
+			continue
+		}
+		entry, err := store.Lookup(check.kind, check.value)
+		if err != nil {
+			return fmt.Errorf("revocation check failed: %w", err)
+		}
+		if entry != nil && entry.matches(iat) {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
+
+// maxRevocations bounds memory use; expired entries are pruned once it is reached
+const maxRevocations = 100000
+
+// MemoryRevocationStore keeps the denylist in memory. It is safe for
+// concurrent use.
+type MemoryRevocationStore struct {
+	mu      sync.RWMutex
+	entries map[string]Revocation
+	now     func() time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		entries: make(map[string]Revocation),
+		now:     time.Now,
+	}
+}
+
+func revocationKey(kind RevocationKind, value string) string {
+	return string(kind) + ":" + value
+}
+
+func (s *MemoryRevocationStore) Revoke(entry Revocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) >= maxRevocations {
+		s.prune()
+	}
+	s.add(entry)
+	return nil
+}
+
+// add must be called with s.mu held for writing
+func (s *MemoryRevocationStore) add(entry Revocation) {
+	key := revocationKey(entry.Kind, entry.Value)
+	if existing, ok := s.entries[key]; ok && !s.expired(existing) {
+		entry = existing.merge(entry)
+	}
+	s.entries[key] = entry
+}
+
+func (s *MemoryRevocationStore) Lookup(kind RevocationKind, value string) (*Revocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[revocationKey(kind, value)]
+	if !ok || s.expired(entry) {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// expired must be called with s.mu held
+func (s *MemoryRevocationStore) expired(entry Revocation) bool {
+	return !entry.Expires.IsZero() && !s.now().Before(entry.Expires)
+}
+
+// prune must be called with s.mu held for writing
+func (s *MemoryRevocationStore) prune() {
+	for key, entry := range s.entries {
+		if s.expired(entry) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// FileRevocationStore is a MemoryRevocationStore persisted to an
+// append-only file of JSON lines, so revocations survive restarts. The file
+// is compacted when it is opened.
+type FileRevocationStore struct {
+	*MemoryRevocationStore
+	path string
+	file *os.File
+	// writeMu serializes appends
+	writeMu sync.Mutex
+}
+
+// OpenFileRevocationStore loads the denylist at path, creating it if needed
+func OpenFileRevocationStore(path string) (*FileRevocationStore, error) {
+	s := &FileRevocationStore{
+		MemoryRevocationStore: NewMemoryRevocationStore(),
+		path:                  path,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open revocation store: %w", err)
+	}
+	s.file = f
+	return s, nil
+}
+
+// Revoke writes the entry to disk before it takes effect
+func (s *FileRevocationStore) Revoke(entry Revocation) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write revocation: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write revocation: %w", err)
+	}
+	return s.MemoryRevocationStore.Revoke(entry)
+}
+
+// Close closes the underlying file
+func (s *FileRevocationStore) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileRevocationStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open revocation store: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Revocation
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		s.add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read revocation store: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the file without expired or superseded entries
+func (s *FileRevocationStore) compact() error {
+	s.mu.Lock()
+	s.prune()
+	var data []byte
+	for _, entry := range s.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	s.mu.Unlock()
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to compact revocation store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to compact revocation store: %w", err)
+	}
+	return nil
+}
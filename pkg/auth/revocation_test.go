@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newRevocationTestClient(store RevocationStore) *Client {
+	return NewClient(Config{
+		JWTSecret:       []byte("test-secret"),
+		TokenDuration:   time.Hour,
+		RevocationStore: store,
+		RefreshStore:    NewMemoryRefreshStore(),
+	})
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	client := newRevocationTestClient(NewMemoryRevocationStore())
+
+	token, _ := client.GenerateToken("jdoe")
+	other, _ := client.GenerateToken("jdoe")
+
+	if err := client.RevokeAccessToken(token); err != nil {
+		t.Fatalf("RevokeAccessToken() unexpected error: %v", err)
+	}
+	if _, err := client.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateToken() error = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := client.ValidateToken(other); err != nil {
+		t.Errorf("revoking one jti must not affect other tokens: %v", err)
+	}
+
+	if err := client.RevokeAccessToken("garbage"); err == nil {
+		t.Error("RevokeAccessToken() should reject malformed tokens")
+	}
+
+	expired := NewClient(Config{JWTSecret: []byte("test-secret"), TokenDuration: -time.Hour})
+	old, _ := expired.GenerateToken("jdoe")
+	if err := client.RevokeAccessToken(old); err != nil {
+		t.Errorf("expired tokens need no revocation: %v", err)
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	client := newRevocationTestClient(NewMemoryRevocationStore())
+
+	before, _ := client.GenerateToken("jdoe")
+	bystander, _ := client.GenerateToken("asmith")
+	refresh, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe"})
+
+	// iat has second precision, so revoke as of a second from now
+	if err := client.RevokeUserTokens("jdoe", time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("RevokeUserTokens() unexpected error: %v", err)
+	}
+
+	if _, err := client.ValidateToken(before); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token issued before revocation: error = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := client.ValidateToken(bystander); err != nil {
+		t.Errorf("other users must be unaffected: %v", err)
+	}
+	if _, _, err := client.RotateRefreshToken(refresh, nil); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh token after user revocation: error = %v", err)
+	}
+
+	// Tokens issued after the cut-off are accepted
+	store := client.config.RevocationStore.(*MemoryRevocationStore)
+	store.Revoke(Revocation{Kind: RevokeUser, Value: "bwayne", IssuedBefore: time.Now().Add(-time.Minute)})
+	after, _ := client.GenerateToken("bwayne")
+	if _, err := client.ValidateToken(after); err != nil {
+		t.Errorf("token issued after revocation rejected: %v", err)
+	}
+}
+
+func TestRevocationsAreNeverNarrowed(t *testing.T) {
+	client := newRevocationTestClient(NewMemoryRevocationStore())
+	store := client.config.RevocationStore.(*MemoryRevocationStore)
+	now := time.Now()
+
+	// Revoking again with an earlier cut-off keeps the later one
+	token, _ := client.GenerateToken("jdoe")
+	client.RevokeUserTokens("jdoe", now.Add(time.Second))
+	client.RevokeUserTokens("jdoe", now.Add(-time.Hour))
+	if _, err := client.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateToken() after narrower revocation: error = %v, want ErrTokenRevoked", err)
+	}
+
+	// A shorter expiry does not cut an entry short, and zero means forever
+	store.Revoke(Revocation{Kind: RevokeSession, Value: "s1", Expires: now.Add(8 * time.Hour)})
+	store.Revoke(Revocation{Kind: RevokeSession, Value: "s1", Expires: now.Add(time.Minute)})
+	if entry, _ := store.Lookup(RevokeSession, "s1"); entry == nil || !entry.Expires.Equal(now.Add(8*time.Hour)) {
+		t.Errorf("session entry = %+v, want it to expire in 8h", entry)
+	}
+	store.Revoke(Revocation{Kind: RevokeSession, Value: "s2"})
+	store.Revoke(Revocation{Kind: RevokeSession, Value: "s2", Expires: now.Add(time.Minute)})
+	if entry, _ := store.Lookup(RevokeSession, "s2"); entry == nil || !entry.Expires.IsZero() {
+		t.Errorf("session entry = %+v, want it kept forever", entry)
+	}
+
+	// An expired entry is replaced rather than merged
+	store.Revoke(Revocation{Kind: RevokeTokenID, Value: "a", Expires: now.Add(-time.Minute)})
+	store.Revoke(Revocation{Kind: RevokeTokenID, Value: "a", Expires: now.Add(time.Minute)})
+	if entry, _ := store.Lookup(RevokeTokenID, "a"); entry == nil || !entry.Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("token entry = %+v, want the new expiry", entry)
+	}
+}
+
+func TestRevokeSessionTokens(t *testing.T) {
+	client := newRevocationTestClient(NewMemoryRevocationStore())
+
+	inSession, _ := client.GenerateToken("jdoe", WithSessionID("s1"))
+	otherSession, _ := client.GenerateToken("jdoe", WithSessionID("s2"))
+	refresh, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe", SessionID: "s1"})
+	otherRefresh, _ := client.IssueRefreshToken(RefreshGrant{UserID: "jdoe", SessionID: "s2"})
+
+	if err := client.RevokeSessionTokens("s1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeSessionTokens() unexpected error: %v", err)
+	}
+	if _, err := client.ValidateToken(inSession); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateToken() error = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := client.ValidateToken(otherSession); err != nil {
+		t.Errorf("other sessions must be unaffected: %v", err)
+	}
+	if _, _, err := client.RotateRefreshToken(refresh, nil); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh token of revoked session: error = %v", err)
+	}
+	if _, _, err := client.RotateRefreshToken(otherRefresh, nil); err != nil {
+		t.Errorf("refresh token of other session rejected: %v", err)
+	}
+}
+
+func TestMemoryRevocationStoreExpiry(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	store.Revoke(Revocation{Kind: RevokeTokenID, Value: "a", Expires: now.Add(time.Minute)})
+	if entry, _ := store.Lookup(RevokeTokenID, "a"); entry == nil {
+		t.Fatal("entry should be found before it expires")
+	}
+
+	store.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if entry, _ := store.Lookup(RevokeTokenID, "a"); entry != nil {
+		t.Error("expired entry should be ignored")
+	}
+	store.mu.Lock()
+	store.prune()
+	n := len(store.entries)
+	store.mu.Unlock()
+	if n != 0 {
+		t.Errorf("prune left %d entries", n)
+	}
+}
+
+func TestFileRevocationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.jsonl")
+
+	store, err := OpenFileRevocationStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileRevocationStore() unexpected error: %v", err)
+	}
+	client := newRevocationTestClient(store)
+	token, _ := client.GenerateToken("jdoe")
+	if err := client.RevokeAccessToken(token); err != nil {
+		t.Fatalf("RevokeAccessToken() unexpected error: %v", err)
+	}
+	store.Revoke(Revocation{Kind: RevokeTokenID, Value: "stale", Expires: time.Now().Add(-time.Hour)})
+	store.Revoke(Revocation{Kind: RevokeUser, Value: "asmith", IssuedBefore: time.Now()})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+
+	reopened, err := OpenFileRevocationStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileRevocationStore() unexpected error: %v", err)
+	}
+	defer reopened.Close()
+
+	client = newRevocationTestClient(reopened)
+	if _, err := client.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("revocation lost across restart: error = %v", err)
+	}
+	if entry, _ := reopened.Lookup(RevokeUser, "asmith"); entry == nil {
+		t.Error("user revocation lost across restart")
+	}
+
+	// Compaction drops the expired entry from the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read store: %v", err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2 {
+		t.Errorf("compacted store has %d lines, want 2:\n%s", n, data)
+	}
+}
@@ -0,0 +1,519 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session defaults
+const (
+	DefaultSessionCookieName      = "session"
+	DefaultSessionIdleTimeout     = 30 * time.Minute
+	DefaultSessionAbsoluteTimeout = 12 * time.Hour
+)
+
+// ErrSessionNotFound is returned by SessionStore for unknown or expired sessions
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTokenBytes is the entropy of a session cookie
+const sessionTokenBytes = 32
+
+// sessionTouchInterval limits how often an unchanged session is written
+// back just to record activity
+const sessionTouchInterval = time.Minute
+
+// Session is a stored server-side session. ID is the SHA-256 hash of the
+// cookie value, so a leaked store cannot be used to hijack sessions, and it
+// is safe to log or put in tokens as the sid claim.
+type Session struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id,omitempty"`
+	// Values must be JSON encodable to survive a FileSessionStore
+	Values    map[string]interface{} `json:"values,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	LastSeen  time.Time              `json:"last_seen"`
+	// ExpiresAt is when the idle or absolute timeout ends the session,
+	// whichever comes first
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionInfo describes a session without its values
+type SessionInfo struct {
+	ID        string
+	UserID    string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+}
+
+func (s *Session) info() SessionInfo {
+	return SessionInfo{
+		ID:        s.ID,
+		UserID:    s.UserID,
+		CreatedAt: s.CreatedAt,
+		LastSeen:  s.LastSeen,
+		ExpiresAt: s.ExpiresAt,
+	}
+}
+
+// SessionStore persists sessions by ID
+type SessionStore interface {
+	// Load returns ErrSessionNotFound for unknown or expired sessions
+	Load(id string) (*Session, error)
+	Save(session *Session) error
+	Delete(id string) error
+	// ListByUser returns the live sessions of userID
+	ListByUser(userID string) ([]*Session, error)
+}
+
+// SessionConfig configures Sessions
+type SessionConfig struct {
+	Store SessionStore
+	// CookieName defaults to DefaultSessionCookieName
+	CookieName   string
+	CookiePath   string
+	CookieDomain string
+	// SameSite defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+	// InsecureCookie drops the Secure attribute. Only for local development
+	// over plain HTTP.
+	InsecureCookie bool
+	// IdleTimeout ends sessions without activity. Defaults to
+	// DefaultSessionIdleTimeout.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions this long after login regardless of
+	// activity. Defaults to DefaultSessionAbsoluteTimeout.
+	AbsoluteTimeout time.Duration
+	Logger          Logger
+}
+
+// Sessions is a cookie based SessionManager backed by a SessionStore.
+// Handlers must be wrapped in Middleware for sessions to be available.
+type Sessions struct {
+	config SessionConfig
+	now    func() time.Time
+}
+
+// NewSessions returns a session manager. Config.Store is required.
+func NewSessions(config SessionConfig) *Sessions {
+	if config.CookieName == "" {
+		config.CookieName = DefaultSessionCookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if config.AbsoluteTimeout <= 0 {
+		config.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+	return &Sessions{config: config, now: time.Now}
+}
+
+// sessionState is the session of one request
+type sessionState struct {
+	mu      sync.Mutex
+	session *Session
+	// token is the cookie value of session
+	token string
+	// hadCookie is set when the request presented a session cookie
+	hadCookie  bool
+	dirty      bool
+	sendCookie bool
+	committed  bool
+}
+
+// Middleware loads the session named by the request's cookie, makes it
+// available to Put, Get, Destroy and RenewID through the request context,
+// and saves it and sets the cookie before the response is written. Expired
+// sessions are discarded. New sessions are only created once something is
+// stored in them.
+func (s *Sessions) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := s.load(r)
+		sw := &sessionWriter{ResponseWriter: w, commit: func() { s.commit(w, state) }}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionKey, state)))
+		sw.commitOnce()
+	})
+}
+
+func (s *Sessions) load(r *http.Request) *sessionState {
+	state := &sessionState{}
+	cookie, err := r.Cookie(s.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return state
+	}
+	state.hadCookie = true
+
+	id := sessionID(cookie.Value)
+	session, err := s.config.Store.Load(id)
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return state
+	case err != nil:
+		s.log("Session load failed", "error", err)
+		return state
+	}
+
+	if now := s.now(); s.expired(session, now) {
+		if err := s.config.Store.Delete(id); err != nil {
+			s.log("Failed to delete expired session", "error", err)
+		}
+		s.log("Session expired", "session", id, "userID", session.UserID)
+		return state
+	}
+
+	state.session = session
+	state.token = cookie.Value
+	return state
+}
+
+func (s *Sessions) expired(session *Session, now time.Time) bool {
+	return now.Sub(session.LastSeen) >= s.config.IdleTimeout ||
+		now.Sub(session.CreatedAt) >= s.config.AbsoluteTimeout
+}
+
+// commit saves the session and writes the cookie. It runs once, before the
+// response header is sent.
+func (s *Sessions) commit(w http.ResponseWriter, state *sessionState) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.committed {
+		return
+	}
+	state.committed = true
+
+	if state.session == nil {
+		if state.hadCookie {
+			s.expireCookie(w)
+		}
+		return
+	}
+
+	now := s.now()
+	if state.dirty || now.Sub(state.session.LastSeen) >= sessionTouchInterval {
+		state.session.LastSeen = now
+		if err := s.save(state.session); err != nil {
+			// The response is already decided; the next request starts over
+			s.log("Session save failed", "session", state.session.ID, "error", err)
+			return
+		}
+	}
+	if state.sendCookie {
+		s.setCookie(w, state)
+	}
+}
+
+func (s *Sessions) save(session *Session) error {
+	session.ExpiresAt = session.LastSeen.Add(s.config.IdleTimeout)
+	if absolute := session.CreatedAt.Add(s.config.AbsoluteTimeout); absolute.Before(session.ExpiresAt) {
+		session.ExpiresAt = absolute
+	}
+	return s.config.Store.Save(session)
+}
+
+func (s *Sessions) setCookie(w http.ResponseWriter, state *sessionState) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.config.CookieName,
+		Value:    state.token,
+		Path:     s.config.CookiePath,
+		Domain:   s.config.CookieDomain,
+		Expires:  state.session.CreatedAt.Add(s.config.AbsoluteTimeout),
+		Secure:   !s.config.InsecureCookie,
+		HttpOnly: true,
+		SameSite: s.config.SameSite,
+	})
+}
+
+func (s *Sessions) expireCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.config.CookieName,
+		Value:    "",
+		Path:     s.config.CookiePath,
+		Domain:   s.config.CookieDomain,
+		MaxAge:   -1,
+		Secure:   !s.config.InsecureCookie,
+		HttpOnly: true,
+		SameSite: s.config.SameSite,
+	})
+}
+
+// state returns the request's session state, or nil outside Middleware
+func (s *Sessions) state(ctx context.Context) *sessionState {
+	state, _ := ctx.Value(sessionKey).(*sessionState)
+	if state == nil {
+		s.log("Session used outside Sessions.Middleware")
+	}
+	return state
+}
+
+// ensure starts a new session if the request has none. It must be called
+// with state.mu held.
+func (s *Sessions) ensure(state *sessionState) error {
+	if state.session != nil {
+		return nil
+	}
+	token, err := newSessionToken()
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	state.session = &Session{
+		ID:        sessionID(token),
+		Values:    make(map[string]interface{}),
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	state.token = token
+	state.dirty = true
+	state.sendCookie = true
+	return nil
+}
+
+// Put stores val in the session, starting one if needed. Changes are saved
+// when the response is written.
+func (s *Sessions) Put(ctx context.Context, key string, val interface{}) {
+	state := s.state(ctx)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := s.ensure(state); err != nil {
+		s.log("Session start failed", "error", err)
+		return
+	}
+	if state.session.Values == nil {
+		state.session.Values = make(map[string]interface{})
+	}
+	state.session.Values[key] = val
+	state.dirty = true
+}
+
+// Get returns a value from the session, or nil
+func (s *Sessions) Get(ctx context.Context, key string) interface{} {
+	state := s.state(ctx)
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.session == nil {
+		return nil
+	}
+	return state.session.Values[key]
+}
+
+// Destroy deletes the session from the store immediately and expires the
+// cookie
+func (s *Sessions) Destroy(ctx context.Context) error {
+	state := s.state(ctx)
+	if state == nil {
+		return fmt.Errorf("no session in context")
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.session == nil {
+		return nil
+	}
+	if err := s.config.Store.Delete(state.session.ID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	state.session = nil
+	state.token = ""
+	state.dirty = false
+	state.sendCookie = false
+	state.hadCookie = true
+	return nil
+}
+
+// RenewID moves the session to a new ID and cookie and deletes the old one
+func (s *Sessions) RenewID(ctx context.Context) error {
+	state := s.state(ctx)
+	if state == nil {
+		return fmt.Errorf("no session in context")
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := s.ensure(state); err != nil {
+		return err
+	}
+	return s.renew(state)
+}
+
+// renew must be called with state.mu held
+func (s *Sessions) renew(state *sessionState) error {
+	token, err := newSessionToken()
+	if err != nil {
+		return err
+	}
+
+	oldID := state.session.ID
+	session := *state.session
+	session.ID = sessionID(token)
+	session.LastSeen = s.now()
+	if err := s.save(&session); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := s.config.Store.Delete(oldID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return fmt.Errorf("failed to delete old session: %w", err)
+	}
+
+	state.session = &session
+	state.token = token
+	state.sendCookie = true
+	return nil
+}
+
+// Login binds the session to userID after a successful authentication and
+// returns the session ID. The session gets a new ID, and the absolute
+// timeout starts over. A session belonging to another user is replaced.
+func (s *Sessions) Login(ctx context.Context, userID string) (string, error) {
+	state := s.state(ctx)
+	if state == nil {
+		return "", fmt.Errorf("no session in context")
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.session != nil && state.session.UserID != "" && state.session.UserID != userID {
+		if err := s.config.Store.Delete(state.session.ID); err != nil {
+			return "", fmt.Errorf("failed to delete session: %w", err)
+		}
+		state.session = nil
+	}
+	if err := s.ensure(state); err != nil {
+		return "", err
+	}
+
+	state.session.UserID = userID
+	state.session.CreatedAt = s.now()
+	if err := s.renew(state); err != nil {
+		return "", err
+	}
+	state.dirty = false
+	return state.session.ID, nil
+}
+
+// UserSessions lists the live sessions of a user
+func (s *Sessions) UserSessions(userID string) ([]SessionInfo, error) {
+	sessions, err := s.config.Store.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	out := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		if !s.expired(session, now) {
+			out = append(out, session.info())
+		}
+	}
+	return out, nil
+}
+
+// DestroySession deletes a session by ID, e.g. one listed by UserSessions
+func (s *Sessions) DestroySession(id string) error {
+	if err := s.config.Store.Delete(id); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// DestroyUserSessions deletes every session of a user
+func (s *Sessions) DestroyUserSessions(userID string) error {
+	sessions, err := s.config.Store.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := s.DestroySession(session.ID); err != nil {
+			return err
+		}
+	}
+	if len(sessions) > 0 {
+		s.log("User sessions destroyed", "userID", userID, "count", len(sessions))
+	}
+	return nil
+}
+
+// SessionFromContext describes the session of the request, if it has one
+func SessionFromContext(ctx context.Context) (SessionInfo, bool) {
+	state, _ := ctx.Value(sessionKey).(*sessionState)
+	if state == nil {
+		return SessionInfo{}, false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.session == nil {
+		return SessionInfo{}, false
+	}
+	return state.session.info(), true
+}
+
+func (s *Sessions) log(msg string, keyvals ...interface{}) {
+	if s.config.Logger != nil {
+		s.config.Logger.Info(msg, keyvals...)
+	}
+}
+
+func newSessionToken() (string, error) {
+	buf := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sessionID returns the store key of a session cookie value
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionWriter commits the session before the response header goes out
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (w *sessionWriter) commitOnce() {
+	if !w.committed {
+		w.committed = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.commitOnce()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commitOnce()
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
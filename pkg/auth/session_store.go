@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxSessions bounds memory use; expired sessions are pruned once it is reached
+const maxSessions = 100000
+
+// MemorySessionStore keeps sessions in memory. Sessions do not survive a
+// restart and are not shared between instances. It is safe for concurrent use.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	// byUser indexes session IDs by user
+	byUser map[string]map[string]bool
+	now    func() time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*Session),
+		byUser:   make(map[string]map[string]bool),
+		now:      time.Now,
+	}
+}
+
+func (s *MemorySessionStore) Load(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok || s.expired(session) {
+		return nil, ErrSessionNotFound
+	}
+	return copySession(session), nil
+}
+
+func (s *MemorySessionStore) Save(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sessions) >= maxSessions {
+		s.prune()
+	}
+	s.put(copySession(session))
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(id)
+	return nil
+}
+
+func (s *MemorySessionStore) ListByUser(userID string) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []*Session
+	for id := range s.byUser[userID] {
+		if session := s.sessions[id]; !s.expired(session) {
+			out = append(out, copySession(session))
+		}
+	}
+	return out, nil
+}
+
+// put must be called with s.mu held for writing
+func (s *MemorySessionStore) put(session *Session) {
+	if old, ok := s.sessions[session.ID]; ok && old.UserID != session.UserID {
+		s.unindex(old)
+	}
+	s.sessions[session.ID] = session
+	if session.UserID != "" {
+		ids := s.byUser[session.UserID]
+		if ids == nil {
+			ids = make(map[string]bool)
+			s.byUser[session.UserID] = ids
+		}
+		ids[session.ID] = true
+	}
+}
+
+// remove must be called with s.mu held for writing
+func (s *MemorySessionStore) remove(id string) {
+	if session, ok := s.sessions[id]; ok {
+		s.unindex(session)
+		delete(s.sessions, id)
+	}
+}
+
+func (s *MemorySessionStore) unindex(session *Session) {
+	if ids := s.byUser[session.UserID]; ids != nil {
+		delete(ids, session.ID)
+		if len(ids) == 0 {
+			delete(s.byUser, session.UserID)
+		}
+	}
+}
+
+// expired must be called with s.mu held
+func (s *MemorySessionStore) expired(session *Session) bool {
+	return !s.now().Before(session.ExpiresAt)
+}
+
+// prune must be called with s.mu held for writing
+func (s *MemorySessionStore) prune() {
+	for id, session := range s.sessions {
+		if s.expired(session) {
+			s.remove(id)
+		}
+	}
+}
+
+func copySession(session *Session) *Session {
+	out := *session
+	if session.Values != nil {
+		out.Values = make(map[string]interface{}, len(session.Values))
+		for k, v := range session.Values {
+			out.Values[k] = v
+		}
+	}
+	return &out
+}
+
+// sessionLogEntry is a line of a FileSessionStore
+type sessionLogEntry struct {
+	Session *Session `json:"session,omitempty"`
+	// Deleted holds the ID of a deleted session
+	Deleted string `json:"deleted,omitempty"`
+}
+
+// FileSessionStore is a MemorySessionStore persisted to an append-only
+// file of JSON lines, so sessions survive restarts of a single instance.
+// The file is compacted when it is opened and whenever superseded entries
+// outnumber live sessions.
+type FileSessionStore struct {
+	*MemorySessionStore
+	path string
+	file *os.File
+	// writeMu serializes appends and compaction
+	writeMu sync.Mutex
+	// lines counts the entries in the file
+	lines int
+}
+
+// OpenFileSessionStore loads the sessions at path, creating the file if needed
+func OpenFileSessionStore(path string) (*FileSessionStore, error) {
+	s := &FileSessionStore{
+		MemorySessionStore: NewMemorySessionStore(),
+		path:               path,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the session to disk before it takes effect
+func (s *FileSessionStore) Save(session *Session) error {
+	return s.append(sessionLogEntry{Session: session}, func() error {
+		return s.MemorySessionStore.Save(session)
+	})
+}
+
+// Delete writes the deletion to disk before it takes effect
+func (s *FileSessionStore) Delete(id string) error {
+	return s.append(sessionLogEntry{Deleted: id}, func() error {
+		return s.MemorySessionStore.Delete(id)
+	})
+}
+
+// Close closes the underlying file
+func (s *FileSessionStore) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.file.Close()
+}
+
+// append writes entry and then applies it in memory. Both happen under
+// writeMu so that a compaction never misses a written entry.
+func (s *FileSessionStore) append(entry sessionLogEntry, apply func() error) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	s.lines++
+	if err := apply(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	live := len(s.sessions)
+	s.mu.RUnlock()
+	if s.lines > 2*live+1000 {
+		// The entry is durable, so a failed compaction loses nothing;
+		// appends carry on in the old file and it is retried next time
+		s.compactLocked()
+	}
+	return nil
+}
+
+func (s *FileSessionStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open session store: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry sessionLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		switch {
+		case entry.Session != nil:
+			s.put(entry.Session)
+		case entry.Deleted != "":
+			s.remove(entry.Deleted)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read session store: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSessionStore) compact() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the file with only live sessions and reopens it
+// for appending. It must be called with s.writeMu held.
+func (s *FileSessionStore) compactLocked() error {
+	s.mu.Lock()
+	s.prune()
+	var data []byte
+	for _, session := range s.sessions {
+		line, err := json.Marshal(sessionLogEntry{Session: session})
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	lines := len(s.sessions)
+	s.mu.Unlock()
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to compact session store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to compact session store: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open session store: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = f
+	s.lines = lines
+	return nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	session := &Session{
+		ID:        "a",
+		UserID:    "jdoe",
+		Values:    map[string]interface{}{"k": "v"},
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(time.Minute),
+	}
+	if err := store.Save(session); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	// The store keeps its own copy
+	session.Values["k"] = "changed"
+	loaded, err := store.Load("a")
+	if err != nil || loaded.Values["k"] != "v" {
+		t.Fatalf("Load() = %+v, %v; want stored copy", loaded, err)
+	}
+
+	// Changing the user moves the session in the index
+	loaded.UserID = "other"
+	store.Save(loaded)
+	if list, _ := store.ListByUser("jdoe"); len(list) != 0 {
+		t.Errorf("ListByUser(jdoe) = %d sessions, want 0", len(list))
+	}
+	if list, _ := store.ListByUser("other"); len(list) != 1 {
+		t.Errorf("ListByUser(other) = %d sessions, want 1", len(list))
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := store.Load("a"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Load() of expired session error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if list, _ := store.ListByUser("other"); len(list) != 0 {
+		t.Errorf("ListByUser() lists expired sessions")
+	}
+}
+
+func TestFileSessionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	expires := time.Now().Add(time.Hour)
+
+	store, err := OpenFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSessionStore() unexpected error: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.Save(&Session{ID: id, UserID: "jdoe", ExpiresAt: expires}); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+	store.Save(&Session{ID: "old", ExpiresAt: time.Now().Add(-time.Minute)})
+	store.Save(&Session{ID: "b", UserID: "jdoe", Values: map[string]interface{}{"k": "v"}, ExpiresAt: expires})
+	if err := store.Delete("c"); err != nil {
+		t.Fatalf("Delete() unexpected error: %v", err)
+	}
+	store.Close()
+
+	reopened, err := OpenFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("reopen unexpected error: %v", err)
+	}
+	defer reopened.Close()
+
+	if list, _ := reopened.ListByUser("jdoe"); len(list) != 2 {
+		t.Errorf("ListByUser() = %d sessions, want 2", len(list))
+	}
+	if b, err := reopened.Load("b"); err != nil || b.Values["k"] != "v" {
+		t.Errorf("Load(b) = %+v, %v; want latest version", b, err)
+	}
+	if _, err := reopened.Load("c"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("deleted session survived reopen: %v", err)
+	}
+
+	// Compaction on open drops expired, deleted and superseded entries
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("compacted file has %d lines, want 2", lines)
+	}
+}
+
+func TestFileSessionStoreCompactsWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	store, err := OpenFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSessionStore() unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	session := &Session{ID: "a", UserID: "jdoe", ExpiresAt: time.Now().Add(time.Hour)}
+	for i := 0; i < 1500; i++ {
+		session.LastSeen = time.Now()
+		if err := store.Save(session); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines > 1000 {
+		t.Errorf("file has %d lines, want it compacted", lines)
+	}
+	if _, err := store.Load("a"); err != nil {
+		t.Errorf("Load() after compaction: %v", err)
+	}
+}
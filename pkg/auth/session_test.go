@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sessionTestServer runs handler behind the session middleware
+type sessionTestServer struct {
+	sessions *Sessions
+	store    *MemorySessionStore
+	handler  http.HandlerFunc
+}
+
+func newSessionTestServer(config SessionConfig) *sessionTestServer {
+	store := NewMemorySessionStore()
+	config.Store = store
+	return &sessionTestServer{sessions: NewSessions(config), store: store}
+}
+
+func (s *sessionTestServer) do(t *testing.T, cookie *http.Cookie) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.sessions.Middleware(s.handler).ServeHTTP(w, r)
+	return w.Result()
+}
+
+func sessionCookie(resp *http.Response) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == DefaultSessionCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSessionsPutGet(t *testing.T) {
+	srv := newSessionTestServer(SessionConfig{})
+
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		if srv.sessions.Get(r.Context(), "theme") != nil {
+			t.Error("new session should be empty")
+		}
+		srv.sessions.Put(r.Context(), "theme", "dark")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	cookie := sessionCookie(srv.do(t, nil))
+	if cookie == nil {
+		t.Fatal("no session cookie set")
+	}
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie = %+v, want Secure, HttpOnly and SameSite=Lax", cookie)
+	}
+
+	var got interface{}
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		got = srv.sessions.Get(r.Context(), "theme")
+	}
+	if resp := srv.do(t, cookie); sessionCookie(resp) != nil {
+		t.Error("unchanged session should not set the cookie again")
+	}
+	if got != "dark" {
+		t.Errorf("Get() = %v, want dark", got)
+	}
+}
+
+func TestSessionsNoSessionWithoutValues(t *testing.T) {
+	srv := newSessionTestServer(SessionConfig{})
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {}
+
+	if cookie := sessionCookie(srv.do(t, nil)); cookie != nil {
+		t.Errorf("cookie = %+v, want none for an unused session", cookie)
+	}
+	if len(srv.store.sessions) != 0 {
+		t.Errorf("store has %d sessions, want 0", len(srv.store.sessions))
+	}
+}
+
+func TestSessionsLoginRenewsID(t *testing.T) {
+	srv := newSessionTestServer(SessionConfig{})
+
+	// An attacker plants a session before the victim logs in
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		srv.sessions.Put(r.Context(), "returnTo", "/home")
+	}
+	planted := sessionCookie(srv.do(t, nil))
+
+	var sid string
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if sid, err = srv.sessions.Login(r.Context(), "jdoe"); err != nil {
+			t.Fatalf("Login() unexpected error: %v", err)
+		}
+	}
+	renewed := sessionCookie(srv.do(t, planted))
+	if renewed == nil || renewed.Value == planted.Value {
+		t.Fatal("login should issue a new session cookie")
+	}
+	if sid != sessionID(renewed.Value) {
+		t.Errorf("Login() = %q, want the hash of the new cookie", sid)
+	}
+
+	var returnTo interface{}
+	var info SessionInfo
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		returnTo = srv.sessions.Get(r.Context(), "returnTo")
+		info, _ = SessionFromContext(r.Context())
+	}
+	srv.do(t, renewed)
+	if returnTo != "/home" || info.UserID != "jdoe" {
+		t.Errorf("renewed session = %v %+v, want values and user kept", returnTo, info)
+	}
+
+	srv.do(t, planted)
+	if returnTo != nil {
+		t.Error("planted session ID must no longer work")
+	}
+}
+
+func TestSessionsLoginAsAnotherUser(t *testing.T) {
+	srv := newSessionTestServer(SessionConfig{})
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		srv.sessions.Login(r.Context(), "alice")
+		srv.sessions.Put(r.Context(), "cart", "alice's")
+	}
+	cookie := sessionCookie(srv.do(t, nil))
+
+	var cart interface{}
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		srv.sessions.Login(r.Context(), "bob")
+		cart = srv.sessions.Get(r.Context(), "cart")
+	}
+	srv.do(t, cookie)
+	if cart != nil {
+		t.Errorf("bob sees %v, want a fresh session", cart)
+	}
+	if sessions, _ := srv.sessions.UserSessions("alice"); len(sessions) != 0 {
+		t.Errorf("alice still has %d sessions", len(sessions))
+	}
+}
+
+func TestSessionsDestroy(t *testing.T) {
+	srv := newSessionTestServer(SessionConfig{})
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		srv.sessions.Login(r.Context(), "jdoe")
+	}
+	cookie := sessionCookie(srv.do(t, nil))
+
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.sessions.Destroy(r.Context()); err != nil {
+			t.Fatalf("Destroy() unexpected error: %v", err)
+		}
+	}
+	expired := sessionCookie(srv.do(t, cookie))
+	if expired == nil || expired.MaxAge >= 0 {
+		t.Errorf("cookie = %+v, want it expired", expired)
+	}
+	if _, err := srv.store.Load(sessionID(cookie.Value)); err != ErrSessionNotFound {
+		t.Errorf("Load() error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSessionsTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed []time.Duration
+		wantOK  bool
+	}{
+		{name: "active", elapsed: []time.Duration{20 * time.Minute, 20 * time.Minute}, wantOK: true},
+		{name: "idle", elapsed: []time.Duration{31 * time.Minute}},
+		{name: "absolute", elapsed: []time.Duration{25 * time.Minute, 25 * time.Minute, 25 * time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSessionTestServer(SessionConfig{
+				IdleTimeout:     30 * time.Minute,
+				AbsoluteTimeout: time.Hour,
+			})
+			now := time.Now()
+			srv.sessions.now = func() time.Time { return now }
+			srv.store.now = srv.sessions.now
+
+			srv.handler = func(w http.ResponseWriter, r *http.Request) {
+				srv.sessions.Login(r.Context(), "jdoe")
+			}
+			cookie := sessionCookie(srv.do(t, nil))
+
+			var ok bool
+			srv.handler = func(w http.ResponseWriter, r *http.Request) {
+				_, ok = SessionFromContext(r.Context())
+			}
+			for _, d := range tt.elapsed {
+				now = now.Add(d)
+				srv.do(t, cookie)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("session alive = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSessionsUserEnumeration(t *testing.T) {
+	srv := newSessionTestServer(SessionConfig{})
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		srv.sessions.Login(r.Context(), "jdoe")
+	}
+	first := sessionCookie(srv.do(t, nil))
+	sessionCookie(srv.do(t, nil))
+
+	sessions, err := srv.sessions.UserSessions("jdoe")
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("UserSessions() = %d sessions, %v; want 2", len(sessions), err)
+	}
+
+	if err := srv.sessions.DestroyUserSessions("jdoe"); err != nil {
+		t.Fatalf("DestroyUserSessions() unexpected error: %v", err)
+	}
+	if sessions, _ := srv.sessions.UserSessions("jdoe"); len(sessions) != 0 {
+		t.Errorf("UserSessions() after destroy = %d sessions, want 0", len(sessions))
+	}
+
+	var ok bool
+	srv.handler = func(w http.ResponseWriter, r *http.Request) {
+		_, ok = SessionFromContext(r.Context())
+	}
+	srv.do(t, first)
+	if ok {
+		t.Error("destroyed session still loads")
+	}
+}
+
+func TestSessionsOutsideMiddleware(t *testing.T) {
+	sessions := NewSessions(SessionConfig{Store: NewMemorySessionStore()})
+	ctx := context.Background()
+
+	sessions.Put(ctx, "k", "v")
+	if sessions.Get(ctx, "k") != nil {
+		t.Error("Get() outside middleware should return nil")
+	}
+	if err := sessions.Destroy(ctx); err == nil {
+		t.Error("Destroy() outside middleware expected error")
+	}
+	if _, err := sessions.Login(ctx, "jdoe"); err == nil {
+		t.Error("Login() outside middleware expected error")
+	}
+}
+
+func TestSessionsImplementsSessionManager(t *testing.T) {
+	var _ SessionManager = NewSessions(SessionConfig{Store: NewMemorySessionStore()})
+}
@@ -0,0 +1,74 @@
+// pkg/authn/authn.go
+package authn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidCredentials is returned when a backend rejects the credentials
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnavailable is returned when a backend cannot be reached
+	ErrUnavailable = errors.New("authentication backend unavailable")
+)
+
+// Backend names reported in Identity.Backend by the built-in authenticators
+const (
+	BackendLDAP = "ldap"
+	BackendFile = "file"
+)
+
+// Identity describes a user verified by an Authenticator
+type Identity struct {
+	// ID is the canonical user identifier, the lower case account name
+	// without domain as returned by CanonicalID. Per-user state such as
+	// second factors is keyed by it.
+	ID string
+	// Username is the name as presented to the backend
+	Username string
+	Domain   string
+	DN       string
+	Name     string
+	Email    string
+	Groups   []string
+	// Attributes holds backend specific extra attributes
+	Attributes map[string][]string
+	// Backend names the backend that verified the credentials
+	Backend string
+	// Methods lists the authentication methods used (amr values)
+	Methods []string
+	// Offline is set when the password was checked against a cached
+	// verifier because the backend was unreachable
+	Offline bool
+}
+
+// Authenticator verifies a username and password. Usernames may carry a
+// domain as "user@domain".
+type Authenticator interface {
+	Authenticate(username, password string) (*Identity, error)
+}
+
+// CanonicalID returns the canonical user ID of a username: the account name
+// in lower case, without a "user@domain" suffix or a down-level
+// "DOMAIN\user" prefix. Directories compare account names without regard
+// to case, so every spelling of an account maps to the same ID.
+func CanonicalID(username string) string {
+	name := strings.ToLower(strings.TrimSpace(username))
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// SplitUsername splits "user@domain" into its parts. Usernames without a
+// domain return an empty domain.
+func SplitUsername(username string) (user, domain string) {
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		return username[:i], username[i+1:]
+	}
+	return username, ""
+}
@@ -0,0 +1,70 @@
+// pkg/authn/chain.go
+package authn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChainBackend is one entry of a Chain
+type ChainBackend struct {
+	// Name identifies the backend and prefixes the IDs of its users unless
+	// it is the first one
+	Name          string
+	Authenticator Authenticator
+	// Domains restricts the backend to usernames in these domains. An empty
+	// list accepts every username, including ones without a domain.
+	Domains []string
+}
+
+func (b ChainBackend) handles(domain string) bool {
+	if len(b.Domains) == 0 {
+		return true
+	}
+	for _, d := range b.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain routes each username to the first backend that handles its
+// domain. That backend is authoritative: when it rejects the credentials or
+// is unavailable the attempt fails, so a later catch-all backend such as a
+// File can never vouch for a user of a routed domain.
+//
+// The first backend is the primary directory and its identity IDs are used
+// as-is. IDs from later backends are prefixed with the backend name, e.g.
+// "file:jdoe", so their users never share a subject, and with it MFA
+// enrollments, credentials and revocations, with users of the primary.
+type Chain struct {
+	backends []ChainBackend
+}
+
+func NewChain(backends ...ChainBackend) *Chain {
+	return &Chain{backends: backends}
+}
+
+func (c *Chain) Authenticate(username, password string) (*Identity, error) {
+	_, domain := SplitUsername(username)
+
+	for i, b := range c.backends {
+		if !b.handles(domain) {
+			continue
+		}
+
+		identity, err := b.Authenticator.Authenticate(username, password)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name, err)
+		}
+		if identity.Backend == "" {
+			identity.Backend = b.Name
+		}
+		if i > 0 {
+			identity.ID = b.Name + ":" + identity.ID
+		}
+		return identity, nil
+	}
+	return nil, fmt.Errorf("%w: no backend for domain %q", ErrInvalidCredentials, domain)
+}
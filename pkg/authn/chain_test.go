@@ -0,0 +1,131 @@
+// pkg/authn/chain_test.go
+package authn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type stubAuthenticator struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (s *stubAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	user, _ := SplitUsername(username)
+	return &Identity{ID: user, Username: username}, nil
+}
+
+func TestChainAuthenticate(t *testing.T) {
+	tests := []struct {
+		name        string
+		username    string
+		corpErr     error
+		fileErr     error
+		wantErr     error
+		wantBackend string
+		wantID      string
+		wantCorp    int
+		wantFile    int
+	}{
+		{
+			name:        "routed backend succeeds",
+			username:    "jdoe@corp.example.com",
+			wantBackend: "corp",
+			wantID:      "jdoe",
+			wantCorp:    1,
+		},
+		{
+			name:     "rejection by the routed backend is final",
+			username: "jdoe@corp.example.com",
+			corpErr:  ErrInvalidCredentials,
+			wantErr:  ErrInvalidCredentials,
+			wantCorp: 1,
+		},
+		{
+			name:     "outage of the routed backend is final",
+			username: "jdoe@corp.example.com",
+			corpErr:  ErrUnavailable,
+			wantErr:  ErrUnavailable,
+			wantCorp: 1,
+		},
+		{
+			name:        "other domains go to the catch-all backend",
+			username:    "dev@dev.local",
+			wantBackend: "file",
+			wantID:      "file:dev",
+			wantFile:    1,
+		},
+		{
+			name:        "catch-all users never get a primary subject",
+			username:    "jdoe@other.example.com",
+			wantBackend: "file",
+			wantID:      "file:jdoe",
+			wantFile:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corp := &stubAuthenticator{err: tt.corpErr}
+			file := &stubAuthenticator{err: tt.fileErr}
+			chain := NewChain(
+				ChainBackend{Name: "corp", Authenticator: corp, Domains: []string{"CORP.example.com"}},
+				ChainBackend{Name: "file", Authenticator: file},
+			)
+
+			identity, err := chain.Authenticate(tt.username, "secret")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Authenticate() unexpected error: %v", err)
+			} else if identity.Backend != tt.wantBackend || identity.ID != tt.wantID {
+				t.Errorf("Authenticate() = %s from %s, want %s from %s", identity.ID, identity.Backend, tt.wantID, tt.wantBackend)
+			}
+
+			if corp.calls != tt.wantCorp || file.calls != tt.wantFile {
+				t.Errorf("calls corp=%d file=%d, want corp=%d file=%d", corp.calls, file.calls, tt.wantCorp, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestChainLDAPRejectionNotRescuedByFile(t *testing.T) {
+	// jdoe has a local development entry with a different password
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dev-pass"), bcrypt.MinCost)
+	file, err := NewFile(writeHtpasswd(t, "jdoe:"+string(hash)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldapClient := &mockLDAPClient{result: &ldap.AuthResult{}, err: ldap.ErrInvalidCredentials}
+	chain := NewChain(
+		ChainBackend{Name: "ldap", Authenticator: NewLDAP(ldapClient), Domains: []string{"corp.example.com"}},
+		ChainBackend{Name: "file", Authenticator: file},
+	)
+
+	if _, err := chain.Authenticate("jdoe@corp.example.com", "dev-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+	}
+	ldapClient.err = ldap.ErrUnavailable
+	if _, err := chain.Authenticate("jdoe@corp.example.com", "dev-pass"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Authenticate() during outage error = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestChainNoBackendForDomain(t *testing.T) {
+	chain := NewChain(ChainBackend{Name: "corp", Authenticator: &stubAuthenticator{}, Domains: []string{"corp.example.com"}})
+
+	if _, err := chain.Authenticate("jdoe@other.example.com", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+	}
+}
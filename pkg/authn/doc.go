@@ -0,0 +1,24 @@
+// Package authn defines a backend-neutral way to verify a username and
+// password and describe the resulting user.
+//
+// An Authenticator returns an Identity on success. Failures are reported
+// with two sentinel errors so callers can react without knowing the backend:
+//   - ErrInvalidCredentials: the backend rejected the username or password
+//   - ErrUnavailable: the backend could not be reached at all
+//
+// The package ships three backends:
+//   - LDAP wraps pkg/ldap for corporate Active Directory
+//   - File reads an htpasswd-style file, for local development without AD
+//   - Chain routes each username to the first backend handling its domain
+//
+// Basic usage:
+//
+//	dev, err := authn.NewFile("users.htpasswd")
+//
+//	chain := authn.NewChain(
+//		authn.ChainBackend{Name: "ldap", Authenticator: authn.NewLDAP(ldapClient), Domains: []string{"corp.example.com"}},
+//		authn.ChainBackend{Name: "file", Authenticator: dev},
+//	)
+//
+//	identity, err := chain.Authenticate("jdoe@corp.example.com", password)
+package authn
@@ -0,0 +1,119 @@
+// pkg/authn/file.go
+package authn
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+// dummyHash is verified for unknown users so timing does not reveal which users exist
+const dummyHash = "$argon2id$v=19$m=65536,t=3,p=2$D6z7Pdt6EOnbI5NUncNr4A$+2M5AkfZD8NG7Imv3H1Ioh4fWC8gFvPZ6hsUWjbN4RM"
+
+type fileUser struct {
+	hash   string
+	groups []string
+}
+
+// File authenticates against an htpasswd-style file, intended for local
+// development. Each line is
+//
+//	username:hash[:group1,group2]
+//
+// where hash is bcrypt (as written by htpasswd -B) or Argon2id. The optional
+// third field lists the user's groups. Blank lines and lines starting with
+// '#' are ignored. Usernames are matched case-insensitively and any
+// "@domain" suffix is ignored.
+type File struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]fileUser
+}
+
+// NewFile loads the htpasswd file at path
+func NewFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the file. On error the previously loaded users are kept.
+func (f *File) Reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	users := make(map[string]fileUser)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("htpasswd line %d: expected username:hash", n)
+		}
+
+		user := fileUser{hash: fields[1]}
+		if len(fields) == 3 && fields[2] != "" {
+			for _, g := range strings.Split(fields[2], ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					user.groups = append(user.groups, g)
+				}
+			}
+		}
+		users[strings.ToLower(fields[0])] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	f.mu.Lock()
+	f.users = users
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *File) Authenticate(username, password string) (*Identity, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	name, domain := SplitUsername(username)
+
+	f.mu.RLock()
+	user, ok := f.users[CanonicalID(name)]
+	f.mu.RUnlock()
+
+	if !ok {
+		passwd.Verify(dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+
+	match, err := passwd.Verify(user.hash, password)
+	if err != nil {
+		return nil, fmt.Errorf("htpasswd entry for %q: %w", name, err)
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		ID:       CanonicalID(name),
+		Username: username,
+		Domain:   domain,
+		Groups:   user.groups,
+		Backend:  BackendFile,
+		Methods:  []string{"pwd"},
+	}, nil
+}
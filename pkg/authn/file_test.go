@@ -0,0 +1,81 @@
+// pkg/authn/file_test.go
+package authn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeHtpasswd(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write htpasswd: %v", err)
+	}
+	return path
+}
+
+func TestFileAuthenticate(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("dev-pass"), bcrypt.MinCost)
+	argonHash, _ := passwd.HashWithParams("dev-pass", passwd.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	path := writeHtpasswd(t, "# local users\n\n"+
+		"alice:"+string(bcryptHash)+"\n"+
+		"Bob:"+argonHash+":app-admins, developers\n")
+
+	backend, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		wantErr    error
+		wantID     string
+		wantGroups []string
+	}{
+		{name: "bcrypt user", username: "alice", password: "dev-pass", wantID: "alice"},
+		{name: "argon2id user with domain and groups", username: "bob@dev.local", password: "dev-pass", wantID: "bob", wantGroups: []string{"app-admins", "developers"}},
+		{name: "wrong password", username: "alice", password: "nope", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", username: "mallory", password: "dev-pass", wantErr: ErrInvalidCredentials},
+		{name: "empty password", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := backend.Authenticate(tt.username, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() unexpected error: %v", err)
+			}
+			if identity.ID != tt.wantID || identity.Backend != "file" {
+				t.Errorf("Authenticate() identity = %+v", identity)
+			}
+			if !reflect.DeepEqual(identity.Groups, tt.wantGroups) {
+				t.Errorf("Authenticate() groups = %v, want %v", identity.Groups, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestNewFileInvalid(t *testing.T) {
+	if _, err := NewFile(writeHtpasswd(t, "alice\n")); err == nil {
+		t.Error("NewFile() expected error for line without hash")
+	}
+	if _, err := NewFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewFile() expected error for missing file")
+	}
+}
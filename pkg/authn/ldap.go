@@ -0,0 +1,141 @@
+// pkg/authn/ldap.go
+package authn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+)
+
+// LDAPClient is the subset of ldap.Client used by the LDAP backend
+type LDAPClient interface {
+	Authenticate(username, password string) (*ldap.AuthResult, error)
+}
+
+// UserDirectory looks up the profile of an authenticated user
+type UserDirectory interface {
+	GetUser(id string, attributes []string) (*ldap.User, error)
+}
+
+// LDAP authenticates against a directory through pkg/ldap
+type LDAP struct {
+	client     LDAPClient
+	directory  UserDirectory
+	attributes []string
+}
+
+// LDAPOption configures optional LDAP backend behaviour
+type LDAPOption func(*LDAP)
+
+// WithProfileLookup loads the user's DN, name, email, groups and the extra
+// attributes from the directory after a successful bind, for use in role
+// mapping. A failed lookup fails the login.
+func WithProfileLookup(directory UserDirectory, attributes ...string) LDAPOption {
+	return func(a *LDAP) {
+		a.directory = directory
+		a.attributes = attributes
+	}
+}
+
+func NewLDAP(client LDAPClient, opts ...LDAPOption) *LDAP {
+	a := &LDAP{client: client}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *LDAP) Authenticate(username, password string) (*Identity, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	result, err := a.client.Authenticate(username, password)
+	switch {
+	case errors.Is(err, ldap.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.Is(err, ldap.ErrInvalidCredentials):
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	case err != nil:
+		return nil, err
+	case result == nil || !result.Success:
+		return nil, ErrInvalidCredentials
+	}
+
+	_, domain := SplitUsername(username)
+	identity := &Identity{
+		ID:       CanonicalID(username),
+		Username: username,
+		Domain:   domain,
+		Backend:  BackendLDAP,
+		Methods:  []string{"pwd"},
+	}
+
+	if a.directory != nil {
+		if err := a.loadProfile(identity); err != nil {
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+// Lookup returns the identity of a user who authenticated without a
+// password, e.g. with a passkey, from the profile directory. Unknown and
+// disabled accounts are rejected with ErrInvalidCredentials.
+func (a *LDAP) Lookup(userID string) (*Identity, error) {
+	if a.directory == nil {
+		return nil, errors.New("profile lookup not configured")
+	}
+	userID = CanonicalID(userID)
+	if userID == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	profile, err := a.directory.GetUser(userID, a.attributes)
+	switch {
+	case errors.Is(err, ldap.ErrUnavailable):
+		return nil, fmt.Errorf("%w: profile lookup: %w", ErrUnavailable, err)
+	case errors.Is(err, ldap.ErrNotFound):
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	case err != nil:
+		return nil, fmt.Errorf("profile lookup failed: %w", err)
+	case profile.Disabled:
+		return nil, fmt.Errorf("%w: account disabled", ErrInvalidCredentials)
+	}
+
+	if profile.ID != "" {
+		userID = CanonicalID(profile.ID)
+	}
+	return &Identity{
+		ID:         userID,
+		Username:   userID,
+		Backend:    BackendLDAP,
+		DN:         profile.DN,
+		Name:       profile.Name,
+		Email:      profile.Email,
+		Groups:     profile.Groups,
+		Attributes: profile.Attributes,
+	}, nil
+}
+
+func (a *LDAP) loadProfile(identity *Identity) error {
+	profile, err := a.directory.GetUser(identity.ID, a.attributes)
+	switch {
+	case errors.Is(err, ldap.ErrUnavailable):
+		return fmt.Errorf("%w: profile lookup: %w", ErrUnavailable, err)
+	case err != nil:
+		return fmt.Errorf("profile lookup failed: %w", err)
+	}
+
+	// The directory's account name is authoritative
+	if profile.ID != "" {
+		identity.ID = CanonicalID(profile.ID)
+	}
+	identity.DN = profile.DN
+	identity.Name = profile.Name
+	identity.Email = profile.Email
+	identity.Groups = profile.Groups
+	identity.Attributes = profile.Attributes
+	return nil
+}
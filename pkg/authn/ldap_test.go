@@ -0,0 +1,218 @@
+// pkg/authn/ldap_test.go
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/ldap"
+)
+
+type mockLDAPClient struct {
+	result *ldap.AuthResult
+	err    error
+}
+
+func (m *mockLDAPClient) Authenticate(username, password string) (*ldap.AuthResult, error) {
+	return m.result, m.err
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		result   *ldap.AuthResult
+		err      error
+		wantErr  error
+		wantAny  bool
+	}{
+		{
+			name:     "success",
+			password: "secret",
+			result:   &ldap.AuthResult{Username: "jdoe@corp.example.com", Success: true},
+		},
+		{
+			name:     "invalid credentials",
+			password: "secret",
+			result:   &ldap.AuthResult{},
+			err:      fmt.Errorf("bind: %w", ldap.ErrInvalidCredentials),
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "unsuccessful result",
+			password: "secret",
+			result:   &ldap.AuthResult{},
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "unavailable",
+			password: "secret",
+			result:   &ldap.AuthResult{},
+			err:      fmt.Errorf("dial: %w", ldap.ErrUnavailable),
+			wantErr:  ErrUnavailable,
+		},
+		{
+			name:     "empty password",
+			password: "",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "other directory error",
+			password: "secret",
+			result:   &ldap.AuthResult{},
+			err:      errors.New("unwilling to perform"),
+			wantAny:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := NewLDAP(&mockLDAPClient{result: tt.result, err: tt.err})
+
+			identity, err := backend.Authenticate("jdoe@corp.example.com", tt.password)
+			if tt.wantAny {
+				if err == nil || errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrUnavailable) {
+					t.Fatalf("Authenticate() error = %v, want unclassified error", err)
+				}
+				return
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() unexpected error: %v", err)
+			}
+
+			if identity.ID != "jdoe" || identity.Domain != "corp.example.com" || identity.Backend != "ldap" {
+				t.Errorf("Authenticate() identity = %+v", identity)
+			}
+		})
+	}
+}
+
+func TestLDAPCanonicalID(t *testing.T) {
+	client := &mockLDAPClient{result: &ldap.AuthResult{Success: true}}
+
+	for _, username := range []string{"JDOE@corp.example.com", "JDoe", `CORP\jdoe`, `corp\JDOE@corp.example.com`, " jdoe "} {
+		identity, err := NewLDAP(client).Authenticate(username, "secret")
+		if err != nil {
+			t.Fatalf("Authenticate(%q) unexpected error: %v", username, err)
+		}
+		if identity.ID != "jdoe" {
+			t.Errorf("Authenticate(%q) ID = %q, want jdoe", username, identity.ID)
+		}
+	}
+
+	// The directory's account name wins over the typed spelling
+	directory := &mockUserDirectory{user: &ldap.User{ID: "JDoe"}}
+	backend := NewLDAP(client, WithProfileLookup(directory))
+	identity, err := backend.Authenticate(`CORP\JDOE`, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ID != "jdoe" || directory.lastID != "jdoe" {
+		t.Errorf("ID = %q, profile looked up as %q; want jdoe", identity.ID, directory.lastID)
+	}
+	if identity, err := backend.Lookup("JDOE"); err != nil || identity.ID != "jdoe" {
+		t.Errorf("Lookup(JDOE) = %+v, %v; want ID jdoe", identity, err)
+	}
+}
+
+type mockUserDirectory struct {
+	user           *ldap.User
+	err            error
+	lastID         string
+	lastAttributes []string
+}
+
+func (m *mockUserDirectory) GetUser(id string, attributes []string) (*ldap.User, error) {
+	m.lastID = id
+	m.lastAttributes = attributes
+	return m.user, m.err
+}
+
+func TestLDAPProfileLookup(t *testing.T) {
+	client := &mockLDAPClient{result: &ldap.AuthResult{Success: true}}
+
+	t.Run("populates identity", func(t *testing.T) {
+		directory := &mockUserDirectory{user: &ldap.User{
+			DN:         "CN=John Doe,OU=People,DC=corp",
+			ID:         "jdoe",
+			Name:       "John Doe",
+			Email:      "jdoe@corp.example.com",
+			Groups:     []string{"CN=Staff,OU=Groups,DC=corp"},
+			Attributes: map[string][]string{"department": {"Treasury"}},
+		}}
+		backend := NewLDAP(client, WithProfileLookup(directory, "department"))
+
+		identity, err := backend.Authenticate("jdoe@corp.example.com", "secret")
+		if err != nil {
+			t.Fatalf("Authenticate() unexpected error: %v", err)
+		}
+		if directory.lastID != "jdoe" || len(directory.lastAttributes) != 1 || directory.lastAttributes[0] != "department" {
+			t.Errorf("GetUser() called with %q %v", directory.lastID, directory.lastAttributes)
+		}
+		if identity.DN != directory.user.DN || identity.Name != "John Doe" || identity.Email != "jdoe@corp.example.com" {
+			t.Errorf("Authenticate() identity = %+v", identity)
+		}
+		if len(identity.Groups) != 1 || identity.Attributes["department"][0] != "Treasury" {
+			t.Errorf("Authenticate() groups = %v, attributes = %v", identity.Groups, identity.Attributes)
+		}
+	})
+
+	t.Run("unavailable directory", func(t *testing.T) {
+		directory := &mockUserDirectory{err: fmt.Errorf("search: %w", ldap.ErrUnavailable)}
+		backend := NewLDAP(client, WithProfileLookup(directory))
+
+		if _, err := backend.Authenticate("jdoe@corp.example.com", "secret"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Authenticate() error = %v, want %v", err, ErrUnavailable)
+		}
+	})
+
+	t.Run("lookup failure", func(t *testing.T) {
+		directory := &mockUserDirectory{err: ldap.ErrNotFound}
+		backend := NewLDAP(client, WithProfileLookup(directory))
+
+		if _, err := backend.Authenticate("jdoe@corp.example.com", "secret"); err == nil {
+			t.Error("Authenticate() expected error")
+		}
+	})
+}
+
+func TestLDAPLookup(t *testing.T) {
+	client := &mockLDAPClient{}
+
+	directory := &mockUserDirectory{user: &ldap.User{DN: "CN=John Doe,OU=People,DC=corp", ID: "jdoe", Name: "John Doe"}}
+	identity, err := NewLDAP(client, WithProfileLookup(directory, "department")).Lookup("jdoe")
+	if err != nil {
+		t.Fatalf("Lookup() unexpected error: %v", err)
+	}
+	if identity.ID != "jdoe" || identity.DN != directory.user.DN || identity.Backend != BackendLDAP || len(identity.Methods) != 0 {
+		t.Errorf("Lookup() identity = %+v", identity)
+	}
+
+	tests := []struct {
+		name      string
+		directory *mockUserDirectory
+		wantErr   error
+	}{
+		{"disabled", &mockUserDirectory{user: &ldap.User{ID: "jdoe", Disabled: true}}, ErrInvalidCredentials},
+		{"not found", &mockUserDirectory{err: ldap.ErrNotFound}, ErrInvalidCredentials},
+		{"unavailable", &mockUserDirectory{err: ldap.ErrUnavailable}, ErrUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewLDAP(client, WithProfileLookup(tt.directory)).Lookup("jdoe"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Lookup() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewLDAP(client).Lookup("jdoe"); err == nil {
+		t.Error("Lookup() without profile directory expected error")
+	}
+}
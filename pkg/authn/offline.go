@@ -0,0 +1,158 @@
+// pkg/authn/offline.go
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+// Defaults for OfflineConfig
+const (
+	DefaultOfflineTTL        = 24 * time.Hour
+	DefaultOfflineMaxEntries = 10000
+)
+
+// AMROffline marks an identity verified against the offline cache
+const AMROffline = "offline"
+
+// OfflineConfig tunes the offline verification cache
+type OfflineConfig struct {
+	// TTL bounds how long after the last online login a cached verifier may be used
+	TTL time.Duration
+	// MaxEntries bounds memory use; the oldest verifier is evicted when full
+	MaxEntries int
+}
+
+type offlineEntry struct {
+	verifier string
+	identity Identity
+	storedAt time.Time
+}
+
+// Offline wraps an Authenticator with a cache of password verifiers, much
+// like Windows cached logons. After each successful online login it stores
+// a slow salted hash (Argon2id) of the password. The cache is consulted only
+// when the wrapped backend reports ErrUnavailable, and any online rejection
+// for a user drops that user's verifier. Entries are keyed by CanonicalID,
+// so every spelling of an account shares one verifier.
+//
+// Identities verified from the cache have Offline set and AMROffline added
+// to Methods; callers should issue them short-lived tokens.
+type Offline struct {
+	next    Authenticator
+	config  OfflineConfig
+	mu      sync.Mutex
+	entries map[string]*offlineEntry
+	hash    func(password string) (string, error)
+	now     func() time.Time
+}
+
+func NewOffline(next Authenticator, config OfflineConfig) *Offline {
+	if config.TTL <= 0 {
+		config.TTL = DefaultOfflineTTL
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultOfflineMaxEntries
+	}
+
+	return &Offline{
+		next:    next,
+		config:  config,
+		entries: make(map[string]*offlineEntry),
+		hash:    passwd.Hash,
+		now:     time.Now,
+	}
+}
+
+func (o *Offline) Authenticate(username, password string) (*Identity, error) {
+	key := CanonicalID(username)
+
+	identity, err := o.next.Authenticate(username, password)
+	switch {
+	case err == nil:
+		o.store(key, identity, password)
+		return identity, nil
+	case errors.Is(err, ErrUnavailable):
+		return o.verify(key, password, err)
+	default:
+		o.invalidate(key)
+		return nil, err
+	}
+}
+
+// Invalidate drops the cached verifier for username, however it is spelled
+func (o *Offline) Invalidate(username string) {
+	o.invalidate(CanonicalID(username))
+}
+
+func (o *Offline) store(key string, identity *Identity, password string) {
+	verifier, err := o.hash(password)
+	if err != nil {
+		// Losing the verifier only disables offline login for this user
+		o.invalidate(key)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.entries[key]; !ok && len(o.entries) >= o.config.MaxEntries {
+		o.evictOldest()
+	}
+	o.entries[key] = &offlineEntry{
+		verifier: verifier,
+		identity: *identity,
+		storedAt: o.now(),
+	}
+}
+
+// verify checks password against the cached verifier. onlineErr is
+// returned unchanged when no usable verifier exists so the caller can still
+// fall back to other outage handling.
+func (o *Offline) verify(key, password string, onlineErr error) (*Identity, error) {
+	o.mu.Lock()
+	entry, ok := o.entries[key]
+	if ok && o.now().Sub(entry.storedAt) >= o.config.TTL {
+		delete(o.entries, key)
+		ok = false
+	}
+	o.mu.Unlock()
+
+	if !ok {
+		return nil, onlineErr
+	}
+
+	match, err := passwd.Verify(entry.verifier, password)
+	if err != nil {
+		return nil, fmt.Errorf("offline verifier: %w", err)
+	}
+	if !match {
+		return nil, fmt.Errorf("%w: offline verification failed", ErrInvalidCredentials)
+	}
+
+	identity := entry.identity
+	identity.Offline = true
+	identity.Methods = append(append([]string{}, identity.Methods...), AMROffline)
+	return &identity, nil
+}
+
+func (o *Offline) invalidate(key string) {
+	o.mu.Lock()
+	delete(o.entries, key)
+	o.mu.Unlock()
+}
+
+func (o *Offline) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range o.entries {
+		if oldestKey == "" || e.storedAt.Before(oldest) {
+			oldestKey, oldest = k, e.storedAt
+		}
+	}
+	delete(o.entries, oldestKey)
+}
@@ -0,0 +1,147 @@
+// pkg/authn/offline_test.go
+package authn
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+// switchableBackend accepts only its password and can be taken offline
+type switchableBackend struct {
+	password string
+	down     bool
+}
+
+func (b *switchableBackend) Authenticate(username, password string) (*Identity, error) {
+	if b.down {
+		return nil, ErrUnavailable
+	}
+	if password != b.password {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{ID: "jdoe", Username: username, Groups: []string{"staff"}, Methods: []string{"pwd"}}, nil
+}
+
+func newTestOffline(backend Authenticator, config OfflineConfig) (*Offline, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewOffline(backend, config)
+	cache.hash = func(password string) (string, error) {
+		return passwd.HashWithParams(password, passwd.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	}
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func TestOfflineUsedOnlyDuringOutage(t *testing.T) {
+	backend := &switchableBackend{password: "s3cret"}
+	cache, _ := newTestOffline(backend, OfflineConfig{})
+
+	identity, err := cache.Authenticate("jdoe@corp", "s3cret")
+	if err != nil || identity.Offline {
+		t.Fatalf("online login = %+v, %v; want online success", identity, err)
+	}
+
+	backend.down = true
+	identity, err = cache.Authenticate("JDOE@corp", "s3cret")
+	if err != nil {
+		t.Fatalf("offline login unexpected error: %v", err)
+	}
+	if !identity.Offline || identity.ID != "jdoe" {
+		t.Errorf("offline identity = %+v", identity)
+	}
+	if len(identity.Methods) != 2 || identity.Methods[1] != AMROffline {
+		t.Errorf("offline methods = %v, want [pwd offline]", identity.Methods)
+	}
+
+	if _, err := cache.Authenticate("jdoe@corp", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("offline wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOfflineWithoutVerifierReportsOutage(t *testing.T) {
+	backend := &switchableBackend{password: "s3cret", down: true}
+	cache, _ := newTestOffline(backend, OfflineConfig{})
+
+	if _, err := cache.Authenticate("jdoe@corp", "s3cret"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestOfflineInvalidatedOnOnlineFailure(t *testing.T) {
+	backend := &switchableBackend{password: "s3cret"}
+	cache, _ := newTestOffline(backend, OfflineConfig{})
+
+	cache.Authenticate("jdoe@corp", "s3cret")
+
+	// The password was changed in the directory; the old one is now rejected online
+	backend.password = "n3w"
+	if _, err := cache.Authenticate("jdoe@corp", "s3cret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("error = %v, want ErrInvalidCredentials", err)
+	}
+
+	backend.down = true
+	if _, err := cache.Authenticate("jdoe@corp", "s3cret"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error = %v, want ErrUnavailable after invalidation", err)
+	}
+}
+
+func TestOfflineInvalidatedAcrossSpellings(t *testing.T) {
+	for _, spelling := range []string{`CORP\jdoe`, "JDoe@corp", " jdoe "} {
+		t.Run(spelling, func(t *testing.T) {
+			backend := &switchableBackend{password: "s3cret"}
+			cache, _ := newTestOffline(backend, OfflineConfig{})
+			cache.Authenticate("jdoe@corp", "s3cret")
+
+			// A failure under another spelling of the account drops the verifier
+			backend.password = "n3w"
+			cache.Authenticate(spelling, "s3cret")
+
+			backend.down = true
+			if _, err := cache.Authenticate("jdoe@corp", "s3cret"); !errors.Is(err, ErrUnavailable) {
+				t.Errorf("error = %v, want ErrUnavailable after invalidation", err)
+			}
+		})
+	}
+
+	backend := &switchableBackend{password: "s3cret"}
+	cache, _ := newTestOffline(backend, OfflineConfig{})
+	cache.Authenticate("jdoe@corp", "s3cret")
+	cache.Invalidate(`CORP\JDOE`)
+	backend.down = true
+	if _, err := cache.Authenticate("jdoe@corp", "s3cret"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error = %v, want ErrUnavailable after Invalidate", err)
+	}
+}
+
+func TestOfflineTTL(t *testing.T) {
+	backend := &switchableBackend{password: "s3cret"}
+	cache, now := newTestOffline(backend, OfflineConfig{TTL: time.Hour})
+
+	cache.Authenticate("jdoe@corp", "s3cret")
+	backend.down = true
+
+	*now = now.Add(time.Hour)
+	if _, err := cache.Authenticate("jdoe@corp", "s3cret"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error = %v, want ErrUnavailable once the verifier expired", err)
+	}
+}
+
+func TestOfflineMaxEntries(t *testing.T) {
+	backend := &switchableBackend{password: "s3cret"}
+	cache, now := newTestOffline(backend, OfflineConfig{MaxEntries: 1})
+
+	cache.Authenticate("first@corp", "s3cret")
+	*now = now.Add(time.Minute)
+	cache.Authenticate("second@corp", "s3cret")
+
+	backend.down = true
+	if _, err := cache.Authenticate("first@corp", "s3cret"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("oldest entry should have been evicted, got %v", err)
+	}
+	if _, err := cache.Authenticate("second@corp", "s3cret"); err != nil {
+		t.Errorf("newest entry should remain, got %v", err)
+	}
+}
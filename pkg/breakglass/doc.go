@@ -0,0 +1,30 @@
+// Package breakglass provides emergency local accounts for use when the
+// directory cannot be reached.
+//
+// Accounts are read from a JSON file maintained by operators:
+//
+//	{
+//	  "accounts": [
+//	    {
+//	      "username": "oncall-primary",
+//	      "password_hash": "$argon2id$v=19$m=65536,t=3,p=2$...",
+//	      "roles": ["operator"],
+//	      "expires_at": "2025-06-30T00:00:00Z",
+//	      "designated": false
+//	    }
+//	  ]
+//	}
+//
+// By default an account is only consulted when the LDAP layer reports an
+// outage. Accounts marked "designated" are dedicated break-glass identities
+// that never exist in the directory and are always verified locally.
+//
+// Every account must carry an expiry so forgotten credentials stop working,
+// and callers are expected to audit every use loudly.
+//
+// Basic usage:
+//
+//	store, err := breakglass.Open("/etc/auth-service/breakglass.json")
+//
+//	account, err := store.Authenticate("oncall-primary", password)
+package breakglass
@@ -0,0 +1,142 @@
+// pkg/breakglass/store.go
+package breakglass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+var (
+	ErrUnknownAccount     = errors.New("unknown break-glass account")
+	ErrInvalidCredentials = errors.New("invalid break-glass credentials")
+	ErrExpired            = errors.New("break-glass account expired")
+)
+
+// dummyHash is verified for unknown accounts so response timing does not
+// reveal which account names exist
+const dummyHash = "$argon2id$v=19$m=65536,t=3,p=2$D6z7Pdt6EOnbI5NUncNr4A$+2M5AkfZD8NG7Imv3H1Ioh4fWC8gFvPZ6hsUWjbN4RM"
+
+// Account is a local credential
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Roles        []string  `json:"roles"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// Designated accounts are always verified locally, never against LDAP
+	Designated bool `json:"designated"`
+}
+
+// HasRole reports whether role is one of the account's allowed roles
+func (a *Account) HasRole(role string) bool {
+	for _, r := range a.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type file struct {
+	Accounts []Account `json:"accounts"`
+}
+
+// Store holds the accounts loaded from a file. It is safe for concurrent use.
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	accounts map[string]*Account
+	now      func() time.Time
+}
+
+// Open loads the accounts file at path
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the accounts file. On error the previously loaded accounts are kept.
+func (s *Store) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read break-glass accounts: %w", err)
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse break-glass accounts: %w", err)
+	}
+
+	accounts := make(map[string]*Account, len(f.Accounts))
+	for i := range f.Accounts {
+		a := f.Accounts[i]
+		key := normalize(a.Username)
+		if key == "" {
+			return fmt.Errorf("break-glass account %d has no username", i)
+		}
+		if a.PasswordHash == "" {
+			return fmt.Errorf("break-glass account %q has no password hash", a.Username)
+		}
+		if a.ExpiresAt.IsZero() {
+			return fmt.Errorf("break-glass account %q has no expiry", a.Username)
+		}
+		if _, ok := accounts[key]; ok {
+			return fmt.Errorf("duplicate break-glass account %q", a.Username)
+		}
+		accounts[key] = &a
+	}
+
+	s.mu.Lock()
+	s.accounts = accounts
+	s.mu.Unlock()
+	return nil
+}
+
+// IsDesignated reports whether username is a dedicated break-glass account
+// that must bypass the directory
+func (s *Store) IsDesignated(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.accounts[normalize(username)]
+	return ok && a.Designated
+}
+
+// Authenticate verifies password against the local account
+func (s *Store) Authenticate(username, password string) (*Account, error) {
+	s.mu.RLock()
+	a, ok := s.accounts[normalize(username)]
+	s.mu.RUnlock()
+
+	if !ok {
+		passwd.Verify(dummyHash, password)
+		return nil, ErrUnknownAccount
+	}
+
+	match, err := passwd.Verify(a.PasswordHash, password)
+	if err != nil {
+		return nil, fmt.Errorf("break-glass account %q: %w", a.Username, err)
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+	if !s.now().Before(a.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	account := *a
+	return &account, nil
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
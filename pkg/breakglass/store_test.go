@@ -0,0 +1,147 @@
+// pkg/breakglass/store_test.go
+package breakglass
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+var testParams = passwd.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func writeAccounts(t *testing.T, accounts []Account) string {
+	t.Helper()
+	data, err := json.Marshal(file{Accounts: accounts})
+	if err != nil {
+		t.Fatalf("marshal accounts: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "breakglass.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write accounts: %v", err)
+	}
+	return path
+}
+
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := passwd.HashWithParams(password, testParams)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	return hash
+}
+
+func TestAuthenticate(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	path := writeAccounts(t, []Account{
+		{Username: "Oncall", PasswordHash: testHash(t, "s3cret"), Roles: []string{"operator"}, ExpiresAt: future},
+		{Username: "expired", PasswordHash: testHash(t, "s3cret"), ExpiresAt: time.Now().Add(-time.Hour)},
+	})
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{name: "valid", username: "oncall", password: "s3cret"},
+		{name: "wrong password", username: "oncall", password: "nope", wantErr: ErrInvalidCredentials},
+		{name: "unknown account", username: "nobody", password: "s3cret", wantErr: ErrUnknownAccount},
+		{name: "expired", username: "expired", password: "s3cret", wantErr: ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := store.Authenticate(tt.username, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() unexpected error: %v", err)
+			}
+			if !account.HasRole("operator") || account.HasRole("admin") {
+				t.Errorf("Authenticate() roles = %v", account.Roles)
+			}
+		})
+	}
+}
+
+func TestIsDesignated(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	path := writeAccounts(t, []Account{
+		{Username: "bg-root", PasswordHash: "$2y$x", ExpiresAt: future, Designated: true},
+		{Username: "jdoe", PasswordHash: "$2y$x", ExpiresAt: future},
+	})
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+
+	if !store.IsDesignated("BG-ROOT") {
+		t.Error("bg-root should be designated")
+	}
+	if store.IsDesignated("jdoe") || store.IsDesignated("nobody") {
+		t.Error("only designated accounts should be reported")
+	}
+}
+
+func TestOpenValidation(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		accounts []Account
+	}{
+		{"missing expiry", []Account{{Username: "a", PasswordHash: "$2y$x"}}},
+		{"missing hash", []Account{{Username: "a", ExpiresAt: future}}},
+		{"missing username", []Account{{PasswordHash: "$2y$x", ExpiresAt: future}}},
+		{"duplicate", []Account{
+			{Username: "a", PasswordHash: "$2y$x", ExpiresAt: future},
+			{Username: "A", PasswordHash: "$2y$x", ExpiresAt: future},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(writeAccounts(t, tt.accounts)); err == nil {
+				t.Error("Open() expected error")
+			}
+		})
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Open() expected error for missing file")
+	}
+}
+
+func TestReloadKeepsAccountsOnError(t *testing.T) {
+	path := writeAccounts(t, []Account{
+		{Username: "oncall", PasswordHash: testHash(t, "s3cret"), ExpiresAt: time.Now().Add(time.Hour)},
+	})
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+
+	os.WriteFile(path, []byte("{not json"), 0o600)
+	if err := store.Reload(); err == nil {
+		t.Fatal("Reload() expected error")
+	}
+
+	if _, err := store.Authenticate("oncall", "s3cret"); err != nil {
+		t.Errorf("Authenticate() after failed reload: %v", err)
+	}
+}
@@ -2,11 +2,21 @@
 package ldap
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	ldapv3 "github.com/go-ldap/ldap/v3"
 )
 
+// ErrInvalidCredentials is returned when the directory rejects the username
+// or password, as opposed to a failure to reach the directory at all
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrUnavailable is returned when no domain controller could be reached.
+// It marks transport-level failures, never a rejected credential.
+var ErrUnavailable = errors.New("directory unavailable")
+
 type LookupService interface {
 	LookupServer(domain string) (string, error)
 }
@@ -15,11 +25,29 @@ type Config struct {
 	Port      string
 	Domain    string
 	LookupSvc LookupService
+
+	// Directory queries bind with a service account rather than the end
+	// user. BaseDN is the search root, e.g. "DC=example,DC=com".
+	BaseDN       string
+	BindDN       string
+	BindPassword string
+
+	// ConnectAttempts is how many domain controllers are tried before the
+	// directory is reported unavailable. Defaults to DefaultConnectAttempts.
+	ConnectAttempts int
+
+	// Limits applied to every directory search. Zero values fall back to
+	// the package defaults.
+	SizeLimit int
+	TimeLimit time.Duration
+	PageSize  uint32
 }
 
 // Add LDAP interface for mocking
 type ldapConnection interface {
 	Bind(username, password string) error
+	Search(req *ldapv3.SearchRequest) (*ldapv3.SearchResult, error)
+	SearchWithPaging(req *ldapv3.SearchRequest, pagingSize uint32) (*ldapv3.SearchResult, error)
 	Close() error
 }
 
@@ -46,25 +74,48 @@ func NewClient(config Config, logger Logger) *Client {
 	}
 }
 
+// DefaultConnectAttempts is the number of domain controllers tried per connection
+const DefaultConnectAttempts = 3
+
+// connect resolves a domain controller and opens an unauthenticated connection
+// to it, moving on to another controller when one cannot be reached
+func (c *Client) connect() (ldapConnection, error) {
+	attempts := c.config.ConnectAttempts
+	if attempts <= 0 {
+		attempts = DefaultConnectAttempts
+	}
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		// Get LDAP server
+		host, err := c.config.LookupSvc.LookupServer(c.config.Domain)
+		if err != nil {
+			c.logger.Error("LDAP lookup failed", "error", err)
+			return nil, fmt.Errorf("failed to lookup LDAP server: %w: %w", ErrUnavailable, err)
+		}
+
+		// Connect to LDAP
+		ldapURL := fmt.Sprintf("ldaps://%s:%s", host, c.config.Port)
+		conn, err := c.dialLDAP(ldapURL)
+		if err == nil {
+			return conn, nil
+		}
+		c.logger.Error("Failed to connect to LDAP", "host", host, "error", err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to connect to LDAP: %w: %w", ErrUnavailable, lastErr)
+}
+
 func (c *Client) Authenticate(username, password string) (*AuthResult, error) {
 	if username == "" || password == "" {
 		c.logger.Error("Empty credentials provided")
 		return &AuthResult{Success: false}, fmt.Errorf("empty credentials")
 	}
 
-	// Get LDAP server
-	host, err := c.config.LookupSvc.LookupServer(c.config.Domain)
-	if err != nil {
-		c.logger.Error("LDAP lookup failed", "error", err)
-		return &AuthResult{Success: false}, fmt.Errorf("failed to lookup LDAP server: %w", err)
-	}
-
-	// Connect to LDAP
-	ldapURL := fmt.Sprintf("ldaps://%s:%s", host, c.config.Port)
-	conn, err := c.dialLDAP(ldapURL)
+	conn, err := c.connect()
 	if err != nil {
-		c.logger.Error("Failed to connect to LDAP", "error", err)
-		return &AuthResult{Success: false}, fmt.Errorf("failed to connect to LDAP: %w", err)
+		return &AuthResult{Success: false}, err
 	}
 	defer conn.Close()
 
@@ -72,6 +123,12 @@ func (c *Client) Authenticate(username, password string) (*AuthResult, error) {
 	err = conn.Bind(username, password)
 	if err != nil {
 		c.logger.Error("Authentication failed", "error", err)
+		if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultInvalidCredentials) {
+			return &AuthResult{Success: false}, fmt.Errorf("authentication failed: %w: %w", ErrInvalidCredentials, err)
+		}
+		if ldapv3.IsErrorWithCode(err, ldapv3.ErrorNetwork) {
+			return &AuthResult{Success: false}, fmt.Errorf("authentication failed: %w: %w", ErrUnavailable, err)
+		}
 		return &AuthResult{Success: false}, fmt.Errorf("authentication failed: %w", err)
 	}
 
@@ -2,8 +2,11 @@
 package ldap
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
 )
 
 // Mock LookupService
@@ -36,15 +39,33 @@ func (m *mockLogger) Error(msg string, keyvals ...interface{}) {
 // Add mock LDAP connection
 type mockLDAPConn struct {
 	shouldError bool
+	bindErr     error
+	entries     []*ldapv3.Entry
+	searchErr   error
+	binds       []string
+	requests    []*ldapv3.SearchRequest
 }
 
 func (m *mockLDAPConn) Bind(username, password string) error {
+	m.binds = append(m.binds, username)
+	if m.bindErr != nil {
+		return m.bindErr
+	}
 	if m.shouldError {
 		return fmt.Errorf("bind error")
 	}
 	return nil
 }
 
+func (m *mockLDAPConn) Search(req *ldapv3.SearchRequest) (*ldapv3.SearchResult, error) {
+	m.requests = append(m.requests, req)
+	return &ldapv3.SearchResult{Entries: m.entries}, m.searchErr
+}
+
+func (m *mockLDAPConn) SearchWithPaging(req *ldapv3.SearchRequest, pagingSize uint32) (*ldapv3.SearchResult, error) {
+	return m.Search(req)
+}
+
 func (m *mockLDAPConn) Close() error {
 	return nil
 }
@@ -193,6 +214,114 @@ func TestAuthenticate(t *testing.T) {
 	}
 }
 
+func TestAuthenticateInvalidCredentials(t *testing.T) {
+	tests := []struct {
+		name            string
+		bindErr         error
+		wantInvalid     bool
+		wantUnavailable bool
+	}{
+		{
+			name:        "invalid credentials",
+			bindErr:     ldapv3.NewError(ldapv3.LDAPResultInvalidCredentials, errors.New("bad password")),
+			wantInvalid: true,
+		},
+		{
+			name:            "network error",
+			bindErr:         ldapv3.NewError(ldapv3.ErrorNetwork, errors.New("connection reset")),
+			wantUnavailable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockLDAPConn{bindErr: tt.bindErr}
+			client := NewClient(Config{
+				Port:      "3269",
+				Domain:    "example.com",
+				LookupSvc: &mockLookupService{host: "ldap.example.com"},
+			}, &mockLogger{})
+			client.dialLDAP = func(addr string) (ldapConnection, error) {
+				return conn, nil
+			}
+
+			_, err := client.Authenticate("testuser", "testpass")
+			if err == nil {
+				t.Fatal("Authenticate() expected error")
+			}
+			if got := errors.Is(err, ErrInvalidCredentials); got != tt.wantInvalid {
+				t.Errorf("errors.Is(err, ErrInvalidCredentials) = %v, want %v", got, tt.wantInvalid)
+			}
+			if got := errors.Is(err, ErrUnavailable); got != tt.wantUnavailable {
+				t.Errorf("errors.Is(err, ErrUnavailable) = %v, want %v", got, tt.wantUnavailable)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUnavailable(t *testing.T) {
+	tests := []struct {
+		name    string
+		lookup  *mockLookupService
+		dialErr error
+	}{
+		{
+			name:   "lookup failure",
+			lookup: &mockLookupService{err: fmt.Errorf("no SRV records")},
+		},
+		{
+			name:    "dial failure",
+			lookup:  &mockLookupService{host: "ldap.example.com"},
+			dialErr: fmt.Errorf("connection refused"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(Config{
+				Port:      "3269",
+				Domain:    "example.com",
+				LookupSvc: tt.lookup,
+			}, &mockLogger{})
+			client.dialLDAP = func(addr string) (ldapConnection, error) {
+				if tt.dialErr != nil {
+					return nil, tt.dialErr
+				}
+				return &mockLDAPConn{}, nil
+			}
+
+			_, err := client.Authenticate("testuser", "testpass")
+			if !errors.Is(err, ErrUnavailable) {
+				t.Errorf("Authenticate() error = %v, want ErrUnavailable", err)
+			}
+		})
+	}
+}
+
+func TestConnectTriesOtherControllers(t *testing.T) {
+	dials := 0
+	client := NewClient(Config{
+		Port:            "3269",
+		Domain:          "example.com",
+		LookupSvc:       &mockLookupService{host: "ldap.example.com"},
+		ConnectAttempts: 3,
+	}, &mockLogger{})
+	client.dialLDAP = func(addr string) (ldapConnection, error) {
+		dials++
+		if dials < 3 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return &mockLDAPConn{}, nil
+	}
+
+	if _, err := client.Authenticate("testuser", "testpass"); err != nil {
+		t.Fatalf("Authenticate() unexpected error: %v", err)
+	}
+	if dials != 3 {
+		t.Errorf("dials = %d, want 3", dials)
+	}
+}
+
 // TestAuthenticateIntegration performs integration tests with actual LDAP server
 // This test is skipped unless explicitly enabled
 func TestAuthenticateIntegration(t *testing.T) {
@@ -0,0 +1,332 @@
+// pkg/ldap/directory.go
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// Defaults applied to directory searches when Config leaves the limits unset
+const (
+	DefaultSizeLimit = 100
+	DefaultTimeLimit = 10 * time.Second
+	DefaultPageSize  = 500
+)
+
+// ErrNotFound is returned when a directory lookup matches no entry
+var ErrNotFound = errors.New("directory entry not found")
+
+// Attribute names used to populate the core fields of User
+const (
+	AttrID       = "sAMAccountName"
+	AttrName     = "displayName"
+	AttrEmail    = "mail"
+	AttrMemberOf = "memberOf"
+
+	AttrAccountControl = "userAccountControl"
+	AttrLockoutTime    = "lockoutTime"
+)
+
+var coreAttributes = []string{AttrID, AttrName, AttrEmail, AttrMemberOf, AttrAccountControl, AttrLockoutTime}
+
+// accountDisable is the ADS_UF_ACCOUNTDISABLE flag of userAccountControl
+const accountDisable = 0x2
+
+// User is a person entry returned by the directory query API
+type User struct {
+	DN         string
+	ID         string
+	Name       string
+	Email      string
+	Groups     []string
+	Attributes map[string][]string
+	// Disabled is set when the account is disabled in the directory
+	Disabled bool
+	// Locked is set while the directory records a lockout. lockoutTime is
+	// only cleared by the next successful bind, so an account whose lockout
+	// has expired still reads as locked until the user logs in again.
+	Locked bool
+}
+
+// SearchField selects which attribute a prefix search matches against
+type SearchField string
+
+const (
+	SearchByID    SearchField = "id"
+	SearchByName  SearchField = "name"
+	SearchByEmail SearchField = "email"
+)
+
+// SearchRequest describes a prefix search for users
+type SearchRequest struct {
+	Field  SearchField
+	Prefix string
+	// Attributes lists extra attributes to return alongside the core fields
+	Attributes []string
+	// Limit caps the number of entries returned; it cannot exceed Config.SizeLimit
+	Limit int
+}
+
+// SearchResult holds the users matched by a search. Truncated is set when the
+// size or time limit cut the result short.
+type SearchResult struct {
+	Users     []*User
+	Truncated bool
+}
+
+// GetUser fetches a single user by account name (sAMAccountName)
+func (c *Client) GetUser(id string, attributes []string) (*User, error) {
+	if id == "" {
+		return nil, fmt.Errorf("user id cannot be empty")
+	}
+
+	filter := fmt.Sprintf("(&(objectCategory=person)(objectClass=user)(%s=%s))", AttrID, ldapv3.EscapeFilter(id))
+	return c.findOne(c.config.BaseDN, ldapv3.ScopeWholeSubtree, filter, attributes)
+}
+
+// GetUserByDN fetches a single user by distinguished name
+func (c *Client) GetUserByDN(dn string, attributes []string) (*User, error) {
+	if dn == "" {
+		return nil, fmt.Errorf("dn cannot be empty")
+	}
+
+	return c.findOne(dn, ldapv3.ScopeBaseObject, "(&(objectCategory=person)(objectClass=user))", attributes)
+}
+
+// SearchUsers finds users whose ID, display name or email starts with the given prefix
+func (c *Client) SearchUsers(req SearchRequest) (*SearchResult, error) {
+	if strings.TrimSpace(req.Prefix) == "" {
+		return nil, fmt.Errorf("search prefix cannot be empty")
+	}
+
+	var attr string
+	switch req.Field {
+	case SearchByID, "":
+		attr = AttrID
+	case SearchByName:
+		attr = AttrName
+	case SearchByEmail:
+		attr = AttrEmail
+	default:
+		return nil, fmt.Errorf("unsupported search field: %s", req.Field)
+	}
+
+	filter := fmt.Sprintf("(&(objectCategory=person)(objectClass=user)(%s=%s*))", attr, ldapv3.EscapeFilter(req.Prefix))
+	return c.search(filter, req.Attributes, req.Limit)
+}
+
+// GroupMembers lists the users that are direct members of a group. The group
+// may be given either as a distinguished name or as its common name.
+func (c *Client) GroupMembers(group string, attributes []string) (*SearchResult, error) {
+	if group == "" {
+		return nil, fmt.Errorf("group cannot be empty")
+	}
+
+	groupDN := group
+	if !strings.Contains(group, "=") {
+		dn, err := c.groupDN(group)
+		if err != nil {
+			return nil, err
+		}
+		groupDN = dn
+	}
+
+	filter := fmt.Sprintf("(&(objectCategory=person)(objectClass=user)(%s=%s))", AttrMemberOf, ldapv3.EscapeFilter(groupDN))
+	return c.search(filter, attributes, 0)
+}
+
+// serviceConnect opens a connection bound as the configured service account
+func (c *Client) serviceConnect() (ldapConnection, error) {
+	if c.config.BindDN == "" || c.config.BindPassword == "" {
+		return nil, fmt.Errorf("directory queries require a service account")
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(c.config.BindDN, c.config.BindPassword); err != nil {
+		conn.Close()
+		c.logger.Error("Service account bind failed", "error", err)
+		return nil, fmt.Errorf("service account bind failed: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (c *Client) groupDN(name string) (string, error) {
+	conn, err := c.serviceConnect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	req := ldapv3.NewSearchRequest(
+		c.config.BaseDN, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+		2, c.timeLimitSeconds(), false,
+		fmt.Sprintf("(&(objectClass=group)(cn=%s))", ldapv3.EscapeFilter(name)),
+		[]string{"dn"}, nil,
+	)
+
+	result, err := conn.Search(req)
+	if err != nil {
+		return "", fmt.Errorf("group lookup failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return "", ErrNotFound
+	}
+	if len(result.Entries) > 1 {
+		return "", fmt.Errorf("group name %q is ambiguous", name)
+	}
+
+	return result.Entries[0].DN, nil
+}
+
+func (c *Client) findOne(baseDN string, scope int, filter string, attributes []string) (*User, error) {
+	conn, err := c.serviceConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := ldapv3.NewSearchRequest(
+		baseDN, scope, ldapv3.NeverDerefAliases,
+		2, c.timeLimitSeconds(), false,
+		filter, withCoreAttributes(attributes), nil,
+	)
+
+	result, err := conn.Search(req)
+	if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchObject) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("directory search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrNotFound
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("directory search matched %d entries", len(result.Entries))
+	}
+
+	return entryToUser(result.Entries[0], attributes), nil
+}
+
+func (c *Client) search(filter string, attributes []string, limit int) (*SearchResult, error) {
+	conn, err := c.serviceConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sizeLimit := c.config.SizeLimit
+	if sizeLimit <= 0 {
+		sizeLimit = DefaultSizeLimit
+	}
+	if limit > 0 && limit < sizeLimit {
+		sizeLimit = limit
+	}
+
+	pageSize := c.config.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if int(pageSize) > sizeLimit {
+		pageSize = uint32(sizeLimit)
+	}
+
+	req := ldapv3.NewSearchRequest(
+		c.config.BaseDN, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+		sizeLimit, c.timeLimitSeconds(), false,
+		filter, withCoreAttributes(attributes), nil,
+	)
+
+	result, err := conn.SearchWithPaging(req, pageSize)
+	truncated := false
+	if err != nil {
+		// Limit errors still carry the entries received so far
+		if !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultSizeLimitExceeded) &&
+			!ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultTimeLimitExceeded) {
+			return nil, fmt.Errorf("directory search failed: %w", err)
+		}
+		truncated = true
+	}
+
+	out := &SearchResult{Truncated: truncated}
+	if result == nil {
+		return out, nil
+	}
+	for _, entry := range result.Entries {
+		if len(out.Users) == sizeLimit {
+			out.Truncated = true
+			break
+		}
+		out.Users = append(out.Users, entryToUser(entry, attributes))
+	}
+
+	return out, nil
+}
+
+func (c *Client) timeLimitSeconds() int {
+	limit := c.config.TimeLimit
+	if limit <= 0 {
+		limit = DefaultTimeLimit
+	}
+	return int(limit / time.Second)
+}
+
+func withCoreAttributes(extra []string) []string {
+	attrs := append([]string{}, coreAttributes...)
+	for _, a := range extra {
+		if !containsFold(attrs, a) {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+func entryToUser(entry *ldapv3.Entry, extra []string) *User {
+	user := &User{
+		DN:     entry.DN,
+		ID:     entry.GetAttributeValue(AttrID),
+		Name:   entry.GetAttributeValue(AttrName),
+		Email:  entry.GetAttributeValue(AttrEmail),
+		Groups: entry.GetAttributeValues(AttrMemberOf),
+	}
+
+	if uac, err := strconv.ParseInt(entry.GetAttributeValue(AttrAccountControl), 10, 64); err == nil {
+		user.Disabled = uac&accountDisable != 0
+	}
+	if lockout := entry.GetAttributeValue(AttrLockoutTime); lockout != "" && lockout != "0" {
+		user.Locked = true
+	}
+
+	for _, a := range extra {
+		if containsFold(coreAttributes, a) {
+			continue
+		}
+		if values := entry.GetEqualFoldAttributeValues(a); len(values) > 0 {
+			if user.Attributes == nil {
+				user.Attributes = make(map[string][]string)
+			}
+			user.Attributes[a] = values
+		}
+	}
+
+	return user
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
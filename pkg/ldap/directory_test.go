@@ -0,0 +1,252 @@
+// pkg/ldap/directory_test.go
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+func newDirectoryTestClient(conn *mockLDAPConn, config Config) *Client {
+	config.Port = "3269"
+	config.Domain = "example.com"
+	config.LookupSvc = &mockLookupService{host: "ldap.example.com"}
+	if config.BindDN == "" {
+		config.BindDN = "CN=svc-auth,OU=Service,DC=example,DC=com"
+		config.BindPassword = "svc-pass"
+	}
+	config.BaseDN = "DC=example,DC=com"
+
+	client := NewClient(config, &mockLogger{})
+	client.dialLDAP = func(addr string) (ldapConnection, error) {
+		return conn, nil
+	}
+	return client
+}
+
+func userEntry(id, name, email string) *ldapv3.Entry {
+	return ldapv3.NewEntry("CN="+name+",OU=People,DC=example,DC=com", map[string][]string{
+		AttrID:       {id},
+		AttrName:     {name},
+		AttrEmail:    {email},
+		AttrMemberOf: {"CN=Staff,OU=Groups,DC=example,DC=com"},
+		"department": {"Engineering"},
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		entries   []*ldapv3.Entry
+		searchErr error
+		wantErr   error
+		wantID    string
+	}{
+		{
+			name:    "found",
+			id:      "jdoe",
+			entries: []*ldapv3.Entry{userEntry("jdoe", "John Doe", "jdoe@example.com")},
+			wantID:  "jdoe",
+		},
+		{
+			name:    "not found",
+			id:      "nobody",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:      "no such object",
+			id:        "jdoe",
+			searchErr: ldapv3.NewError(ldapv3.LDAPResultNoSuchObject, errors.New("no such object")),
+			wantErr:   ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockLDAPConn{entries: tt.entries, searchErr: tt.searchErr}
+			client := newDirectoryTestClient(conn, Config{})
+
+			user, err := client.GetUser(tt.id, []string{"department"})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GetUser() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetUser() unexpected error: %v", err)
+			}
+
+			if user.ID != tt.wantID {
+				t.Errorf("GetUser() ID = %v, want %v", user.ID, tt.wantID)
+			}
+			if got := user.Attributes["department"]; len(got) != 1 || got[0] != "Engineering" {
+				t.Errorf("GetUser() department = %v, want [Engineering]", got)
+			}
+			if len(conn.binds) == 0 || conn.binds[0] != client.config.BindDN {
+				t.Errorf("expected service account bind, got %v", conn.binds)
+			}
+		})
+	}
+}
+
+func TestGetUserAccountStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		uac          string
+		lockout      string
+		wantDisabled bool
+		wantLocked   bool
+	}{
+		{name: "active", uac: "512", lockout: "0"},
+		{name: "disabled", uac: "514", wantDisabled: true},
+		{name: "locked", uac: "512", lockout: "133497000000000000", wantLocked: true},
+		{name: "no status attributes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := userEntry("jdoe", "John Doe", "jdoe@example.com")
+			if tt.uac != "" {
+				entry.Attributes = append(entry.Attributes, ldapv3.NewEntryAttribute(AttrAccountControl, []string{tt.uac}))
+			}
+			if tt.lockout != "" {
+				entry.Attributes = append(entry.Attributes, ldapv3.NewEntryAttribute(AttrLockoutTime, []string{tt.lockout}))
+			}
+			client := newDirectoryTestClient(&mockLDAPConn{entries: []*ldapv3.Entry{entry}}, Config{})
+
+			user, err := client.GetUser("jdoe", nil)
+			if err != nil {
+				t.Fatalf("GetUser() unexpected error: %v", err)
+			}
+			if user.Disabled != tt.wantDisabled || user.Locked != tt.wantLocked {
+				t.Errorf("Disabled, Locked = %v, %v, want %v, %v", user.Disabled, user.Locked, tt.wantDisabled, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestGetUserRequiresServiceAccount(t *testing.T) {
+	conn := &mockLDAPConn{}
+	client := newDirectoryTestClient(conn, Config{})
+	client.config.BindPassword = ""
+
+	if _, err := client.GetUser("jdoe", nil); err == nil {
+		t.Error("expected error without service account credentials")
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	tests := []struct {
+		name          string
+		req           SearchRequest
+		entries       []*ldapv3.Entry
+		searchErr     error
+		wantErr       bool
+		wantCount     int
+		wantTruncated bool
+		wantFilter    string
+	}{
+		{
+			name:       "by name",
+			req:        SearchRequest{Field: SearchByName, Prefix: "John"},
+			entries:    []*ldapv3.Entry{userEntry("jdoe", "John Doe", "jdoe@example.com")},
+			wantCount:  1,
+			wantFilter: "(displayName=John*)",
+		},
+		{
+			name:       "by email escapes filter",
+			req:        SearchRequest{Field: SearchByEmail, Prefix: "j*)(uid=*"},
+			wantFilter: `(mail=j\2a\29\28uid=\2a*)`,
+		},
+		{
+			name: "limit truncates",
+			req:  SearchRequest{Prefix: "j", Limit: 1},
+			entries: []*ldapv3.Entry{
+				userEntry("jdoe", "John Doe", "jdoe@example.com"),
+				userEntry("jroe", "Jane Roe", "jroe@example.com"),
+			},
+			wantCount:     1,
+			wantTruncated: true,
+		},
+		{
+			name:          "size limit exceeded keeps partial results",
+			req:           SearchRequest{Prefix: "j"},
+			entries:       []*ldapv3.Entry{userEntry("jdoe", "John Doe", "jdoe@example.com")},
+			searchErr:     ldapv3.NewError(ldapv3.LDAPResultSizeLimitExceeded, errors.New("size limit")),
+			wantCount:     1,
+			wantTruncated: true,
+		},
+		{
+			name:    "empty prefix",
+			req:     SearchRequest{Prefix: " "},
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			req:     SearchRequest{Field: "phone", Prefix: "555"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockLDAPConn{entries: tt.entries, searchErr: tt.searchErr}
+			client := newDirectoryTestClient(conn, Config{})
+
+			result, err := client.SearchUsers(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SearchUsers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(result.Users) != tt.wantCount {
+				t.Errorf("SearchUsers() returned %d users, want %d", len(result.Users), tt.wantCount)
+			}
+			if result.Truncated != tt.wantTruncated {
+				t.Errorf("SearchUsers() truncated = %v, want %v", result.Truncated, tt.wantTruncated)
+			}
+			if tt.wantFilter != "" && !strings.Contains(conn.requests[0].Filter, tt.wantFilter) {
+				t.Errorf("SearchUsers() filter = %v, want it to contain %v", conn.requests[0].Filter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	conn := &mockLDAPConn{entries: []*ldapv3.Entry{userEntry("jdoe", "John Doe", "jdoe@example.com")}}
+	client := newDirectoryTestClient(conn, Config{})
+
+	result, err := client.GroupMembers("CN=Staff,OU=Groups,DC=example,DC=com", nil)
+	if err != nil {
+		t.Fatalf("GroupMembers() unexpected error: %v", err)
+	}
+	if len(result.Users) != 1 {
+		t.Errorf("GroupMembers() returned %d users, want 1", len(result.Users))
+	}
+	if !strings.Contains(conn.requests[0].Filter, "memberOf=CN=Staff") {
+		t.Errorf("GroupMembers() filter = %v", conn.requests[0].Filter)
+	}
+}
+
+func TestSearchLimits(t *testing.T) {
+	conn := &mockLDAPConn{}
+	client := newDirectoryTestClient(conn, Config{SizeLimit: 25})
+
+	if _, err := client.SearchUsers(SearchRequest{Prefix: "j", Limit: 500}); err != nil {
+		t.Fatalf("SearchUsers() unexpected error: %v", err)
+	}
+
+	req := conn.requests[0]
+	if req.SizeLimit != 25 {
+		t.Errorf("SizeLimit = %d, want 25", req.SizeLimit)
+	}
+	if req.TimeLimit != int(DefaultTimeLimit.Seconds()) {
+		t.Errorf("TimeLimit = %d, want %d", req.TimeLimit, int(DefaultTimeLimit.Seconds()))
+	}
+}
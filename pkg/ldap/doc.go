@@ -6,6 +6,7 @@
 // The package provides:
 //   - LDAP server connection management
 //   - User authentication
+//   - Directory queries (user lookup, prefix search, group members) via a service account
 //   - Secure TLS connections
 //   - Platform-independent server resolution
 //
@@ -23,6 +24,11 @@
 //	    log.Fatal(err)
 //	}
 //
+// Directory queries require BaseDN, BindDN and BindPassword:
+//
+//	user, err := client.GetUser("jdoe", []string{"department"})
+//	result, err := client.SearchUsers(ldap.SearchRequest{Field: ldap.SearchByName, Prefix: "John"})
+//
 // Security Considerations:
 //   - All connections use LDAPS (LDAP over TLS)
 //   - Credentials are never logged
@@ -0,0 +1,159 @@
+// pkg/mfa/challenge.go
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults for Challenges
+const (
+	DefaultChallengeLifetime = 5 * time.Minute
+	DefaultMaxAttempts       = 5
+)
+
+// ErrInvalidChallenge is returned for unknown, expired, used and exhausted
+// challenge tokens
+var ErrInvalidChallenge = errors.New("invalid MFA challenge")
+
+// challengeBytes is the entropy of a challenge token
+const challengeBytes = 32
+
+// maxChallenges bounds memory use; expired challenges are pruned once it is
+// reached
+const maxChallenges = 100000
+
+// Challenge is a login whose first factor passed, waiting for the second
+type Challenge struct {
+	UserID string
+	// Login holds whatever the caller needs to finish the login
+	Login     interface{}
+	ExpiresAt time.Time
+	attempts  int
+}
+
+// Challenges hands out the opaque tokens that carry a login from the first
+// factor to the second. A token is good for one successful verification
+// and a few wrong codes. It is safe for concurrent use.
+type Challenges struct {
+	mu          sync.Mutex
+	challenges  map[string]*Challenge
+	lifetime    time.Duration
+	maxAttempts int
+	now         func() time.Time
+}
+
+// NewChallenges keeps challenges in memory, which only works for a single
+// instance. Zero values select the defaults.
+func NewChallenges(lifetime time.Duration, maxAttempts int) *Challenges {
+	if lifetime <= 0 {
+		lifetime = DefaultChallengeLifetime
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return &Challenges{
+		challenges:  make(map[string]*Challenge),
+		lifetime:    lifetime,
+		maxAttempts: maxAttempts,
+		now:         time.Now,
+	}
+}
+
+// Issue stores a challenge for userID and returns its token
+func (c *Challenges) Issue(userID string, login interface{}) (string, error) {
+	buf := make([]byte, challengeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate MFA challenge: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.challenges) >= maxChallenges {
+		c.prune()
+	}
+	c.challenges[hashChallenge(token)] = &Challenge{
+		UserID:    userID,
+		Login:     login,
+		ExpiresAt: c.now().Add(c.lifetime),
+	}
+	return token, nil
+}
+
+// Lookup returns the challenge of a token without using it up
+func (c *Challenges) Lookup(token string) (*Challenge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, err := c.get(token)
+	if err != nil {
+		return nil, err
+	}
+	out := *challenge
+	return &out, nil
+}
+
+// Fail records a wrong code and reports whether the challenge is still
+// usable. After too many wrong codes the user has to log in again.
+func (c *Challenges) Fail(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, err := c.get(token)
+	if err != nil {
+		return false
+	}
+	challenge.attempts++
+	if challenge.attempts >= c.maxAttempts {
+		delete(c.challenges, hashChallenge(token))
+		return false
+	}
+	return true
+}
+
+// Complete uses up the challenge after its code was verified. It fails if
+// another request completed it first.
+func (c *Challenges) Complete(token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.get(token); err != nil {
+		return err
+	}
+	delete(c.challenges, hashChallenge(token))
+	return nil
+}
+
+// get must be called with c.mu held
+func (c *Challenges) get(token string) (*Challenge, error) {
+	if token == "" {
+		return nil, ErrInvalidChallenge
+	}
+	challenge, ok := c.challenges[hashChallenge(token)]
+	if !ok || !c.now().Before(challenge.ExpiresAt) {
+		return nil, ErrInvalidChallenge
+	}
+	return challenge, nil
+}
+
+// prune must be called with c.mu held
+func (c *Challenges) prune() {
+	now := c.now()
+	for hash, challenge := range c.challenges {
+		if !now.Before(challenge.ExpiresAt) {
+			delete(c.challenges, hash)
+		}
+	}
+}
+
+func hashChallenge(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,48 @@
+package mfa
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChallenges(t *testing.T) {
+	c := NewChallenges(time.Minute, 3)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+
+	token, err := c.Issue("jdoe", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := c.Lookup(token)
+	if err != nil || challenge.UserID != "jdoe" || challenge.Login != "state" {
+		t.Fatalf("Lookup() = %+v, %v", challenge, err)
+	}
+	if !c.Fail(token) || !c.Fail(token) {
+		t.Fatal("challenge exhausted before the attempt limit")
+	}
+	if c.Fail(token) {
+		t.Error("challenge usable after the attempt limit")
+	}
+	if _, err := c.Lookup(token); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("exhausted challenge: Lookup() error = %v, want %v", err, ErrInvalidChallenge)
+	}
+
+	token, _ = c.Issue("jdoe", nil)
+	if err := c.Complete(token); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Complete(token); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("second Complete() error = %v, want %v", err, ErrInvalidChallenge)
+	}
+
+	token, _ = c.Issue("jdoe", nil)
+	now = now.Add(time.Minute)
+	if _, err := c.Lookup(token); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("expired challenge: Lookup() error = %v, want %v", err, ErrInvalidChallenge)
+	}
+	if _, err := c.Lookup(""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("empty token: Lookup() error = %v, want %v", err, ErrInvalidChallenge)
+	}
+}
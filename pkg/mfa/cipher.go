@@ -0,0 +1,65 @@
+// pkg/mfa/cipher.go
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the size of the AES-256 key that encrypts stored secrets
+const KeySize = 32
+
+// ErrDecrypt is returned for stored secrets that cannot be decrypted, because
+// they were encrypted with another key, altered, or moved to another user
+var ErrDecrypt = errors.New("failed to decrypt TOTP secret")
+
+// Cipher encrypts TOTP secrets at rest with AES-256-GCM. The user ID is
+// authenticated along with each secret, so a secret copied to another
+// user's enrollment does not decrypt.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher takes a KeySize byte key, kept outside the enrollment store
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("TOTP encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts the secret of userID
+func (c *Cipher) Seal(userID string, secret []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, secret, []byte(userID))
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed for userID
+func (c *Cipher) Open(userID, sealed string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	secret, err := c.aead.Open(nil, nonce, ciphertext, []byte(userID))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return secret, nil
+}
@@ -0,0 +1,40 @@
+package mfa
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("12345678901234567890")
+	sealed, err := c.Seal("jdoe", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains([]byte(sealed), []byte(EncodeSecret(secret))) {
+		t.Fatal("sealed secret contains the plain secret")
+	}
+
+	got, err := c.Open("jdoe", sealed)
+	if err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("Open() = %q, %v; want %q", got, err, secret)
+	}
+	if _, err := c.Open("asmith", sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open() for another user error = %v, want %v", err, ErrDecrypt)
+	}
+
+	other, _ := NewCipher(bytes.Repeat([]byte{2}, KeySize))
+	if _, err := other.Open("jdoe", sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open() with another key error = %v, want %v", err, ErrDecrypt)
+	}
+
+	if _, err := NewCipher([]byte("short")); err == nil {
+		t.Error("NewCipher() with a short key succeeded")
+	}
+}
@@ -0,0 +1,25 @@
+// Package mfa provides TOTP second factors (RFC 6238) for logins verified
+// against the directory.
+//
+// Users enroll by scanning the provisioning URI into an authenticator app
+// and confirming with a first code. Enrollment also hands out single-use
+// recovery codes for when the authenticator is lost. Secrets are stored
+// encrypted with a key kept outside the store; recovery codes are stored
+// hashed. A code is accepted at most once, so a code observed by an
+// attacker cannot be replayed while it is still valid.
+//
+// Basic usage:
+//
+//	cipher, err := mfa.NewCipher(key)
+//	store, err := mfa.OpenFileStore("/var/lib/auth-service/mfa.json")
+//	manager := mfa.NewManager(store, cipher, mfa.Config{Issuer: "Example"})
+//
+//	provisioning, err := manager.Enroll(userID)
+//	// show provisioning.URI as a QR code and the recovery codes once
+//	err = manager.Confirm(userID, code)
+//
+//	method, err := manager.Verify(userID, code)
+//
+// Challenges carries a login from the password step to the code step with
+// an opaque token and a limited number of attempts.
+package mfa
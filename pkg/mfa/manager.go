@@ -0,0 +1,289 @@
+// pkg/mfa/manager.go
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+// DefaultRecoveryCodes is the number of recovery codes handed out at
+// enrollment
+const DefaultRecoveryCodes = 10
+
+var (
+	// ErrAlreadyEnrolled is returned when enrolling a user whose enrollment
+	// is confirmed. It has to be disabled first.
+	ErrAlreadyEnrolled = errors.New("user already enrolled for MFA")
+	// ErrInvalidCode is returned for wrong codes
+	ErrInvalidCode = errors.New("invalid MFA code")
+	// ErrCodeReused is returned for a TOTP code that was already accepted,
+	// or one older than the last accepted code
+	ErrCodeReused = errors.New("MFA code already used")
+)
+
+// recoveryCodeBytes gives recovery codes 80 bits of entropy, enough for an
+// unsalted hash to stand up to guessing
+const recoveryCodeBytes = 10
+
+// recoveryEncoding spells recovery codes without padding and in lower case
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Config configures a Manager
+type Config struct {
+	// Issuer names the service in authenticator apps
+	Issuer string
+	TOTP   TOTP
+	// RecoveryCodes defaults to DefaultRecoveryCodes
+	RecoveryCodes int
+}
+
+// Provisioning is handed to the user once, at enrollment. Neither the
+// secret nor the recovery codes can be retrieved again.
+type Provisioning struct {
+	// Secret is the base32 secret for manual entry
+	Secret string
+	// URI is the otpauth URI to render as a QR code
+	URI           string
+	RecoveryCodes []string
+}
+
+// Manager enrolls users for TOTP and verifies their codes. It serializes
+// changes to enrollments, so a code is only ever accepted once as long as a
+// single Manager uses the store.
+type Manager struct {
+	store  Store
+	cipher *Cipher
+	config Config
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+func NewManager(store Store, cipher *Cipher, config Config) *Manager {
+	if config.RecoveryCodes <= 0 {
+		config.RecoveryCodes = DefaultRecoveryCodes
+	}
+	return &Manager{
+		store:  store,
+		cipher: cipher,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Enroll starts an enrollment with a new secret and recovery codes,
+// replacing an unconfirmed one. It takes effect once confirmed with a code.
+func (m *Manager) Enroll(userID string) (*Provisioning, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, err := m.store.Load(userID)
+	switch {
+	case err == nil && existing.Confirmed:
+		return nil, ErrAlreadyEnrolled
+	case err != nil && !errors.Is(err, ErrNotEnrolled):
+		return nil, err
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := m.cipher.Seal(userID, secret)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := m.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Save(&Enrollment{
+		UserID:        userID,
+		Secret:        sealed,
+		RecoveryCodes: hashes,
+		CreatedAt:     m.now(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save MFA enrollment: %w", err)
+	}
+
+	return &Provisioning{
+		Secret:        EncodeSecret(secret),
+		URI:           m.config.TOTP.ProvisioningURI(m.config.Issuer, userID, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// Confirm completes an enrollment with a code from the user's
+// authenticator
+func (m *Manager) Confirm(userID, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, err := m.store.Load(userID)
+	if err != nil {
+		return err
+	}
+	if enrollment.Confirmed {
+		return ErrAlreadyEnrolled
+	}
+	step, err := m.matchTOTP(enrollment, code)
+	if err != nil {
+		return err
+	}
+	enrollment.Confirmed = true
+	enrollment.LastStep = step
+	return m.store.Save(enrollment)
+}
+
+// Enrolled reports whether userID has a confirmed enrollment and must pass
+// a second factor at login
+func (m *Manager) Enrolled(userID string) (bool, error) {
+	enrollment, err := m.store.Load(userID)
+	switch {
+	case errors.Is(err, ErrNotEnrolled):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return enrollment.Confirmed, nil
+}
+
+// Verify checks a TOTP or recovery code of an enrolled user and returns the
+// amr value of the method used. Accepted TOTP codes cannot be replayed and
+// recovery codes are used up.
+func (m *Manager) Verify(userID, code string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, err := m.store.Load(userID)
+	if err != nil {
+		return "", err
+	}
+	if !enrollment.Confirmed {
+		return "", ErrNotEnrolled
+	}
+
+	step, err := m.matchTOTP(enrollment, code)
+	switch {
+	case err == nil:
+		enrollment.LastStep = step
+		if err := m.store.Save(enrollment); err != nil {
+			return "", err
+		}
+		return auth.AMROTP, nil
+	case !errors.Is(err, ErrInvalidCode):
+		return "", err
+	}
+
+	if i := matchRecoveryCode(enrollment.RecoveryCodes, code); i >= 0 {
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes[:i], enrollment.RecoveryCodes[i+1:]...)
+		if err := m.store.Save(enrollment); err != nil {
+			return "", err
+		}
+		return auth.AMRRecoveryCode, nil
+	}
+	return "", ErrInvalidCode
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (m *Manager) RemainingRecoveryCodes(userID string) (int, error) {
+	enrollment, err := m.store.Load(userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(enrollment.RecoveryCodes), nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of an enrolled user
+func (m *Manager) RegenerateRecoveryCodes(userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, err := m.store.Load(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enrollment.Confirmed {
+		return nil, ErrNotEnrolled
+	}
+	codes, hashes, err := m.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	enrollment.RecoveryCodes = hashes
+	if err := m.store.Save(enrollment); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the enrollment of userID
+func (m *Manager) Disable(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store.Delete(userID)
+}
+
+// matchTOTP returns the step of a valid code that was not used before
+func (m *Manager) matchTOTP(enrollment *Enrollment, code string) (int64, error) {
+	secret, err := m.cipher.Open(enrollment.UserID, enrollment.Secret)
+	if err != nil {
+		return 0, err
+	}
+	step, ok := m.config.TOTP.Match(secret, code, m.now())
+	if !ok {
+		return 0, ErrInvalidCode
+	}
+	if step <= enrollment.LastStep {
+		return 0, ErrCodeReused
+	}
+	return step, nil
+}
+
+// recoveryCodes returns new codes, formatted as xxxx-xxxx-xxxx-xxxx, and
+// their hashes
+func (m *Manager) recoveryCodes() ([]string, []string, error) {
+	codes := make([]string, m.config.RecoveryCodes)
+	hashes := make([]string, m.config.RecoveryCodes)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := recoveryEncoding.EncodeToString(buf)
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		hashes[i] = hashRecoveryCode(raw)
+	}
+	return codes, hashes, nil
+}
+
+// matchRecoveryCode returns the index of the hash of code, or -1. Dashes,
+// spaces and case are ignored.
+func matchRecoveryCode(hashes []string, code string) int {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(normalized) != 16 {
+		return -1
+	}
+	hash := hashRecoveryCode(normalized)
+	match := -1
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			match = i
+		}
+	}
+	return match
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
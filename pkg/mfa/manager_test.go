@@ -0,0 +1,128 @@
+package mfa
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+func newTestManager(t *testing.T) (*Manager, *time.Time) {
+	t.Helper()
+	cipher, err := NewCipher(bytes.Repeat([]byte{7}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(NewMemoryStore(), cipher, Config{Issuer: "Example"})
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+// codeAt returns the code of provisioning's secret at time at
+func codeAt(t *testing.T, p *Provisioning, at time.Time) string {
+	t.Helper()
+	secret, err := secretEncoding.DecodeString(p.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var totp TOTP
+	return totp.Code(secret, totp.Step(at))
+}
+
+func TestManagerEnrollment(t *testing.T) {
+	m, now := newTestManager(t)
+
+	p, err := m.Enroll("jdoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.RecoveryCodes) != DefaultRecoveryCodes || !strings.HasPrefix(p.URI, "otpauth://totp/Example:jdoe?") {
+		t.Fatalf("Enroll() = %+v", p)
+	}
+	if enrolled, _ := m.Enrolled("jdoe"); enrolled {
+		t.Error("unconfirmed enrollment counts as enrolled")
+	}
+	if _, err := m.Verify("jdoe", codeAt(t, p, *now)); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("Verify() before confirmation error = %v, want %v", err, ErrNotEnrolled)
+	}
+
+	// A restarted enrollment replaces the secret
+	p, _ = m.Enroll("jdoe")
+	if err := m.Confirm("jdoe", "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Confirm() with wrong code error = %v, want %v", err, ErrInvalidCode)
+	}
+	if err := m.Confirm("jdoe", codeAt(t, p, *now)); err != nil {
+		t.Fatalf("Confirm() unexpected error: %v", err)
+	}
+	if enrolled, _ := m.Enrolled("jdoe"); !enrolled {
+		t.Error("confirmed enrollment not reported as enrolled")
+	}
+	if _, err := m.Enroll("jdoe"); !errors.Is(err, ErrAlreadyEnrolled) {
+		t.Errorf("Enroll() of enrolled user error = %v, want %v", err, ErrAlreadyEnrolled)
+	}
+
+	if err := m.Disable("jdoe"); err != nil {
+		t.Fatal(err)
+	}
+	if enrolled, _ := m.Enrolled("jdoe"); enrolled {
+		t.Error("disabled user still enrolled")
+	}
+}
+
+func TestManagerVerify(t *testing.T) {
+	m, now := newTestManager(t)
+	p, _ := m.Enroll("jdoe")
+	if err := m.Confirm("jdoe", codeAt(t, p, *now)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The code used for confirmation cannot be used to log in
+	if _, err := m.Verify("jdoe", codeAt(t, p, *now)); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("Verify() of the confirmation code error = %v, want %v", err, ErrCodeReused)
+	}
+
+	*now = now.Add(DefaultPeriod)
+	code := codeAt(t, p, *now)
+	method, err := m.Verify("jdoe", code)
+	if err != nil || method != auth.AMROTP {
+		t.Fatalf("Verify() = %q, %v; want %q", method, err, auth.AMROTP)
+	}
+	if _, err := m.Verify("jdoe", code); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("replayed code error = %v, want %v", err, ErrCodeReused)
+	}
+	// Nor can a code from before the accepted one, though still in the window
+	if _, err := m.Verify("jdoe", codeAt(t, p, now.Add(-DefaultPeriod))); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("older code error = %v, want %v", err, ErrCodeReused)
+	}
+
+	recovery := strings.ToUpper(strings.ReplaceAll(p.RecoveryCodes[3], "-", " "))
+	method, err = m.Verify("jdoe", recovery)
+	if err != nil || method != auth.AMRRecoveryCode {
+		t.Fatalf("Verify() with recovery code = %q, %v; want %q", method, err, auth.AMRRecoveryCode)
+	}
+	if _, err := m.Verify("jdoe", p.RecoveryCodes[3]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("used recovery code error = %v, want %v", err, ErrInvalidCode)
+	}
+	if n, _ := m.RemainingRecoveryCodes("jdoe"); n != DefaultRecoveryCodes-1 {
+		t.Errorf("RemainingRecoveryCodes() = %d, want %d", n, DefaultRecoveryCodes-1)
+	}
+
+	codes, err := m.RegenerateRecoveryCodes("jdoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Verify("jdoe", p.RecoveryCodes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replaced recovery code error = %v, want %v", err, ErrInvalidCode)
+	}
+	if _, err := m.Verify("jdoe", codes[0]); err != nil {
+		t.Errorf("new recovery code: unexpected error %v", err)
+	}
+
+	if _, err := m.Verify("asmith", "123456"); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("Verify() of unknown user error = %v, want %v", err, ErrNotEnrolled)
+	}
+}
@@ -0,0 +1,168 @@
+// pkg/mfa/store.go
+package mfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotEnrolled is returned for users without a confirmed second factor
+var ErrNotEnrolled = errors.New("user not enrolled for MFA")
+
+// Enrollment is a user's TOTP second factor
+type Enrollment struct {
+	UserID string `json:"user_id"`
+	// Secret is the TOTP secret sealed by a Cipher
+	Secret string `json:"secret"`
+	// Confirmed is set once the user proved their authenticator works.
+	// Unconfirmed enrollments are not asked for at login.
+	Confirmed bool `json:"confirmed"`
+	// RecoveryCodes holds hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// LastStep is the time step of the last accepted code. Codes of this
+	// step or earlier are replays.
+	LastStep  int64     `json:"last_step,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps enrollments by canonical user ID
+type Store interface {
+	// Load returns ErrNotEnrolled for users without an enrollment
+	Load(userID string) (*Enrollment, error)
+	Save(enrollment *Enrollment) error
+	Delete(userID string) error
+}
+
+// MemoryStore keeps enrollments in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	enrollments map[string]*Enrollment
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{enrollments: make(map[string]*Enrollment)}
+}
+
+func (s *MemoryStore) Load(userID string) (*Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	enrollment, ok := s.enrollments[userID]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	return copyEnrollment(enrollment), nil
+}
+
+func (s *MemoryStore) Save(enrollment *Enrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enrollments[enrollment.UserID] = copyEnrollment(enrollment)
+	return nil
+}
+
+func (s *MemoryStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.enrollments, userID)
+	return nil
+}
+
+func copyEnrollment(enrollment *Enrollment) *Enrollment {
+	out := *enrollment
+	out.RecoveryCodes = append([]string(nil), enrollment.RecoveryCodes...)
+	return &out
+}
+
+// FileStore is a MemoryStore persisted to a JSON file, so enrollments
+// survive restarts of a single instance. Every change rewrites the file
+// before it takes effect. Secrets in the file are encrypted; the key must
+// be kept elsewhere.
+type FileStore struct {
+	*MemoryStore
+	path string
+	// writeMu serializes rewrites of the file
+	writeMu sync.Mutex
+}
+
+type storeFile struct {
+	Enrollments []*Enrollment `json:"enrollments"`
+}
+
+// OpenFileStore loads the enrollments at path. A missing file is created on
+// the first change.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MFA enrollments: %w", err)
+	}
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse MFA enrollments: %w", err)
+	}
+	for _, enrollment := range f.Enrollments {
+		s.enrollments[enrollment.UserID] = enrollment
+	}
+	return s, nil
+}
+
+func (s *FileStore) Save(enrollment *Enrollment) error {
+	return s.update(func(enrollments map[string]*Enrollment) {
+		enrollments[enrollment.UserID] = copyEnrollment(enrollment)
+	})
+}
+
+func (s *FileStore) Delete(userID string) error {
+	return s.update(func(enrollments map[string]*Enrollment) {
+		delete(enrollments, userID)
+	})
+}
+
+// update applies change to a copy of the enrollments, writes the copy and
+// only then makes it current
+func (s *FileStore) update(change func(map[string]*Enrollment)) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.RLock()
+	enrollments := make(map[string]*Enrollment, len(s.enrollments)+1)
+	for userID, enrollment := range s.enrollments {
+		enrollments[userID] = enrollment
+	}
+	s.mu.RUnlock()
+	change(enrollments)
+
+	f := storeFile{Enrollments: make([]*Enrollment, 0, len(enrollments))}
+	for _, enrollment := range enrollments {
+		f.Enrollments = append(f.Enrollments, enrollment)
+	}
+	sort.Slice(f.Enrollments, func(i, j int) bool {
+		return f.Enrollments[i].UserID < f.Enrollments[j].UserID
+	})
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write MFA enrollments: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write MFA enrollments: %w", err)
+	}
+
+	s.mu.Lock()
+	s.enrollments = enrollments
+	s.mu.Unlock()
+	return nil
+}
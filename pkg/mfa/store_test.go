@@ -0,0 +1,50 @@
+package mfa
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mfa.json")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("jdoe"); !errors.Is(err, ErrNotEnrolled) {
+		t.Fatalf("Load() error = %v, want %v", err, ErrNotEnrolled)
+	}
+
+	want := &Enrollment{
+		UserID:        "jdoe",
+		Secret:        "sealed",
+		Confirmed:     true,
+		RecoveryCodes: []string{"a", "b"},
+		LastStep:      42,
+		CreatedAt:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	store.Save(&Enrollment{UserID: "asmith", Secret: "sealed"})
+	store.Delete("asmith")
+
+	// Changes to a loaded enrollment stay local until saved
+	loaded, _ := store.Load("jdoe")
+	loaded.RecoveryCodes[0] = "changed"
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Load("jdoe")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() after reopen = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := reopened.Load("asmith"); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("deleted enrollment: Load() error = %v, want %v", err, ErrNotEnrolled)
+	}
+}
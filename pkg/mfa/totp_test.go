@@ -0,0 +1,75 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1
+	secret := []byte("12345678901234567890")
+	totp := TOTP{Digits: 8}
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		if got := totp.Code(secret, totp.Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPMatch(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	var totp TOTP
+	now := time.Unix(1111111109, 0)
+	step := totp.Step(now)
+
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{"current", totp.Code(secret, step), true},
+		{"previous period", totp.Code(secret, step-1), true},
+		{"next period", " " + totp.Code(secret, step+1) + " ", true},
+		{"two periods old", totp.Code(secret, step-2), false},
+		{"wrong length", "12345", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := totp.Match(secret, tt.code, now); ok != tt.wantOK {
+				t.Errorf("Match(%q) = %v, want %v", tt.code, ok, tt.wantOK)
+			}
+		})
+	}
+
+	if got, _ := totp.Match(secret, totp.Code(secret, step-1), now); got != step-1 {
+		t.Errorf("Match() step = %d, want %d", got, step-1)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := TOTP{}.ProvisioningURI("Example Corp", "jdoe", []byte("12345678901234567890"))
+	for _, want := range []string{
+		"otpauth://totp/Example%20Corp:jdoe?",
+		"secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"issuer=Example+Corp",
+		"digits=6",
+		"period=30",
+	} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("ProvisioningURI() = %s, want it to contain %s", uri, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// pkg/oauth/assertion.go
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+// ClientAssertionType is the client_assertion_type of private_key_jwt
+// (RFC 7523 section 2.2)
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// MaxAssertionLifetime bounds how far in the future a client assertion may
+// expire, which bounds the memory needed for replay detection
+const MaxAssertionLifetime = 10 * time.Minute
+
+// assertionLeeway tolerates clock skew between clients and us
+const assertionLeeway = 30 * time.Second
+
+// ErrAssertionReplayed is returned for client assertions used twice
+var ErrAssertionReplayed = errors.New("client assertion replayed")
+
+// assertionAlgorithms are the accepted signature algorithms; HMAC would
+// need a shared secret and "none" is never acceptable
+var assertionAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ReplayCache remembers single-use identifiers until they expire
+type ReplayCache = auth.ReplayCache
+
+// AssertionVerifier checks private_key_jwt client assertions
+type AssertionVerifier struct {
+	// Audiences accepted in the aud claim, normally the token endpoint URL
+	// and the issuer
+	Audiences []string
+	Replay    ReplayCache
+	now       func() time.Time
+}
+
+// NewAssertionVerifier accepts assertions for any of audiences and detects
+// replay in memory
+func NewAssertionVerifier(audiences ...string) *AssertionVerifier {
+	return &AssertionVerifier{
+		Audiences: audiences,
+		Replay:    NewMemoryReplayCache(),
+		now:       time.Now,
+	}
+}
+
+// ClientID returns the client an assertion claims to come from, without
+// verifying it
+func (v *AssertionVerifier) ClientID(assertion string) (string, error) {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &claims); err != nil {
+		return "", fmt.Errorf("%w: malformed client assertion", ErrInvalidClient)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("%w: client assertion without sub", ErrInvalidClient)
+	}
+	return claims.Subject, nil
+}
+
+// Verify checks that assertion was signed by one of the client's keys, is
+// issued by and about the client, is meant for us, and has not been seen
+// before (RFC 7523 section 3)
+func (v *AssertionVerifier) Verify(client *Client, assertion string) error {
+	if client.JWKS == nil {
+		return fmt.Errorf("%w: client %q has no keys", ErrInvalidClient, client.ID)
+	}
+
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(assertion, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		keys := client.JWKS.Keys
+		for _, jwk := range keys {
+			if jwk.Kid == kid || len(keys) == 1 && (kid == "" || jwk.Kid == "") {
+				return jwk.PublicKey()
+			}
+		}
+		return nil, fmt.Errorf("no key %q", kid)
+	},
+		jwt.WithValidMethods(assertionAlgorithms),
+		jwt.WithIssuer(client.ID),
+		jwt.WithSubject(client.ID),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(v.now),
+		jwt.WithLeeway(assertionLeeway),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: client assertion of %q: %w", ErrInvalidClient, client.ID, err)
+	}
+
+	if !v.forUs(claims.Audience) {
+		return fmt.Errorf("%w: client assertion of %q has audience %v", ErrInvalidClient, client.ID, claims.Audience)
+	}
+	expiresAt := claims.ExpiresAt.Time
+	if expiresAt.After(v.now().Add(MaxAssertionLifetime)) {
+		return fmt.Errorf("%w: client assertion of %q expires too late", ErrInvalidClient, client.ID)
+	}
+	if claims.ID == "" {
+		return fmt.Errorf("%w: client assertion of %q has no jti", ErrInvalidClient, client.ID)
+	}
+	if v.Replay != nil && v.Replay.Seen(client.ID+" "+claims.ID, expiresAt.Add(assertionLeeway)) {
+		return fmt.Errorf("%w: %w", ErrInvalidClient, ErrAssertionReplayed)
+	}
+	return nil
+}
+
+func (v *AssertionVerifier) forUs(audience jwt.ClaimStrings) bool {
+	for _, aud := range audience {
+		if contains(v.Audiences, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryReplayCache is a ReplayCache for a single instance
+type MemoryReplayCache = auth.MemoryReplayCache
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return auth.NewMemoryReplayCache()
+}
@@ -0,0 +1,99 @@
+// pkg/oauth/assertion_test.go
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+const testTokenEndpoint = "https://auth.example.com/token"
+
+// keyClient returns a private_key_jwt client and its signing key
+func keyClient(t *testing.T, id string) (*Client, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := auth.PublicJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid = id + "-1"
+	return &Client{ID: id, JWKS: &auth.JWKSet{Keys: []auth.JWK{jwk}}, GrantTypes: []string{GrantClientCredentials}}, key
+}
+
+func signAssertion(t *testing.T, key interface{}, method jwt.SigningMethod, kid string, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func assertionClaims(clientID, jti string) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{testTokenEndpoint},
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+}
+
+func TestAssertionVerifier(t *testing.T) {
+	client, key := keyClient(t, "batch")
+	_, otherKey := keyClient(t, "other")
+	verifier := NewAssertionVerifier(testTokenEndpoint, "https://auth.example.com")
+
+	valid := signAssertion(t, key, jwt.SigningMethodES256, "batch-1", assertionClaims("batch", "a1"))
+	if id, err := verifier.ClientID(valid); err != nil || id != "batch" {
+		t.Fatalf("ClientID() = %q, %v; want batch", id, err)
+	}
+	if err := verifier.Verify(client, valid); err != nil {
+		t.Fatalf("Verify() unexpected error: %v", err)
+	}
+	if err := verifier.Verify(client, valid); !errors.Is(err, ErrAssertionReplayed) {
+		t.Errorf("replayed assertion error = %v, want %v", err, ErrAssertionReplayed)
+	}
+
+	tests := []struct {
+		name   string
+		key    interface{}
+		method jwt.SigningMethod
+		claims func(*jwt.RegisteredClaims)
+	}{
+		{"wrong key", otherKey, jwt.SigningMethodES256, nil},
+		{"HMAC", []byte("secret"), jwt.SigningMethodHS256, nil},
+		{"other issuer", key, jwt.SigningMethodES256, func(c *jwt.RegisteredClaims) { c.Issuer = "other" }},
+		{"other audience", key, jwt.SigningMethodES256, func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"https://elsewhere.example.com/token"} }},
+		{"expired", key, jwt.SigningMethodES256, func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) }},
+		{"no expiry", key, jwt.SigningMethodES256, func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }},
+		{"expires too late", key, jwt.SigningMethodES256, func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour)) }},
+		{"no jti", key, jwt.SigningMethodES256, func(c *jwt.RegisteredClaims) { c.ID = "" }},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := assertionClaims("batch", string(rune('b'+i)))
+			if tt.claims != nil {
+				tt.claims(&claims)
+			}
+			assertion := signAssertion(t, tt.key, tt.method, "batch-1", claims)
+			if err := verifier.Verify(client, assertion); !errors.Is(err, ErrInvalidClient) {
+				t.Errorf("Verify() error = %v, want %v", err, ErrInvalidClient)
+			}
+		})
+	}
+}
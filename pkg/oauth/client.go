@@ -0,0 +1,99 @@
+// pkg/oauth/client.go
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var (
+	// ErrInvalidClient is returned when client authentication fails
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrNoClientCredentials is returned when a request carries no client credentials
+	ErrNoClientCredentials = errors.New("no client credentials")
+)
+
+// ClientCredentials extracts the client ID and secret from the Authorization
+// header or from the request body. Using both methods at once is rejected as
+// RFC 6749 requires. The form must be parseable; ParseForm is called.
+func ClientCredentials(r *http.Request) (clientID, secret string, err error) {
+	if err := r.ParseForm(); err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrInvalidClient, err)
+	}
+
+	formID := r.PostForm.Get("client_id")
+	formSecret := r.PostForm.Get("client_secret")
+
+	if user, pass, ok := r.BasicAuth(); ok {
+		if formSecret != "" {
+			return "", "", fmt.Errorf("%w: multiple authentication methods", ErrInvalidClient)
+		}
+		// Basic credentials are form-urlencoded before base64 encoding
+		if clientID, err = url.QueryUnescape(user); err != nil {
+			return "", "", fmt.Errorf("%w: %w", ErrInvalidClient, err)
+		}
+		if secret, err = url.QueryUnescape(pass); err != nil {
+			return "", "", fmt.Errorf("%w: %w", ErrInvalidClient, err)
+		}
+		if formID != "" && formID != clientID {
+			return "", "", fmt.Errorf("%w: client_id does not match", ErrInvalidClient)
+		}
+		return clientID, secret, nil
+	}
+
+	if formID == "" || formSecret == "" {
+		return "", "", ErrNoClientCredentials
+	}
+	return formID, formSecret, nil
+}
+
+// AuthenticateRequest authenticates the confidential client of a token
+// endpoint request, with a client secret or, when assertions is not nil,
+// with a private_key_jwt client assertion (RFC 7523). Requests without
+// credentials return ErrNoClientCredentials.
+func AuthenticateRequest(r *http.Request, clients ClientRegistry, assertions *AssertionVerifier) (*Client, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClient, err)
+	}
+
+	assertion := r.PostForm.Get("client_assertion")
+	assertionType := r.PostForm.Get("client_assertion_type")
+	if assertion == "" && assertionType == "" {
+		clientID, secret, err := ClientCredentials(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := clients.AuthenticateClient(clientID, secret); err != nil {
+			return nil, err
+		}
+		return clients.Client(clientID)
+	}
+
+	if assertions == nil {
+		return nil, fmt.Errorf("%w: client assertions are not accepted", ErrInvalidClient)
+	}
+	if assertionType != ClientAssertionType || assertion == "" {
+		return nil, fmt.Errorf("%w: unsupported client_assertion_type %q", ErrInvalidClient, assertionType)
+	}
+	if _, _, ok := r.BasicAuth(); ok || r.PostForm.Get("client_secret") != "" {
+		return nil, fmt.Errorf("%w: multiple authentication methods", ErrInvalidClient)
+	}
+
+	clientID, err := assertions.ClientID(assertion)
+	if err != nil {
+		return nil, err
+	}
+	if formID := r.PostForm.Get("client_id"); formID != "" && formID != clientID {
+		return nil, fmt.Errorf("%w: client_id does not match", ErrInvalidClient)
+	}
+	client, err := clients.Client(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClient, err)
+	}
+	if err := assertions.Verify(client, assertion); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
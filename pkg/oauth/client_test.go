@@ -0,0 +1,120 @@
+// pkg/oauth/client_test.go
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+var testParams = passwd.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func formRequest(form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestClientCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		form       url.Values
+		basicUser  string
+		basicPass  string
+		wantID     string
+		wantSecret string
+		wantErr    error
+	}{
+		{name: "basic", basicUser: "gateway", basicPass: "s3cret", wantID: "gateway", wantSecret: "s3cret"},
+		{name: "basic is url encoded", basicUser: "my%3Aapp", basicPass: "a%2Bb", wantID: "my:app", wantSecret: "a+b"},
+		{name: "form", form: url.Values{"client_id": {"gateway"}, "client_secret": {"s3cret"}}, wantID: "gateway", wantSecret: "s3cret"},
+		{name: "basic with matching client_id", form: url.Values{"client_id": {"gateway"}}, basicUser: "gateway", basicPass: "s3cret", wantID: "gateway", wantSecret: "s3cret"},
+		{name: "both methods", form: url.Values{"client_secret": {"x"}}, basicUser: "gateway", basicPass: "s3cret", wantErr: ErrInvalidClient},
+		{name: "mismatched client_id", form: url.Values{"client_id": {"other"}}, basicUser: "gateway", basicPass: "s3cret", wantErr: ErrInvalidClient},
+		{name: "none", wantErr: ErrNoClientCredentials},
+		{name: "form without secret", form: url.Values{"client_id": {"gateway"}}, wantErr: ErrNoClientCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := formRequest(tt.form)
+			if tt.basicUser != "" {
+				req.SetBasicAuth(tt.basicUser, tt.basicPass)
+			}
+
+			id, secret, err := ClientCredentials(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ClientCredentials() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClientCredentials() unexpected error: %v", err)
+			}
+			if id != tt.wantID || secret != tt.wantSecret {
+				t.Errorf("ClientCredentials() = %q, %q, want %q, %q", id, secret, tt.wantID, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	hash, err := passwd.HashWithParams("s3cret", testParams)
+	if err != nil {
+		t.Fatalf("HashWithParams() unexpected error: %v", err)
+	}
+	batch, key := keyClient(t, "batch")
+	clients := StaticRegistry{
+		"gateway": {ID: "gateway", SecretHash: hash, GrantTypes: []string{GrantClientCredentials}},
+		"batch":   batch,
+	}
+	verifier := NewAssertionVerifier(testTokenEndpoint)
+
+	assertionForm := func(jti string) url.Values {
+		return url.Values{
+			"client_assertion_type": {ClientAssertionType},
+			"client_assertion":      {signAssertion(t, key, jwt.SigningMethodES256, "batch-1", assertionClaims("batch", jti))},
+		}
+	}
+
+	req := formRequest(url.Values{"client_id": {"gateway"}, "client_secret": {"s3cret"}})
+	if client, err := AuthenticateRequest(req, clients, verifier); err != nil || client.ID != "gateway" {
+		t.Errorf("secret: AuthenticateRequest() = %v, %v; want gateway", client, err)
+	}
+
+	req = formRequest(assertionForm("1"))
+	if client, err := AuthenticateRequest(req, clients, verifier); err != nil || client.ID != "batch" {
+		t.Errorf("assertion: AuthenticateRequest() = %v, %v; want batch", client, err)
+	}
+
+	if _, err := AuthenticateRequest(formRequest(assertionForm("2")), clients, nil); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("assertions disabled: error = %v, want %v", err, ErrInvalidClient)
+	}
+
+	mismatched := assertionForm("3")
+	mismatched.Set("client_id", "gateway")
+	if _, err := AuthenticateRequest(formRequest(mismatched), clients, verifier); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("mismatched client_id: error = %v, want %v", err, ErrInvalidClient)
+	}
+
+	both := assertionForm("4")
+	both.Set("client_secret", "s3cret")
+	if _, err := AuthenticateRequest(formRequest(both), clients, verifier); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("two methods: error = %v, want %v", err, ErrInvalidClient)
+	}
+
+	if err := clients.AuthenticateClient("batch", ""); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("key client authenticated with a secret: %v", err)
+	}
+
+	if _, err := AuthenticateRequest(formRequest(url.Values{}), clients, verifier); !errors.Is(err, ErrNoClientCredentials) {
+		t.Errorf("no credentials: error = %v, want %v", err, ErrNoClientCredentials)
+	}
+}
@@ -0,0 +1,150 @@
+// pkg/oauth/code.go
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultCodeLifetime is how long an authorization code can be redeemed
+const DefaultCodeLifetime = time.Minute
+
+var (
+	// ErrInvalidCode is returned for unknown and expired codes
+	ErrInvalidCode = errors.New("invalid authorization code")
+	// ErrCodeReused is returned when a code is redeemed twice. The tokens
+	// issued for the first redemption should be revoked.
+	ErrCodeReused = errors.New("authorization code reused")
+)
+
+// codeBytes is the entropy of an authorization code
+const codeBytes = 32
+
+// AuthorizationCode is what an authorization code stands for: a user's
+// consent for one client, bound to the redirect URI and PKCE challenge of
+// the authorization request
+type AuthorizationCode struct {
+	ClientID      string
+	RedirectURI   string
+	CodeChallenge string
+	Scope         []string
+	Nonce         string
+
+	UserID     string
+	SessionID  string
+	AuthTime   time.Time
+	AMR        []string
+	Name       string
+	Email      string
+	Groups     []string
+	Attributes map[string][]string
+	// Offline is set when the password was verified without the directory
+	Offline bool
+
+	ExpiresAt time.Time
+}
+
+// CodeStore keeps issued codes by hash. Implementations must make Consume
+// atomic so that a code can only be redeemed once.
+type CodeStore interface {
+	Save(hash string, code AuthorizationCode) error
+	// Consume returns the code and marks it used. Unknown or expired codes
+	// return ErrInvalidCode; used codes return ErrCodeReused along with the
+	// code, so its tokens can be revoked.
+	Consume(hash string) (*AuthorizationCode, error)
+}
+
+// IssueCode stores code and returns the opaque value handed to the client
+func IssueCode(store CodeStore, code AuthorizationCode) (string, error) {
+	buf := make([]byte, codeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(buf)
+
+	if code.ExpiresAt.IsZero() {
+		code.ExpiresAt = time.Now().Add(DefaultCodeLifetime)
+	}
+	if err := store.Save(hashCode(value), code); err != nil {
+		return "", fmt.Errorf("failed to store authorization code: %w", err)
+	}
+	return value, nil
+}
+
+// RedeemCode consumes a code presented at the token endpoint
+func RedeemCode(store CodeStore, value string) (*AuthorizationCode, error) {
+	if value == "" {
+		return nil, ErrInvalidCode
+	}
+	return store.Consume(hashCode(value))
+}
+
+func hashCode(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// maxCodes bounds memory use; expired codes are pruned once it is reached
+const maxCodes = 100000
+
+type storedCode struct {
+	code AuthorizationCode
+	used bool
+}
+
+// MemoryCodeStore keeps codes in memory. It is safe for concurrent use.
+type MemoryCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]*storedCode
+	now   func() time.Time
+}
+
+func NewMemoryCodeStore() *MemoryCodeStore {
+	return &MemoryCodeStore{
+		codes: make(map[string]*storedCode),
+		now:   time.Now,
+	}
+}
+
+func (s *MemoryCodeStore) Save(hash string, code AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.codes) >= maxCodes {
+		s.prune()
+	}
+	s.codes[hash] = &storedCode{code: code}
+	return nil
+}
+
+func (s *MemoryCodeStore) Consume(hash string) (*AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.codes[hash]
+	if !ok || !s.now().Before(stored.code.ExpiresAt) {
+		return nil, ErrInvalidCode
+	}
+	code := stored.code
+	if stored.used {
+		return &code, ErrCodeReused
+	}
+	stored.used = true
+	return &code, nil
+}
+
+// prune must be called with s.mu held
+func (s *MemoryCodeStore) prune() {
+	now := s.now()
+	for hash, stored := range s.codes {
+		if !now.Before(stored.code.ExpiresAt) {
+			delete(s.codes, hash)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// pkg/oauth/code_test.go
+package oauth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthorizationCodes(t *testing.T) {
+	store := NewMemoryCodeStore()
+
+	code, err := IssueCode(store, AuthorizationCode{ClientID: "portal", UserID: "jdoe"})
+	if err != nil {
+		t.Fatalf("IssueCode() unexpected error: %v", err)
+	}
+	if _, ok := store.codes[code]; ok {
+		t.Error("codes must be stored hashed")
+	}
+
+	redeemed, err := RedeemCode(store, code)
+	if err != nil || redeemed.UserID != "jdoe" {
+		t.Fatalf("RedeemCode() = %+v, %v", redeemed, err)
+	}
+
+	replayed, err := RedeemCode(store, code)
+	if !errors.Is(err, ErrCodeReused) || replayed == nil || replayed.ClientID != "portal" {
+		t.Errorf("second RedeemCode() = %+v, %v; want ErrCodeReused with the code", replayed, err)
+	}
+
+	if _, err := RedeemCode(store, "unknown"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("unknown code error = %v, want ErrInvalidCode", err)
+	}
+	if _, err := RedeemCode(store, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("empty code error = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestAuthorizationCodeExpiry(t *testing.T) {
+	store := NewMemoryCodeStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	code, _ := IssueCode(store, AuthorizationCode{UserID: "jdoe", ExpiresAt: now.Add(time.Minute)})
+	now = now.Add(time.Minute)
+	if _, err := RedeemCode(store, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expired code error = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestAuthorizationCodeConcurrentRedemption(t *testing.T) {
+	store := NewMemoryCodeStore()
+	code, _ := IssueCode(store, AuthorizationCode{UserID: "jdoe"})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := RedeemCode(store, code); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Errorf("%d redemptions succeeded, want 1", succeeded)
+	}
+}
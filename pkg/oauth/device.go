@@ -0,0 +1,306 @@
+// pkg/oauth/device.go
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the device authorization grant (RFC 8628)
+const (
+	DefaultDeviceCodeLifetime = 10 * time.Minute
+	DefaultPollInterval       = 5 * time.Second
+)
+
+// slowDownStep is added to the poll interval each time a client polls too
+// fast (RFC 8628 section 3.5)
+const slowDownStep = 5 * time.Second
+
+// Errors of device code polling; each maps to the OAuth error of the same name
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+	// ErrInvalidDeviceCode is returned for unknown and already redeemed codes
+	ErrInvalidDeviceCode = errors.New("invalid device code")
+	// ErrInvalidUserCode is returned for unknown, expired and decided user codes
+	ErrInvalidUserCode = errors.New("invalid user code")
+	// ErrUserCodeTaken is returned by DeviceStore.Save for user codes in use
+	ErrUserCodeTaken = errors.New("user code in use")
+)
+
+// DeviceStatus is the state of a device grant
+type DeviceStatus string
+
+const (
+	DevicePending  DeviceStatus = "pending"
+	DeviceApproved DeviceStatus = "approved"
+	DeviceDenied   DeviceStatus = "denied"
+	DeviceRedeemed DeviceStatus = "redeemed"
+)
+
+// userCodeAlphabet has no vowels, to avoid words, and no easily confused
+// characters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength gives about 34 bits of entropy
+const userCodeLength = 8
+
+// DeviceGrant is a device authorization request and, once the user has
+// decided, its outcome
+type DeviceGrant struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scope          []string
+	Status         DeviceStatus
+	ExpiresAt      time.Time
+	Interval       time.Duration
+	LastPoll       time.Time
+
+	// Set on approval
+	UserID     string
+	AuthTime   time.Time
+	AMR        []string
+	Name       string
+	Email      string
+	Groups     []string
+	Attributes map[string][]string
+	// Offline is set when the password was verified without the directory
+	Offline bool
+}
+
+// DeviceStore keeps device grants until they expire. Updates must be
+// atomic so that a grant is decided and redeemed only once.
+type DeviceStore interface {
+	// Save adds a grant, returning ErrUserCodeTaken when its user code is
+	// used by a live grant
+	Save(grant DeviceGrant) error
+	// UpdateByDeviceCode applies fn to the live grant with the device code
+	// hash. Changes made by fn are kept even when it returns an error,
+	// which is passed on. Unknown hashes return ErrInvalidDeviceCode.
+	UpdateByDeviceCode(hash string, fn func(*DeviceGrant) error) error
+	// UpdateByUserCode is UpdateByDeviceCode for a normalized user code.
+	// Unknown codes return ErrInvalidUserCode.
+	UpdateByUserCode(userCode string, fn func(*DeviceGrant) error) error
+}
+
+// StartDeviceAuthorization stores grant as pending and returns its device
+// code and user code. ExpiresAt and Interval get defaults when unset.
+func StartDeviceAuthorization(store DeviceStore, grant DeviceGrant) (deviceCode, userCode string, err error) {
+	buf := make([]byte, codeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate device code: %w", err)
+	}
+	deviceCode = base64.RawURLEncoding.EncodeToString(buf)
+
+	grant.DeviceCodeHash = hashCode(deviceCode)
+	grant.Status = DevicePending
+	if grant.ExpiresAt.IsZero() {
+		grant.ExpiresAt = time.Now().Add(DefaultDeviceCodeLifetime)
+	}
+	if grant.Interval <= 0 {
+		grant.Interval = DefaultPollInterval
+	}
+
+	// Collisions are rare; retry a few times before giving up
+	for attempt := 0; attempt < 5; attempt++ {
+		if grant.UserCode, err = newUserCode(); err != nil {
+			return "", "", err
+		}
+		err = store.Save(grant)
+		if !errors.Is(err, ErrUserCodeTaken) {
+			break
+		}
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store device grant: %w", err)
+	}
+	return deviceCode, FormatUserCode(grant.UserCode), nil
+}
+
+// PollDeviceCode is a token request for a device code. It returns the
+// approved grant exactly once; otherwise it returns the error to report:
+// ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied, ErrExpiredToken or
+// ErrInvalidDeviceCode.
+func PollDeviceCode(store DeviceStore, deviceCode string, now time.Time) (*DeviceGrant, error) {
+	if deviceCode == "" {
+		return nil, ErrInvalidDeviceCode
+	}
+
+	var approved DeviceGrant
+	err := store.UpdateByDeviceCode(hashCode(deviceCode), func(grant *DeviceGrant) error {
+		if !now.Before(grant.ExpiresAt) {
+			return ErrExpiredToken
+		}
+		if grant.Status == DeviceRedeemed {
+			return ErrInvalidDeviceCode
+		}
+		tooFast := !grant.LastPoll.IsZero() && now.Sub(grant.LastPoll) < grant.Interval
+		grant.LastPoll = now
+		if tooFast {
+			grant.Interval += slowDownStep
+			return ErrSlowDown
+		}
+
+		switch grant.Status {
+		case DeviceApproved:
+			grant.Status = DeviceRedeemed
+			approved = *grant
+			return nil
+		case DeviceDenied:
+			return ErrAccessDenied
+		default:
+			return ErrAuthorizationPending
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &approved, nil
+}
+
+// PendingDeviceGrant returns the live pending grant for a user code as
+// entered by the user
+func PendingDeviceGrant(store DeviceStore, userCode string, now time.Time) (*DeviceGrant, error) {
+	var pending DeviceGrant
+	err := store.UpdateByUserCode(NormalizeUserCode(userCode), func(grant *DeviceGrant) error {
+		if grant.Status != DevicePending || !now.Before(grant.ExpiresAt) {
+			return ErrInvalidUserCode
+		}
+		pending = *grant
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// DecideDeviceGrant records the user's decision on a pending grant. approve
+// fills in the user's identity; a nil approve denies the grant.
+func DecideDeviceGrant(store DeviceStore, userCode string, now time.Time, approve func(*DeviceGrant)) error {
+	return store.UpdateByUserCode(NormalizeUserCode(userCode), func(grant *DeviceGrant) error {
+		if grant.Status != DevicePending || !now.Before(grant.ExpiresAt) {
+			return ErrInvalidUserCode
+		}
+		if approve == nil {
+			grant.Status = DeviceDenied
+			return nil
+		}
+		approve(grant)
+		grant.Status = DeviceApproved
+		return nil
+	})
+}
+
+// NormalizeUserCode uppercases a user code as typed and drops separators
+// and other characters outside the alphabet
+func NormalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(input) {
+		if strings.ContainsRune(userCodeAlphabet, c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// FormatUserCode splits a normalized user code for display, as in "WDJB-MJHT"
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func newUserCode() (string, error) {
+	buf := make([]byte, userCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate user code: %w", err)
+	}
+	code := make([]byte, userCodeLength)
+	for i, b := range buf {
+		// 256 is not a multiple of 20; the bias is negligible here
+		code[i] = userCodeAlphabet[int(b)%len(userCodeAlphabet)]
+	}
+	return string(code), nil
+}
+
+// maxDeviceGrants bounds memory use; expired grants are pruned once it is
+// reached
+const maxDeviceGrants = 100000
+
+// MemoryDeviceStore keeps device grants in memory. It is safe for
+// concurrent use.
+type MemoryDeviceStore struct {
+	mu         sync.Mutex
+	grants     map[string]*DeviceGrant
+	byUserCode map[string]string
+	now        func() time.Time
+}
+
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{
+		grants:     make(map[string]*DeviceGrant),
+		byUserCode: make(map[string]string),
+		now:        time.Now,
+	}
+}
+
+func (s *MemoryDeviceStore) Save(grant DeviceGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.grants) >= maxDeviceGrants {
+		s.prune()
+	}
+	if hash, ok := s.byUserCode[grant.UserCode]; ok {
+		if s.now().Before(s.grants[hash].ExpiresAt) {
+			return ErrUserCodeTaken
+		}
+		delete(s.grants, hash)
+	}
+	s.grants[grant.DeviceCodeHash] = &grant
+	s.byUserCode[grant.UserCode] = grant.DeviceCodeHash
+	return nil
+}
+
+func (s *MemoryDeviceStore) UpdateByDeviceCode(hash string, fn func(*DeviceGrant) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[hash]
+	if !ok {
+		return ErrInvalidDeviceCode
+	}
+	return fn(grant)
+}
+
+func (s *MemoryDeviceStore) UpdateByUserCode(userCode string, fn func(*DeviceGrant) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[s.byUserCode[userCode]]
+	if !ok {
+		return ErrInvalidUserCode
+	}
+	return fn(grant)
+}
+
+// prune removes expired grants; it must be called with s.mu held
+func (s *MemoryDeviceStore) prune() {
+	now := s.now()
+	for hash, grant := range s.grants {
+		if !now.Before(grant.ExpiresAt) {
+			delete(s.grants, hash)
+			delete(s.byUserCode, grant.UserCode)
+		}
+	}
+}
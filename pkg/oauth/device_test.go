@@ -0,0 +1,107 @@
+// pkg/oauth/device_test.go
+package oauth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceGrantFlow(t *testing.T) {
+	store := NewMemoryDeviceStore()
+	now := time.Now()
+
+	deviceCode, userCode, err := StartDeviceAuthorization(store, DeviceGrant{ClientID: "cli", Scope: []string{"openid"}})
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization() unexpected error: %v", err)
+	}
+	if len(userCode) != 9 || userCode[4] != '-' || NormalizeUserCode(userCode) != strings.ReplaceAll(userCode, "-", "") {
+		t.Errorf("user code = %q, want XXXX-XXXX", userCode)
+	}
+
+	if _, err := PollDeviceCode(store, deviceCode, now); !errors.Is(err, ErrAuthorizationPending) {
+		t.Errorf("first poll error = %v, want %v", err, ErrAuthorizationPending)
+	}
+	if _, err := PollDeviceCode(store, deviceCode, now.Add(time.Second)); !errors.Is(err, ErrSlowDown) {
+		t.Errorf("fast poll error = %v, want %v", err, ErrSlowDown)
+	}
+	// The interval grew to 10s after slowing down
+	now = now.Add(6 * time.Second)
+	if _, err := PollDeviceCode(store, deviceCode, now); !errors.Is(err, ErrSlowDown) {
+		t.Errorf("poll within the increased interval error = %v, want %v", err, ErrSlowDown)
+	}
+
+	// Users type codes loosely
+	typed := strings.ToLower(strings.ReplaceAll(userCode, "-", " "))
+	pending, err := PendingDeviceGrant(store, typed, now)
+	if err != nil || pending.ClientID != "cli" {
+		t.Fatalf("PendingDeviceGrant() = %+v, %v", pending, err)
+	}
+	if err := DecideDeviceGrant(store, typed, now, func(g *DeviceGrant) { g.UserID = "jdoe" }); err != nil {
+		t.Fatalf("DecideDeviceGrant() unexpected error: %v", err)
+	}
+	if err := DecideDeviceGrant(store, typed, now, nil); !errors.Is(err, ErrInvalidUserCode) {
+		t.Errorf("second decision error = %v, want %v", err, ErrInvalidUserCode)
+	}
+
+	// Each slow_down added 5s, so the client must now wait 15s
+	now = now.Add(15 * time.Second)
+	grant, err := PollDeviceCode(store, deviceCode, now)
+	if err != nil || grant.UserID != "jdoe" || grant.Status != DeviceRedeemed {
+		t.Fatalf("PollDeviceCode() = %+v, %v; want the approved grant", grant, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := PollDeviceCode(store, deviceCode, now); !errors.Is(err, ErrInvalidDeviceCode) {
+		t.Errorf("redeemed code error = %v, want %v", err, ErrInvalidDeviceCode)
+	}
+}
+
+func TestDeviceGrantDeniedAndExpired(t *testing.T) {
+	store := NewMemoryDeviceStore()
+	now := time.Now()
+
+	deviceCode, userCode, _ := StartDeviceAuthorization(store, DeviceGrant{ClientID: "cli", ExpiresAt: now.Add(time.Minute)})
+	if err := DecideDeviceGrant(store, userCode, now, nil); err != nil {
+		t.Fatalf("DecideDeviceGrant(deny) unexpected error: %v", err)
+	}
+	if _, err := PollDeviceCode(store, deviceCode, now); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("denied poll error = %v, want %v", err, ErrAccessDenied)
+	}
+
+	deviceCode, userCode, _ = StartDeviceAuthorization(store, DeviceGrant{ClientID: "cli", ExpiresAt: now.Add(time.Minute)})
+	later := now.Add(2 * time.Minute)
+	if _, err := PendingDeviceGrant(store, userCode, later); !errors.Is(err, ErrInvalidUserCode) {
+		t.Errorf("expired user code error = %v, want %v", err, ErrInvalidUserCode)
+	}
+	if _, err := PollDeviceCode(store, deviceCode, later); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expired poll error = %v, want %v", err, ErrExpiredToken)
+	}
+
+	if _, err := PollDeviceCode(store, "unknown", now); !errors.Is(err, ErrInvalidDeviceCode) {
+		t.Errorf("unknown code error = %v, want %v", err, ErrInvalidDeviceCode)
+	}
+}
+
+func TestMemoryDeviceStoreUserCodes(t *testing.T) {
+	store := NewMemoryDeviceStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	grant := DeviceGrant{DeviceCodeHash: "a", UserCode: "BCDFGHJK", ExpiresAt: now.Add(time.Minute)}
+	if err := store.Save(grant); err != nil {
+		t.Fatal(err)
+	}
+	grant.DeviceCodeHash = "b"
+	if err := store.Save(grant); !errors.Is(err, ErrUserCodeTaken) {
+		t.Errorf("duplicate user code error = %v, want %v", err, ErrUserCodeTaken)
+	}
+
+	// Codes of expired grants can be reused
+	now = now.Add(2 * time.Minute)
+	grant.ExpiresAt = now.Add(time.Minute)
+	if err := store.Save(grant); err != nil {
+		t.Errorf("reusing expired user code: %v", err)
+	}
+}
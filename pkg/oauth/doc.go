@@ -0,0 +1,45 @@
+// Package oauth provides the OAuth 2.0 protocol pieces shared by the
+// service's endpoints, starting with client authentication.
+//
+// Clients authenticate with HTTP Basic or with client_id and client_secret
+// form parameters (RFC 6749 section 2.3.1). Secrets are stored as password
+// hashes understood by package passwd:
+//
+//	clients := oauth.StaticRegistry{
+//		"api-gateway": {ID: "api-gateway", SecretHash: "$argon2id$v=19$m=65536,t=3,p=2$..."},
+//	}
+//
+//	clientID, secret, err := oauth.ClientCredentials(r)
+//	if err == nil {
+//		err = clients.AuthenticateClient(clientID, secret)
+//	}
+//
+// For the authorization code flow, a ClientRegistry holds the registered
+// redirect URIs of each client, PKCE (RFC 7636) is mandatory with the S256
+// method, and codes are single use: IssueCode stores a code by hash and
+// RedeemCode consumes it, reporting ErrCodeReused on a second attempt so
+// that the tokens issued for it can be revoked.
+//
+// Relying applications are described in a FileRegistry: how each client
+// authenticates (a secret hash, or public keys for private_key_jwt), its
+// redirect URIs, allowed grant types, token audience and lifetimes, and
+// the role mapping profile of its users. Start reloads the file when it
+// changes:
+//
+//	registry, err := oauth.LoadRegistry("clients.json",
+//		oauth.WithAssertionAudience("https://auth.example.com/token"))
+//	registry.Start(ctx, 30*time.Second, logger)
+//
+//	client, err := registry.AuthenticateRequest(r)
+//
+// The device authorization grant (RFC 8628) keeps pending grants in a
+// DeviceStore. StartDeviceAuthorization returns the device code for the
+// client and the user code for the user, DecideDeviceGrant records the
+// user's decision, and PollDeviceCode answers the client's polls with
+// ErrAuthorizationPending, ErrSlowDown and the other polling errors until
+// it hands out the approved grant once.
+//
+// Token exchange (RFC 8693) is governed by each client's ExchangePolicy:
+// the audiences it may obtain tokens for, the most scope those tokens can
+// carry, the actors it may name and how long the act chain may grow.
+package oauth
@@ -0,0 +1,108 @@
+// pkg/oauth/exchange.go
+package oauth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+// Token types of the token exchange grant (RFC 8693 section 3). Access
+// tokens issued by this service are JWTs, so both name the same tokens.
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// DefaultMaxDelegation is the longest act chain an exchange may produce
+// when the policy does not set one
+const DefaultMaxDelegation = 3
+
+var (
+	// ErrInvalidTarget is returned for audiences a client may not exchange
+	// tokens for
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrInvalidActor is returned for actors a client may not name
+	ErrInvalidActor = errors.New("invalid actor")
+	// ErrDelegationDepth is returned when an exchange would make the act
+	// chain longer than the policy allows
+	ErrDelegationDepth = errors.New("delegation chain too long")
+)
+
+// ExchangePolicy limits the token exchanges (RFC 8693) of a client. An
+// exchanged token keeps the subject of the presented token but is issued
+// for another audience, with at most the presented token's scope, and
+// records the client, or the party named by the actor token, in the act
+// claim.
+type ExchangePolicy struct {
+	// Audiences the client may request tokens for
+	Audiences []string `json:"audiences"`
+	// Scopes caps the scope of exchanged tokens. When empty, exchanged
+	// tokens can only narrow the scope of the presented token.
+	Scopes []string `json:"scopes,omitempty"`
+	// Actors may be named by actor tokens besides the client itself
+	Actors []string `json:"actors,omitempty"`
+	// MaxDelegation caps the length of the act chain. Defaults to
+	// DefaultMaxDelegation.
+	MaxDelegation int `json:"max_delegation,omitempty"`
+}
+
+// AllowsAudience reports whether the client may exchange tokens for audience
+func (p *ExchangePolicy) AllowsAudience(audience string) bool {
+	return contains(p.Audiences, audience)
+}
+
+// AllowsActor reports whether clientID may exchange tokens on behalf of actor
+func (p *ExchangePolicy) AllowsActor(clientID, actor string) bool {
+	return actor == clientID || contains(p.Actors, actor)
+}
+
+// ExchangeScopes returns the scopes of an exchanged token. held are the
+// scopes of the presented token; a token without scopes is limited to the
+// policy's scopes. Without requested scopes everything allowed is granted.
+func (p *ExchangePolicy) ExchangeScopes(held, requested []string) ([]string, error) {
+	allowed := held
+	if len(allowed) == 0 {
+		allowed = p.Scopes
+	}
+	if len(p.Scopes) > 0 {
+		var capped []string
+		for _, scope := range allowed {
+			if contains(p.Scopes, scope) {
+				capped = append(capped, scope)
+			}
+		}
+		allowed = capped
+	}
+
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	for _, scope := range requested {
+		if !contains(allowed, scope) {
+			return nil, fmt.Errorf("%w: %q cannot be obtained by exchange", ErrInvalidScope, scope)
+		}
+	}
+	return requested, nil
+}
+
+// Delegate returns the act claim of an exchanged token: actor followed by
+// the chain of the presented token
+func (p *ExchangePolicy) Delegate(prior *auth.Actor, actor string) (*auth.Actor, error) {
+	max := p.MaxDelegation
+	if max <= 0 {
+		max = DefaultMaxDelegation
+	}
+	act := &auth.Actor{Subject: actor, Actor: prior}
+	if act.Depth() > max {
+		return nil, fmt.Errorf("%w: %d actors, at most %d allowed", ErrDelegationDepth, act.Depth(), max)
+	}
+	return act, nil
+}
+
+// ExchangeableTokenType reports whether tokens of tokenType can be
+// presented in a token exchange
+func ExchangeableTokenType(tokenType string) bool {
+	return tokenType == TokenTypeAccessToken || tokenType == TokenTypeJWT
+}
@@ -0,0 +1,72 @@
+// pkg/oauth/exchange_test.go
+package oauth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+func TestExchangeScopes(t *testing.T) {
+	capped := &ExchangePolicy{Audiences: []string{"ledger"}, Scopes: []string{"ledger:read", "profile"}}
+	open := &ExchangePolicy{Audiences: []string{"ledger"}}
+
+	tests := []struct {
+		name      string
+		policy    *ExchangePolicy
+		held      []string
+		requested []string
+		want      []string
+		wantErr   bool
+	}{
+		{"narrowed to policy", capped, []string{"openid", "profile", "ledger:read"}, nil, []string{"profile", "ledger:read"}, false},
+		{"requested subset", capped, []string{"profile", "ledger:read"}, []string{"ledger:read"}, []string{"ledger:read"}, false},
+		{"not held", capped, []string{"profile"}, []string{"ledger:read"}, nil, true},
+		{"outside policy", capped, []string{"ledger:write"}, []string{"ledger:write"}, nil, true},
+		{"token without scope", capped, nil, []string{"ledger:read"}, []string{"ledger:read"}, false},
+		{"open policy keeps held", open, []string{"a", "b"}, nil, []string{"a", "b"}, false},
+		{"open policy cannot widen", open, []string{"a"}, []string{"b"}, nil, true},
+		{"open policy without scope", open, nil, []string{"a"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.ExchangeScopes(tt.held, tt.requested)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidScope) {
+					t.Errorf("ExchangeScopes() error = %v, want %v", err, ErrInvalidScope)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExchangeScopes() = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeDelegate(t *testing.T) {
+	policy := &ExchangePolicy{Audiences: []string{"ledger"}, Actors: []string{"batch"}, MaxDelegation: 2}
+
+	if !policy.AllowsActor("orders", "orders") || !policy.AllowsActor("orders", "batch") || policy.AllowsActor("orders", "jdoe") {
+		t.Error("AllowsActor() must accept the client itself and the listed actors only")
+	}
+
+	act, err := policy.Delegate(nil, "gateway")
+	if err != nil || act.Subject != "gateway" || act.Actor != nil {
+		t.Fatalf("Delegate(nil) = %+v, %v", act, err)
+	}
+	act, err = policy.Delegate(act, "orders")
+	if err != nil || act.Subject != "orders" || act.Actor.Subject != "gateway" {
+		t.Fatalf("Delegate(gateway) = %+v, %v; want orders after gateway", act, err)
+	}
+	if _, err := policy.Delegate(act, "ledger"); !errors.Is(err, ErrDelegationDepth) {
+		t.Errorf("third delegation error = %v, want %v", err, ErrDelegationDepth)
+	}
+
+	deep := &auth.Actor{Subject: "a", Actor: &auth.Actor{Subject: "b", Actor: &auth.Actor{Subject: "c"}}}
+	if _, err := (&ExchangePolicy{}).Delegate(deep, "d"); !errors.Is(err, ErrDelegationDepth) {
+		t.Errorf("default limit error = %v, want %v", err, ErrDelegationDepth)
+	}
+}
@@ -0,0 +1,164 @@
+// pkg/oauth/file_registry.go
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+)
+
+// registryFile is the format of the client registry file
+type registryFile struct {
+	Clients []*Client `json:"clients"`
+}
+
+// RegistryOption configures a FileRegistry
+type RegistryOption func(*FileRegistry)
+
+// WithAssertionAudience enables private_key_jwt client authentication for
+// assertions addressed to any of audiences, normally the token endpoint
+// URL and the issuer
+func WithAssertionAudience(audiences ...string) RegistryOption {
+	return func(f *FileRegistry) {
+		f.assertions = NewAssertionVerifier(audiences...)
+	}
+}
+
+// FileRegistry is a ClientRegistry read from a JSON file:
+//
+//	{"clients": [
+//	  {"client_id": "portal", "client_secret_hash": "$argon2id$...",
+//	   "redirect_uris": ["https://portal.example.com/callback"],
+//	   "application": "portal", "access_token_lifetime": "15m"},
+//	  {"client_id": "nightly-batch", "jwks": {"keys": [...]},
+//	   "grant_types": ["client_credentials"], "audience": "ledger"}
+//	]}
+//
+// Reload, or Start, picks up changes without a restart. It is safe for
+// concurrent use.
+type FileRegistry struct {
+	mu         sync.RWMutex
+	path       string
+	modTime    time.Time
+	clients    StaticRegistry
+	assertions *AssertionVerifier
+}
+
+// LoadRegistry reads the client registry at path
+func LoadRegistry(path string, opts ...RegistryOption) (*FileRegistry, error) {
+	f := &FileRegistry{path: path}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the registry file. On error the current clients are kept.
+func (f *FileRegistry) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read client registry: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read client registry: %w", err)
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse client registry: %w", err)
+	}
+
+	clients := make(StaticRegistry, len(file.Clients))
+	for _, client := range file.Clients {
+		if client == nil {
+			return fmt.Errorf("client registry has an empty entry")
+		}
+		if err := client.Validate(); err != nil {
+			return err
+		}
+		if _, ok := clients[client.ID]; ok {
+			return fmt.Errorf("duplicate client %q", client.ID)
+		}
+		clients[client.ID] = client
+	}
+
+	f.mu.Lock()
+	f.clients = clients
+	f.modTime = info.ModTime()
+	f.mu.Unlock()
+	return nil
+}
+
+// Start reloads the registry every interval, when the file has changed,
+// until ctx is cancelled. Errors are reported to logger, which may be nil.
+func (f *FileRegistry) Start(ctx context.Context, interval time.Duration, logger auth.Logger) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				f.tick(logger)
+			}
+		}
+	}()
+}
+
+func (f *FileRegistry) tick(logger auth.Logger) {
+	info, err := os.Stat(f.path)
+	if err == nil {
+		f.mu.RLock()
+		unchanged := info.ModTime().Equal(f.modTime)
+		f.mu.RUnlock()
+		if unchanged {
+			return
+		}
+		err = f.Reload()
+	}
+	if logger == nil {
+		return
+	}
+	if err != nil {
+		logger.Info("Client registry reload failed", "error", err)
+		return
+	}
+	logger.Info("Client registry reloaded", "path", f.path)
+}
+
+func (f *FileRegistry) registry() StaticRegistry {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.clients
+}
+
+func (f *FileRegistry) Client(clientID string) (*Client, error) {
+	return f.registry().Client(clientID)
+}
+
+func (f *FileRegistry) AuthenticateClient(clientID, secret string) error {
+	return f.registry().AuthenticateClient(clientID, secret)
+}
+
+// AuthenticateRequest authenticates the client of a token endpoint request
+// with a secret or, when enabled, private_key_jwt
+func (f *FileRegistry) AuthenticateRequest(r *http.Request) (*Client, error) {
+	return AuthenticateRequest(r, f, f.assertions)
+}
+
+// AllowedPostLogoutRedirect lets the registry serve as the logout redirect
+// policy of pkg/auth
+func (f *FileRegistry) AllowedPostLogoutRedirect(clientID, uri string) bool {
+	return f.registry().AllowedPostLogoutRedirect(clientID, uri)
+}
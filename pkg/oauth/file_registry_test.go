@@ -0,0 +1,127 @@
+// pkg/oauth/file_registry_test.go
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+func writeRegistry(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	hash, err := passwd.HashWithParams("s3cret", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeRegistry(t, path, `{"clients": [
+		{"client_id": "portal", "client_secret_hash": "`+hash+`",
+		 "redirect_uris": ["https://portal.example.com/cb"],
+		 "post_logout_redirect_uris": ["https://portal.example.com/"],
+		 "application": "portal", "access_token_lifetime": "15m"}
+	]}`)
+
+	registry, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatalf("LoadRegistry() unexpected error: %v", err)
+	}
+	portal, err := registry.Client("portal")
+	if err != nil || time.Duration(portal.AccessTokenLifetime) != 15*time.Minute {
+		t.Fatalf("Client(portal) = %+v, %v", portal, err)
+	}
+	if err := registry.AuthenticateClient("portal", "s3cret"); err != nil {
+		t.Errorf("AuthenticateClient() unexpected error: %v", err)
+	}
+	if !registry.AllowedPostLogoutRedirect("portal", "https://portal.example.com/") {
+		t.Error("registered post-logout redirect rejected")
+	}
+
+	// Invalid files keep the current clients
+	writeRegistry(t, path, `{"clients": [{"client_id": "portal"}, {"client_id": "portal"}]}`)
+	if err := registry.Reload(); err == nil {
+		t.Error("Reload() expected error for duplicate clients")
+	}
+	writeRegistry(t, path, `{"clients": [`)
+	if err := registry.Reload(); err == nil {
+		t.Error("Reload() expected error for malformed JSON")
+	}
+	if _, err := registry.Client("portal"); err != nil {
+		t.Errorf("Client(portal) after failed reload: %v", err)
+	}
+
+	writeRegistry(t, path, `{"clients": [{"client_id": "cli", "grant_types": ["`+GrantDeviceCode+`"]}]}`)
+	if err := registry.Reload(); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if _, err := registry.Client("portal"); !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("removed client still registered: %v", err)
+	}
+}
+
+func TestFileRegistryPrivateKeyJWT(t *testing.T) {
+	client, key := keyClient(t, "batch")
+	jwks, err := json.Marshal(client.JWKS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeRegistry(t, path, `{"clients": [{"client_id": "batch", "jwks": `+string(jwks)+`, "grant_types": ["client_credentials"]}]}`)
+
+	registry, err := LoadRegistry(path, WithAssertionAudience(testTokenEndpoint))
+	if err != nil {
+		t.Fatalf("LoadRegistry() unexpected error: %v", err)
+	}
+
+	form := url.Values{
+		"client_assertion_type": {ClientAssertionType},
+		"client_assertion":      {signAssertion(t, key, jwt.SigningMethodES256, "batch-1", assertionClaims("batch", "x"))},
+	}
+	authenticated, err := registry.AuthenticateRequest(formRequest(form))
+	if err != nil || authenticated.ID != "batch" {
+		t.Errorf("AuthenticateRequest() = %v, %v; want batch", authenticated, err)
+	}
+	if _, err := registry.AuthenticateRequest(formRequest(form)); !errors.Is(err, ErrAssertionReplayed) {
+		t.Errorf("replayed assertion error = %v, want %v", err, ErrAssertionReplayed)
+	}
+}
+
+func TestFileRegistryStartReloadsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeRegistry(t, path, `{"clients": [{"client_id": "a"}]}`)
+	registry, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry.Start(ctx, 10*time.Millisecond, nil)
+
+	writeRegistry(t, path, `{"clients": [{"client_id": "b"}]}`)
+	// Make the change visible even on file systems with coarse timestamps
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := registry.Client("b"); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("registry change not picked up")
+}
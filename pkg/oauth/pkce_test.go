@@ -0,0 +1,54 @@
+// pkg/oauth/pkce_test.go
+package oauth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// From RFC 7636 appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestValidateCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		wantErr   bool
+	}{
+		{name: "S256", challenge: testChallenge, method: "S256"},
+		{name: "plain", challenge: testChallenge, method: "plain", wantErr: true},
+		{name: "missing method", challenge: testChallenge, wantErr: true},
+		{name: "missing challenge", method: "S256", wantErr: true},
+		{name: "wrong length", challenge: testChallenge[:42], method: "S256", wantErr: true},
+		{name: "invalid characters", challenge: strings.Repeat("+", 43), method: "S256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCodeChallenge(tt.challenge, tt.method)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateCodeChallenge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPKCE) {
+				t.Errorf("error = %v, want ErrInvalidPKCE", err)
+			}
+		})
+	}
+}
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	if err := VerifyCodeVerifier(testVerifier, testChallenge); err != nil {
+		t.Errorf("VerifyCodeVerifier() unexpected error: %v", err)
+	}
+	if err := VerifyCodeVerifier(strings.Repeat("a", 43), testChallenge); !errors.Is(err, ErrInvalidPKCE) {
+		t.Errorf("wrong verifier error = %v, want ErrInvalidPKCE", err)
+	}
+	if err := VerifyCodeVerifier("short", testChallenge); !errors.Is(err, ErrInvalidPKCE) {
+		t.Errorf("short verifier error = %v, want ErrInvalidPKCE", err)
+	}
+}
@@ -0,0 +1,266 @@
+// pkg/oauth/registry.go
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+var (
+	// ErrUnknownClient is returned by ClientRegistry for unregistered client IDs
+	ErrUnknownClient = errors.New("unknown client")
+	// ErrInvalidScope is returned for scopes a client may not request
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// Grant types a client can be registered for
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// Token endpoint authentication methods (RFC 7591 section 2)
+const (
+	AuthMethodNone              = "none"
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+)
+
+// Client is a registered OAuth client: a relying application, an OpenID
+// Connect relying party or a non-human service
+type Client struct {
+	ID   string `json:"client_id"`
+	Name string `json:"client_name,omitempty"`
+	// SecretHash is a package passwd hash of the client secret
+	SecretHash string `json:"client_secret_hash,omitempty"`
+	// JWKS holds the public keys of clients authenticating with
+	// private_key_jwt. Clients with neither a secret nor keys are public,
+	// such as single page apps and CLI tools.
+	JWKS *auth.JWKSet `json:"jwks,omitempty"`
+	// RedirectURIs must match redirect_uri exactly
+	RedirectURIs           []string `json:"redirect_uris,omitempty"`
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+	// GrantTypes the client may use. Defaults to authorization_code for
+	// clients with redirect URIs.
+	GrantTypes []string `json:"grant_types,omitempty"`
+	// Scopes are granted to the client itself by the client_credentials
+	// grant. Requests may narrow them. They also bound the scopes users
+	// can grant the client beyond the OpenID Connect ones.
+	Scopes []string `json:"scopes,omitempty"`
+	// TokenExchange is required for the token exchange grant and limits
+	// the tokens the client can obtain with it
+	TokenExchange *ExchangePolicy `json:"token_exchange,omitempty"`
+	// Application selects the role mapping profile for the client's users
+	Application string `json:"application,omitempty"`
+	// Audience of the client's access tokens. Defaults to Application, and
+	// to the configured audience when both are empty.
+	Audience string `json:"audience,omitempty"`
+	// DPoPBoundAccessTokens requires a DPoP proof with every token request
+	// so that all of the client's access tokens are bound (RFC 9449)
+	DPoPBoundAccessTokens bool `json:"dpop_bound_access_tokens,omitempty"`
+	// TLSClientCertificateBoundAccessTokens requires token requests over
+	// mutual TLS so that all of the client's access tokens are bound to its
+	// certificate (RFC 8705)
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+	// Token lifetimes override the endpoint defaults when set
+	AccessTokenLifetime Duration `json:"access_token_lifetime,omitempty"`
+	IDTokenLifetime     Duration `json:"id_token_lifetime,omitempty"`
+}
+
+// Public reports whether the client cannot authenticate
+func (c *Client) Public() bool {
+	return c.SecretHash == "" && c.JWKS == nil
+}
+
+// AuthMethod returns how the client authenticates at the token endpoint
+func (c *Client) AuthMethod() string {
+	switch {
+	case c.JWKS != nil:
+		return AuthMethodPrivateKeyJWT
+	case c.SecretHash != "":
+		return AuthMethodClientSecretBasic
+	default:
+		return AuthMethodNone
+	}
+}
+
+// AllowsRedirectURI reports whether uri is registered for the client
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsPostLogoutRedirectURI reports whether uri is registered for the
+// client's RP-initiated logout
+func (c *Client) AllowsPostLogoutRedirectURI(uri string) bool {
+	return contains(c.PostLogoutRedirectURIs, uri)
+}
+
+// AllowsGrantType reports whether the client may use grantType
+func (c *Client) AllowsGrantType(grantType string) bool {
+	if len(c.GrantTypes) == 0 {
+		return grantType == GrantAuthorizationCode && len(c.RedirectURIs) > 0
+	}
+	return contains(c.GrantTypes, grantType)
+}
+
+// GrantScopes returns the scopes a client_credentials request gets: all
+// registered scopes when none are requested, or the requested ones if the
+// client holds each of them
+func (c *Client) GrantScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return c.Scopes, nil
+	}
+	for _, scope := range requested {
+		if !contains(c.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %q not registered for client %q", ErrInvalidScope, scope, c.ID)
+		}
+	}
+	return requested, nil
+}
+
+// TokenAudience returns the audience of the client's access tokens, or ""
+// for the configured default
+func (c *Client) TokenAudience() string {
+	if c.Audience != "" {
+		return c.Audience
+	}
+	return c.Application
+}
+
+// Validate checks a registration for mistakes that would make the client
+// unusable or unsafe
+func (c *Client) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if c.SecretHash != "" && c.JWKS != nil {
+		return fmt.Errorf("client %q: use either a secret or keys", c.ID)
+	}
+	if c.JWKS != nil {
+		if len(c.JWKS.Keys) == 0 {
+			return fmt.Errorf("client %q: jwks has no keys", c.ID)
+		}
+		for _, jwk := range c.JWKS.Keys {
+			if _, err := jwk.PublicKey(); err != nil {
+				return fmt.Errorf("client %q: %w", c.ID, err)
+			}
+		}
+	}
+	for _, uri := range append(append([]string{}, c.RedirectURIs...), c.PostLogoutRedirectURIs...) {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("client %q: redirect URI %q must be absolute without fragment", c.ID, uri)
+		}
+	}
+	for _, grantType := range c.GrantTypes {
+		switch grantType {
+		case GrantAuthorizationCode:
+			if len(c.RedirectURIs) == 0 {
+				return fmt.Errorf("client %q: authorization_code needs redirect_uris", c.ID)
+			}
+		case GrantClientCredentials:
+			if c.Public() {
+				return fmt.Errorf("client %q: client_credentials needs a confidential client", c.ID)
+			}
+		case GrantTokenExchange:
+			if c.Public() {
+				return fmt.Errorf("client %q: token exchange needs a confidential client", c.ID)
+			}
+			if c.TokenExchange == nil || len(c.TokenExchange.Audiences) == 0 {
+				return fmt.Errorf("client %q: token exchange needs a token_exchange policy with audiences", c.ID)
+			}
+		case GrantRefreshToken, GrantDeviceCode:
+		default:
+			return fmt.Errorf("client %q: unknown grant type %q", c.ID, grantType)
+		}
+	}
+	if c.AccessTokenLifetime < 0 || c.IDTokenLifetime < 0 {
+		return fmt.Errorf("client %q: token lifetimes must not be negative", c.ID)
+	}
+	return nil
+}
+
+// ClientRegistry looks up and authenticates registered clients
+type ClientRegistry interface {
+	// Client returns ErrUnknownClient for unregistered IDs
+	Client(clientID string) (*Client, error)
+	// AuthenticateClient verifies the secret of a confidential client.
+	// Clients using private_key_jwt cannot authenticate with a secret.
+	AuthenticateClient(clientID, secret string) error
+}
+
+// StaticRegistry is a fixed set of clients keyed by ID
+type StaticRegistry map[string]*Client
+
+func (r StaticRegistry) Client(clientID string) (*Client, error) {
+	client, ok := r[clientID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownClient, clientID)
+	}
+	return client, nil
+}
+
+func (r StaticRegistry) AuthenticateClient(clientID, secret string) error {
+	client, ok := r[clientID]
+	if !ok || client.SecretHash == "" {
+		return fmt.Errorf("%w: no client %q with a secret", ErrInvalidClient, clientID)
+	}
+	return verifySecret(client, secret)
+}
+
+// AllowedPostLogoutRedirect lets the registry serve as the logout redirect
+// policy of pkg/auth
+func (r StaticRegistry) AllowedPostLogoutRedirect(clientID, uri string) bool {
+	client, ok := r[clientID]
+	return ok && client.AllowsPostLogoutRedirectURI(uri)
+}
+
+func verifySecret(client *Client, secret string) error {
+	match, err := passwd.Verify(client.SecretHash, secret)
+	if err != nil {
+		return fmt.Errorf("client %q: %w", client.ID, err)
+	}
+	if !match {
+		return fmt.Errorf("%w: wrong secret for %q", ErrInvalidClient, client.ID)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Duration is a time.Duration written as a string such as "15m" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15m\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
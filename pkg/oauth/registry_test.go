@@ -0,0 +1,136 @@
+// pkg/oauth/registry_test.go
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/auth"
+	"github.com/yovily/customers/citi/auth-service/pkg/passwd"
+)
+
+func TestStaticRegistry(t *testing.T) {
+	hash, err := passwd.HashWithParams("s3cret", testParams)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	registry := StaticRegistry{
+		"portal": {ID: "portal", SecretHash: hash, RedirectURIs: []string{"https://portal.example.com/cb"},
+			PostLogoutRedirectURIs: []string{"https://portal.example.com/bye"}},
+		"spa": {ID: "spa", RedirectURIs: []string{"https://spa.example.com/cb"}},
+	}
+
+	if _, err := registry.Client("nobody"); !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("Client() error = %v, want %v", err, ErrUnknownClient)
+	}
+
+	portal, err := registry.Client("portal")
+	if err != nil || portal.Public() {
+		t.Fatalf("Client(portal) = %+v, %v; want confidential client", portal, err)
+	}
+	if !portal.AllowsRedirectURI("https://portal.example.com/cb") || portal.AllowsRedirectURI("https://portal.example.com/cb/") {
+		t.Error("redirect URIs must match exactly")
+	}
+
+	if err := registry.AuthenticateClient("portal", "s3cret"); err != nil {
+		t.Errorf("AuthenticateClient() unexpected error: %v", err)
+	}
+	if err := registry.AuthenticateClient("portal", "wrong"); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("AuthenticateClient() wrong secret error = %v", err)
+	}
+	if err := registry.AuthenticateClient("nobody", "s3cret"); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("AuthenticateClient() unknown client error = %v", err)
+	}
+	if err := registry.AuthenticateClient("spa", ""); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("public clients cannot authenticate: %v", err)
+	}
+
+	if !registry.AllowedPostLogoutRedirect("portal", "https://portal.example.com/bye") {
+		t.Error("registered post-logout redirect rejected")
+	}
+	if registry.AllowedPostLogoutRedirect("spa", "https://portal.example.com/bye") {
+		t.Error("post-logout redirect of another client allowed")
+	}
+}
+
+func TestClientGrantTypesAndAudience(t *testing.T) {
+	web := &Client{ID: "web", RedirectURIs: []string{"https://web.example.com/cb"}, Application: "portal"}
+	if !web.AllowsGrantType(GrantAuthorizationCode) || web.AllowsGrantType(GrantClientCredentials) {
+		t.Error("clients with redirect URIs default to authorization_code only")
+	}
+	if web.TokenAudience() != "portal" {
+		t.Errorf("TokenAudience() = %q, want the application", web.TokenAudience())
+	}
+
+	batch := &Client{ID: "batch", SecretHash: "x", GrantTypes: []string{GrantClientCredentials}, Application: "portal", Audience: "ledger"}
+	if batch.AllowsGrantType(GrantAuthorizationCode) || !batch.AllowsGrantType(GrantClientCredentials) {
+		t.Error("registered grant types not honoured")
+	}
+	if batch.TokenAudience() != "ledger" {
+		t.Errorf("TokenAudience() = %q, want ledger", batch.TokenAudience())
+	}
+	if batch.AuthMethod() != AuthMethodClientSecretBasic {
+		t.Errorf("AuthMethod() = %q", batch.AuthMethod())
+	}
+}
+
+func TestClientValidate(t *testing.T) {
+	keys, _ := keyClient(t, "k")
+	tests := []struct {
+		name   string
+		client Client
+	}{
+		{"no ID", Client{}},
+		{"secret and keys", Client{ID: "c", SecretHash: "x", JWKS: keys.JWKS}},
+		{"empty key set", Client{ID: "c", JWKS: &auth.JWKSet{}}},
+		{"bad key", Client{ID: "c", JWKS: &auth.JWKSet{Keys: []auth.JWK{{Kty: "RSA"}}}}},
+		{"relative redirect", Client{ID: "c", RedirectURIs: []string{"/cb"}}},
+		{"redirect with fragment", Client{ID: "c", RedirectURIs: []string{"https://c.example.com/cb#x"}}},
+		{"code without redirect", Client{ID: "c", GrantTypes: []string{GrantAuthorizationCode}}},
+		{"public client credentials", Client{ID: "c", GrantTypes: []string{GrantClientCredentials}}},
+		{"unknown grant", Client{ID: "c", SecretHash: "x", GrantTypes: []string{"password"}}},
+		{"exchange without policy", Client{ID: "c", SecretHash: "x", GrantTypes: []string{GrantTokenExchange}}},
+		{"public exchange", Client{ID: "c", GrantTypes: []string{GrantTokenExchange}, TokenExchange: &ExchangePolicy{Audiences: []string{"a"}}}},
+		{"negative lifetime", Client{ID: "c", AccessTokenLifetime: Duration(-time.Minute)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.client.Validate(); err == nil {
+				t.Error("Validate() expected error")
+			}
+		})
+	}
+
+	if err := keys.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error for key client: %v", err)
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var client Client
+	if err := json.Unmarshal([]byte(`{"client_id":"c","access_token_lifetime":"15m"}`), &client); err != nil {
+		t.Fatalf("Unmarshal() unexpected error: %v", err)
+	}
+	if time.Duration(client.AccessTokenLifetime) != 15*time.Minute {
+		t.Errorf("AccessTokenLifetime = %v, want 15m", time.Duration(client.AccessTokenLifetime))
+	}
+	if err := json.Unmarshal([]byte(`{"access_token_lifetime":900}`), &client); err == nil {
+		t.Error("Unmarshal() expected error for a number")
+	}
+}
+
+func TestClientGrantScopes(t *testing.T) {
+	client := &Client{ID: "batch", Scopes: []string{"ledger:read", "ledger:write"}}
+
+	if got, err := client.GrantScopes(nil); err != nil || len(got) != 2 {
+		t.Errorf("GrantScopes(nil) = %v, %v; want all registered scopes", got, err)
+	}
+	if got, err := client.GrantScopes([]string{"ledger:read"}); err != nil || len(got) != 1 || got[0] != "ledger:read" {
+		t.Errorf("GrantScopes(ledger:read) = %v, %v", got, err)
+	}
+	if _, err := client.GrantScopes([]string{"ledger:read", "admin"}); !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("GrantScopes(admin) error = %v, want %v", err, ErrInvalidScope)
+	}
+}
@@ -0,0 +1,16 @@
+// Package passwd hashes and verifies passwords stored by the service itself,
+// such as local break-glass accounts.
+//
+// New hashes use Argon2id encoded in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// Verification also accepts bcrypt hashes ($2a$, $2b$, $2y$) so existing
+// htpasswd-style files can be used unchanged.
+//
+// Basic usage:
+//
+//	hash, err := passwd.Hash("correct horse battery staple")
+//
+//	ok, err := passwd.Verify(hash, candidate)
+package passwd
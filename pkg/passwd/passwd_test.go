@@ -0,0 +1,92 @@
+// pkg/passwd/passwd_test.go
+package passwd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := HashWithParams("s3cret", testParams)
+	if err != nil {
+		t.Fatalf("HashWithParams() unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash = %v, want argon2id PHC string", hash)
+	}
+
+	other, _ := HashWithParams("s3cret", testParams)
+	if hash == other {
+		t.Error("hashes of the same password must use different salts")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"correct password", "s3cret", true},
+		{"wrong password", "S3cret", false},
+		{"empty password", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(hash, tt.password)
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	raw, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	// htpasswd writes $2y$, which is the same algorithm
+	for _, hash := range []string{string(raw), "$2y$" + string(raw)[4:]} {
+		if ok, err := Verify(hash, "s3cret"); err != nil || !ok {
+			t.Errorf("Verify(%s) = %v, %v, want true", hash[:4], ok, err)
+		}
+		if ok, _ := Verify(hash, "wrong"); ok {
+			t.Errorf("Verify(%s) accepted wrong password", hash[:4])
+		}
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"unknown scheme", "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="},
+		{"plaintext", "s3cret"},
+		{"truncated argon2id", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA"},
+		{"bad parameters", "$argon2id$v=19$m=x$c2FsdA$a2V5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := Verify(tt.hash, "s3cret")
+			if err == nil || ok {
+				t.Errorf("Verify() = %v, %v, want error", ok, err)
+			}
+		})
+	}
+
+	if _, err := Verify("plaintext", "x"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("Verify() error = %v, want ErrUnsupportedHash", err)
+	}
+}
@@ -0,0 +1,45 @@
+// Package roles derives application roles from directory groups and
+// attributes, so that roles in tokens come from the directory rather than
+// from what a client asks for.
+//
+// Mappings are read from a JSON file maintained by operators:
+//
+//	{
+//	  "default": {
+//	    "rules": [
+//	      {"group": "CN=All Staff,*", "roles": ["user"]}
+//	    ],
+//	    "deny": [
+//	      {"attribute": "employeeType", "value": "contractor", "roles": ["admin"]}
+//	    ]
+//	  },
+//	  "applications": {
+//	    "payments": {
+//	      "rules": [
+//	        {"group": "payments-admins", "roles": ["admin"]},
+//	        {"group": "payments-*", "attribute": "department", "value": "Treasury", "roles": ["approver"]}
+//	      ],
+//	      "deny": [
+//	        {"group": "CN=Suspended,*"}
+//	      ]
+//	    }
+//	  }
+//	}
+//
+// Group patterns are case-insensitive globs ("*" and "?") matched against
+// both the full group DN and its common name. A rule with a group and an
+// attribute needs both to match. The default profile applies to every
+// application; an application's rules and deny rules are added to it.
+//
+// Deny rules are applied after all grants: a matching deny rule removes the
+// roles it lists, or every role when it lists none.
+//
+// Basic usage:
+//
+//	mapper, err := roles.Load("/etc/auth-service/roles.json")
+//
+//	granted, err := mapper.Roles("payments", roles.Subject{
+//		Groups:     user.Groups,
+//		Attributes: user.Attributes,
+//	})
+package roles
@@ -0,0 +1,286 @@
+// pkg/roles/roles.go
+package roles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownApplication is returned for applications without a mapping
+var ErrUnknownApplication = errors.New("unknown application")
+
+// Config is the role mapping file
+type Config struct {
+	// Default applies to every application, including the empty one
+	Default      Profile            `json:"default"`
+	Applications map[string]Profile `json:"applications"`
+}
+
+// Profile is a set of grant and deny rules
+type Profile struct {
+	Rules []Rule `json:"rules"`
+	Deny  []Rule `json:"deny"`
+}
+
+// Rule matches a subject by group, attribute or both
+type Rule struct {
+	// Group is a pattern matched against group DNs and common names
+	Group string `json:"group,omitempty"`
+	// Attribute names a directory attribute; Value is a pattern matched
+	// against its values
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	// Roles are granted by a rule, or removed by a deny rule. A deny rule
+	// without roles removes every role.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Subject is what roles are derived from
+type Subject struct {
+	// Groups holds group DNs or names
+	Groups     []string
+	Attributes map[string][]string
+}
+
+// Mapper derives roles from a Config. It is safe for concurrent use.
+type Mapper struct {
+	mu     sync.RWMutex
+	path   string
+	config Config
+}
+
+// New returns a Mapper for config
+func New(config Config) (*Mapper, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Mapper{config: config}, nil
+}
+
+// Load reads the mapping file at path
+func Load(path string) (*Mapper, error) {
+	m := &Mapper{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the mapping file. On error the previous mappings are kept.
+func (m *Mapper) Reload() error {
+	if m.path == "" {
+		return fmt.Errorf("role mapper was not loaded from a file")
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to read role mappings: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse role mappings: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.config = config
+	m.mu.Unlock()
+	return nil
+}
+
+// Roles returns the sorted roles subject holds in application. An empty
+// application uses only the default profile.
+func (m *Mapper) Roles(application string, subject Subject) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	profiles := []Profile{m.config.Default}
+	if application != "" {
+		app, ok := m.config.Applications[application]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownApplication, application)
+		}
+		profiles = append(profiles, app)
+	}
+
+	granted := make(map[string]bool)
+	for _, p := range profiles {
+		for _, rule := range p.Rules {
+			if rule.matches(subject) {
+				for _, role := range rule.Roles {
+					granted[role] = true
+				}
+			}
+		}
+	}
+	for _, p := range profiles {
+		for _, rule := range p.Deny {
+			if !rule.matches(subject) {
+				continue
+			}
+			if len(rule.Roles) == 0 {
+				return []string{}, nil
+			}
+			for _, role := range rule.Roles {
+				delete(granted, role)
+			}
+		}
+	}
+
+	out := make([]string, 0, len(granted))
+	for role := range granted {
+		out = append(out, role)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (r *Rule) matches(subject Subject) bool {
+	if r.Group != "" && !r.matchesGroup(subject.Groups) {
+		return false
+	}
+	if r.Attribute != "" && !r.matchesAttribute(subject.Attributes) {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) matchesGroup(groups []string) bool {
+	for _, group := range groups {
+		if match(r.Group, group) {
+			return true
+		}
+		if cn := commonName(group); cn != "" && match(r.Group, cn) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesAttribute(attributes map[string][]string) bool {
+	for name, values := range attributes {
+		// LDAP attribute names are case-insensitive
+		if !strings.EqualFold(name, r.Attribute) {
+			continue
+		}
+		for _, value := range values {
+			if match(r.Value, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Config) validate() error {
+	if err := c.Default.validate("default"); err != nil {
+		return err
+	}
+	for name, p := range c.Applications {
+		if name == "" {
+			return fmt.Errorf("role mappings: application with empty name")
+		}
+		if err := p.validate(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Profile) validate(name string) error {
+	for i, rule := range p.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("role mappings: %s rule %d: %w", name, i, err)
+		}
+		if len(rule.Roles) == 0 {
+			return fmt.Errorf("role mappings: %s rule %d: no roles", name, i)
+		}
+	}
+	for i, rule := range p.Deny {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("role mappings: %s deny rule %d: %w", name, i, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if r.Group == "" && r.Attribute == "" {
+		return fmt.Errorf("no group or attribute")
+	}
+	if r.Attribute != "" && r.Value == "" {
+		return fmt.Errorf("attribute %q has no value", r.Attribute)
+	}
+	if r.Attribute == "" && r.Value != "" {
+		return fmt.Errorf("value without attribute")
+	}
+	for _, role := range r.Roles {
+		if strings.TrimSpace(role) == "" {
+			return fmt.Errorf("empty role")
+		}
+	}
+	return nil
+}
+
+// match reports whether s matches the glob pattern, ignoring case. "*"
+// matches any run of characters and "?" a single character.
+func match(pattern, s string) bool {
+	p := []rune(strings.ToLower(pattern))
+	r := []rune(strings.ToLower(s))
+
+	// Iterative matching with backtracking to the last star
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(r) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == r[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// commonName returns the value of a leading CN RDN, or "" if dn does not
+// start with one
+func commonName(dn string) string {
+	if len(dn) < 3 || !strings.EqualFold(dn[:3], "cn=") {
+		return ""
+	}
+
+	var b strings.Builder
+	for i := 3; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			if i+1 < len(dn) {
+				i++
+				b.WriteByte(dn[i])
+			}
+		case ',', '+':
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(dn[i])
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,213 @@
+// pkg/roles/roles_test.go
+package roles
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testConfig = Config{
+	Default: Profile{
+		Rules: []Rule{
+			{Group: "CN=All Staff,*", Roles: []string{"user"}},
+		},
+		Deny: []Rule{
+			{Attribute: "employeeType", Value: "contractor", Roles: []string{"admin"}},
+		},
+	},
+	Applications: map[string]Profile{
+		"payments": {
+			Rules: []Rule{
+				{Group: "payments-admins", Roles: []string{"admin"}},
+				{Group: "payments-*", Attribute: "department", Value: "treasury", Roles: []string{"approver"}},
+			},
+			Deny: []Rule{
+				{Group: "CN=Suspended,*"},
+			},
+		},
+	},
+}
+
+func TestRoles(t *testing.T) {
+	mapper, err := New(testConfig)
+	if err != nil {
+		t.Fatalf("New() unexpected error: %v", err)
+	}
+
+	staff := "CN=All Staff,OU=Groups,DC=corp,DC=example,DC=com"
+	admins := "CN=Payments-Admins,OU=Groups,DC=corp,DC=example,DC=com"
+	operators := "CN=payments-ops,OU=Groups,DC=corp,DC=example,DC=com"
+
+	tests := []struct {
+		name        string
+		application string
+		subject     Subject
+		want        []string
+		wantErr     error
+	}{
+		{
+			name:    "default profile",
+			subject: Subject{Groups: []string{staff, admins}},
+			want:    []string{"user"},
+		},
+		{
+			name:        "application rules match common name",
+			application: "payments",
+			subject:     Subject{Groups: []string{staff, admins}},
+			want:        []string{"admin", "user"},
+		},
+		{
+			name:        "group and attribute must both match",
+			application: "payments",
+			subject:     Subject{Groups: []string{operators}},
+			want:        []string{},
+		},
+		{
+			name:        "attribute names ignore case",
+			application: "payments",
+			subject: Subject{
+				Groups:     []string{operators},
+				Attributes: map[string][]string{"Department": {"Treasury"}},
+			},
+			want: []string{"approver"},
+		},
+		{
+			name:        "deny removes listed roles",
+			application: "payments",
+			subject: Subject{
+				Groups:     []string{staff, admins},
+				Attributes: map[string][]string{"employeeType": {"Contractor"}},
+			},
+			want: []string{"user"},
+		},
+		{
+			name:        "deny without roles removes everything",
+			application: "payments",
+			subject:     Subject{Groups: []string{staff, admins, "CN=Suspended,OU=Groups,DC=corp"}},
+			want:        []string{},
+		},
+		{
+			name:        "unknown application",
+			application: "ledger",
+			subject:     Subject{Groups: []string{staff}},
+			wantErr:     ErrUnknownApplication,
+		},
+		{
+			name:    "no groups",
+			subject: Subject{},
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapper.Roles(tt.application, tt.subject)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Roles() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Roles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "rule without condition", config: Config{Default: Profile{Rules: []Rule{{Roles: []string{"user"}}}}}},
+		{name: "rule without roles", config: Config{Default: Profile{Rules: []Rule{{Group: "staff"}}}}},
+		{name: "attribute without value", config: Config{Default: Profile{Deny: []Rule{{Attribute: "employeeType"}}}}},
+		{name: "value without attribute", config: Config{Default: Profile{Deny: []Rule{{Group: "staff", Value: "x"}}}}},
+		{name: "empty role", config: Config{Applications: map[string]Profile{"app": {Rules: []Rule{{Group: "staff", Roles: []string{" "}}}}}}},
+		{name: "empty application name", config: Config{Applications: map[string]Profile{"": {}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Error("New() expected error")
+			}
+		})
+	}
+}
+
+func TestLoadAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("write mappings: %v", err)
+		}
+	}
+
+	write(`{"default": {"rules": [{"group": "staff", "roles": ["user"]}]}}`)
+	mapper, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	subject := Subject{Groups: []string{"staff"}}
+	if got, _ := mapper.Roles("", subject); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Fatalf("Roles() = %v, want [user]", got)
+	}
+
+	write(`{"default": {"rules": [{"group": "staff", "roles": ["reader"]}]}}`)
+	if err := mapper.Reload(); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if got, _ := mapper.Roles("", subject); !reflect.DeepEqual(got, []string{"reader"}) {
+		t.Fatalf("Roles() after reload = %v, want [reader]", got)
+	}
+
+	write(`{"default": {"rules": [{"roles": ["admin"]}]}}`)
+	if err := mapper.Reload(); err == nil {
+		t.Fatal("Reload() expected error for invalid mappings")
+	}
+	if got, _ := mapper.Roles("", subject); !reflect.DeepEqual(got, []string{"reader"}) {
+		t.Errorf("Roles() after failed reload = %v, want previous mappings", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"staff", "STAFF", true},
+		{"payments-*", "payments-ops", true},
+		{"payments-*", "payments", false},
+		{"*,OU=Groups,*", "CN=a,OU=Groups,DC=corp", true},
+		{"app-?", "app-1", true},
+		{"app-?", "app-12", false},
+		{"*", "", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCommonName(t *testing.T) {
+	tests := map[string]string{
+		"CN=Payments Admins,OU=Groups,DC=corp": "Payments Admins",
+		`cn=Smith\, John,OU=People`:            "Smith, John",
+		"OU=Groups,DC=corp":                    "",
+		"staff":                                "",
+	}
+
+	for dn, want := range tests {
+		if got := commonName(dn); got != want {
+			t.Errorf("commonName(%q) = %q, want %q", dn, got, want)
+		}
+	}
+}
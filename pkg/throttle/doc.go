@@ -0,0 +1,33 @@
+// Package throttle protects directory accounts from lockout caused by
+// repeated failed logins.
+//
+// Active Directory locks an account after a fixed number of bad passwords,
+// so every failed bind against it counts towards locking out a real
+// employee. A Limiter sits in front of the LDAP bind and tracks failures per
+// canonical username and per client IP over sliding windows:
+//   - After a few free attempts each further failure doubles the delay the
+//     caller must wait before the next attempt
+//   - A local soft lockout kicks in below the AD lockout threshold, so the
+//     directory never sees enough failures to lock the account
+//   - A single IP failing against many distinct usernames (password
+//     spraying) or failing too often overall is blocked outright
+//   - Attempts allowed by Check count as in flight until their outcome is
+//     recorded, so a burst of parallel binds cannot pass the threshold
+//     before the first failure is recorded
+//
+// Basic usage:
+//
+//	limiter := throttle.New(throttle.Config{LockoutThreshold: 4}, logger)
+//
+//	if d := limiter.Check(username, ip); !d.Allowed {
+//		// respond 429 with d.RetryAfter
+//	}
+//	switch {
+//	case authenticated:
+//		limiter.RecordSuccess(username, ip)
+//	case rejected:
+//		limiter.RecordFailure(username, ip)
+//	default: // e.g. directory unavailable
+//		limiter.Release(username, ip)
+//	}
+package throttle
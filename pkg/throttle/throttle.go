@@ -0,0 +1,372 @@
+// pkg/throttle/throttle.go
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/authn"
+)
+
+// Defaults applied to zero Config fields
+const (
+	DefaultWindow             = 15 * time.Minute
+	DefaultFreeAttempts       = 2
+	DefaultBaseDelay          = time.Second
+	DefaultMaxDelay           = time.Minute
+	DefaultLockoutThreshold   = 4
+	DefaultLockoutDuration    = 15 * time.Minute
+	DefaultIPFailureThreshold = 30
+	DefaultSprayThreshold     = 5
+	DefaultSprayBlockDuration = time.Hour
+	DefaultAttemptTimeout     = 30 * time.Second
+)
+
+// Reasons reported in a Decision
+const (
+	ReasonDelay    = "delay"
+	ReasonLockout  = "lockout"
+	ReasonIP       = "ip_failures"
+	ReasonSpraying = "password_spraying"
+	ReasonInFlight = "attempts_in_flight"
+)
+
+// Config tunes the limiter. LockoutThreshold must stay below the directory's
+// own lockout threshold for the soft lockout to protect accounts.
+type Config struct {
+	// Window is the sliding window over which failures are counted
+	Window time.Duration
+	// FreeAttempts is the number of failures allowed before delays start
+	FreeAttempts int
+	// BaseDelay is the first enforced delay; it doubles with each further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold failures within Window lock the username for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// IPFailureThreshold failures within Window block the IP until they age out
+	IPFailureThreshold int
+	// SprayThreshold distinct usernames failing from one IP within Window
+	// block that IP for SprayBlockDuration
+	SprayThreshold     int
+	SprayBlockDuration time.Duration
+	// AttemptTimeout bounds how long an allowed attempt counts as in flight
+	// when its outcome is never recorded, e.g. after a bind timeout
+	AttemptTimeout time.Duration
+}
+
+type Logger interface {
+	Error(msg string, args ...interface{})
+}
+
+// Decision is the outcome of Check. When Allowed is false RetryAfter says
+// how long the caller has to wait.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Reason     string
+}
+
+type userRecord struct {
+	failures    []time.Time
+	lockedUntil time.Time
+	// inFlight holds the start of attempts allowed by Check whose outcome
+	// has not been recorded yet
+	inFlight []time.Time
+}
+
+type ipFailure struct {
+	at       time.Time
+	username string
+}
+
+type ipRecord struct {
+	failures     []ipFailure
+	blockedUntil time.Time
+}
+
+// maxRecords bounds memory use; stale records are pruned once it is reached
+const maxRecords = 100000
+
+// Limiter tracks failed logins. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	config Config
+	users  map[string]*userRecord
+	ips    map[string]*ipRecord
+	logger Logger
+	now    func() time.Time
+}
+
+func New(config Config, logger Logger) *Limiter {
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	if config.FreeAttempts <= 0 {
+		config.FreeAttempts = DefaultFreeAttempts
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = DefaultBaseDelay
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = DefaultMaxDelay
+	}
+	if config.LockoutThreshold <= 0 {
+		config.LockoutThreshold = DefaultLockoutThreshold
+	}
+	if config.LockoutDuration <= 0 {
+		config.LockoutDuration = DefaultLockoutDuration
+	}
+	if config.IPFailureThreshold <= 0 {
+		config.IPFailureThreshold = DefaultIPFailureThreshold
+	}
+	if config.SprayThreshold <= 0 {
+		config.SprayThreshold = DefaultSprayThreshold
+	}
+	if config.SprayBlockDuration <= 0 {
+		config.SprayBlockDuration = DefaultSprayBlockDuration
+	}
+	if config.AttemptTimeout <= 0 {
+		config.AttemptTimeout = DefaultAttemptTimeout
+	}
+
+	return &Limiter{
+		config: config,
+		users:  make(map[string]*userRecord),
+		ips:    make(map[string]*ipRecord),
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// CanonicalUsername normalizes the different ways a directory account can be
+// written ("jdoe", "JDoe@corp.example.com", `CORP\jdoe`) to a single key.
+// Domain qualifiers are dropped, so the same account name in two domains
+// shares one budget, which errs on the side of protecting the account. It
+// is the account's authn.CanonicalID, so throttle keys match token subjects.
+func CanonicalUsername(username string) string {
+	return authn.CanonicalID(username)
+}
+
+// Check reports whether a login attempt for username from ip may proceed.
+// An allowed attempt is reserved until its outcome is recorded with
+// RecordFailure, RecordSuccess or Release, so that parallel attempts cannot
+// together exceed the lockout threshold before the first failure is
+// recorded.
+func (l *Limiter) Check(username, ip string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var retry time.Duration
+	reason := ""
+
+	if rec, ok := l.ips[ip]; ok && ip != "" {
+		rec.failures = l.pruneIPFailures(rec.failures, now)
+		if now.Before(rec.blockedUntil) {
+			retry, reason = rec.blockedUntil.Sub(now), ReasonSpraying
+		} else if len(rec.failures) >= l.config.IPFailureThreshold {
+			// Blocked until enough failures age out of the window
+			excess := len(rec.failures) - l.config.IPFailureThreshold
+			retry, reason = rec.failures[excess].at.Add(l.config.Window).Sub(now), ReasonIP
+		}
+	}
+
+	key := CanonicalUsername(username)
+	if rec, ok := l.users[key]; ok {
+		rec.failures = l.pruneFailures(rec.failures, now)
+		if now.Before(rec.lockedUntil) {
+			if wait := rec.lockedUntil.Sub(now); wait > retry {
+				retry, reason = wait, ReasonLockout
+			}
+		} else if n := len(rec.failures); n > 0 {
+			if wait := rec.failures[n-1].Add(l.delay(n)).Sub(now); wait > retry {
+				retry, reason = wait, ReasonDelay
+			}
+		}
+	}
+
+	if retry > 0 {
+		return Decision{Allowed: false, RetryAfter: retry, Reason: reason}
+	}
+	return l.reserve(key, now)
+}
+
+// reserve counts an attempt as in flight unless the attempts already in
+// flight could together reach the lockout threshold. It must be called with l.mu held.
+func (l *Limiter) reserve(key string, now time.Time) Decision {
+	if len(l.users)+len(l.ips) >= maxRecords {
+		l.prune(now)
+	}
+	user, ok := l.users[key]
+	if !ok {
+		user = &userRecord{}
+		l.users[key] = user
+	}
+	user.inFlight = l.pruneInFlight(user.inFlight, now)
+	// Once a lockout expired the failures still count, and attempts go
+	// one at a time
+	allowed := l.config.LockoutThreshold - len(user.failures)
+	if allowed < 1 {
+		allowed = 1
+	}
+	if len(user.inFlight) >= allowed {
+		return Decision{
+			Allowed:    false,
+			RetryAfter: user.inFlight[0].Add(l.config.AttemptTimeout).Sub(now),
+			Reason:     ReasonInFlight,
+		}
+	}
+	user.inFlight = append(user.inFlight, now)
+	return Decision{Allowed: true}
+}
+
+// Release ends an attempt allowed by Check without counting it, for
+// outcomes that say nothing about the password such as directory outages
+func (l *Limiter) Release(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if user, ok := l.users[CanonicalUsername(username)]; ok {
+		user.inFlight = releaseAttempt(user.inFlight)
+	}
+}
+
+// releaseAttempt drops the oldest attempt in flight
+func releaseAttempt(inFlight []time.Time) []time.Time {
+	if len(inFlight) == 0 {
+		return inFlight
+	}
+	return inFlight[1:]
+}
+
+// RecordFailure counts a failed bind. Only credential failures should be
+// recorded; directory outages must not count against the user.
+func (l *Limiter) RecordFailure(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.users)+len(l.ips) >= maxRecords {
+		l.prune(now)
+	}
+
+	key := CanonicalUsername(username)
+	user, ok := l.users[key]
+	if !ok {
+		user = &userRecord{}
+		l.users[key] = user
+	}
+	user.failures = append(l.pruneFailures(user.failures, now), now)
+	user.inFlight = releaseAttempt(user.inFlight)
+
+	if len(user.failures) >= l.config.LockoutThreshold && !now.Before(user.lockedUntil) {
+		user.lockedUntil = now.Add(l.config.LockoutDuration)
+		l.logger.Error("Account soft-locked after repeated failures",
+			"username", key, "failures", len(user.failures), "until", user.lockedUntil)
+	}
+
+	if ip == "" {
+		return
+	}
+
+	rec, ok := l.ips[ip]
+	if !ok {
+		rec = &ipRecord{}
+		l.ips[ip] = rec
+	}
+	rec.failures = append(l.pruneIPFailures(rec.failures, now), ipFailure{at: now, username: key})
+
+	if distinct := distinctUsers(rec.failures); distinct >= l.config.SprayThreshold && !now.Before(rec.blockedUntil) {
+		rec.blockedUntil = now.Add(l.config.SprayBlockDuration)
+		l.logger.Error("Password spraying detected",
+			"ip", ip, "usernames", distinct, "until", rec.blockedUntil)
+	}
+}
+
+// RecordSuccess clears the failure history of username. The IP history is
+// kept so a sprayer cannot reset it with one valid account.
+func (l *Limiter) RecordSuccess(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := CanonicalUsername(username)
+	user, ok := l.users[key]
+	if !ok {
+		return
+	}
+	// Attempts still in flight keep their reservations
+	if user.inFlight = releaseAttempt(l.pruneInFlight(user.inFlight, l.now())); len(user.inFlight) == 0 {
+		delete(l.users, key)
+		return
+	}
+	user.failures = nil
+	user.lockedUntil = time.Time{}
+}
+
+// delay returns the wait enforced after the n-th failure
+func (l *Limiter) delay(n int) time.Duration {
+	if n <= l.config.FreeAttempts {
+		return 0
+	}
+
+	d := l.config.BaseDelay
+	for i := l.config.FreeAttempts + 1; i < n; i++ {
+		d *= 2
+		if d >= l.config.MaxDelay {
+			return l.config.MaxDelay
+		}
+	}
+	return d
+}
+
+func (l *Limiter) pruneFailures(failures []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-l.config.Window)
+	i := 0
+	for i < len(failures) && !failures[i].After(cutoff) {
+		i++
+	}
+	return failures[i:]
+}
+
+func (l *Limiter) pruneInFlight(inFlight []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-l.config.AttemptTimeout)
+	i := 0
+	for i < len(inFlight) && !inFlight[i].After(cutoff) {
+		i++
+	}
+	return inFlight[i:]
+}
+
+func (l *Limiter) pruneIPFailures(failures []ipFailure, now time.Time) []ipFailure {
+	cutoff := now.Add(-l.config.Window)
+	i := 0
+	for i < len(failures) && !failures[i].at.After(cutoff) {
+		i++
+	}
+	return failures[i:]
+}
+
+// prune drops records with no recent failures, no attempts in flight and no
+// active lockout
+func (l *Limiter) prune(now time.Time) {
+	for key, rec := range l.users {
+		rec.inFlight = l.pruneInFlight(rec.inFlight, now)
+		if rec.failures = l.pruneFailures(rec.failures, now); len(rec.failures) == 0 && len(rec.inFlight) == 0 && !now.Before(rec.lockedUntil) {
+			delete(l.users, key)
+		}
+	}
+	for key, rec := range l.ips {
+		if rec.failures = l.pruneIPFailures(rec.failures, now); len(rec.failures) == 0 && !now.Before(rec.blockedUntil) {
+			delete(l.ips, key)
+		}
+	}
+}
+
+func distinctUsers(failures []ipFailure) int {
+	seen := make(map[string]struct{}, len(failures))
+	for _, f := range failures {
+		seen[f.username] = struct{}{}
+	}
+	return len(seen)
+}
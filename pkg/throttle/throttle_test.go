@@ -0,0 +1,245 @@
+// pkg/throttle/throttle_test.go
+package throttle
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockLogger struct {
+	errorMsgs []string
+}
+
+func (m *mockLogger) Error(msg string, keyvals ...interface{}) {
+	m.errorMsgs = append(m.errorMsgs, msg)
+}
+
+func newTestLimiter(config Config) (*Limiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := New(config, &mockLogger{})
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestCanonicalUsername(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"jdoe", "jdoe"},
+		{" JDoe ", "jdoe"},
+		{"jdoe@corp.example.com", "jdoe"},
+		{`CORP\JDOE`, "jdoe"},
+		{`CORP\jdoe@corp.example.com`, "jdoe"},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalUsername(tt.in); got != tt.want {
+			t.Errorf("CanonicalUsername(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	limiter, now := newTestLimiter(Config{
+		FreeAttempts:     2,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		LockoutThreshold: 10,
+	})
+
+	// Free attempts are not delayed
+	for i := 0; i < 2; i++ {
+		if d := limiter.Check("jdoe", "10.0.0.1"); !d.Allowed {
+			t.Fatalf("attempt %d should be allowed", i+1)
+		}
+		limiter.RecordFailure("jdoe", "10.0.0.1")
+	}
+	if d := limiter.Check("jdoe", "10.0.0.1"); !d.Allowed {
+		t.Fatal("attempt 3 should be allowed")
+	}
+
+	wantDelays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, want := range wantDelays {
+		limiter.RecordFailure("JDOE@example.com", "10.0.0.1")
+
+		d := limiter.Check("jdoe", "10.0.0.1")
+		if d.Allowed || d.Reason != ReasonDelay {
+			t.Fatalf("failure %d: decision = %+v, want delay", i+3, d)
+		}
+		if d.RetryAfter != want {
+			t.Errorf("failure %d: RetryAfter = %v, want %v", i+3, d.RetryAfter, want)
+		}
+
+		*now = now.Add(want)
+		if d := limiter.Check("jdoe", "10.0.0.1"); !d.Allowed {
+			t.Fatalf("failure %d: attempt after delay should be allowed, got %+v", i+3, d)
+		}
+	}
+}
+
+func TestSoftLockout(t *testing.T) {
+	limiter, now := newTestLimiter(Config{
+		LockoutThreshold: 3,
+		LockoutDuration:  10 * time.Minute,
+		FreeAttempts:     5,
+	})
+
+	for i := 0; i < 3; i++ {
+		limiter.RecordFailure("jdoe", "10.0.0.1")
+	}
+
+	d := limiter.Check("jdoe", "10.0.0.2")
+	if d.Allowed || d.Reason != ReasonLockout {
+		t.Fatalf("decision = %+v, want lockout", d)
+	}
+	if d.RetryAfter != 10*time.Minute {
+		t.Errorf("RetryAfter = %v, want 10m", d.RetryAfter)
+	}
+
+	// Other users are unaffected
+	if d := limiter.Check("asmith", "10.0.0.2"); !d.Allowed {
+		t.Errorf("other user should be allowed, got %+v", d)
+	}
+
+	*now = now.Add(10 * time.Minute)
+	if d := limiter.Check("jdoe", "10.0.0.2"); !d.Allowed {
+		t.Errorf("lockout should have expired, got %+v", d)
+	}
+}
+
+func TestRecordSuccessResetsUser(t *testing.T) {
+	limiter, _ := newTestLimiter(Config{FreeAttempts: 1})
+
+	limiter.RecordFailure("jdoe", "10.0.0.1")
+	limiter.RecordFailure("jdoe", "10.0.0.1")
+	if d := limiter.Check("jdoe", "10.0.0.1"); d.Allowed {
+		t.Fatal("expected delay before success")
+	}
+
+	limiter.RecordSuccess("jdoe", "10.0.0.1")
+	if d := limiter.Check("jdoe", "10.0.0.1"); !d.Allowed {
+		t.Errorf("success should reset failures, got %+v", d)
+	}
+}
+
+func TestPasswordSprayingDetection(t *testing.T) {
+	limiter, now := newTestLimiter(Config{
+		SprayThreshold:     3,
+		SprayBlockDuration: time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		limiter.RecordFailure(fmt.Sprintf("user%d", i), "10.0.0.9")
+	}
+
+	d := limiter.Check("someone-else", "10.0.0.9")
+	if d.Allowed || d.Reason != ReasonSpraying {
+		t.Fatalf("decision = %+v, want spraying block", d)
+	}
+	if d.RetryAfter != time.Hour {
+		t.Errorf("RetryAfter = %v, want 1h", d.RetryAfter)
+	}
+
+	// The same usernames from a different IP are only subject to their own history
+	if d := limiter.Check("someone-else", "10.0.0.10"); !d.Allowed {
+		t.Errorf("other IP should be allowed, got %+v", d)
+	}
+
+	if len(limiter.logger.(*mockLogger).errorMsgs) == 0 {
+		t.Error("expected spraying to be logged")
+	}
+
+	*now = now.Add(time.Hour)
+	if d := limiter.Check("someone-else", "10.0.0.9"); !d.Allowed {
+		t.Errorf("block should have expired, got %+v", d)
+	}
+}
+
+func TestIPFailureThreshold(t *testing.T) {
+	limiter, now := newTestLimiter(Config{
+		Window:             time.Minute,
+		IPFailureThreshold: 3,
+		SprayThreshold:     100,
+		FreeAttempts:       100,
+		LockoutThreshold:   100,
+	})
+
+	for i := 0; i < 3; i++ {
+		limiter.RecordFailure("jdoe", "10.0.0.1")
+		*now = now.Add(10 * time.Second)
+	}
+
+	d := limiter.Check("asmith", "10.0.0.1")
+	if d.Allowed || d.Reason != ReasonIP {
+		t.Fatalf("decision = %+v, want IP block", d)
+	}
+	// The oldest failure ages out 60s after it happened; 30s have passed
+	if d.RetryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want 30s", d.RetryAfter)
+	}
+
+	*now = now.Add(30 * time.Second)
+	if d := limiter.Check("asmith", "10.0.0.1"); !d.Allowed {
+		t.Errorf("IP should be allowed once failures age out, got %+v", d)
+	}
+}
+
+func TestConcurrentAttempts(t *testing.T) {
+	limiter, now := newTestLimiter(Config{
+		LockoutThreshold: 4,
+		FreeAttempts:     10,
+		AttemptTimeout:   30 * time.Second,
+	})
+
+	// A burst of parallel attempts is allowed only up to the threshold
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter.Check("jdoe", "10.0.0.1").Allowed {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 4 {
+		t.Fatalf("%d parallel attempts allowed, want 4", allowed)
+	}
+	d := limiter.Check("jdoe", "10.0.0.1")
+	if d.Allowed || d.Reason != ReasonInFlight || d.RetryAfter != 30*time.Second {
+		t.Errorf("decision = %+v, want attempts in flight", d)
+	}
+
+	// Outcomes free the reservations
+	limiter.Release("jdoe", "10.0.0.1")
+	if d := limiter.Check("jdoe", "10.0.0.1"); !d.Allowed {
+		t.Errorf("attempt after release should be allowed, got %+v", d)
+	}
+	for i := 0; i < 4; i++ {
+		limiter.RecordFailure("jdoe", "10.0.0.1")
+	}
+	if d := limiter.Check("jdoe", "10.0.0.1"); d.Allowed || d.Reason != ReasonLockout {
+		t.Errorf("decision = %+v, want lockout", d)
+	}
+
+	// Attempts whose outcome is never recorded expire
+	if d := limiter.Check("asmith", "10.0.0.1"); !d.Allowed {
+		t.Fatal("first attempt should be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		limiter.Check("asmith", "10.0.0.1")
+	}
+	if d := limiter.Check("asmith", "10.0.0.1"); d.Allowed {
+		t.Fatal("fifth attempt in flight should be refused")
+	}
+	*now = now.Add(30 * time.Second)
+	if d := limiter.Check("asmith", "10.0.0.1"); !d.Allowed {
+		t.Errorf("attempt after timeout should be allowed, got %+v", d)
+	}
+}
@@ -0,0 +1,171 @@
+// pkg/webauthn/attestation.go
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Attestation types recorded in Credential.AttestationType
+const (
+	AttestationNone  = "none"
+	AttestationSelf  = "self"
+	AttestationBasic = "basic"
+)
+
+// ErrUnsupportedAttestation is returned for attestation formats other than
+// none and packed
+var ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+
+// idFIDOGenCeAAGUID is the certificate extension carrying the AAGUID of
+// the authenticator model
+var idFIDOGenCeAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	format   string
+	stmt     map[interface{}]interface{}
+	authData *authenticatorData
+}
+
+func parseAttestationObject(raw []byte) (*attestationObject, error) {
+	value, n, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(raw) {
+		return nil, fmt.Errorf("trailing bytes after attestation object")
+	}
+	object, err := cborMap(value)
+	if err != nil {
+		return nil, err
+	}
+	format, _ := object["fmt"].(string)
+	stmt, err := cborMap(object["attStmt"])
+	if err != nil {
+		return nil, fmt.Errorf("attStmt: %w", err)
+	}
+	rawData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("authData missing")
+	}
+	data, err := parseAuthenticatorData(rawData)
+	if err != nil {
+		return nil, err
+	}
+	return &attestationObject{format: format, stmt: stmt, authData: data}, nil
+}
+
+// verifyAttestation checks the attestation statement and returns the
+// attestation type
+func (rp *RelyingParty) verifyAttestation(attestation *attestationObject, clientDataHash []byte) (string, error) {
+	switch attestation.format {
+	case "none":
+		if len(attestation.stmt) != 0 {
+			return "", fmt.Errorf("none attestation with a statement")
+		}
+		if rp.config.AttestationRoots != nil {
+			return "", fmt.Errorf("attestation required")
+		}
+		return AttestationNone, nil
+	case "packed":
+		return rp.verifyPacked(attestation, clientDataHash)
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedAttestation, attestation.format)
+	}
+}
+
+// verifyPacked verifies a packed attestation statement (WebAuthn section
+// 8.2), signed either by an attestation certificate or by the credential
+// key itself
+func (rp *RelyingParty) verifyPacked(attestation *attestationObject, clientDataHash []byte) (string, error) {
+	alg, _ := attestation.stmt["alg"].(int64)
+	sig, _ := attestation.stmt["sig"].([]byte)
+	if len(sig) == 0 {
+		return "", fmt.Errorf("packed attestation without signature")
+	}
+	data := attestation.authData
+	signed := append(append([]byte(nil), data.raw...), clientDataHash...)
+
+	x5c, hasCertificates := attestation.stmt["x5c"].([]interface{})
+	if !hasCertificates {
+		if rp.config.AttestationRoots != nil {
+			return "", fmt.Errorf("self attestation not trusted")
+		}
+		if int(alg) != data.algorithm {
+			return "", fmt.Errorf("self attestation alg %d does not match the credential", alg)
+		}
+		if err := verifySignature(data.publicKey, data.algorithm, signed, sig); err != nil {
+			return "", err
+		}
+		return AttestationSelf, nil
+	}
+
+	var certificates []*x509.Certificate
+	for _, item := range x5c {
+		der, ok := item.([]byte)
+		if !ok {
+			return "", fmt.Errorf("invalid x5c entry")
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", fmt.Errorf("x5c: %w", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return "", fmt.Errorf("empty x5c")
+	}
+	leaf := certificates[0]
+	if alg != AlgES256 && alg != AlgRS256 {
+		return "", fmt.Errorf("%w: attestation alg %d", ErrUnsupportedAlgorithm, alg)
+	}
+	if err := verifySignature(leaf.PublicKey, int(alg), signed, sig); err != nil {
+		return "", err
+	}
+	if err := checkAttestationCertificate(leaf, data.aaguid); err != nil {
+		return "", err
+	}
+
+	if rp.config.AttestationRoots != nil {
+		intermediates := x509.NewCertPool()
+		for _, certificate := range certificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         rp.config.AttestationRoots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return "", fmt.Errorf("attestation certificate not trusted: %w", err)
+		}
+	}
+	return AttestationBasic, nil
+}
+
+// checkAttestationCertificate applies the packed attestation certificate
+// requirements of WebAuthn section 8.2.1
+func checkAttestationCertificate(certificate *x509.Certificate, aaguid []byte) error {
+	subject := certificate.Subject
+	if certificate.Version != 3 || len(subject.Country) == 0 || len(subject.Organization) == 0 || subject.CommonName == "" {
+		return fmt.Errorf("attestation certificate subject incomplete")
+	}
+	if len(subject.OrganizationalUnit) != 1 || subject.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return fmt.Errorf("attestation certificate OU is not Authenticator Attestation")
+	}
+	if certificate.IsCA {
+		return fmt.Errorf("attestation certificate is a CA")
+	}
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(idFIDOGenCeAAGUID) {
+			continue
+		}
+		var certified []byte
+		if _, err := asn1.Unmarshal(extension.Value, &certified); err != nil || extension.Critical || !bytes.Equal(certified, aaguid) {
+			return fmt.Errorf("attestation certificate AAGUID does not match the authenticator")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// pkg/webauthn/authdata.go
+package webauthn
+
+import (
+	"crypto"
+	"encoding/binary"
+	"fmt"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackedUp       = 0x10
+	flagAttestedData   = 0x40
+	flagExtensionData  = 0x80
+)
+
+// authenticatorData is the parsed authenticator data of a registration or
+// assertion (WebAuthn section 6.1)
+type authenticatorData struct {
+	raw       []byte
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// Attested credential data, only present at registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    crypto.PublicKey
+	coseKey      []byte
+	algorithm    int
+}
+
+func (a *authenticatorData) has(flag byte) bool {
+	return a.flags&flag != 0
+}
+
+// maxCredentialIDLength is the limit of WebAuthn section 6.1
+const maxCredentialIDLength = 1023
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("authenticator data too short")
+	}
+	data := &authenticatorData{
+		raw:       raw,
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	if data.has(flagAttestedData) {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("attested credential data too short")
+		}
+		data.aaguid = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength > maxCredentialIDLength || len(rest) < idLength {
+			return nil, fmt.Errorf("invalid credential ID length")
+		}
+		data.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		public, alg, n, err := parseCOSEKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("credential public key: %w", err)
+		}
+		data.publicKey, data.algorithm = public, alg
+		data.coseKey = rest[:n]
+		rest = rest[n:]
+	}
+	if data.has(flagExtensionData) {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("extensions: %w", err)
+		}
+		rest = rest[n:]
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing bytes in authenticator data")
+	}
+	return data, nil
+}
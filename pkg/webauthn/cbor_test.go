@@ -0,0 +1,62 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 8949 appendix A
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.hex)
+		got, n, err := decodeCBOR(data)
+		if err != nil || n != len(data) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeCBOR(%s) = %#v, %d, %v; want %#v", tt.hex, got, n, err, tt.want)
+		}
+	}
+
+	// Only the first item is decoded
+	if _, n, err := decodeCBOR([]byte{0x01, 0x02}); err != nil || n != 1 {
+		t.Errorf("decodeCBOR() with trailing data = %d, %v; want 1", n, err)
+	}
+
+	invalid := []string{
+		"",
+		"18",                 // missing argument
+		"45010203",           // byte string longer than data
+		"9f0102ff",           // indefinite length
+		"c11a514b67b0",       // tag
+		"f93c00",             // float
+		"a2010201",           // duplicate key, truncated
+		"a2010201" + "03",    // duplicate key
+		"9b7fffffffffffffff", // huge array
+	}
+	for _, h := range invalid {
+		data, _ := hex.DecodeString(h)
+		if _, _, err := decodeCBOR(data); !errors.Is(err, errCBOR) {
+			t.Errorf("decodeCBOR(%s) error = %v, want %v", h, err, errCBOR)
+		}
+	}
+}
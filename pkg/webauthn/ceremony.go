@@ -0,0 +1,87 @@
+// pkg/webauthn/ceremony.go
+package webauthn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownCeremony is returned for unknown, finished and expired ceremony
+// tokens
+var ErrUnknownCeremony = errors.New("unknown WebAuthn ceremony")
+
+// ceremonyTokenBytes is the entropy of ceremony tokens
+const ceremonyTokenBytes = 32
+
+// maxCeremonies bounds memory use; expired ceremonies are pruned once it is
+// reached
+const maxCeremonies = 100000
+
+// Ceremonies keeps the sessions of ceremonies in progress under opaque
+// tokens handed to the browser. Each can be finished once. It keeps them in
+// memory, which only works for a single instance, and is safe for
+// concurrent use.
+type Ceremonies struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	now      func() time.Time
+}
+
+func NewCeremonies() *Ceremonies {
+	return &Ceremonies{
+		sessions: make(map[string]*Session),
+		now:      time.Now,
+	}
+}
+
+// Start stores session and returns its token
+func (c *Ceremonies) Start(session *Session) (string, error) {
+	raw, err := randomBytes(ceremonyTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := encodeBase64URL(raw)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sessions) >= maxCeremonies {
+		c.prune()
+	}
+	c.sessions[hashCeremonyToken(token)] = session
+	return token, nil
+}
+
+// Take removes and returns the session of token
+func (c *Ceremonies) Take(token string) (*Session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := hashCeremonyToken(token)
+	session, ok := c.sessions[hash]
+	if !ok || token == "" {
+		return nil, ErrUnknownCeremony
+	}
+	delete(c.sessions, hash)
+	if !c.now().Before(session.ExpiresAt) {
+		return nil, ErrUnknownCeremony
+	}
+	return session, nil
+}
+
+// prune must be called with c.mu held
+func (c *Ceremonies) prune() {
+	now := c.now()
+	for hash, session := range c.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(c.sessions, hash)
+		}
+	}
+}
+
+func hashCeremonyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,34 @@
+package webauthn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCeremonies(t *testing.T) {
+	now := time.Now()
+	c := NewCeremonies()
+	c.now = func() time.Time { return now }
+
+	token, err := c.Start(&Session{UserID: "jdoe", ExpiresAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := c.Take(token)
+	if err != nil || session.UserID != "jdoe" {
+		t.Fatalf("Take() = %+v, %v", session, err)
+	}
+	if _, err := c.Take(token); !errors.Is(err, ErrUnknownCeremony) {
+		t.Errorf("second Take() error = %v, want %v", err, ErrUnknownCeremony)
+	}
+
+	token, _ = c.Start(&Session{UserID: "jdoe", ExpiresAt: now.Add(time.Minute)})
+	now = now.Add(2 * time.Minute)
+	if _, err := c.Take(token); !errors.Is(err, ErrUnknownCeremony) {
+		t.Errorf("Take() of expired ceremony: error = %v, want %v", err, ErrUnknownCeremony)
+	}
+	if _, err := c.Take(""); !errors.Is(err, ErrUnknownCeremony) {
+		t.Errorf("Take(\"\") error = %v, want %v", err, ErrUnknownCeremony)
+	}
+}
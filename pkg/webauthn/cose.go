@@ -0,0 +1,108 @@
+// pkg/webauthn/cose.go
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported credential keys
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+)
+
+// ErrUnsupportedAlgorithm is returned for credential keys other than ES256
+// and RS256
+var ErrUnsupportedAlgorithm = errors.New("unsupported credential algorithm")
+
+// COSE key parameters (RFC 9052, RFC 9053)
+const (
+	coseKty     = 1
+	coseAlg     = 3
+	coseCrv     = -1
+	coseX       = -2
+	coseY       = -3
+	coseRSAN    = -1
+	coseRSAE    = -2
+	coseKtyEC2  = 2
+	coseKtyRSA  = 3
+	coseCrvP256 = 1
+)
+
+// minRSABits rejects weak RSA credential keys
+const minRSABits = 2048
+
+// parseCOSEKey decodes a COSE_Key and returns the public key, its algorithm
+// and the number of bytes it took
+func parseCOSEKey(data []byte) (crypto.PublicKey, int, int, error) {
+	value, n, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	key, err := cborMap(value)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	kty, _ := key[int64(coseKty)].(int64)
+	alg, _ := key[int64(coseAlg)].(int64)
+
+	switch {
+	case alg == AlgES256 && kty == coseKtyEC2:
+		if crv, _ := key[int64(coseCrv)].(int64); crv != coseCrvP256 {
+			return nil, 0, 0, fmt.Errorf("%w: ES256 key on curve %d", ErrUnsupportedAlgorithm, crv)
+		}
+		x, _ := key[int64(coseX)].([]byte)
+		y, _ := key[int64(coseY)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, 0, fmt.Errorf("invalid EC2 key coordinates")
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, 0, 0, fmt.Errorf("EC2 key is not on the curve")
+		}
+		return public, AlgES256, n, nil
+	case alg == AlgRS256 && kty == coseKtyRSA:
+		modulus, _ := key[int64(coseRSAN)].([]byte)
+		exponent, _ := key[int64(coseRSAE)].([]byte)
+		if len(exponent) == 0 || len(exponent) > 4 {
+			return nil, 0, 0, fmt.Errorf("invalid RSA exponent")
+		}
+		e := new(big.Int).SetBytes(exponent)
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}
+		if public.N.BitLen() < minRSABits || public.E < 3 || public.E%2 == 0 {
+			return nil, 0, 0, fmt.Errorf("weak or invalid RSA key")
+		}
+		return public, AlgRS256, n, nil
+	default:
+		return nil, 0, 0, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedAlgorithm, kty, alg)
+	}
+}
+
+// verifySignature checks a WebAuthn signature: ASN.1 DER for ES256,
+// PKCS #1 v1.5 for RS256, both over SHA-256
+func verifySignature(public crypto.PublicKey, alg int, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch key := public.(type) {
+	case *ecdsa.PublicKey:
+		if alg != AlgES256 || !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.New("signature verification failed")
+		}
+	case *rsa.PublicKey:
+		if alg != AlgRS256 {
+			return errors.New("signature verification failed")
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, public)
+	}
+	return nil
+}
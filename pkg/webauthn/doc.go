@@ -0,0 +1,31 @@
+// Package webauthn implements the relying party side of WebAuthn
+// registration and authentication ceremonies for security keys, platform
+// authenticators and passkeys.
+//
+// Credentials use ES256 or RS256 keys. "none" and "packed" attestation are
+// verified; with AttestationRoots configured only credentials attested by
+// a trusted certificate are registered. Signature counters that do not
+// increase are rejected as a sign of a cloned authenticator, and the user
+// verification policy of the relying party is enforced. Passwordless
+// logins always require user verification.
+//
+// Basic usage:
+//
+//	rp, err := webauthn.NewRelyingParty(webauthn.Config{
+//		ID:      "example.com",
+//		Origins: []string{"https://login.example.com"},
+//	})
+//
+//	options, session, err := rp.BeginRegistration(webauthn.User{ID: userID}, existing)
+//	// send options to navigator.credentials.create, keep session
+//	credential, err := rp.FinishRegistration(session, response)
+//	err = store.Save(credential)
+//
+//	options, session, err = rp.BeginLogin(credentials)
+//	// send options to navigator.credentials.get, keep session
+//	assertion, err := rp.FinishLogin(session, credential, response)
+//	err = store.UpdateSignCount(assertion.Credential, credential.SignCount)
+//
+// Ceremonies keeps sessions between the two steps under opaque tokens.
+// Package webauthntest provides a software authenticator for tests.
+package webauthn
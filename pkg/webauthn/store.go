@@ -0,0 +1,231 @@
+// pkg/webauthn/store.go
+package webauthn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownCredential is returned for credential IDs that are not
+// registered
+var ErrUnknownCredential = errors.New("unknown credential")
+
+// CredentialStore keeps credentials by ID and by canonical user ID
+type CredentialStore interface {
+	// Credentials returns the user's credentials, none if the user has not
+	// registered any
+	Credentials(userID string) ([]Credential, error)
+	// Credential returns ErrUnknownCredential for unregistered IDs
+	Credential(id []byte) (*Credential, error)
+	// Save adds a credential
+	Save(credential *Credential) error
+	// UpdateSignCount stores the counter and last use of credential after a
+	// login, but only while the stored counter still is previous, so two
+	// logins cannot both advance it from the same value. Otherwise it
+	// returns ErrCounterRegression.
+	UpdateSignCount(credential *Credential, previous uint32) error
+	Delete(userID string, id []byte) error
+}
+
+// MemoryStore keeps credentials in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	credentials map[string]*Credential
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{credentials: make(map[string]*Credential)}
+}
+
+func (s *MemoryStore) Credentials(userID string) ([]Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []Credential
+	for _, credential := range s.credentials {
+		if credential.UserID == userID {
+			out = append(out, *copyCredential(credential))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemoryStore) Credential(id []byte) (*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credential, ok := s.credentials[credentialKey(id)]
+	if !ok {
+		return nil, ErrUnknownCredential
+	}
+	return copyCredential(credential), nil
+}
+
+func (s *MemoryStore) Save(credential *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveCredential(s.credentials, credential)
+}
+
+func (s *MemoryStore) UpdateSignCount(credential *Credential, previous uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateSignCount(s.credentials, credential, previous)
+}
+
+func (s *MemoryStore) Delete(userID string, id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return deleteCredential(s.credentials, userID, id)
+}
+
+// saveCredential refuses to move a credential ID to another user
+func saveCredential(credentials map[string]*Credential, credential *Credential) error {
+	key := credentialKey(credential.ID)
+	if existing, ok := credentials[key]; ok && existing.UserID != credential.UserID {
+		return fmt.Errorf("credential already registered to another user")
+	}
+	credentials[key] = copyCredential(credential)
+	return nil
+}
+
+func updateSignCount(credentials map[string]*Credential, credential *Credential, previous uint32) error {
+	key := credentialKey(credential.ID)
+	existing, ok := credentials[key]
+	if !ok || existing.UserID != credential.UserID {
+		return ErrUnknownCredential
+	}
+	if existing.SignCount != previous {
+		return ErrCounterRegression
+	}
+	updated := copyCredential(existing)
+	updated.SignCount = credential.SignCount
+	updated.LastUsedAt = credential.LastUsedAt
+	credentials[key] = updated
+	return nil
+}
+
+func deleteCredential(credentials map[string]*Credential, userID string, id []byte) error {
+	key := credentialKey(id)
+	if existing, ok := credentials[key]; !ok || existing.UserID != userID {
+		return ErrUnknownCredential
+	}
+	delete(credentials, key)
+	return nil
+}
+
+func credentialKey(id []byte) string {
+	return hex.EncodeToString(id)
+}
+
+func copyCredential(credential *Credential) *Credential {
+	out := *credential
+	out.ID = bytes.Clone(credential.ID)
+	out.UserHandle = bytes.Clone(credential.UserHandle)
+	out.PublicKey = bytes.Clone(credential.PublicKey)
+	out.AAGUID = bytes.Clone(credential.AAGUID)
+	out.Transports = append([]string(nil), credential.Transports...)
+	return &out
+}
+
+// FileStore is a MemoryStore persisted to a JSON file, so credentials
+// survive restarts of a single instance. Every change rewrites the file
+// before it takes effect.
+type FileStore struct {
+	*MemoryStore
+	path string
+	// writeMu serializes rewrites of the file
+	writeMu sync.Mutex
+}
+
+type storeFile struct {
+	Credentials []*Credential `json:"credentials"`
+}
+
+// OpenFileStore loads the credentials at path. A missing file is created on
+// the first change.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebAuthn credentials: %w", err)
+	}
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse WebAuthn credentials: %w", err)
+	}
+	for _, credential := range f.Credentials {
+		s.credentials[credentialKey(credential.ID)] = credential
+	}
+	return s, nil
+}
+
+func (s *FileStore) Save(credential *Credential) error {
+	return s.update(func(credentials map[string]*Credential) error {
+		return saveCredential(credentials, credential)
+	})
+}
+
+func (s *FileStore) UpdateSignCount(credential *Credential, previous uint32) error {
+	return s.update(func(credentials map[string]*Credential) error {
+		return updateSignCount(credentials, credential, previous)
+	})
+}
+
+func (s *FileStore) Delete(userID string, id []byte) error {
+	return s.update(func(credentials map[string]*Credential) error {
+		return deleteCredential(credentials, userID, id)
+	})
+}
+
+// update applies change to a copy of the credentials, writes the copy and
+// only then makes it current
+func (s *FileStore) update(change func(map[string]*Credential) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.RLock()
+	credentials := make(map[string]*Credential, len(s.credentials)+1)
+	for key, credential := range s.credentials {
+		credentials[key] = credential
+	}
+	s.mu.RUnlock()
+	if err := change(credentials); err != nil {
+		return err
+	}
+
+	f := storeFile{Credentials: make([]*Credential, 0, len(credentials))}
+	for _, credential := range credentials {
+		f.Credentials = append(f.Credentials, credential)
+	}
+	sort.Slice(f.Credentials, func(i, j int) bool {
+		return bytes.Compare(f.Credentials[i].ID, f.Credentials[j].ID) < 0
+	})
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write WebAuthn credentials: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write WebAuthn credentials: %w", err)
+	}
+
+	s.mu.Lock()
+	s.credentials = credentials
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,80 @@
+package webauthn
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	first := &Credential{ID: []byte{1}, UserID: "jdoe", CreatedAt: time.Unix(1, 0)}
+	second := &Credential{ID: []byte{2}, UserID: "jdoe", CreatedAt: time.Unix(2, 0)}
+	for _, credential := range []*Credential{second, first, {ID: []byte{3}, UserID: "asmith"}} {
+		if err := s.Save(credential); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	credentials, _ := s.Credentials("jdoe")
+	if len(credentials) != 2 || credentials[0].ID[0] != 1 || credentials[1].ID[0] != 2 {
+		t.Errorf("Credentials() = %+v, want both of jdoe's in registration order", credentials)
+	}
+	if err := s.Save(&Credential{ID: []byte{3}, UserID: "jdoe"}); err == nil {
+		t.Error("Save() moved a credential to another user")
+	}
+
+	first.SignCount = 7
+	if err := s.UpdateSignCount(first, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Credential([]byte{1}); got.SignCount != 7 {
+		t.Errorf("SignCount = %d after update, want 7", got.SignCount)
+	}
+	// A concurrent login read the counter before the update above
+	first.SignCount = 8
+	if err := s.UpdateSignCount(first, 0); !errors.Is(err, ErrCounterRegression) {
+		t.Errorf("UpdateSignCount() from a stale counter: error = %v, want %v", err, ErrCounterRegression)
+	}
+
+	if err := s.Delete("asmith", []byte{1}); !errors.Is(err, ErrUnknownCredential) {
+		t.Errorf("Delete() of another user's credential: error = %v, want %v", err, ErrUnknownCredential)
+	}
+	if err := s.Delete("jdoe", []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Credential([]byte{1}); !errors.Is(err, ErrUnknownCredential) {
+		t.Errorf("Credential() after delete: error = %v, want %v", err, ErrUnknownCredential)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webauthn.json")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := &Credential{ID: []byte{1}, UserID: "jdoe", UserHandle: []byte{9}, PublicKey: []byte{5}, SignCount: 3}
+	if err := s.Save(credential); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(&Credential{ID: []byte{2}, UserID: "jdoe"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("jdoe", []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Credential([]byte{1})
+	if err != nil || got.SignCount != 3 || got.UserHandle[0] != 9 {
+		t.Errorf("Credential() after reopen = %+v, %v", got, err)
+	}
+	if credentials, _ := reopened.Credentials("jdoe"); len(credentials) != 1 {
+		t.Errorf("Credentials() after reopen = %d credentials, want 1", len(credentials))
+	}
+}
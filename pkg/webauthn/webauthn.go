@@ -0,0 +1,571 @@
+// pkg/webauthn/webauthn.go
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// User verification requirements (WebAuthn section 5.8.6)
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// DefaultTimeout is how long a ceremony may take
+const DefaultTimeout = 5 * time.Minute
+
+// challengeBytes is the entropy of ceremony challenges
+const challengeBytes = 32
+
+// userHandleBytes is the size of generated user handles. Handles are random
+// so they reveal nothing about the user.
+const userHandleBytes = 32
+
+var (
+	// ErrInvalidResponse is returned for responses that fail verification
+	ErrInvalidResponse = errors.New("invalid WebAuthn response")
+	// ErrUserVerificationRequired is returned when the authenticator did not
+	// verify the user although the policy requires it
+	ErrUserVerificationRequired = errors.New("user verification required")
+	// ErrCounterRegression is returned when the signature counter did not
+	// increase, a sign that the authenticator was cloned
+	ErrCounterRegression = errors.New("signature counter did not increase")
+	// ErrSessionExpired is returned for ceremonies finished too late
+	ErrSessionExpired = errors.New("WebAuthn ceremony expired")
+)
+
+// Config describes the relying party
+type Config struct {
+	// ID is the relying party ID, a registrable domain such as
+	// "example.com". Credentials are scoped to it.
+	ID string
+	// Name is shown by authenticators
+	Name string
+	// Origins lists the origins ceremonies may run on, such as
+	// "https://login.example.com"
+	Origins []string
+	// UserVerification defaults to UserVerificationPreferred. Passwordless
+	// logins always require user verification.
+	UserVerification string
+	// Timeout defaults to DefaultTimeout
+	Timeout time.Duration
+	// Attestation is the conveyance preference sent to the browser and
+	// defaults to "none". "none" and "packed" attestation statements are
+	// accepted either way.
+	Attestation string
+	// AttestationRoots, when set, are the only certificate authorities
+	// trusted for packed attestation certificates; self and none
+	// attestation are then rejected
+	AttestationRoots *x509.CertPool
+}
+
+// RelyingParty runs registration and authentication ceremonies
+type RelyingParty struct {
+	config Config
+	now    func() time.Time
+}
+
+func NewRelyingParty(config Config) (*RelyingParty, error) {
+	if config.ID == "" || len(config.Origins) == 0 {
+		return nil, errors.New("relying party ID and origins are required")
+	}
+	switch config.UserVerification {
+	case "":
+		config.UserVerification = UserVerificationPreferred
+	case UserVerificationRequired, UserVerificationPreferred, UserVerificationDiscouraged:
+	default:
+		return nil, fmt.Errorf("invalid user verification requirement %q", config.UserVerification)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Attestation == "" {
+		config.Attestation = "none"
+	}
+	if config.Name == "" {
+		config.Name = config.ID
+	}
+	return &RelyingParty{config: config, now: time.Now}, nil
+}
+
+// User is the account a credential is registered for
+type User struct {
+	// ID is the canonical user ID
+	ID          string
+	Name        string
+	DisplayName string
+}
+
+// Session is the server-side state of a ceremony, kept between its begin
+// and finish steps
+type Session struct {
+	Challenge []byte
+	// UserID is empty for passwordless logins, where the credential
+	// identifies the user
+	UserID     string
+	UserHandle []byte
+	// AllowedCredentials limits logins to these credential IDs
+	AllowedCredentials [][]byte
+	UserVerification   string
+	ExpiresAt          time.Time
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID     []byte `json:"id"`
+	UserID string `json:"user_id"`
+	// UserHandle is the user.id the authenticator stores with a
+	// discoverable credential and returns in assertions
+	UserHandle []byte `json:"user_handle"`
+	// PublicKey is the COSE_Key of the credential
+	PublicKey []byte `json:"public_key"`
+	Algorithm int    `json:"alg"`
+	SignCount uint32 `json:"sign_count"`
+	AAGUID    []byte `json:"aaguid,omitempty"`
+	// AttestationType is "none", "self" or "basic"
+	AttestationType string   `json:"attestation_type"`
+	Transports      []string `json:"transports,omitempty"`
+	// BackupEligible is set for synced passkeys, which live in a cloud
+	// account rather than on one device
+	BackupEligible bool      `json:"backup_eligible,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastUsedAt     time.Time `json:"last_used_at,omitempty"`
+}
+
+// Options sent to navigator.credentials.create and .get, in the JSON
+// serialization of WebAuthn Level 3 with base64url binary values
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the options of a registration ceremony
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options of an authentication ceremony
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the credential created by the browser
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the assertion returned by the browser
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// CredentialID returns the raw ID of the asserted credential, to look it up
+// before FinishLogin
+func (r *AssertionResponse) CredentialID() ([]byte, error) {
+	id, err := decodeBase64URL(r.RawID)
+	if err != nil || len(id) == 0 {
+		return nil, fmt.Errorf("%w: invalid rawId", ErrInvalidResponse)
+	}
+	return id, nil
+}
+
+// Assertion is a verified authentication
+type Assertion struct {
+	// Credential is the credential with its new counter and last use, to
+	// be saved by the caller
+	Credential   *Credential
+	UserVerified bool
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// BeginRegistration starts registering a new credential for user. existing
+// are the user's credentials, which the authenticator must not register
+// again; their user handle is reused.
+func (rp *RelyingParty) BeginRegistration(user User, existing []Credential) (*CreationOptions, *Session, error) {
+	challenge, err := randomBytes(challengeBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	var handle []byte
+	for _, credential := range existing {
+		if len(credential.UserHandle) > 0 {
+			handle = credential.UserHandle
+			break
+		}
+	}
+	if handle == nil {
+		if handle, err = randomBytes(userHandleBytes); err != nil {
+			return nil, nil, err
+		}
+	}
+	name, displayName := user.Name, user.DisplayName
+	if name == "" {
+		name = user.ID
+	}
+	if displayName == "" {
+		displayName = name
+	}
+
+	options := &CreationOptions{
+		Challenge: encodeBase64URL(challenge),
+		RP:        RelyingPartyEntity{ID: rp.config.ID, Name: rp.config.Name},
+		User:      UserEntity{ID: encodeBase64URL(handle), Name: name, DisplayName: displayName},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: rp.config.UserVerification,
+		},
+		Attestation: rp.config.Attestation,
+	}
+	return options, &Session{
+		Challenge:        challenge,
+		UserID:           user.ID,
+		UserHandle:       handle,
+		UserVerification: rp.config.UserVerification,
+		ExpiresAt:        rp.now().Add(rp.config.Timeout),
+	}, nil
+}
+
+// FinishRegistration verifies a new credential (WebAuthn section 7.1) and
+// returns it for storage
+func (rp *RelyingParty) FinishRegistration(session *Session, response *RegistrationResponse) (*Credential, error) {
+	if !rp.now().Before(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: type %q", ErrInvalidResponse, response.Type)
+	}
+	clientDataJSON, err := decodeBase64URL(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: clientDataJSON: %w", ErrInvalidResponse, err)
+	}
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", session.Challenge); err != nil {
+		return nil, err
+	}
+	attestationObject, err := decodeBase64URL(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestationObject: %w", ErrInvalidResponse, err)
+	}
+	attestation, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	data := attestation.authData
+	if err := rp.verifyAuthenticatorData(data, session.UserVerification); err != nil {
+		return nil, err
+	}
+	if !data.has(flagAttestedData) {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrInvalidResponse)
+	}
+	if rawID, err := decodeBase64URL(response.RawID); err != nil || !bytes.Equal(rawID, data.credentialID) {
+		return nil, fmt.Errorf("%w: rawId does not match the attested credential", ErrInvalidResponse)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	attestationType, err := rp.verifyAttestation(attestation, clientDataHash[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation: %w", ErrInvalidResponse, err)
+	}
+
+	now := rp.now()
+	return &Credential{
+		ID:              append([]byte(nil), data.credentialID...),
+		UserID:          session.UserID,
+		UserHandle:      session.UserHandle,
+		PublicKey:       append([]byte(nil), data.coseKey...),
+		Algorithm:       data.algorithm,
+		SignCount:       data.signCount,
+		AAGUID:          append([]byte(nil), data.aaguid...),
+		AttestationType: attestationType,
+		Transports:      response.Response.Transports,
+		BackupEligible:  data.has(flagBackupEligible),
+		CreatedAt:       now,
+	}, nil
+}
+
+// BeginLogin starts authenticating with one of credentials, all of which
+// must belong to the same user
+func (rp *RelyingParty) BeginLogin(credentials []Credential) (*RequestOptions, *Session, error) {
+	if len(credentials) == 0 {
+		return nil, nil, errors.New("no credentials to authenticate with")
+	}
+	session, options, err := rp.beginLogin(rp.config.UserVerification)
+	if err != nil {
+		return nil, nil, err
+	}
+	session.UserID = credentials[0].UserID
+	for _, credential := range credentials {
+		if credential.UserID != session.UserID {
+			return nil, nil, errors.New("credentials of different users")
+		}
+		session.AllowedCredentials = append(session.AllowedCredentials, credential.ID)
+	}
+	options.AllowCredentials = descriptors(credentials)
+	return options, session, nil
+}
+
+// BeginPasswordlessLogin starts a login with any discoverable credential.
+// The authenticator must verify the user, as the credential is the only
+// factor presented.
+func (rp *RelyingParty) BeginPasswordlessLogin() (*RequestOptions, *Session, error) {
+	session, options, err := rp.beginLogin(UserVerificationRequired)
+	if err != nil {
+		return nil, nil, err
+	}
+	return options, session, nil
+}
+
+func (rp *RelyingParty) beginLogin(userVerification string) (*Session, *RequestOptions, error) {
+	challenge, err := randomBytes(challengeBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	session := &Session{
+		Challenge:        challenge,
+		UserVerification: userVerification,
+		ExpiresAt:        rp.now().Add(rp.config.Timeout),
+	}
+	options := &RequestOptions{
+		Challenge:        encodeBase64URL(challenge),
+		RPID:             rp.config.ID,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		UserVerification: userVerification,
+	}
+	return session, options, nil
+}
+
+// FinishLogin verifies an assertion made with credential (WebAuthn section
+// 7.2), which the caller looked up by response.CredentialID. The returned
+// credential carries the new signature counter and must be saved.
+func (rp *RelyingParty) FinishLogin(session *Session, credential *Credential, response *AssertionResponse) (*Assertion, error) {
+	if !rp.now().Before(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: type %q", ErrInvalidResponse, response.Type)
+	}
+	rawID, err := response.CredentialID()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(rawID, credential.ID) {
+		return nil, fmt.Errorf("%w: assertion for another credential", ErrInvalidResponse)
+	}
+	if session.UserID != "" && credential.UserID != session.UserID {
+		return nil, fmt.Errorf("%w: credential of another user", ErrInvalidResponse)
+	}
+	if len(session.AllowedCredentials) > 0 && !containsID(session.AllowedCredentials, credential.ID) {
+		return nil, fmt.Errorf("%w: credential not allowed", ErrInvalidResponse)
+	}
+	if response.Response.UserHandle != "" {
+		handle, err := decodeBase64URL(response.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, credential.UserHandle) {
+			return nil, fmt.Errorf("%w: userHandle does not match the credential", ErrInvalidResponse)
+		}
+	} else if session.UserID == "" {
+		// Passwordless logins rely on the user handle of a discoverable credential
+		return nil, fmt.Errorf("%w: userHandle missing", ErrInvalidResponse)
+	}
+
+	clientDataJSON, err := decodeBase64URL(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: clientDataJSON: %w", ErrInvalidResponse, err)
+	}
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", session.Challenge); err != nil {
+		return nil, err
+	}
+	rawData, err := decodeBase64URL(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: authenticatorData: %w", ErrInvalidResponse, err)
+	}
+	data, err := parseAuthenticatorData(rawData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	if err := rp.verifyAuthenticatorData(data, session.UserVerification); err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeBase64URL(response.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidResponse, err)
+	}
+	public, alg, _, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("stored credential key: %w", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := verifySignature(public, alg, append(append([]byte(nil), rawData...), clientDataHash[:]...), signature); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	// Authenticators without a counter always report 0
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return nil, ErrCounterRegression
+	}
+
+	updated := *credential
+	updated.SignCount = data.signCount
+	updated.LastUsedAt = rp.now()
+	return &Assertion{Credential: &updated, UserVerified: data.has(flagUserVerified)}, nil
+}
+
+// verifyClientData checks the type, challenge and origin of client data
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("%w: clientDataJSON: %w", ErrInvalidResponse, err)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("%w: client data type %q", ErrInvalidResponse, data.Type)
+	}
+	got, err := decodeBase64URL(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+	if !rp.allowedOrigin(data.Origin) {
+		return fmt.Errorf("%w: origin %q not allowed", ErrInvalidResponse, data.Origin)
+	}
+	if data.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin ceremony", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// verifyAuthenticatorData checks the RP ID hash and the user presence and
+// verification flags
+func (rp *RelyingParty) verifyAuthenticatorData(data *authenticatorData, userVerification string) error {
+	rpIDHash := sha256.Sum256([]byte(rp.config.ID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, rpIDHash[:]) != 1 {
+		return fmt.Errorf("%w: RP ID hash mismatch", ErrInvalidResponse)
+	}
+	if !data.has(flagUserPresent) {
+		return fmt.Errorf("%w: user not present", ErrInvalidResponse)
+	}
+	if userVerification == UserVerificationRequired && !data.has(flagUserVerified) {
+		return ErrUserVerificationRequired
+	}
+	if data.has(flagBackedUp) && !data.has(flagBackupEligible) {
+		return fmt.Errorf("%w: backed up credential not backup eligible", ErrInvalidResponse)
+	}
+	return nil
+}
+
+func (rp *RelyingParty) allowedOrigin(origin string) bool {
+	for _, allowed := range rp.config.Origins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func descriptors(credentials []Credential) []CredentialDescriptor {
+	var out []CredentialDescriptor
+	for _, credential := range credentials {
+		out = append(out, CredentialDescriptor{
+			Type:       "public-key",
+			ID:         encodeBase64URL(credential.ID),
+			Transports: credential.Transports,
+		})
+	}
+	return out
+}
+
+func containsID(ids [][]byte, id []byte) bool {
+	for _, candidate := range ids {
+		if bytes.Equal(candidate, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func randomBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return buf, nil
+}
+
+func encodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeBase64URL accepts base64url with or without padding
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,268 @@
+package webauthn_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn/webauthntest"
+)
+
+const testOrigin = "https://login.example.com"
+
+func newRP(t *testing.T, config webauthn.Config) *webauthn.RelyingParty {
+	t.Helper()
+	if config.ID == "" {
+		config.ID = "example.com"
+	}
+	if config.Origins == nil {
+		config.Origins = []string{testOrigin}
+	}
+	rp, err := webauthn.NewRelyingParty(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator) (*webauthn.Credential, error) {
+	t.Helper()
+	options, session, err := rp.BeginRegistration(webauthn.User{ID: "jdoe", DisplayName: "Jane Doe"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := authenticator.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp.FinishRegistration(session, response)
+}
+
+func login(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator, credential *webauthn.Credential) (*webauthn.Assertion, error) {
+	t.Helper()
+	options, session, err := rp.BeginLogin([]webauthn.Credential{*credential})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := authenticator.Login(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp.FinishLogin(session, credential, response)
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	tests := []struct {
+		name            string
+		authenticator   *webauthntest.Authenticator
+		wantAttestation string
+	}{
+		{"ES256 none", webauthntest.New(testOrigin), webauthn.AttestationNone},
+		{"ES256 packed self", &webauthntest.Authenticator{Origin: testOrigin, Attestation: "packed"}, webauthn.AttestationSelf},
+		{"RS256 packed self", &webauthntest.Authenticator{Origin: testOrigin, Attestation: "packed", Algorithm: webauthn.AlgRS256}, webauthn.AttestationSelf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRP(t, webauthn.Config{})
+			credential, err := register(t, rp, tt.authenticator)
+			if err != nil {
+				t.Fatalf("FinishRegistration() unexpected error: %v", err)
+			}
+			if credential.UserID != "jdoe" || credential.AttestationType != tt.wantAttestation || len(credential.UserHandle) == 0 {
+				t.Fatalf("credential = %+v", credential)
+			}
+
+			for i := 0; i < 2; i++ {
+				assertion, err := login(t, rp, tt.authenticator, credential)
+				if err != nil {
+					t.Fatalf("FinishLogin() unexpected error: %v", err)
+				}
+				if !assertion.UserVerified || assertion.Credential.SignCount != uint32(i+1) {
+					t.Errorf("assertion = %+v", assertion)
+				}
+				credential = assertion.Credential
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRejects(t *testing.T) {
+	rp := newRP(t, webauthn.Config{UserVerification: webauthn.UserVerificationRequired})
+
+	_, err := register(t, rp, &webauthntest.Authenticator{Origin: testOrigin, SkipUserVerification: true})
+	if !errors.Is(err, webauthn.ErrUserVerificationRequired) {
+		t.Errorf("without user verification: error = %v, want %v", err, webauthn.ErrUserVerificationRequired)
+	}
+	if _, err := register(t, rp, webauthntest.New("https://evil.example.net")); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Errorf("foreign origin: error = %v, want %v", err, webauthn.ErrInvalidResponse)
+	}
+
+	// A response to another ceremony's challenge
+	authenticator := webauthntest.New(testOrigin)
+	options, _, _ := rp.BeginRegistration(webauthn.User{ID: "jdoe"}, nil)
+	_, session, _ := rp.BeginRegistration(webauthn.User{ID: "jdoe"}, nil)
+	response, _ := authenticator.Register(options)
+	if _, err := rp.FinishRegistration(session, response); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Errorf("other challenge: error = %v, want %v", err, webauthn.ErrInvalidResponse)
+	}
+
+	// Credentials of another relying party
+	other := newRP(t, webauthn.Config{ID: "other.example.com"})
+	options, _, _ = other.BeginRegistration(webauthn.User{ID: "jdoe"}, nil)
+	_, session, _ = rp.BeginRegistration(webauthn.User{ID: "jdoe"}, nil)
+	response, _ = authenticator.Register(options)
+	if _, err := rp.FinishRegistration(session, response); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Errorf("other RP: error = %v, want %v", err, webauthn.ErrInvalidResponse)
+	}
+}
+
+func TestPackedAttestationCertificate(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Attestation Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	attestationKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Test Key",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}, ca, &attestationKey.PublicKey, caKey)
+	leaf, _ := x509.ParseCertificate(leafDER)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	rp := newRP(t, webauthn.Config{Attestation: "direct", AttestationRoots: roots})
+
+	attested := &webauthntest.Authenticator{
+		Origin:                 testOrigin,
+		Attestation:            "packed",
+		AttestationKey:         attestationKey,
+		AttestationCertificate: leaf,
+	}
+	credential, err := register(t, rp, attested)
+	if err != nil || credential.AttestationType != webauthn.AttestationBasic {
+		t.Fatalf("FinishRegistration() = %+v, %v; want basic attestation", credential, err)
+	}
+
+	// With trust anchors configured, unattested credentials are refused
+	for _, authenticator := range []*webauthntest.Authenticator{
+		webauthntest.New(testOrigin),
+		{Origin: testOrigin, Attestation: "packed"},
+	} {
+		if _, err := register(t, rp, authenticator); !errors.Is(err, webauthn.ErrInvalidResponse) {
+			t.Errorf("attestation %q: error = %v, want %v", authenticator.Attestation, err, webauthn.ErrInvalidResponse)
+		}
+	}
+}
+
+func TestFinishLoginRejects(t *testing.T) {
+	rp := newRP(t, webauthn.Config{})
+	authenticator := webauthntest.New(testOrigin)
+	credential, err := register(t, rp, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cloned authenticator is caught once both copies have been used
+	clone := authenticator.Clone()
+	assertion, err := login(t, rp, authenticator, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(t, rp, clone, assertion.Credential); !errors.Is(err, webauthn.ErrCounterRegression) {
+		t.Errorf("cloned authenticator: error = %v, want %v", err, webauthn.ErrCounterRegression)
+	}
+
+	// Authenticators without a counter always report 0
+	counterless := &webauthntest.Authenticator{Origin: testOrigin, NoCounter: true}
+	credential, _ = register(t, rp, counterless)
+	for i := 0; i < 2; i++ {
+		if _, err := login(t, rp, counterless, credential); err != nil {
+			t.Errorf("counterless login %d: unexpected error %v", i, err)
+		}
+	}
+
+	// An assertion signed by another credential's key
+	other := webauthntest.New(testOrigin)
+	otherCredential, _ := register(t, rp, other)
+	options, session, _ := rp.BeginLogin([]webauthn.Credential{*otherCredential})
+	response, _ := other.Login(options)
+	response.RawID, response.ID = webauthnID(credential), webauthnID(credential)
+	response.Response.UserHandle = ""
+	if _, err := rp.FinishLogin(session, credential, response); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Errorf("assertion by another key: error = %v, want %v", err, webauthn.ErrInvalidResponse)
+	}
+
+	// Passwordless logins need user verification
+	unverified := &webauthntest.Authenticator{Origin: testOrigin, SkipUserVerification: true}
+	credential, _ = register(t, rp, unverified)
+	options, session, _ = rp.BeginPasswordlessLogin()
+	response, _ = unverified.Login(options)
+	if _, err := rp.FinishLogin(session, credential, response); !errors.Is(err, webauthn.ErrUserVerificationRequired) {
+		t.Errorf("passwordless without user verification: error = %v, want %v", err, webauthn.ErrUserVerificationRequired)
+	}
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	rp := newRP(t, webauthn.Config{})
+	authenticator := &webauthntest.Authenticator{Origin: testOrigin, BackupEligible: true}
+	credential, err := register(t, rp, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credential.BackupEligible {
+		t.Error("synced passkey not marked backup eligible")
+	}
+
+	options, session, err := rp.BeginPasswordlessLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.AllowCredentials) != 0 || options.UserVerification != webauthn.UserVerificationRequired {
+		t.Fatalf("options = %+v", options)
+	}
+	response, _ := authenticator.Login(options)
+	id, err := response.CredentialID()
+	if err != nil || string(id) != string(credential.ID) {
+		t.Fatalf("CredentialID() = %x, %v", id, err)
+	}
+	if _, err := rp.FinishLogin(session, credential, response); err != nil {
+		t.Fatalf("FinishLogin() unexpected error: %v", err)
+	}
+
+	// The user handle must be the credential's
+	options, session, _ = rp.BeginPasswordlessLogin()
+	response, _ = authenticator.Login(options)
+	response.Response.UserHandle = "AAAA"
+	if _, err := rp.FinishLogin(session, credential, response); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Errorf("foreign user handle: error = %v, want %v", err, webauthn.ErrInvalidResponse)
+	}
+}
+
+func webauthnID(credential *webauthn.Credential) string {
+	return base64.RawURLEncoding.EncodeToString(credential.ID)
+}
@@ -0,0 +1,272 @@
+// pkg/webauthn/webauthntest/authenticator.go
+package webauthntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/yovily/customers/citi/auth-service/pkg/webauthn"
+)
+
+// Authenticator is a software authenticator, standing in for the browser
+// and a security key or platform authenticator in tests
+type Authenticator struct {
+	// Origin is reported in client data
+	Origin string
+	// Algorithm of new credentials, webauthn.AlgES256 by default
+	Algorithm int
+	// Attestation is "none" (the default) or "packed". Packed statements
+	// are signed by AttestationKey when set, else by the credential key.
+	Attestation            string
+	AttestationKey         crypto.Signer
+	AttestationCertificate *x509.Certificate
+	AAGUID                 [16]byte
+	// SkipUserVerification leaves the UV flag unset, as authenticators
+	// without a PIN or biometric do
+	SkipUserVerification bool
+	// BackupEligible marks credentials as synced passkeys
+	BackupEligible bool
+	// NoCounter keeps the signature counter at 0, as many passkey
+	// providers do
+	NoCounter bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        crypto.Signer
+	algorithm  int
+	signCount  uint32
+}
+
+// New returns an authenticator for ceremonies on origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Clone returns a copy holding the same keys and counters, like an
+// authenticator whose keys were extracted
+func (a *Authenticator) Clone() *Authenticator {
+	clone := *a
+	clone.credentials = nil
+	for _, c := range a.credentials {
+		copied := *c
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return &clone
+}
+
+// Register creates a credential as navigator.credentials.create would
+func (a *Authenticator) Register(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	algorithm := a.Algorithm
+	if algorithm == 0 {
+		algorithm = webauthn.AlgES256
+	}
+	supported := false
+	for _, param := range options.PubKeyCredParams {
+		supported = supported || param.Alg == algorithm
+	}
+	if !supported {
+		return nil, fmt.Errorf("algorithm %d not requested", algorithm)
+	}
+	for _, excluded := range options.ExcludeCredentials {
+		id, _ := base64.RawURLEncoding.DecodeString(excluded.ID)
+		if a.find(options.RP.ID, id) != nil {
+			return nil, errors.New("authenticator already registered")
+		}
+	}
+
+	key, err := generateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := base64.RawURLEncoding.DecodeString(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	c := &credential{id: id, rpID: options.RP.ID, userHandle: handle, key: key, algorithm: algorithm}
+	a.credentials = append(a.credentials, c)
+
+	clientDataJSON := a.clientData("webauthn.create", options.Challenge)
+	attested := append(append([]byte(nil), a.AAGUID[:]...), byte(len(id)>>8), byte(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey(key.Public(), algorithm)...)
+	authData := a.authenticatorData(c, 0x40, attested)
+
+	statement := map[interface{}]interface{}{}
+	format := "none"
+	if a.Attestation == "packed" {
+		format = "packed"
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+		signer, alg := key, algorithm
+		if a.AttestationKey != nil {
+			signer, alg = a.AttestationKey, algorithmOf(a.AttestationKey)
+			statement["x5c"] = []interface{}{a.AttestationCertificate.Raw}
+		}
+		sig, err := sign(signer, signed)
+		if err != nil {
+			return nil, err
+		}
+		statement["alg"] = alg
+		statement["sig"] = sig
+	}
+	attestationObject := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+
+	response := &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: base64.RawURLEncoding.EncodeToString(id),
+		Type:  "public-key",
+	}
+	response.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON)
+	response.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(attestationObject)
+	response.Response.Transports = []string{"internal"}
+	return response, nil
+}
+
+// Login makes an assertion as navigator.credentials.get would, with the
+// first allowed credential or, without allowed credentials, the first
+// discoverable one for the RP
+func (a *Authenticator) Login(options *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	var c *credential
+	if len(options.AllowCredentials) == 0 {
+		for _, candidate := range a.credentials {
+			if candidate.rpID == options.RPID {
+				c = candidate
+				break
+			}
+		}
+	}
+	for _, allowed := range options.AllowCredentials {
+		id, _ := base64.RawURLEncoding.DecodeString(allowed.ID)
+		if c = a.find(options.RPID, id); c != nil {
+			break
+		}
+	}
+	if c == nil {
+		return nil, errors.New("no credential for this relying party")
+	}
+	if !a.NoCounter {
+		c.signCount++
+	}
+
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	authData := a.authenticatorData(c, 0, nil)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signature, err := sign(c.key, append(append([]byte(nil), authData...), clientDataHash[:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawID: base64.RawURLEncoding.EncodeToString(c.id),
+		Type:  "public-key",
+	}
+	response.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON)
+	response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	response.Response.UserHandle = base64.RawURLEncoding.EncodeToString(c.userHandle)
+	return response, nil
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && string(c.id) == string(id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *Authenticator) authenticatorData(c *credential, flags byte, attested []byte) []byte {
+	flags |= 0x01
+	if !a.SkipUserVerification {
+		flags |= 0x04
+	}
+	if a.BackupEligible {
+		flags |= 0x08
+	}
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, c.signCount)
+	return append(data, attested...)
+}
+
+func generateKey(algorithm int) (crypto.Signer, error) {
+	switch algorithm {
+	case webauthn.AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case webauthn.AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %d", algorithm)
+	}
+}
+
+func algorithmOf(key crypto.Signer) int {
+	if _, ok := key.Public().(*rsa.PublicKey); ok {
+		return webauthn.AlgRS256
+	}
+	return webauthn.AlgES256
+}
+
+func sign(key crypto.Signer, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// coseKey encodes a public key as COSE_Key
+func coseKey(public crypto.PublicKey, algorithm int) []byte {
+	switch key := public.(type) {
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		return encodeCBOR(map[interface{}]interface{}{1: 2, 3: algorithm, -1: 1, -2: x, -3: y})
+	case *rsa.PublicKey:
+		e := big32(key.E)
+		return encodeCBOR(map[interface{}]interface{}{1: 3, 3: algorithm, -1: key.N.Bytes(), -2: e})
+	default:
+		panic(fmt.Sprintf("webauthntest: unsupported key %T", public))
+	}
+}
+
+func big32(e int) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(e))
+	for len(buf) > 1 && buf[0] == 0 {
+		buf = buf[1:]
+	}
+	return buf
+}
@@ -0,0 +1,9 @@
+// Package webauthntest provides a software authenticator for testing
+// WebAuthn relying parties without a browser or security key.
+//
+//	authenticator := webauthntest.New("https://login.example.com")
+//
+//	options, session, err := rp.BeginRegistration(user, nil)
+//	response, err := authenticator.Register(options)
+//	credential, err := rp.FinishRegistration(session, response)
+package webauthntest